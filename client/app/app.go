@@ -10,8 +10,10 @@ import (
 
 	"github.com/RicardoCenci/iot-distributed-architecture/client/config"
 	"github.com/RicardoCenci/iot-distributed-architecture/client/device"
+	"github.com/RicardoCenci/iot-distributed-architecture/client/ingest"
 	"github.com/RicardoCenci/iot-distributed-architecture/client/mqtt"
 	"github.com/RicardoCenci/iot-distributed-architecture/client/queue"
+	"github.com/RicardoCenci/iot-distributed-architecture/client/timesync"
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
 	"google.golang.org/protobuf/proto"
@@ -55,17 +57,103 @@ func getEnvOrDefault(key string, defaultValue string) string {
 	return value
 }
 
+// publisherClient is the transport of the buffered publishers.
+type publisherClient interface {
+	mqtt.Publisher
+	Close() error
+}
+
+// newClient connects to the ingest service over gRPC when it is enabled, and
+// to the MQTT broker otherwise.
+func (a *App) newClient() (publisherClient, error) {
+	if a.config.GRPC.Enabled {
+		return ingest.NewClient(
+			a.logger,
+			a.config.GRPC.Address,
+			ingest.WithTLS(a.config.GRPC.TLS),
+			ingest.WithDeviceToken(a.device.DeviceID, a.config.GRPC.Token),
+		)
+	}
+
+	return mqtt.NewClient(
+		a.logger,
+		a.config.MQTT.Broker,
+		a.device.DeviceID,
+		a.config.MQTT.User,
+		a.config.MQTT.Password,
+		mqtt.WithPublishTimeout(a.config.MQTT.PublishTimeout),
+	)
+}
+
+// newClock creates the clock keeping the device time in sync with the server
+// over client, or returns nil when the transport cannot carry the time
+// responses.
+func (a *App) newClock(client publisherClient) *timesync.Clock {
+	timeSyncClient, ok := client.(timesync.Client)
+	if !ok {
+		a.logger.Warn("Time sync requires the MQTT transport, device timestamps are not corrected")
+		return nil
+	}
+
+	return timesync.NewClock(
+		timeSyncClient,
+		a.device.DeviceID,
+		a.config.TimeSync.RequestTopic,
+		a.config.TimeSync.ResponseTopic,
+		a.logger,
+		timesync.WithInterval(a.config.TimeSync.Interval),
+	)
+}
+
+// encodeBase64 encodes a message for MQTT: the protobuf wire format, base64
+// encoded.
+func encodeBase64[T proto.Message](message T) ([]byte, error) {
+	protoData, err := proto.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal protobuf: %w", err)
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(protoData)))
+	base64.StdEncoding.Encode(encoded, protoData)
+	return encoded, nil
+}
+
 func (a *App) Run(ctx context.Context) {
 
 	a.logger.Info("Starting application")
 
-	client, err := mqtt.NewClient(a.logger, a.config.MQTT.Broker, a.device.DeviceID, a.config.MQTT.User, a.config.MQTT.Password)
-
+	client, err := a.newClient()
 	if err != nil {
-		a.logger.Error("Failed to create MQTT client", "error", err)
+		a.logger.Error("Failed to create client", "error", err)
 		return
 	}
 
+	var wg sync.WaitGroup
+
+	now := time.Now
+
+	if a.config.TimeSync.Enabled {
+		if clock := a.newClock(client); clock != nil {
+			now = clock.Now
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := clock.Run(ctx); err != nil {
+					a.logger.Error("Failed to synchronize the clock", "error", err)
+				}
+			}()
+		}
+	}
+
+	encodeSensorData := encodeBase64[*protosensor.SensorData]
+	encodeMetricsData := encodeBase64[*protosensor.MetricsData]
+
+	if a.config.GRPC.Enabled {
+		encodeSensorData = ingest.EncodeSensorData
+		encodeMetricsData = ingest.EncodeMetricsData
+	}
+
 	dataMetrics := mqtt.NewMetrics(a.config.MQTT.Topics[config.TopicDataJSON].Topic)
 
 	dataPublisher := mqtt.BufferedPublisher[DataMessage]{
@@ -82,21 +170,14 @@ func (a *App) Run(ctx context.Context) {
 			}),
 		),
 		MessageTransformer: func(msg DataMessage) ([]byte, error) {
-			sensorData := &protosensor.SensorData{
+			data := &protosensor.SensorData{
 				SensorId:    msg.DeviceID,
 				Humidity:    msg.Humidity,
 				Temperature: msg.Temperature,
-				Timestamp:   msg.Timestamp.Unix(),
-			}
-
-			protoData, err := proto.Marshal(sensorData)
-			if err != nil {
-				return nil, fmt.Errorf("failed to marshal protobuf: %w", err)
 			}
+			data.SetReadingTime(msg.Timestamp)
 
-			encoded := make([]byte, base64.StdEncoding.EncodedLen(len(protoData)))
-			base64.StdEncoding.Encode(encoded, protoData)
-			return encoded, nil
+			return encodeSensorData(data)
 		},
 		QoS:   a.config.MQTT.QoS,
 		Topic: a.config.MQTT.Topics[config.TopicDataJSON].Topic,
@@ -118,29 +199,21 @@ func (a *App) Run(ctx context.Context) {
 			}),
 		),
 		MessageTransformer: func(msg MetricMessage) ([]byte, error) {
-			metricsData := &protosensor.MetricsData{
+			data := &protosensor.MetricsData{
 				SensorId:     msg.DeviceID,
 				CpuUsage:     msg.CPUUsage,
 				MemoryUsage:  msg.MemoryUsage,
 				DiskUsage:    msg.DiskUsage,
 				NetworkUsage: msg.NetworkUsage,
-				Timestamp:    msg.Timestamp.Unix(),
-			}
-			protoData, err := proto.Marshal(metricsData)
-			if err != nil {
-				return nil, fmt.Errorf("failed to marshal protobuf: %w", err)
 			}
+			data.SetReadingTime(msg.Timestamp)
 
-			encoded := make([]byte, base64.StdEncoding.EncodedLen(len(protoData)))
-			base64.StdEncoding.Encode(encoded, protoData)
-			return encoded, nil
+			return encodeMetricsData(data)
 		},
 		QoS:   a.config.MQTT.QoS,
 		Topic: a.config.MQTT.Topics[config.TopicMetrics].Topic,
 	}
 
-	var wg sync.WaitGroup
-
 	if !a.config.MQTT.Topics[config.TopicMetrics].IsDisabled {
 		wg.Add(1)
 
@@ -175,12 +248,12 @@ func (a *App) Run(ctx context.Context) {
 			dataMetrics.Print(a.logger)
 			metricMetrics.Print(a.logger)
 
-			a.logger.Debug("Closing MQTT client")
+			a.logger.Debug("Closing client")
 			client.Close()
 
 			return
 		case <-ticker.C:
-			timestamp := time.Now()
+			timestamp := now()
 
 			sensorData := a.device.GetSensorData()
 
@@ -14,6 +14,12 @@ var defaultBackoffConfig = BackoffConfig{
 	MaxRetries: 3,
 }
 
+var defaultTimeSyncConfig = TimeSyncConfig{
+	RequestTopic:  "iot.device.time.request",
+	ResponseTopic: "iot.device.time.response.{device_id}",
+	Interval:      time.Minute,
+}
+
 var defaultBufferConfig = BufferConfig{
 	Capacity: 10,
 	Backoff:  defaultBackoffConfig,
@@ -105,6 +111,54 @@ func (c *Config) LoadFromTomlFile(filename string) error {
 		}
 	}
 
+	if v := configMap.Get("mqtt.publishTimeoutInSeconds"); v != nil {
+		if i, ok := v.(int); ok {
+			c.MQTT.PublishTimeout = time.Duration(i) * time.Second
+		}
+	}
+
+	if v := configMap.Get("grpc"); v != nil {
+		if m, ok := v.(map[string]interface{}); ok {
+			if b, ok := m["enabled"].(bool); ok {
+				c.GRPC.Enabled = b
+			}
+
+			if s, ok := m["address"].(string); ok {
+				c.GRPC.Address = s
+			}
+
+			if b, ok := m["tls"].(bool); ok {
+				c.GRPC.TLS = b
+			}
+
+			if s, ok := m["token"].(string); ok {
+				c.GRPC.Token = s
+			}
+		}
+	}
+
+	if v := configMap.Get("time_sync"); v != nil {
+		if m, ok := v.(map[string]interface{}); ok {
+			c.TimeSync = defaultTimeSyncConfig
+
+			if b, ok := m["enabled"].(bool); ok {
+				c.TimeSync.Enabled = b
+			}
+
+			if s, ok := m["request_topic"].(string); ok {
+				c.TimeSync.RequestTopic = s
+			}
+
+			if s, ok := m["response_topic"].(string); ok {
+				c.TimeSync.ResponseTopic = s
+			}
+
+			if i, ok := m["intervalInSeconds"].(int); ok {
+				c.TimeSync.Interval = time.Duration(i) * time.Second
+			}
+		}
+	}
+
 	for _, topic := range TOPICS {
 		key := fmt.Sprintf("mqtt.topics.%s", string(topic))
 
@@ -187,7 +241,11 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("device id is required")
 	}
 
-	if c.MQTT.Broker == "" {
+	if c.GRPC.Enabled && c.GRPC.Address == "" {
+		return fmt.Errorf("grpc address is required")
+	}
+
+	if !c.GRPC.Enabled && c.MQTT.Broker == "" {
 		return fmt.Errorf("mqtt broker is required")
 	}
 
@@ -221,6 +279,24 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("log level must be debug, info, warn or error")
 	}
 
+	if c.TimeSync.Enabled {
+		if c.GRPC.Enabled {
+			return fmt.Errorf("time sync requires the mqtt transport")
+		}
+
+		if c.TimeSync.RequestTopic == "" || c.TimeSync.ResponseTopic == "" {
+			return fmt.Errorf("time sync request and response topics are required")
+		}
+
+		if c.TimeSync.Interval <= 0 {
+			return fmt.Errorf("time sync interval must be positive")
+		}
+	}
+
+	if c.GRPC.Enabled {
+		return nil
+	}
+
 	if c.MQTT.User == "" {
 		return fmt.Errorf("mqtt user is required")
 	}
@@ -87,6 +87,20 @@ topic=iot/device/metrics`,
 					c.MQTT.Topics[TopicDataJSON].Buffer.Backoff.MaxRetries == 5
 			},
 		},
+		{
+			name: "config with publish timeout",
+			content: `[device]
+id=test-device
+
+[mqtt]
+broker=tcp://localhost:1883
+qos=1
+publishTimeoutInSeconds=5`,
+			wantErr: false,
+			validate: func(c *Config) bool {
+				return c.MQTT.PublishTimeout == 5*time.Second
+			},
+		},
 		{
 			name: "config with wifi",
 			content: `[device]
@@ -109,6 +123,50 @@ ssid=MyWiFi`,
 				return c.WiFi != nil && c.WiFi.SSID == "MyWiFi"
 			},
 		},
+		{
+			name: "config with grpc",
+			content: `[device]
+id=test-device
+
+[grpc]
+enabled=true
+address=localhost:50051
+tls=true
+token=secret
+
+[mqtt.topics.data_json]
+topic=iot.device.data.json
+
+[mqtt.topics.metrics]
+topic=iot/device/metrics`,
+			wantErr: false,
+			validate: func(c *Config) bool {
+				return c.GRPC.Enabled && c.GRPC.Address == "localhost:50051" && c.GRPC.TLS && c.GRPC.Token == "secret"
+			},
+		},
+		{
+			name: "config with time sync",
+			content: `[device]
+id=test-device
+
+[time_sync]
+enabled=true
+response_topic=time/{device_id}
+intervalInSeconds=30
+
+[mqtt.topics.data_json]
+topic=iot.device.data.json
+
+[mqtt.topics.metrics]
+topic=iot/device/metrics`,
+			wantErr: false,
+			validate: func(c *Config) bool {
+				return c.TimeSync.Enabled &&
+					c.TimeSync.RequestTopic == "iot.device.time.request" &&
+					c.TimeSync.ResponseTopic == "time/{device_id}" &&
+					c.TimeSync.Interval == 30*time.Second
+			},
+		},
 		{
 			name:     "non-existent file",
 			content:  "",
@@ -159,8 +217,25 @@ func TestConfig_Validate(t *testing.T) {
 			config: &Config{
 				Device: DeviceConfig{ID: "test-device"},
 				MQTT: MQTTConfig{
-					Broker: "tcp://localhost:1883",
-					QoS:    1,
+					Broker:   "tcp://localhost:1883",
+					User:     "test-user",
+					Password: "test-password",
+					QoS:      1,
+					Topics: map[Topic]TopicConfig{
+						TopicDataJSON: {Topic: "iot.device.data.json"},
+						TopicMetrics:  {Topic: "iot/device/metrics"},
+					},
+				},
+				Log: logger.Config{Level: "info"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "grpc without mqtt broker and credentials",
+			config: &Config{
+				Device: DeviceConfig{ID: "test-device"},
+				GRPC:   GRPCConfig{Enabled: true, Address: "localhost:50051"},
+				MQTT: MQTTConfig{
 					Topics: map[Topic]TopicConfig{
 						TopicDataJSON: {Topic: "iot.device.data.json"},
 						TopicMetrics:  {Topic: "iot/device/metrics"},
@@ -170,6 +245,37 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "time sync over grpc",
+			config: &Config{
+				Device:   DeviceConfig{ID: "test-device"},
+				GRPC:     GRPCConfig{Enabled: true, Address: "localhost:50051"},
+				TimeSync: TimeSyncConfig{Enabled: true, RequestTopic: "request", ResponseTopic: "response", Interval: time.Minute},
+				MQTT: MQTTConfig{
+					Topics: map[Topic]TopicConfig{
+						TopicDataJSON: {Topic: "iot.device.data.json"},
+						TopicMetrics:  {Topic: "iot/device/metrics"},
+					},
+				},
+				Log: logger.Config{Level: "info"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing grpc address",
+			config: &Config{
+				Device: DeviceConfig{ID: "test-device"},
+				GRPC:   GRPCConfig{Enabled: true},
+				MQTT: MQTTConfig{
+					Topics: map[Topic]TopicConfig{
+						TopicDataJSON: {Topic: "iot.device.data.json"},
+						TopicMetrics:  {Topic: "iot/device/metrics"},
+					},
+				},
+				Log: logger.Config{Level: "info"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "missing device id",
 			config: &Config{
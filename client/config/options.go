@@ -47,13 +47,41 @@ type MQTTConfig struct {
 	Password string                `json:"password"`
 	Topics   map[Topic]TopicConfig `json:"topics"`
 	QoS      int                   `json:"qos"`
+
+	// PublishTimeout is how long a publish waits for the broker to
+	// acknowledge it. Zero waits until it is acknowledged.
+	PublishTimeout time.Duration `json:"publishTimeoutInSeconds"`
+}
+
+// GRPCConfig sends readings to the ingest service over gRPC instead of MQTT
+// when enabled. The MQTT topics still name the publishers and can disable the
+// metrics.
+type GRPCConfig struct {
+	Enabled bool   `json:"enabled"`
+	Address string `json:"address"`
+	TLS     bool   `json:"tls"`
+	// Token authenticates the device with the ingest service.
+	Token string `json:"token"`
+}
+
+// TimeSyncConfig keeps the device clock in sync with the server when
+// enabled, by sending a time request to RequestTopic every Interval and
+// reading the response on ResponseTopic, where {device_id} is replaced by the
+// device ID. It needs the MQTT transport.
+type TimeSyncConfig struct {
+	Enabled       bool          `json:"enabled"`
+	RequestTopic  string        `json:"request_topic"`
+	ResponseTopic string        `json:"response_topic"`
+	Interval      time.Duration `json:"intervalInSeconds"`
 }
 
 type Config struct {
-	Log    logger.Config `json:"log"`
-	Device DeviceConfig  `json:"device"`
-	WiFi   *WiFiConfig   `json:"wifi,omitempty"`
-	MQTT   MQTTConfig    `json:"mqtt"`
+	Log      logger.Config  `json:"log"`
+	Device   DeviceConfig   `json:"device"`
+	WiFi     *WiFiConfig    `json:"wifi,omitempty"`
+	MQTT     MQTTConfig     `json:"mqtt"`
+	GRPC     GRPCConfig     `json:"grpc"`
+	TimeSync TimeSyncConfig `json:"time_sync"`
 }
 
 type Option func(*Config)
@@ -94,12 +122,37 @@ func WithQoS(qoS int) Option {
 	}
 }
 
+func WithPublishTimeout(publishTimeout time.Duration) Option {
+	return func(c *Config) {
+		c.MQTT.PublishTimeout = publishTimeout
+	}
+}
+
 func WithBroker(broker string) Option {
 	return func(c *Config) {
 		c.MQTT.Broker = broker
 	}
 }
 
+func WithCredentials(user, password string) Option {
+	return func(c *Config) {
+		c.MQTT.User = user
+		c.MQTT.Password = password
+	}
+}
+
+func WithGRPC(grpc GRPCConfig) Option {
+	return func(c *Config) {
+		c.GRPC = grpc
+	}
+}
+
+func WithTimeSync(timeSync TimeSyncConfig) Option {
+	return func(c *Config) {
+		c.TimeSync = timeSync
+	}
+}
+
 func (c *Config) Merge(options ...Option) *Config {
 	for _, option := range options {
 		option(c)
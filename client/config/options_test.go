@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 )
@@ -53,6 +54,13 @@ func TestConfigOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("WithCredentials", func(t *testing.T) {
+		cfg := NewConfig(WithCredentials("user", "password"))
+		if cfg.MQTT.User != "user" || cfg.MQTT.Password != "password" {
+			t.Errorf("WithCredentials() User = %v, Password = %v, want user, password", cfg.MQTT.User, cfg.MQTT.Password)
+		}
+	})
+
 	t.Run("WithQoS", func(t *testing.T) {
 		cfg := NewConfig(WithQoS(2))
 		if cfg.MQTT.QoS != 2 {
@@ -60,6 +68,13 @@ func TestConfigOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("WithPublishTimeout", func(t *testing.T) {
+		cfg := NewConfig(WithPublishTimeout(5 * time.Second))
+		if cfg.MQTT.PublishTimeout != 5*time.Second {
+			t.Errorf("WithPublishTimeout() PublishTimeout = %v, want %v", cfg.MQTT.PublishTimeout, 5*time.Second)
+		}
+	})
+
 	t.Run("WithTopics", func(t *testing.T) {
 		topics := map[Topic]TopicConfig{
 			TopicDataJSON: {Topic: "test/data"},
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"os"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,17 +13,45 @@ import (
 	"github.com/RicardoCenci/iot-distributed-architecture/client/device"
 	"github.com/RicardoCenci/iot-distributed-architecture/client/drivers"
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/mqttbroker"
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/protobuf/proto"
 )
 
+// startBroker starts an in-process MQTT broker and counts the messages
+// published to topic, so the tests run without network access.
+func startBroker(t *testing.T, topic string) (*mqttbroker.Broker, *atomic.Int64) {
+	t.Helper()
+
+	broker := mqttbroker.New(logger.NewSlogLogger(logger.Config{Level: "error"}))
+	if err := broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	received := new(atomic.Int64)
+	if err := broker.Subscribe(topic, func(topic string, payload []byte) {
+		received.Add(1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return broker, received
+}
+
 func TestE2E_AppLifecycle(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping E2E test in short mode")
 	}
 
+	broker, received := startBroker(t, "iot/e2e/test/data")
+
 	cfg := config.NewConfig(
 		config.WithDevice(config.DeviceConfig{ID: "e2e-test-device"}),
-		config.WithBroker("tcp://test.mosquitto.org:1883"),
+		config.WithBroker(broker.URL()),
+		config.WithCredentials("e2e-user", "e2e-password"),
 		config.WithQoS(1),
+		config.WithPublishTimeout(5*time.Second),
 		config.WithTopics(map[config.Topic]config.TopicConfig{
 			config.TopicDataJSON: {Topic: "iot/e2e/test/data"},
 			config.TopicMetrics:  {Topic: "iot/e2e/test/metrics"},
@@ -60,6 +90,10 @@ func TestE2E_AppLifecycle(t *testing.T) {
 	case <-time.After(6 * time.Second):
 		t.Error("E2E test timed out")
 	}
+
+	if received.Load() == 0 {
+		t.Error("No sensor data reached the broker")
+	}
 }
 
 func TestE2E_ConfigLoadAndValidate(t *testing.T) {
@@ -76,7 +110,9 @@ level=info
 id=e2e-test-device-123
 
 [mqtt]
-broker=tcp://test.mosquitto.org:1883
+broker=tcp://localhost:1883
+user=e2e-user
+password=e2e-password
 qos=1
 
 [mqtt.topics.data_json]
@@ -103,8 +139,8 @@ topic=iot/e2e/test/metrics
 		t.Errorf("Device ID = %v, want %v", cfg.Device.ID, "e2e-test-device-123")
 	}
 
-	if cfg.MQTT.Broker != "tcp://test.mosquitto.org:1883" {
-		t.Errorf("MQTT Broker = %v, want %v", cfg.MQTT.Broker, "tcp://test.mosquitto.org:1883")
+	if cfg.MQTT.Broker != "tcp://localhost:1883" {
+		t.Errorf("MQTT Broker = %v, want %v", cfg.MQTT.Broker, "tcp://localhost:1883")
 	}
 }
 
@@ -148,6 +184,8 @@ func TestE2E_FullIntegration(t *testing.T) {
 	}
 	defer os.Remove(tmpfile.Name())
 
+	broker, received := startBroker(t, "iot/e2e/integration/data")
+
 	configContent := `[log]
 level=info
 
@@ -155,7 +193,9 @@ level=info
 id=e2e-integration-test
 
 [mqtt]
-broker=tcp://test.mosquitto.org:1883
+broker=` + broker.URL() + `
+user=e2e-user
+password=e2e-password
 qos=1
 
 [mqtt.topics.data_json]
@@ -206,4 +246,111 @@ topic=iot/e2e/integration/metrics
 	case <-time.After(4 * time.Second):
 		t.Error("Full integration test timed out")
 	}
+
+	if received.Load() == 0 {
+		t.Error("No sensor data reached the broker")
+	}
+}
+
+func TestE2E_TimeSync(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping E2E test in short mode")
+	}
+
+	broker := mqttbroker.New(logger.NewSlogLogger(logger.Config{Level: "error"}))
+	if err := broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	// The server clock is an hour ahead of the device.
+	const offset = time.Hour
+
+	if err := broker.Subscribe("iot/e2e/time/request", func(topic string, payload []byte) {
+		var request protosensor.TimeRequest
+		if err := decodeBase64(payload, &request); err != nil {
+			t.Error(err)
+			return
+		}
+
+		serverNow := time.Now().Add(offset).UnixMilli()
+		response, err := proto.Marshal(&protosensor.TimeResponse{
+			SensorId:            request.SensorId,
+			ClientSendTimeMs:    request.ClientSendTimeMs,
+			ServerReceiveTimeMs: serverNow,
+			ServerSendTimeMs:    serverNow,
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		go broker.Publish("iot/e2e/time/response/"+request.SensorId, []byte(base64.StdEncoding.EncodeToString(response)))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var readAt atomic.Int64
+	if err := broker.Subscribe("iot/e2e/time/data", func(topic string, payload []byte) {
+		var data protosensor.SensorData
+		if err := decodeBase64(payload, &data); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Workers that only read timestamp get the same time to the second.
+		got, ok := data.ReadingTime()
+		if !ok || data.GetTime() == nil || got.Unix() != data.Timestamp {
+			t.Errorf("sensor data time = %v and timestamp = %d, want both set to the same time", data.GetTime(), data.Timestamp)
+			return
+		}
+		readAt.Store(got.UnixNano())
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.NewConfig(
+		config.WithDevice(config.DeviceConfig{ID: "e2e-time-sync"}),
+		config.WithBroker(broker.URL()),
+		config.WithCredentials("e2e-user", "e2e-password"),
+		config.WithQoS(1),
+		config.WithPublishTimeout(5*time.Second),
+		config.WithTopics(map[config.Topic]config.TopicConfig{
+			config.TopicDataJSON: {Topic: "iot/e2e/time/data"},
+			config.TopicMetrics:  {Topic: "iot/e2e/time/metrics", IsDisabled: true},
+		}),
+		config.WithTimeSync(config.TimeSyncConfig{
+			Enabled:       true,
+			RequestTopic:  "iot/e2e/time/request",
+			ResponseTopic: "iot/e2e/time/response/{device_id}",
+			Interval:      time.Minute,
+		}),
+	)
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Config validation failed: %v", err)
+	}
+
+	log := logger.NewSlogLogger(logger.Config{Level: "error"})
+	appInstance := app.NewApp(cfg, device.NewDevice(cfg.Device.ID, drivers.NewRandomDataDriver()), log)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2500*time.Millisecond)
+	defer cancel()
+
+	appInstance.Run(ctx)
+
+	got := time.Unix(0, readAt.Load())
+	want := time.Now().Add(offset)
+
+	if diff := want.Sub(got); diff < 0 || diff > 5*time.Second {
+		t.Errorf("sensor data timestamp = %v, want about %v", got, want)
+	}
+}
+
+func decodeBase64(payload []byte, message proto.Message) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(decoded, message)
 }
@@ -5,14 +5,20 @@ go 1.24.0
 require (
 	github.com/RicardoCenci/iot-distributed-architecture/shared v0.0.0
 	github.com/eclipse/paho.mqtt.golang v1.5.1
-	github.com/rabbitmq/amqp091-go v1.10.0
-	google.golang.org/protobuf v1.34.2
+	google.golang.org/grpc v1.77.0
+	google.golang.org/protobuf v1.36.10
 )
 
 require (
 	github.com/gorilla/websocket v1.5.3 // indirect
-	golang.org/x/net v0.44.0 // indirect
-	golang.org/x/sync v0.17.0 // indirect
+	github.com/mochi-mqtt/server/v2 v2.7.9 // indirect
+	github.com/rs/xid v1.4.0 // indirect
+	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/sync v0.18.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/text v0.31.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251022142026-3a174f9686a8 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace github.com/RicardoCenci/iot-distributed-architecture/shared => ../shared
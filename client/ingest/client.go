@@ -0,0 +1,177 @@
+package ingest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// deviceIDMetadata names the device of a call, as the ingest service expects.
+const deviceIDMetadata = "x-device-id"
+
+// Client publishes readings to the ingest service over gRPC. It has the same
+// Publish method as the MQTT client, so buffered publishers can use either.
+type Client struct {
+	conn    *grpc.ClientConn
+	client  protosensor.IngestServiceClient
+	logger  logger.Interface
+	options *ClientOptions
+}
+
+type ClientOptions struct {
+	publishTimeout time.Duration
+	tls            bool
+	deviceID       string
+	token          string
+	dialOptions    []grpc.DialOption
+}
+
+type ClientOption func(*ClientOptions)
+
+// WithPublishTimeout sets how long Publish waits for the service to accept a
+// batch. Zero waits forever.
+func WithPublishTimeout(publishTimeout time.Duration) ClientOption {
+	return func(options *ClientOptions) {
+		options.publishTimeout = publishTimeout
+	}
+}
+
+// WithTLS connects with TLS, verifying the server against the system roots.
+func WithTLS(enabled bool) ClientOption {
+	return func(options *ClientOptions) {
+		options.tls = enabled
+	}
+}
+
+// WithDeviceToken authenticates every call as deviceID with its token. The
+// service only accepts readings whose sensor_id is deviceID.
+func WithDeviceToken(deviceID, token string) ClientOption {
+	return func(options *ClientOptions) {
+		options.deviceID = deviceID
+		options.token = token
+	}
+}
+
+// WithDialOptions adds options to the gRPC connection, such as a custom
+// dialer in tests.
+func WithDialOptions(dialOptions ...grpc.DialOption) ClientOption {
+	return func(options *ClientOptions) {
+		options.dialOptions = append(options.dialOptions, dialOptions...)
+	}
+}
+
+// NewClient creates a client of the ingest service at target, such as
+// "localhost:50051". The connection is established lazily, by the first
+// publish.
+func NewClient(logger logger.Interface, target string, clientOptions ...ClientOption) (*Client, error) {
+	defaultOptions := &ClientOptions{
+		publishTimeout: 10 * time.Second,
+	}
+
+	for _, option := range clientOptions {
+		option(defaultOptions)
+	}
+
+	creds := insecure.NewCredentials()
+	if defaultOptions.tls {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
+	dialOptions := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if defaultOptions.token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(deviceCredentials{
+			deviceID: defaultOptions.deviceID,
+			token:    defaultOptions.token,
+			tls:      defaultOptions.tls,
+		}))
+	}
+	dialOptions = append(dialOptions, defaultOptions.dialOptions...)
+
+	logger.Debug("Creating gRPC ingest client", "target", target, "tls", defaultOptions.tls)
+
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+
+	return &Client{
+		conn:    conn,
+		client:  protosensor.NewIngestServiceClient(conn),
+		logger:  logger,
+		options: defaultOptions,
+	}, nil
+}
+
+// PublishReadings sends a batch of readings and returns how many the service
+// accepted.
+func (c *Client) PublishReadings(ctx context.Context, request *protosensor.PublishReadingsRequest) (uint64, error) {
+	if c.options.publishTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.publishTimeout)
+		defer cancel()
+	}
+
+	response, err := c.client.PublishReadings(ctx, request)
+	if err != nil {
+		return 0, fmt.Errorf("failed to publish readings: %w", err)
+	}
+
+	return response.GetAccepted(), nil
+}
+
+// Publish sends a payload built by EncodeSensorData or EncodeMetricsData.
+// The topic, QoS and retained flag only apply to MQTT and are ignored.
+func (c *Client) Publish(topic string, payload []byte, qos int, retained bool) error {
+	var request protosensor.PublishReadingsRequest
+	if err := proto.Unmarshal(payload, &request); err != nil {
+		return fmt.Errorf("failed to unmarshal readings: %w", err)
+	}
+
+	_, err := c.PublishReadings(context.Background(), &request)
+	return err
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// deviceCredentials sends the device ID and its bearer token with every
+// call. Over TLS they are never sent on an unencrypted connection.
+type deviceCredentials struct {
+	deviceID string
+	token    string
+	tls      bool
+}
+
+func (c deviceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		deviceIDMetadata: c.deviceID,
+		"authorization":  "Bearer " + c.token,
+	}, nil
+}
+
+func (c deviceCredentials) RequireTransportSecurity() bool {
+	return c.tls
+}
+
+// EncodeSensorData encodes sensor data as a payload for Publish.
+func EncodeSensorData(data *protosensor.SensorData) ([]byte, error) {
+	return proto.Marshal(&protosensor.PublishReadingsRequest{
+		SensorData: []*protosensor.SensorData{data},
+	})
+}
+
+// EncodeMetricsData encodes metrics data as a payload for Publish.
+func EncodeMetricsData(data *protosensor.MetricsData) ([]byte, error) {
+	return proto.Marshal(&protosensor.PublishReadingsRequest{
+		MetricsData: []*protosensor.MetricsData{data},
+	})
+}
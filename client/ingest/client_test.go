@@ -0,0 +1,170 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any)            {}
+func (m *mockLogger) Info(msg string, args ...any)             {}
+func (m *mockLogger) Warn(msg string, args ...any)             {}
+func (m *mockLogger) Error(msg string, args ...any)            {}
+func (m *mockLogger) WithContext(args ...any) logger.Interface { return m }
+
+// mockIngestServer records the requests it receives and fails them with err.
+type mockIngestServer struct {
+	protosensor.UnimplementedIngestServiceServer
+
+	mu       sync.Mutex
+	requests []*protosensor.PublishReadingsRequest
+	metadata []metadata.MD
+	err      error
+}
+
+func (m *mockIngestServer) PublishReadings(ctx context.Context, request *protosensor.PublishReadingsRequest) (*protosensor.PublishReadingsResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, request)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	m.metadata = append(m.metadata, md)
+
+	return &protosensor.PublishReadingsResponse{
+		Accepted: uint64(len(request.GetSensorData()) + len(request.GetMetricsData())),
+	}, nil
+}
+
+func newTestClient(t *testing.T, server *mockIngestServer, options ...ClientOption) *Client {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	grpcServer := grpc.NewServer()
+	protosensor.RegisterIngestServiceServer(grpcServer, server)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	options = append(options, WithDialOptions(
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	))
+
+	client, err := NewClient(&mockLogger{}, "passthrough:///bufnet", options...)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestClient_Publish(t *testing.T) {
+	server := &mockIngestServer{}
+	client := newTestClient(t, server)
+
+	payload, err := EncodeSensorData(&protosensor.SensorData{SensorId: "sensor-1", Humidity: 40, Temperature: 21.5, Timestamp: 1700000000})
+	if err != nil {
+		t.Fatalf("EncodeSensorData() error = %v", err)
+	}
+
+	if err := client.Publish("ignored", payload, 1, false); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	payload, err = EncodeMetricsData(&protosensor.MetricsData{SensorId: "sensor-1", CpuUsage: 12})
+	if err != nil {
+		t.Fatalf("EncodeMetricsData() error = %v", err)
+	}
+
+	if err := client.Publish("ignored", payload, 1, false); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	if len(server.requests) != 2 {
+		t.Fatalf("server received %d requests, want 2", len(server.requests))
+	}
+
+	sensorData := server.requests[0].GetSensorData()
+	if len(sensorData) != 1 || sensorData[0].GetSensorId() != "sensor-1" || sensorData[0].GetTemperature() != 21.5 {
+		t.Errorf("sensor data = %v", sensorData)
+	}
+
+	metricsData := server.requests[1].GetMetricsData()
+	if len(metricsData) != 1 || metricsData[0].GetCpuUsage() != 12 {
+		t.Errorf("metrics data = %v", metricsData)
+	}
+}
+
+func TestClient_Publish_SendsDeviceToken(t *testing.T) {
+	server := &mockIngestServer{}
+	client := newTestClient(t, server, WithDeviceToken("sensor-1", "secret"))
+
+	if err := client.Publish("ignored", mustEncode(t), 1, false); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	md := server.metadata[0]
+	if got := md.Get(deviceIDMetadata); len(got) != 1 || got[0] != "sensor-1" {
+		t.Errorf("%s = %v, want [sensor-1]", deviceIDMetadata, got)
+	}
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer secret" {
+		t.Errorf("authorization = %v, want [Bearer secret]", got)
+	}
+}
+
+func TestClient_Publish_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		serverErr error
+		payload   []byte
+	}{
+		{
+			name:      "rejected by the service",
+			serverErr: status.Error(codes.Unavailable, "broker down"),
+			payload:   mustEncode(t),
+		},
+		{
+			name:    "invalid payload",
+			payload: []byte{0xff, 0xff},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, &mockIngestServer{err: tt.serverErr})
+
+			if err := client.Publish("ignored", tt.payload, 1, false); err == nil {
+				t.Error("Publish() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func mustEncode(t *testing.T) []byte {
+	t.Helper()
+
+	payload, err := EncodeSensorData(&protosensor.SensorData{SensorId: "sensor-1"})
+	if err != nil {
+		t.Fatalf("EncodeSensorData() error = %v", err)
+	}
+
+	return payload
+}
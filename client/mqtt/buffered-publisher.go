@@ -8,9 +8,15 @@ import (
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 )
 
+// Publisher sends a payload to a topic. Client publishes over MQTT; the
+// ingest package has a gRPC implementation.
+type Publisher interface {
+	Publish(topic string, payload []byte, qos int, retained bool) error
+}
+
 type BufferedPublisher[T any] struct {
 	Logger             logger.Interface
-	Client             *Client
+	Client             Publisher
 	Metrics            *Metrics
 	Queue              *queue.Queue[T]
 	MessageTransformer func(T) ([]byte, error)
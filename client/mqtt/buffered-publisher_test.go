@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/client/queue"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/mqttbroker"
 	mqttProvider "github.com/eclipse/paho.mqtt.golang"
 )
 
@@ -34,6 +35,10 @@ func (m *mockToken) Wait() bool {
 	return true
 }
 
+func (m *mockToken) WaitTimeout(time.Duration) bool {
+	return true
+}
+
 func (m *mockToken) Error() error {
 	return m.err
 }
@@ -79,13 +84,13 @@ func TestBufferedPublisher_Run(t *testing.T) {
 				queue.WithBackoff[string](backoffConfig),
 			)
 
-			transformer := func(msg string) string {
-				return "transformed: " + msg
+			transformer := func(msg string) ([]byte, error) {
+				return []byte("transformed: " + msg), nil
 			}
 
 			publisher := BufferedPublisher[string]{
 				Logger:             logger,
-				Client:             &Client{client: mockMQTT, logger: logger},
+				Client:             &Client{client: mockMQTT, logger: logger, options: &ClientOptions{}},
 				Metrics:            metrics,
 				Queue:              q,
 				MessageTransformer: transformer,
@@ -142,13 +147,13 @@ func TestBufferedPublisher_MessageTransformer(t *testing.T) {
 	metrics := NewMetrics("test/topic")
 	q := queue.New[int](queue.WithCapacity[int](10))
 
-	transformer := func(msg int) string {
-		return "number: " + string(rune(msg+'0'))
+	transformer := func(msg int) ([]byte, error) {
+		return []byte("number: " + string(rune(msg+'0'))), nil
 	}
 
 	publisher := BufferedPublisher[int]{
 		Logger:             logger,
-		Client:             &Client{client: mockMQTT, logger: logger},
+		Client:             &Client{client: mockMQTT, logger: logger, options: &ClientOptions{}},
 		Metrics:            metrics,
 		Queue:              q,
 		MessageTransformer: transformer,
@@ -184,10 +189,10 @@ func TestBufferedPublisher_Close(t *testing.T) {
 
 	publisher := BufferedPublisher[string]{
 		Logger:             logger,
-		Client:             &Client{client: mockMQTT, logger: logger},
+		Client:             &Client{client: mockMQTT, logger: logger, options: &ClientOptions{}},
 		Metrics:            metrics,
 		Queue:              q,
-		MessageTransformer: func(msg string) string { return msg },
+		MessageTransformer: func(msg string) ([]byte, error) { return []byte(msg), nil },
 		QoS:                1,
 		Topic:              "test/topic",
 	}
@@ -210,10 +215,10 @@ func TestBufferedPublisher_MultipleMessages(t *testing.T) {
 
 	publisher := BufferedPublisher[string]{
 		Logger:             logger,
-		Client:             &Client{client: mockMQTT, logger: logger},
+		Client:             &Client{client: mockMQTT, logger: logger, options: &ClientOptions{}},
 		Metrics:            metrics,
 		Queue:              q,
-		MessageTransformer: func(msg string) string { return msg },
+		MessageTransformer: func(msg string) ([]byte, error) { return []byte(msg), nil },
 		QoS:                1,
 		Topic:              "test/topic",
 	}
@@ -243,3 +248,112 @@ func TestBufferedPublisher_MultipleMessages(t *testing.T) {
 	cancel()
 	q.Close()
 }
+
+func TestBufferedPublisher_RetriesWithEmbeddedBroker(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault func(*mqttbroker.Broker)
+		// wantRetry is whether the publisher has to publish the message again.
+		wantRetry bool
+	}{
+		{
+			name:      "latency",
+			fault:     func(b *mqttbroker.Broker) { b.InjectLatency(100 * time.Millisecond) },
+			wantRetry: false,
+		},
+		{
+			name:      "lost puback",
+			fault:     func(b *mqttbroker.Broker) { b.DropPubacks(1) },
+			wantRetry: true,
+		},
+		{
+			name:      "disconnect",
+			fault:     func(b *mqttbroker.Broker) { b.Disconnect("test-client") },
+			wantRetry: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &mockLogger{}
+			broker := startBroker(t)
+
+			received := make(chan string, 10)
+			if err := broker.Subscribe("test/topic", func(topic string, payload []byte) {
+				received <- string(payload)
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			client, err := NewClient(logger, broker.URL(), "test-client", "user", "password", WithPublishTimeout(300*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			metrics := NewMetrics("test/topic")
+			q := queue.New[string](
+				queue.WithCapacity[string](10),
+				queue.WithBackoff[string](queue.BackoffConfig{
+					Base:       50 * time.Millisecond,
+					Factor:     2,
+					MaxDelay:   time.Second,
+					MaxRetries: 5,
+				}),
+			)
+
+			publisher := BufferedPublisher[string]{
+				Logger:             logger,
+				Client:             client,
+				Metrics:            metrics,
+				Queue:              q,
+				MessageTransformer: func(msg string) ([]byte, error) { return []byte(msg), nil },
+				QoS:                1,
+				Topic:              "test/topic",
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tt.fault(broker)
+
+			go publisher.Run(ctx)
+			defer q.Close()
+
+			if err := q.Enqueue(queue.Message[string]{Data: "reading"}); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case msg := <-received:
+				if msg != "reading" {
+					t.Errorf("received %q, want %q", msg, "reading")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("message was never delivered")
+			}
+
+			// A lost PUBACK makes the publisher retry a message that was
+			// already delivered, so it arrives twice.
+			if tt.wantRetry {
+				select {
+				case <-received:
+				case <-time.After(5 * time.Second):
+					t.Fatal("message was not published again after its PUBACK was lost")
+				}
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for metrics.GetNumberOfMessages()-metrics.GetNumberOfErrors() < 1 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if tt.wantRetry && metrics.GetNumberOfErrors() == 0 {
+				t.Error("the lost PUBACK was not counted as an error")
+			}
+			if metrics.GetNumberOfMessages()-metrics.GetNumberOfErrors() != 1 {
+				t.Errorf("successful publishes = %d, want 1", metrics.GetNumberOfMessages()-metrics.GetNumberOfErrors())
+			}
+		})
+	}
+}
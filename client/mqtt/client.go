@@ -2,14 +2,41 @@ package mqtt
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 	mqttProvider "github.com/eclipse/paho.mqtt.golang"
 )
 
 type Client struct {
-	client mqttProvider.Client
-	logger logger.Interface
+	client  mqttProvider.Client
+	logger  logger.Interface
+	options *ClientOptions
+
+	mu            sync.Mutex
+	subscriptions map[string]subscription
+}
+
+type subscription struct {
+	qos     byte
+	handler func(payload []byte)
+}
+
+type ClientOptions struct {
+	publishTimeout time.Duration
+}
+
+type ClientOption func(*ClientOptions)
+
+// WithPublishTimeout sets how long Publish waits for the broker to
+// acknowledge a message before giving up, so a lost PUBACK fails the publish
+// instead of blocking it forever. By default Publish waits until the message
+// is acknowledged.
+func WithPublishTimeout(publishTimeout time.Duration) ClientOption {
+	return func(options *ClientOptions) {
+		options.publishTimeout = publishTimeout
+	}
 }
 
 func NewClient(
@@ -18,7 +45,20 @@ func NewClient(
 	clientID string,
 	user string,
 	password string,
+	clientOptions ...ClientOption,
 ) (*Client, error) {
+	defaultOptions := &ClientOptions{}
+
+	for _, option := range clientOptions {
+		option(defaultOptions)
+	}
+
+	c := &Client{
+		logger:        logger,
+		options:       defaultOptions,
+		subscriptions: make(map[string]subscription),
+	}
+
 	options := mqttProvider.NewClientOptions()
 	options.AddBroker(broker)
 	options.SetClientID(clientID)
@@ -27,28 +67,26 @@ func NewClient(
 
 	options.OnConnect = func(client mqttProvider.Client) {
 		logger.Debug("Connected to MQTT broker")
+		c.resubscribe()
 	}
 
 	options.OnConnectionLost = func(client mqttProvider.Client, err error) {
 		logger.Error("Connection Lost", "error", err.Error())
 	}
 
-	client := mqttProvider.NewClient(options)
+	c.client = mqttProvider.NewClient(options)
 
 	logger.Debug("Connecting to MQTT broker",
 		"broker", broker,
 		"clientID", clientID,
 	)
-	token := client.Connect()
+	token := c.client.Connect()
 
 	if token.Wait() && token.Error() != nil {
 		return nil, fmt.Errorf("failed to connect to MQTT broker: %s", token.Error())
 	}
 
-	return &Client{
-		client: client,
-		logger: logger,
-	}, nil
+	return c, nil
 }
 
 func (c *Client) Publish(topic string, payload []byte, qos int, retained bool) error {
@@ -59,6 +97,10 @@ func (c *Client) Publish(topic string, payload []byte, qos int, retained bool) e
 		payload,
 	)
 
+	if c.options.publishTimeout > 0 && !token.WaitTimeout(c.options.publishTimeout) {
+		return fmt.Errorf("timed out waiting for the broker to acknowledge the message after %s", c.options.publishTimeout)
+	}
+
 	if token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
@@ -66,6 +108,48 @@ func (c *Client) Publish(topic string, payload []byte, qos int, retained bool) e
 	return nil
 }
 
+// Subscribe calls handler with the payload of every message published to
+// topic. The subscription is renewed every time the client reconnects, since
+// the broker forgets it with the session.
+func (c *Client) Subscribe(topic string, qos int, handler func(payload []byte)) error {
+	c.mu.Lock()
+	c.subscriptions[topic] = subscription{qos: byte(qos), handler: handler}
+	c.mu.Unlock()
+
+	return c.subscribe(topic, byte(qos), handler)
+}
+
+func (c *Client) subscribe(topic string, qos byte, handler func(payload []byte)) error {
+	token := c.client.Subscribe(topic, qos, func(_ mqttProvider.Client, msg mqttProvider.Message) {
+		handler(msg.Payload())
+	})
+
+	if c.options.publishTimeout > 0 && !token.WaitTimeout(c.options.publishTimeout) {
+		return fmt.Errorf("timed out waiting for the broker to acknowledge the subscription to %s after %s", topic, c.options.publishTimeout)
+	}
+
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+
+	return nil
+}
+
+func (c *Client) resubscribe() {
+	c.mu.Lock()
+	subscriptions := make(map[string]subscription, len(c.subscriptions))
+	for topic, s := range c.subscriptions {
+		subscriptions[topic] = s
+	}
+	c.mu.Unlock()
+
+	for topic, s := range subscriptions {
+		if err := c.subscribe(topic, s.qos, s.handler); err != nil {
+			c.logger.Error("Failed to renew subscription", "error", err, "topic", topic)
+		}
+	}
+}
+
 func (c *Client) Close() error {
 	c.client.Disconnect(1000)
 	return nil
@@ -1,61 +1,83 @@
 package mqtt
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/mqttbroker"
+)
+
+func startBroker(t *testing.T) *mqttbroker.Broker {
+	t.Helper()
+
+	broker := mqttbroker.New(&mockLogger{})
+	if err := broker.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	return broker
+}
 
 func TestNewClient_InvalidBroker(t *testing.T) {
 	logger := &mockLogger{}
-	_, err := NewClient(logger, "invalid://broker", "test-client")
+	_, err := NewClient(logger, "invalid://broker", "test-client", "user", "password")
 	if err == nil {
 		t.Error("NewClient() with invalid broker should return error")
 	}
 }
 
 func TestClient_Publish(t *testing.T) {
-	logger := &mockLogger{}
-
 	tests := []struct {
-		name     string
-		broker   string
-		clientID string
-		wantErr  bool
+		name    string
+		fault   func(*mqttbroker.Broker)
+		wantErr bool
 	}{
 		{
-			name:     "invalid broker",
-			broker:   "invalid://broker",
-			clientID: "test-client",
-			wantErr:  true,
+			name:    "acknowledged",
+			fault:   func(b *mqttbroker.Broker) {},
+			wantErr: false,
+		},
+		{
+			name:    "slow acknowledgement",
+			fault:   func(b *mqttbroker.Broker) { b.InjectLatency(100 * time.Millisecond) },
+			wantErr: false,
+		},
+		{
+			name:    "lost acknowledgement",
+			fault:   func(b *mqttbroker.Broker) { b.DropPubacks(1) },
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			client, err := NewClient(logger, tt.broker, tt.clientID)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if tt.wantErr {
-				return
+			logger := &mockLogger{}
+			broker := startBroker(t)
+
+			client, err := NewClient(logger, broker.URL(), "test-client", "user", "password", WithPublishTimeout(500*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
 			}
+			defer client.Close()
+
+			tt.fault(broker)
 
-			err = client.Publish("test/topic", "test payload", 1, false)
+			err = client.Publish("test/topic", []byte("test payload"), 1, false)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Publish() error = %v, wantErr %v", err, tt.wantErr)
 			}
-
-			if client != nil {
-				client.Close()
-			}
 		})
 	}
 }
 
 func TestClient_Close(t *testing.T) {
 	logger := &mockLogger{}
+	broker := startBroker(t)
 
-	client, err := NewClient(logger, "tcp://test.mosquitto.org:1883", "test-client")
+	client, err := NewClient(logger, broker.URL(), "test-client", "user", "password")
 	if err != nil {
-		t.Skipf("Skipping test: cannot connect to broker: %v", err)
+		t.Fatal(err)
 	}
 
 	err = client.Close()
@@ -63,3 +85,56 @@ func TestClient_Close(t *testing.T) {
 		t.Errorf("Close() error = %v, want nil", err)
 	}
 }
+
+func TestClient_Subscribe(t *testing.T) {
+	logger := &mockLogger{}
+	broker := startBroker(t)
+
+	client, err := NewClient(logger, broker.URL(), "test-client", "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	received := make(chan string, 10)
+	if err := client.Subscribe("test/topic", 1, func(payload []byte) {
+		select {
+		case received <- string(payload):
+		default:
+		}
+	}); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	expect := func(want string) {
+		t.Helper()
+
+		deadline := time.After(5 * time.Second)
+		for {
+			if err := broker.Publish("test/topic", []byte(want)); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case got := <-received:
+				// Earlier retries may still be delivered.
+				if got == want {
+					return
+				}
+			case <-time.After(100 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("did not receive %q", want)
+			}
+		}
+	}
+
+	expect("before")
+
+	// The broker forgets the subscription with the session, so the client
+	// subscribes again once it reconnects.
+	if !broker.Disconnect("test-client") {
+		t.Fatal("client was not connected")
+	}
+
+	expect("after")
+}
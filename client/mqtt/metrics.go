@@ -41,11 +41,11 @@ func (m *Metrics) GetAvgPublishingTime() time.Duration {
 }
 
 func (m *Metrics) GetNumberOfErrors() int64 {
-	return m.numberOfErrors
+	return atomic.LoadInt64(&m.numberOfErrors)
 }
 
 func (m *Metrics) GetNumberOfMessages() int64 {
-	return m.numberOfMessages
+	return atomic.LoadInt64(&m.numberOfMessages)
 }
 
 func (m *Metrics) Print(logger logger.Interface) {
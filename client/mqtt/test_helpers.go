@@ -1,15 +1,26 @@
 package mqtt
 
-import "github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+import (
+	"sync"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+)
 
 type mockLogger struct {
+	mu   sync.Mutex
 	logs []string
 }
 
-func (m *mockLogger) Debug(msg string, args ...any)            { m.logs = append(m.logs, "debug: "+msg) }
-func (m *mockLogger) Info(msg string, args ...any)             { m.logs = append(m.logs, "info: "+msg) }
-func (m *mockLogger) Warn(msg string, args ...any)             { m.logs = append(m.logs, "warn: "+msg) }
-func (m *mockLogger) Error(msg string, args ...any)            { m.logs = append(m.logs, "error: "+msg) }
+func (m *mockLogger) log(line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logs = append(m.logs, line)
+}
+
+func (m *mockLogger) Debug(msg string, args ...any)            { m.log("debug: " + msg) }
+func (m *mockLogger) Info(msg string, args ...any)             { m.log("info: " + msg) }
+func (m *mockLogger) Warn(msg string, args ...any)             { m.log("warn: " + msg) }
+func (m *mockLogger) Error(msg string, args ...any)            { m.log("error: " + msg) }
 func (m *mockLogger) WithContext(args ...any) logger.Interface { return m }
 
 var _ logger.Interface = (*mockLogger)(nil)
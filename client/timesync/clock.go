@@ -0,0 +1,244 @@
+package timesync
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// DeviceIDPlaceholder is replaced by the device ID in the response topic.
+const DeviceIDPlaceholder = "{device_id}"
+
+// Client is the transport time requests and responses go through. It is
+// satisfied by *mqtt.Client.
+type Client interface {
+	Publish(topic string, payload []byte, qos int, retained bool) error
+	Subscribe(topic string, qos int, handler func(payload []byte)) error
+}
+
+type Options struct {
+	interval     time.Duration
+	samples      int
+	maxRoundTrip time.Duration
+	now          func() time.Time
+}
+
+type Option func(*Options)
+
+// WithInterval sets how often the clock sends a time request.
+func WithInterval(interval time.Duration) Option {
+	return func(options *Options) {
+		options.interval = interval
+	}
+}
+
+// WithSamples sets how many of the latest responses the offset is picked
+// from.
+func WithSamples(samples int) Option {
+	return func(options *Options) {
+		options.samples = samples
+	}
+}
+
+// WithMaxRoundTrip sets the longest round trip of a response that is still
+// used. Slower responses say little about the offset.
+func WithMaxRoundTrip(maxRoundTrip time.Duration) Option {
+	return func(options *Options) {
+		options.maxRoundTrip = maxRoundTrip
+	}
+}
+
+// WithClock sets the function returning the local time, time.Now by default.
+func WithClock(now func() time.Time) Option {
+	return func(options *Options) {
+		options.now = now
+	}
+}
+
+type sample struct {
+	offset    time.Duration
+	roundTrip time.Duration
+}
+
+// Clock keeps the local time in sync with the server, NTP style. It sends
+// time requests and, from the times of each response, estimates the offset of
+// the local clock and the round trip of the exchange. The offset of the
+// response with the shortest round trip among the latest ones is used, since
+// it was delayed the least by the network.
+type Clock struct {
+	client        Client
+	deviceID      string
+	requestTopic  string
+	responseTopic string
+	logger        logger.Interface
+	options       *Options
+
+	mu      sync.Mutex
+	samples []sample
+	next    int
+	offset  time.Duration
+	synced  bool
+}
+
+// NewClock sends requests to requestTopic and expects the responses on
+// responseTopic, after replacing DeviceIDPlaceholder by the device ID.
+func NewClock(client Client, deviceID, requestTopic, responseTopic string, logger logger.Interface, options ...Option) *Clock {
+	defaultOptions := &Options{
+		interval:     time.Minute,
+		samples:      8,
+		maxRoundTrip: 10 * time.Second,
+		now:          time.Now,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	if defaultOptions.interval <= 0 {
+		defaultOptions.interval = time.Minute
+	}
+
+	if defaultOptions.samples < 1 {
+		defaultOptions.samples = 8
+	}
+
+	if defaultOptions.maxRoundTrip <= 0 {
+		defaultOptions.maxRoundTrip = 10 * time.Second
+	}
+
+	return &Clock{
+		client:        client,
+		deviceID:      deviceID,
+		requestTopic:  requestTopic,
+		responseTopic: strings.ReplaceAll(responseTopic, DeviceIDPlaceholder, deviceID),
+		logger:        logger,
+		options:       defaultOptions,
+		samples:       make([]sample, 0, defaultOptions.samples),
+	}
+}
+
+// Now returns the local time corrected by the estimated offset. Until a
+// response arrives it is the local time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	offset := c.offset
+	c.mu.Unlock()
+
+	return c.options.now().Add(offset)
+}
+
+// Offset returns the estimated offset of the local clock, positive when it is
+// behind the server, and whether a response arrived yet.
+func (c *Clock) Offset() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset, c.synced
+}
+
+// Run subscribes to the responses and sends a request right away and then
+// every interval, until ctx is cancelled.
+func (c *Clock) Run(ctx context.Context) error {
+	if err := c.client.Subscribe(c.responseTopic, 0, c.handle); err != nil {
+		return fmt.Errorf("failed to subscribe to time responses: %w", err)
+	}
+
+	ticker := time.NewTicker(c.options.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.request(); err != nil {
+			c.logger.Warn("Failed to send time request", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// request is published with QoS 0, since a late request only yields a
+// response that is discarded for its round trip.
+func (c *Clock) request() error {
+	body, err := proto.Marshal(&protosensor.TimeRequest{
+		SensorId:         c.deviceID,
+		ClientSendTimeMs: c.options.now().UnixMilli(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal time request: %w", err)
+	}
+
+	return c.client.Publish(c.requestTopic, []byte(base64.StdEncoding.EncodeToString(body)), 0, false)
+}
+
+func (c *Clock) handle(payload []byte) {
+	received := c.options.now()
+
+	decoded, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		c.logger.Warn("Failed to decode time response", "error", err)
+		return
+	}
+
+	var response protosensor.TimeResponse
+	if err := proto.Unmarshal(decoded, &response); err != nil {
+		c.logger.Warn("Failed to parse time response", "error", err)
+		return
+	}
+
+	if response.SensorId != c.deviceID {
+		return
+	}
+
+	t0 := time.UnixMilli(response.ClientSendTimeMs)
+	t1 := time.UnixMilli(response.ServerReceiveTimeMs)
+	t2 := time.UnixMilli(response.ServerSendTimeMs)
+	t3 := received
+
+	s := sample{
+		offset:    (t1.Sub(t0) + t2.Sub(t3)) / 2,
+		roundTrip: t3.Sub(t0) - t2.Sub(t1),
+	}
+
+	if s.roundTrip < 0 || s.roundTrip > c.options.maxRoundTrip {
+		c.logger.Debug("Discarding time response", "round_trip", s.roundTrip)
+		return
+	}
+
+	offset := c.add(s)
+
+	c.logger.Debug("Synchronized clock", "offset", offset, "round_trip", s.roundTrip)
+}
+
+func (c *Clock) add(s sample) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.samples) < c.options.samples {
+		c.samples = append(c.samples, s)
+	} else {
+		c.samples[c.next] = s
+		c.next = (c.next + 1) % c.options.samples
+	}
+
+	best := c.samples[0]
+	for _, candidate := range c.samples[1:] {
+		if candidate.roundTrip < best.roundTrip {
+			best = candidate
+		}
+	}
+
+	c.offset = best.offset
+	c.synced = true
+
+	return c.offset
+}
@@ -0,0 +1,265 @@
+package timesync
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+var server = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type mockClient struct {
+	mu           sync.Mutex
+	topics       []string
+	requests     []*protosensor.TimeRequest
+	subscribed   string
+	subscribeErr error
+}
+
+func (m *mockClient) Publish(topic string, payload []byte, qos int, retained bool) error {
+	decoded, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return err
+	}
+
+	var request protosensor.TimeRequest
+	if err := proto.Unmarshal(decoded, &request); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topics = append(m.topics, topic)
+	m.requests = append(m.requests, &request)
+	return nil
+}
+
+func (m *mockClient) Subscribe(topic string, qos int, handler func(payload []byte)) error {
+	m.subscribed = topic
+	return m.subscribeErr
+}
+
+func (m *mockClient) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.requests)
+}
+
+// exchange is one request and response, with t0 and t3 on the local clock
+// and t1 and t2 on the server clock.
+type exchange struct {
+	deviceID       string
+	t0, t1, t2, t3 time.Time
+}
+
+func (e exchange) payload(t *testing.T) []byte {
+	t.Helper()
+
+	body, err := proto.Marshal(&protosensor.TimeResponse{
+		SensorId:            e.deviceID,
+		ClientSendTimeMs:    e.t0.UnixMilli(),
+		ServerReceiveTimeMs: e.t1.UnixMilli(),
+		ServerSendTimeMs:    e.t2.UnixMilli(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(body))
+}
+
+// symmetric is an exchange with the local clock offset from the server, the
+// same delay both ways and the server taking 50ms to answer.
+func symmetric(offset, delay time.Duration) exchange {
+	t1 := server
+	t2 := t1.Add(50 * time.Millisecond)
+
+	return exchange{
+		deviceID: "device-1",
+		t0:       t1.Add(-delay).Add(-offset),
+		t1:       t1,
+		t2:       t2,
+		t3:       t2.Add(delay).Add(-offset),
+	}
+}
+
+func TestClock_Handle(t *testing.T) {
+	tests := []struct {
+		name       string
+		exchanges  []exchange
+		wantOffset time.Duration
+		wantSynced bool
+	}{
+		{
+			name:       "clock behind",
+			exchanges:  []exchange{symmetric(10*time.Second, 100*time.Millisecond)},
+			wantOffset: 10 * time.Second,
+			wantSynced: true,
+		},
+		{
+			name:       "clock ahead",
+			exchanges:  []exchange{symmetric(-time.Hour, 100*time.Millisecond)},
+			wantOffset: -time.Hour,
+			wantSynced: true,
+		},
+		{
+			name: "shortest round trip wins",
+			exchanges: []exchange{
+				symmetric(10*time.Second, 20*time.Millisecond),
+				// Delayed on the way back only, so its offset is off.
+				func() exchange {
+					e := symmetric(10*time.Second, 20*time.Millisecond)
+					e.t3 = e.t3.Add(2 * time.Second)
+					return e
+				}(),
+			},
+			wantOffset: 10 * time.Second,
+			wantSynced: true,
+		},
+		{
+			name:      "round trip too long",
+			exchanges: []exchange{symmetric(10*time.Second, 6*time.Second)},
+		},
+		{
+			name: "other device",
+			exchanges: []exchange{func() exchange {
+				e := symmetric(10*time.Second, 100*time.Millisecond)
+				e.deviceID = "device-2"
+				return e
+			}()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var local time.Time
+
+			c := NewClock(&mockClient{}, "device-1", "time/request", "time/response/{device_id}", &mockLogger{},
+				WithClock(func() time.Time { return local }),
+			)
+
+			for _, e := range tt.exchanges {
+				local = e.t3
+				c.handle(e.payload(t))
+			}
+
+			offset, synced := c.Offset()
+			if offset != tt.wantOffset || synced != tt.wantSynced {
+				t.Errorf("Offset() = %v, %v, want %v, %v", offset, synced, tt.wantOffset, tt.wantSynced)
+			}
+
+			if want := local.Add(tt.wantOffset); !c.Now().Equal(want) {
+				t.Errorf("Now() = %v, want %v", c.Now(), want)
+			}
+		})
+	}
+}
+
+func TestClock_Handle_SlidingSamples(t *testing.T) {
+	var local time.Time
+
+	c := NewClock(&mockClient{}, "device-1", "time/request", "time/response/{device_id}", &mockLogger{},
+		WithClock(func() time.Time { return local }),
+		WithSamples(2),
+	)
+
+	// The fastest response is forgotten once two newer ones arrived, so a
+	// device whose clock was stepped follows the new offset.
+	for _, e := range []exchange{
+		symmetric(10*time.Second, 10*time.Millisecond),
+		symmetric(time.Second, 100*time.Millisecond),
+		symmetric(time.Second, 200*time.Millisecond),
+	} {
+		local = e.t3
+		c.handle(e.payload(t))
+	}
+
+	if offset, _ := c.Offset(); offset != time.Second {
+		t.Errorf("Offset() = %v, want %v", offset, time.Second)
+	}
+}
+
+func TestClock_InvalidOptions(t *testing.T) {
+	var local time.Time
+
+	c := NewClock(&mockClient{}, "device-1", "time/request", "time/response/{device_id}", &mockLogger{},
+		WithClock(func() time.Time { return local }),
+		WithInterval(0),
+		WithSamples(0),
+		WithMaxRoundTrip(-time.Second),
+	)
+
+	if c.options.interval != time.Minute || c.options.samples != 8 || c.options.maxRoundTrip != 10*time.Second {
+		t.Errorf("options = %+v, want the defaults", c.options)
+	}
+
+	e := symmetric(time.Second, 10*time.Millisecond)
+	local = e.t3
+	c.handle(e.payload(t))
+
+	if offset, synced := c.Offset(); !synced || offset != time.Second {
+		t.Errorf("Offset() = %v, %v, want %v, true", offset, synced, time.Second)
+	}
+}
+
+func TestClock_Run(t *testing.T) {
+	client := &mockClient{}
+
+	c := NewClock(client, "device-1", "time/request", "time/response/{device_id}", &mockLogger{},
+		WithInterval(10*time.Millisecond),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.count() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if client.subscribed != "time/response/device-1" {
+		t.Errorf("subscribed to %q, want %q", client.subscribed, "time/response/device-1")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if len(client.requests) < 2 || client.topics[0] != "time/request" || client.requests[0].SensorId != "device-1" {
+		t.Errorf("published %v to %v, want periodic requests of device-1 to time/request", client.requests, client.topics)
+	}
+}
+
+func TestClock_Run_SubscribeFails(t *testing.T) {
+	client := &mockClient{subscribeErr: errors.New("not authorized")}
+
+	c := NewClock(client, "device-1", "time/request", "time/response/{device_id}", &mockLogger{})
+
+	if err := c.Run(context.Background()); err == nil {
+		t.Error("Run() error = nil")
+	}
+	if client.count() != 0 {
+		t.Errorf("published %d requests, want none", client.count())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:1883", "address the embedded MQTT broker listens on")
+	numDevices := flag.Int("num-devices", 10, "number of simulated devices")
+	store := flag.String("store", "memory", "sensor data store, memory or sqlite")
+	sqlitePath := flag.String("sqlite-path", "devstack.db", "SQLite file used by the sqlite store")
+	metricsAddress := flag.String("metrics-address", ":2112", "address the Prometheus metrics are served on")
+	logLevel := flag.String("log-level", "info", "log level of the broker and workers")
+	deviceLogLevel := flag.String("device-log-level", "warn", "log level of the simulated devices")
+
+	flag.Parse()
+
+	deviceLogger := logger.NewSlogLogger(logger.Config{Level: *deviceLogLevel})
+	logger := logger.NewSlogLogger(logger.Config{Level: *logLevel})
+
+	stack := NewStack(Config{
+		Address:         *address,
+		Devices:         *numDevices,
+		Store:           *store,
+		SQLitePath:      *sqlitePath,
+		MetricsAddress:  *metricsAddress,
+		DataTopic:       "iot.device.data.binary",
+		MetricsTopic:    "iot.device.metrics",
+		ShutdownTimeout: 10 * time.Second,
+	}, logger, deviceLogger)
+
+	if err := stack.Start(); err != nil {
+		log.Fatalf("Failed to start devstack: %v", err)
+	}
+
+	logger.Info("Devstack is running. Press Ctrl+C to stop.",
+		"mqtt", stack.URL(),
+		"metrics_address", *metricsAddress,
+		"store", *store,
+	)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rows, err := stack.StoredRows()
+			if err != nil {
+				logger.Error("Failed to count stored sensor data", "error", err)
+				continue
+			}
+			logger.Info("Stored sensor data", "rows", rows)
+		case <-sig:
+			logger.Info("Shutting down devstack")
+
+			if err := stack.Close(); err != nil {
+				logger.Error("Failed to shut down devstack cleanly", "error", err)
+				os.Exit(1)
+			}
+
+			logger.Info("Devstack stopped")
+			return
+		}
+	}
+}
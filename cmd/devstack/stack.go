@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/client/app"
+	clientconfig "github.com/RicardoCenci/iot-distributed-architecture/client/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/client/device"
+	"github.com/RicardoCenci/iot-distributed-architecture/client/drivers"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/mqttbroker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/mqtt"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/health"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	datahandler "github.com/RicardoCenci/iot-distributed-architecture/workers/data/handler"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/parser"
+	metricshandler "github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/handler"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/prometheus"
+)
+
+// Config configures the development stack.
+type Config struct {
+	// Address is the address the embedded MQTT broker listens on.
+	Address string
+	// Devices is the number of simulated devices.
+	Devices int
+	// Store is where sensor data is stored, "memory" or "sqlite".
+	Store string
+	// SQLitePath is the SQLite file used by the sqlite store.
+	SQLitePath string
+	// MetricsAddress is the address the Prometheus metrics are served on.
+	MetricsAddress string
+	DataTopic      string
+	MetricsTopic   string
+	// ShutdownTimeout is how long Close waits for the workers to drain.
+	ShutdownTimeout time.Duration
+}
+
+// Stack runs the embedded MQTT broker, the data and metrics pipelines of the
+// workers and the simulated devices in a single process.
+type Stack struct {
+	config       Config
+	logger       logger.Interface
+	deviceLogger logger.Interface
+
+	mqttBroker *mqttbroker.Broker
+	store      database.SensorStore
+	writer     *database.BatchWriter
+	prometheus *prometheus.Client
+
+	brokers   []*mqtt.Broker
+	consumers []*consumer.Consumer
+	cancel    context.CancelFunc
+
+	cancelDevices context.CancelFunc
+	devices       sync.WaitGroup
+}
+
+// NewStack creates a stack. Devices log to deviceLogger, which is usually
+// quieter than logger because every device logs its publishing metrics.
+func NewStack(config Config, logger logger.Interface, deviceLogger logger.Interface) *Stack {
+	return &Stack{
+		config:       config,
+		logger:       logger,
+		deviceLogger: deviceLogger,
+	}
+}
+
+// Start starts every component, the devices last so nothing they publish is
+// missed. On failure the components already started are stopped.
+func (s *Stack) Start() error {
+	if err := s.start(); err != nil {
+		s.Close()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Stack) start() error {
+	s.mqttBroker = mqttbroker.New(s.logger, mqttbroker.WithAddress(s.config.Address))
+
+	if err := s.mqttBroker.Start(); err != nil {
+		return fmt.Errorf("failed to start MQTT broker: %w", err)
+	}
+
+	store, err := openStore(s.config)
+	if err != nil {
+		return err
+	}
+	s.store = store
+
+	s.writer = database.NewBatchWriter(store, s.logger)
+
+	s.prometheus = prometheus.NewClient(s.logger, s.config.MetricsAddress)
+
+	s.prometheus.Handle("/healthz", health.Handler(map[string]health.Check{
+		"store": store.Ping,
+	}))
+
+	if err := s.prometheus.Start(); err != nil {
+		return fmt.Errorf("failed to start Prometheus client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	// Readings failing validation are stored flagged rather than dropped, so
+	// they can be inspected.
+	sensorParser, err := parser.NewParser(parser.Rules{Mode: parser.ModeFlag, RequireSensorID: true})
+	if err != nil {
+		return fmt.Errorf("failed to create sensor data parser: %w", err)
+	}
+
+	dataHandler := datahandler.NewHandler(s.writer, sensorParser, s.logger)
+
+	if err := s.consume(ctx, "data-worker", s.config.DataTopic, dataHandler.Handle); err != nil {
+		return err
+	}
+
+	metricsHandler := metricshandler.NewHandler(s.prometheus, s.logger)
+
+	if err := s.consume(ctx, "metrics-worker", s.config.MetricsTopic, metricsHandler.Handle); err != nil {
+		return err
+	}
+
+	s.startDevices()
+
+	return nil
+}
+
+func openStore(config Config) (database.SensorStore, error) {
+	switch config.Store {
+	case "memory":
+		return database.NewMemory(), nil
+	case "sqlite":
+		store, err := database.NewSQLite(config.SQLitePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SQLite store: %w", err)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown store %q, expected memory or sqlite", config.Store)
+	}
+}
+
+// consume connects a worker to the embedded MQTT broker, the same way the
+// workers do with BROKER=mqtt, and starts consuming topic.
+func (s *Stack) consume(ctx context.Context, worker, topic string, handler func(broker.Message) error) error {
+	workerBroker := mqtt.NewBroker(s.mqttBroker.URL(), s.logger, mqtt.WithClientID("devstack-"+worker))
+
+	if err := workerBroker.Connect(); err != nil {
+		return fmt.Errorf("failed to connect %s to MQTT broker: %w", worker, err)
+	}
+	s.brokers = append(s.brokers, workerBroker)
+
+	queue := mqtt.NewQueue(topic, mqtt.WithGroup(worker))
+	workerBroker.SetupQueue(queue)
+
+	workerConsumer := consumer.NewConsumer(
+		workerBroker,
+		s.logger,
+		worker,
+		consumer.WithPrefetchCount(20),
+		consumer.WithWorkers(4),
+	)
+
+	if err := workerConsumer.Start(ctx, queue, handler); err != nil {
+		return fmt.Errorf("failed to start %s consumer: %w", worker, err)
+	}
+	s.consumers = append(s.consumers, workerConsumer)
+
+	return nil
+}
+
+func (s *Stack) startDevices() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancelDevices = cancel
+
+	for i := 0; i < s.config.Devices; i++ {
+		deviceID := fmt.Sprintf("devstack-device-%d", i)
+
+		config := clientconfig.NewConfig(
+			clientconfig.WithDevice(clientconfig.DeviceConfig{ID: deviceID}),
+			clientconfig.WithBroker(s.mqttBroker.URL()),
+			clientconfig.WithCredentials("devstack", "devstack"),
+			clientconfig.WithQoS(1),
+			clientconfig.WithPublishTimeout(10*time.Second),
+			clientconfig.WithTopics(map[clientconfig.Topic]clientconfig.TopicConfig{
+				clientconfig.TopicDataJSON: {Topic: s.config.DataTopic},
+				clientconfig.TopicMetrics:  {Topic: s.config.MetricsTopic},
+			}),
+		)
+
+		device := app.NewApp(config, device.NewDevice(deviceID, drivers.NewRandomDataDriver()), s.deviceLogger)
+
+		s.devices.Add(1)
+		go func() {
+			defer s.devices.Done()
+			device.Run(ctx)
+		}()
+	}
+
+	s.logger.Info("Started simulated devices", "devices", s.config.Devices)
+}
+
+// URL returns the URL of the embedded MQTT broker, for connecting more
+// clients.
+func (s *Stack) URL() string {
+	return s.mqttBroker.URL()
+}
+
+// StoredRows returns the number of sensor data rows stored so far.
+func (s *Stack) StoredRows() (int64, error) {
+	return s.store.CountSensorData()
+}
+
+// Close stops the devices first, then drains the workers and flushes the
+// sensor data still buffered.
+func (s *Stack) Close() error {
+	var errs []error
+
+	if s.cancelDevices != nil {
+		s.cancelDevices()
+		s.devices.Wait()
+	}
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer shutdownCancel()
+
+	for _, c := range s.consumers {
+		if err := c.Wait(shutdownCtx); err != nil {
+			s.logger.Warn("In-flight messages were not drained before the shutdown timeout", "error", err)
+		}
+	}
+
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush buffered sensor data: %w", err))
+		}
+	}
+
+	for _, b := range s.brokers {
+		if err := b.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close broker connection: %w", err))
+		}
+	}
+
+	if s.store != nil {
+		if err := s.store.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close store: %w", err))
+		}
+	}
+
+	if s.prometheus != nil {
+		if err := s.prometheus.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close Prometheus client: %w", err))
+		}
+	}
+
+	if s.mqttBroker != nil {
+		if err := s.mqttBroker.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
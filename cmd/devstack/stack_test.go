@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+// freeAddress returns a loopback address nothing listens on.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func TestStack_DevicesToWorkers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping devstack test in short mode")
+	}
+
+	tests := []struct {
+		name  string
+		store string
+	}{
+		{name: "memory store", store: "memory"},
+		{name: "sqlite store", store: "sqlite"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricsAddress := freeAddress(t)
+
+			stack := NewStack(Config{
+				Address:         "127.0.0.1:0",
+				Devices:         3,
+				Store:           tt.store,
+				SQLitePath:      filepath.Join(t.TempDir(), "devstack.db"),
+				MetricsAddress:  metricsAddress,
+				DataTopic:       "iot.device.data.binary",
+				MetricsTopic:    "iot.device.metrics",
+				ShutdownTimeout: 5 * time.Second,
+			}, &mockLogger{}, &mockLogger{})
+
+			if err := stack.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(10 * time.Second)
+
+			var rows int64
+			for rows < 3 && time.Now().Before(deadline) {
+				time.Sleep(100 * time.Millisecond)
+
+				var err error
+				if rows, err = stack.StoredRows(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if rows < 3 {
+				t.Errorf("stored %d rows, want at least one per device", rows)
+			}
+
+			var metrics string
+			for !strings.Contains(metrics, `device_id="devstack-device-2"`) && time.Now().Before(deadline) {
+				time.Sleep(100 * time.Millisecond)
+				metrics = scrape(t, "http://"+metricsAddress+"/metrics")
+			}
+
+			for i := 0; i < 3; i++ {
+				want := `iot_device_cpu_usage_percent{device_id="devstack-device-` + string(rune('0'+i)) + `"}`
+				if !strings.Contains(metrics, want) {
+					t.Errorf("metrics do not contain %s", want)
+				}
+			}
+
+			if err := stack.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestStack_UnknownStore(t *testing.T) {
+	stack := NewStack(Config{
+		Address:        "127.0.0.1:0",
+		Store:          "cassandra",
+		MetricsAddress: freeAddress(t),
+	}, &mockLogger{}, &mockLogger{})
+
+	if err := stack.Start(); err == nil {
+		stack.Close()
+		t.Error("Start() with an unknown store succeeded")
+	}
+}
+
+func scrape(t *testing.T, url string) string {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
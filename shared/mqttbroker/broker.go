@@ -0,0 +1,175 @@
+package mqttbroker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// ErrInjectedDisconnect is the reason given to clients disconnected by
+// Disconnect and DisconnectAll.
+var ErrInjectedDisconnect = errors.New("injected disconnect")
+
+// Broker is an in-process MQTT broker, so tests and local tools can run
+// without an external broker or internet access.
+//
+// Every client is allowed to connect, whatever its credentials, and to
+// publish and subscribe to any topic. Faults such as latency, lost PUBACKs
+// and disconnections can be injected while it runs.
+type Broker struct {
+	logger  logger.Interface
+	options *Options
+	server  *mqttserver.Server
+	tcp     *listeners.TCP
+	faults  *faultHook
+
+	mu             sync.Mutex
+	subscriptionID int
+}
+
+type Options struct {
+	address string
+}
+
+type Option func(*Options)
+
+// WithAddress sets the TCP address the broker listens on. It defaults to a
+// random port on the loopback interface.
+func WithAddress(address string) Option {
+	return func(options *Options) {
+		options.address = address
+	}
+}
+
+func New(logger logger.Interface, options ...Option) *Broker {
+	defaultOptions := &Options{
+		address: "127.0.0.1:0",
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Broker{
+		logger:  logger,
+		options: defaultOptions,
+		faults:  &faultHook{},
+	}
+}
+
+// Start starts listening and serving clients. It returns once the listener is
+// bound, so URL can be used right away.
+func (b *Broker) Start() error {
+	server := mqttserver.New(&mqttserver.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		return fmt.Errorf("failed to add auth hook: %w", err)
+	}
+
+	if err := server.AddHook(b.faults, nil); err != nil {
+		return fmt.Errorf("failed to add fault hook: %w", err)
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: b.options.address})
+	if err := server.AddListener(tcp); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", b.options.address, err)
+	}
+
+	b.faults.mu.Lock()
+	b.faults.server = server
+	b.faults.mu.Unlock()
+
+	b.server = server
+	b.tcp = tcp
+
+	go func() {
+		if err := server.Serve(); err != nil {
+			b.logger.Error("MQTT broker stopped serving", "error", err)
+		}
+	}()
+
+	b.logger.Info("Embedded MQTT broker started", "url", b.URL())
+	return nil
+}
+
+// URL returns the URL clients connect to, for example tcp://127.0.0.1:38211.
+func (b *Broker) URL() string {
+	return "tcp://" + b.tcp.Address()
+}
+
+// Subscribe calls handler for every message published to a topic matching
+// filter. Handlers run on the connection of the publishing client, so they
+// must not block.
+func (b *Broker) Subscribe(filter string, handler func(topic string, payload []byte)) error {
+	b.mu.Lock()
+	b.subscriptionID++
+	id := b.subscriptionID
+	b.mu.Unlock()
+
+	err := b.server.Subscribe(filter, id, func(_ *mqttserver.Client, _ packets.Subscription, pk packets.Packet) {
+		handler(pk.TopicName, pk.Payload)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", filter, err)
+	}
+
+	return nil
+}
+
+// Publish publishes payload to topic as the broker itself.
+func (b *Broker) Publish(topic string, payload []byte) error {
+	if err := b.server.Publish(topic, payload, false, 1); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+// Disconnect drops the connection of the client with clientID, as if the
+// network failed. It returns false if no such client is connected.
+func (b *Broker) Disconnect(clientID string) bool {
+	client, ok := b.server.Clients.Get(clientID)
+	if !ok || client.Net.Inline || client.Closed() {
+		return false
+	}
+
+	client.Stop(ErrInjectedDisconnect)
+	return true
+}
+
+// DisconnectAll drops the connection of every client and returns how many
+// were disconnected.
+func (b *Broker) DisconnectAll() int {
+	n := 0
+	for id := range b.server.Clients.GetAll() {
+		if b.Disconnect(id) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Close disconnects every client and stops the broker.
+func (b *Broker) Close() error {
+	if b.server == nil {
+		return nil
+	}
+
+	if err := b.server.Close(); err != nil {
+		return fmt.Errorf("failed to close MQTT broker: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package mqttbroker
+
+import (
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+func startBroker(t *testing.T) *Broker {
+	t.Helper()
+
+	b := New(&mockLogger{})
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return b
+}
+
+func connect(t *testing.T, b *Broker, clientID string) paho.Client {
+	t.Helper()
+
+	client := paho.NewClient(paho.NewClientOptions().
+		AddBroker(b.URL()).
+		SetClientID(clientID).
+		SetUsername("user").
+		SetPassword("password").
+		SetAutoReconnect(false))
+
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+
+	return client
+}
+
+func subscribe(t *testing.T, b *Broker, filter string) <-chan string {
+	t.Helper()
+
+	received := make(chan string, 10)
+	if err := b.Subscribe(filter, func(topic string, payload []byte) {
+		received <- topic + " " + string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return received
+}
+
+func receive(t *testing.T, received <-chan string) string {
+	t.Helper()
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return ""
+	}
+}
+
+func TestBroker_PublishAndSubscribe(t *testing.T) {
+	b := startBroker(t)
+	received := subscribe(t, b, "iot/#")
+
+	client := connect(t, b, "device")
+
+	token := client.Publish("iot/data", 1, false, "hello")
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to publish: %v", token.Error())
+	}
+
+	if got := receive(t, received); got != "iot/data hello" {
+		t.Errorf("received %q, want %q", got, "iot/data hello")
+	}
+
+	if err := b.Publish("iot/command", []byte("reboot")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := receive(t, received); got != "iot/command reboot" {
+		t.Errorf("received %q, want %q", got, "iot/command reboot")
+	}
+}
+
+func TestBroker_InjectLatency(t *testing.T) {
+	b := startBroker(t)
+	client := connect(t, b, "device")
+
+	b.InjectLatency(200 * time.Millisecond)
+
+	start := time.Now()
+	token := client.Publish("iot/data", 1, false, "slow")
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to publish: %v", token.Error())
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("PUBACK arrived after %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestBroker_DropPubacks(t *testing.T) {
+	b := startBroker(t)
+	received := subscribe(t, b, "iot/data")
+	client := connect(t, b, "device")
+
+	b.DropPubacks(1)
+
+	token := client.Publish("iot/data", 1, false, "lost")
+	if token.WaitTimeout(300 * time.Millisecond) {
+		t.Fatalf("PUBACK received, want it dropped (error %v)", token.Error())
+	}
+
+	// The message is delivered even though its PUBACK is lost.
+	if got := receive(t, received); got != "iot/data lost" {
+		t.Errorf("received %q, want %q", got, "iot/data lost")
+	}
+
+	token = client.Publish("iot/data", 1, false, "acked")
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to publish after the dropped PUBACK: %v", token.Error())
+	}
+}
+
+func TestBroker_Disconnect(t *testing.T) {
+	b := startBroker(t)
+
+	lost := make(chan error, 1)
+	client := paho.NewClient(paho.NewClientOptions().
+		AddBroker(b.URL()).
+		SetClientID("device").
+		SetAutoReconnect(false).
+		SetConnectionLostHandler(func(_ paho.Client, err error) { lost <- err }))
+
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect: %v", token.Error())
+	}
+	defer client.Disconnect(0)
+
+	if b.Disconnect("unknown") {
+		t.Error("Disconnect() of an unknown client returned true")
+	}
+
+	if !b.Disconnect("device") {
+		t.Fatal("Disconnect() returned false")
+	}
+
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not lose its connection")
+	}
+
+	if n := b.DisconnectAll(); n != 0 {
+		t.Errorf("DisconnectAll() = %d, want 0", n)
+	}
+}
@@ -0,0 +1,79 @@
+package mqttbroker
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+// faultHook injects faults into the messages published by clients. Messages
+// published by the broker itself are left alone.
+type faultHook struct {
+	mqttserver.HookBase
+
+	mu          sync.Mutex
+	server      *mqttserver.Server
+	latency     time.Duration
+	dropPubacks int
+}
+
+func (h *faultHook) ID() string {
+	return "faults"
+}
+
+func (h *faultHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{mqttserver.OnPublish}, []byte{b})
+}
+
+// OnPublish runs on the connection of the publishing client before the
+// message is delivered and acknowledged, so sleeping in it delays the PUBACK.
+func (h *faultHook) OnPublish(cl *mqttserver.Client, pk packets.Packet) (packets.Packet, error) {
+	if cl.Net.Inline {
+		return pk, nil
+	}
+
+	h.mu.Lock()
+	latency := h.latency
+	drop := pk.FixedHeader.Qos > 0 && h.dropPubacks > 0
+	if drop {
+		h.dropPubacks--
+	}
+	server := h.server
+	h.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	if drop {
+		// The message is still delivered, as when a PUBACK is lost on the way
+		// back, so the client retrying it produces a duplicate.
+		server.Publish(pk.TopicName, pk.Payload, pk.FixedHeader.Retain, pk.FixedHeader.Qos)
+		return pk, packets.ErrRejectPacket
+	}
+
+	return pk, nil
+}
+
+// InjectLatency holds every message clients publish after the call for d
+// before the broker handles it, so both its delivery to subscribers and its
+// PUBACK arrive d later. Zero removes the latency.
+func (b *Broker) InjectLatency(d time.Duration) {
+	b.faults.mu.Lock()
+	defer b.faults.mu.Unlock()
+
+	b.faults.latency = d
+}
+
+// DropPubacks delivers the next n QoS 1 and 2 messages published by clients
+// without acknowledging them, so their publishers time out waiting for the
+// PUBACK or PUBREC.
+func (b *Broker) DropPubacks(n int) {
+	b.faults.mu.Lock()
+	defer b.faults.mu.Unlock()
+
+	b.faults.dropPubacks = n
+}
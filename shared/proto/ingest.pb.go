@@ -0,0 +1,193 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.21.12
+// source: ingest.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PublishReadingsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SensorData    []*SensorData          `protobuf:"bytes,1,rep,name=sensor_data,json=sensorData,proto3" json:"sensor_data,omitempty"`
+	MetricsData   []*MetricsData         `protobuf:"bytes,2,rep,name=metrics_data,json=metricsData,proto3" json:"metrics_data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishReadingsRequest) Reset() {
+	*x = PublishReadingsRequest{}
+	mi := &file_ingest_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishReadingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishReadingsRequest) ProtoMessage() {}
+
+func (x *PublishReadingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishReadingsRequest.ProtoReflect.Descriptor instead.
+func (*PublishReadingsRequest) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PublishReadingsRequest) GetSensorData() []*SensorData {
+	if x != nil {
+		return x.SensorData
+	}
+	return nil
+}
+
+func (x *PublishReadingsRequest) GetMetricsData() []*MetricsData {
+	if x != nil {
+		return x.MetricsData
+	}
+	return nil
+}
+
+type PublishReadingsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// accepted is the number of readings forwarded to the broker.
+	Accepted      uint64 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublishReadingsResponse) Reset() {
+	*x = PublishReadingsResponse{}
+	mi := &file_ingest_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublishReadingsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublishReadingsResponse) ProtoMessage() {}
+
+func (x *PublishReadingsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublishReadingsResponse.ProtoReflect.Descriptor instead.
+func (*PublishReadingsResponse) Descriptor() ([]byte, []int) {
+	return file_ingest_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PublishReadingsResponse) GetAccepted() uint64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+var File_ingest_proto protoreflect.FileDescriptor
+
+const file_ingest_proto_rawDesc = "" +
+	"\n" +
+	"\fingest.proto\x12\x05proto\x1a\x12metrics_data.proto\x1a\x11sensor_data.proto\"\x83\x01\n" +
+	"\x16PublishReadingsRequest\x122\n" +
+	"\vsensor_data\x18\x01 \x03(\v2\x11.proto.SensorDataR\n" +
+	"sensorData\x125\n" +
+	"\fmetrics_data\x18\x02 \x03(\v2\x12.proto.MetricsDataR\vmetricsData\"5\n" +
+	"\x17PublishReadingsResponse\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\x04R\baccepted2\xb4\x01\n" +
+	"\rIngestService\x12P\n" +
+	"\x0fPublishReadings\x12\x1d.proto.PublishReadingsRequest\x1a\x1e.proto.PublishReadingsResponse\x12Q\n" +
+	"\x0eStreamReadings\x12\x1d.proto.PublishReadingsRequest\x1a\x1e.proto.PublishReadingsResponse(\x01BCZAgithub.com/RicardoCenci/iot-distributed-architecture/shared/protob\x06proto3"
+
+var (
+	file_ingest_proto_rawDescOnce sync.Once
+	file_ingest_proto_rawDescData []byte
+)
+
+func file_ingest_proto_rawDescGZIP() []byte {
+	file_ingest_proto_rawDescOnce.Do(func() {
+		file_ingest_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ingest_proto_rawDesc), len(file_ingest_proto_rawDesc)))
+	})
+	return file_ingest_proto_rawDescData
+}
+
+var file_ingest_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_ingest_proto_goTypes = []any{
+	(*PublishReadingsRequest)(nil),  // 0: proto.PublishReadingsRequest
+	(*PublishReadingsResponse)(nil), // 1: proto.PublishReadingsResponse
+	(*SensorData)(nil),              // 2: proto.SensorData
+	(*MetricsData)(nil),             // 3: proto.MetricsData
+}
+var file_ingest_proto_depIdxs = []int32{
+	2, // 0: proto.PublishReadingsRequest.sensor_data:type_name -> proto.SensorData
+	3, // 1: proto.PublishReadingsRequest.metrics_data:type_name -> proto.MetricsData
+	0, // 2: proto.IngestService.PublishReadings:input_type -> proto.PublishReadingsRequest
+	0, // 3: proto.IngestService.StreamReadings:input_type -> proto.PublishReadingsRequest
+	1, // 4: proto.IngestService.PublishReadings:output_type -> proto.PublishReadingsResponse
+	1, // 5: proto.IngestService.StreamReadings:output_type -> proto.PublishReadingsResponse
+	4, // [4:6] is the sub-list for method output_type
+	2, // [2:4] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_ingest_proto_init() }
+func file_ingest_proto_init() {
+	if File_ingest_proto != nil {
+		return
+	}
+	file_metrics_data_proto_init()
+	file_sensor_data_proto_init()
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ingest_proto_rawDesc), len(file_ingest_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_ingest_proto_goTypes,
+		DependencyIndexes: file_ingest_proto_depIdxs,
+		MessageInfos:      file_ingest_proto_msgTypes,
+	}.Build()
+	File_ingest_proto = out.File
+	file_ingest_proto_goTypes = nil
+	file_ingest_proto_depIdxs = nil
+}
@@ -0,0 +1,169 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.21.12
+// source: ingest.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	IngestService_PublishReadings_FullMethodName = "/proto.IngestService/PublishReadings"
+	IngestService_StreamReadings_FullMethodName  = "/proto.IngestService/StreamReadings"
+)
+
+// IngestServiceClient is the client API for IngestService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// IngestService accepts device readings over gRPC, as an alternative to
+// publishing them over MQTT. Accepted readings are forwarded to the same
+// exchanges as the MQTT ones.
+type IngestServiceClient interface {
+	// PublishReadings forwards a batch of readings. The response is only sent
+	// once every reading of the batch is accepted by the broker.
+	PublishReadings(ctx context.Context, in *PublishReadingsRequest, opts ...grpc.CallOption) (*PublishReadingsResponse, error)
+	// StreamReadings forwards the batches sent on the stream as they arrive,
+	// and reports how many readings were accepted once the client closes it.
+	StreamReadings(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishReadingsRequest, PublishReadingsResponse], error)
+}
+
+type ingestServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIngestServiceClient(cc grpc.ClientConnInterface) IngestServiceClient {
+	return &ingestServiceClient{cc}
+}
+
+func (c *ingestServiceClient) PublishReadings(ctx context.Context, in *PublishReadingsRequest, opts ...grpc.CallOption) (*PublishReadingsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PublishReadingsResponse)
+	err := c.cc.Invoke(ctx, IngestService_PublishReadings_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ingestServiceClient) StreamReadings(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[PublishReadingsRequest, PublishReadingsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &IngestService_ServiceDesc.Streams[0], IngestService_StreamReadings_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PublishReadingsRequest, PublishReadingsResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type IngestService_StreamReadingsClient = grpc.ClientStreamingClient[PublishReadingsRequest, PublishReadingsResponse]
+
+// IngestServiceServer is the server API for IngestService service.
+// All implementations must embed UnimplementedIngestServiceServer
+// for forward compatibility.
+//
+// IngestService accepts device readings over gRPC, as an alternative to
+// publishing them over MQTT. Accepted readings are forwarded to the same
+// exchanges as the MQTT ones.
+type IngestServiceServer interface {
+	// PublishReadings forwards a batch of readings. The response is only sent
+	// once every reading of the batch is accepted by the broker.
+	PublishReadings(context.Context, *PublishReadingsRequest) (*PublishReadingsResponse, error)
+	// StreamReadings forwards the batches sent on the stream as they arrive,
+	// and reports how many readings were accepted once the client closes it.
+	StreamReadings(grpc.ClientStreamingServer[PublishReadingsRequest, PublishReadingsResponse]) error
+	mustEmbedUnimplementedIngestServiceServer()
+}
+
+// UnimplementedIngestServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIngestServiceServer struct{}
+
+func (UnimplementedIngestServiceServer) PublishReadings(context.Context, *PublishReadingsRequest) (*PublishReadingsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PublishReadings not implemented")
+}
+func (UnimplementedIngestServiceServer) StreamReadings(grpc.ClientStreamingServer[PublishReadingsRequest, PublishReadingsResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamReadings not implemented")
+}
+func (UnimplementedIngestServiceServer) mustEmbedUnimplementedIngestServiceServer() {}
+func (UnimplementedIngestServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeIngestServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IngestServiceServer will
+// result in compilation errors.
+type UnsafeIngestServiceServer interface {
+	mustEmbedUnimplementedIngestServiceServer()
+}
+
+func RegisterIngestServiceServer(s grpc.ServiceRegistrar, srv IngestServiceServer) {
+	// If the following call pancis, it indicates UnimplementedIngestServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&IngestService_ServiceDesc, srv)
+}
+
+func _IngestService_PublishReadings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PublishReadingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IngestServiceServer).PublishReadings(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IngestService_PublishReadings_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IngestServiceServer).PublishReadings(ctx, req.(*PublishReadingsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IngestService_StreamReadings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(IngestServiceServer).StreamReadings(&grpc.GenericServerStream[PublishReadingsRequest, PublishReadingsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type IngestService_StreamReadingsServer = grpc.ClientStreamingServer[PublishReadingsRequest, PublishReadingsResponse]
+
+// IngestService_ServiceDesc is the grpc.ServiceDesc for IngestService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IngestService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.IngestService",
+	HandlerType: (*IngestServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PublishReadings",
+			Handler:    _IngestService_PublishReadings_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamReadings",
+			Handler:       _IngestService_StreamReadings_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "ingest.proto",
+}
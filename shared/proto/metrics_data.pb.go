@@ -9,6 +9,7 @@ package proto
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -22,13 +23,18 @@ const (
 )
 
 type MetricsData struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	SensorId      string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
-	CpuUsage      float32                `protobuf:"fixed32,2,opt,name=cpu_usage,json=cpuUsage,proto3" json:"cpu_usage,omitempty"`
-	MemoryUsage   float32                `protobuf:"fixed32,3,opt,name=memory_usage,json=memoryUsage,proto3" json:"memory_usage,omitempty"`
-	DiskUsage     float32                `protobuf:"fixed32,4,opt,name=disk_usage,json=diskUsage,proto3" json:"disk_usage,omitempty"`
-	NetworkUsage  float32                `protobuf:"fixed32,5,opt,name=network_usage,json=networkUsage,proto3" json:"network_usage,omitempty"`
-	Timestamp     int64                  `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	SensorId     string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	CpuUsage     float32                `protobuf:"fixed32,2,opt,name=cpu_usage,json=cpuUsage,proto3" json:"cpu_usage,omitempty"`
+	MemoryUsage  float32                `protobuf:"fixed32,3,opt,name=memory_usage,json=memoryUsage,proto3" json:"memory_usage,omitempty"`
+	DiskUsage    float32                `protobuf:"fixed32,4,opt,name=disk_usage,json=diskUsage,proto3" json:"disk_usage,omitempty"`
+	NetworkUsage float32                `protobuf:"fixed32,5,opt,name=network_usage,json=networkUsage,proto3" json:"network_usage,omitempty"`
+	// timestamp is the time the reading was taken, in seconds since the Unix
+	// epoch. It is kept for workers that do not read time yet.
+	Timestamp int64 `protobuf:"varint,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// time is the time the reading was taken, with sub-second precision. It
+	// takes precedence over timestamp when set.
+	Time          *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=time,proto3" json:"time,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -105,11 +111,18 @@ func (x *MetricsData) GetTimestamp() int64 {
 	return 0
 }
 
+func (x *MetricsData) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
 var File_metrics_data_proto protoreflect.FileDescriptor
 
 const file_metrics_data_proto_rawDesc = "" +
 	"\n" +
-	"\x12metrics_data.proto\x12\x05proto\"\xcc\x01\n" +
+	"\x12metrics_data.proto\x12\x05proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xfc\x01\n" +
 	"\vMetricsData\x12\x1b\n" +
 	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12\x1b\n" +
 	"\tcpu_usage\x18\x02 \x01(\x02R\bcpuUsage\x12!\n" +
@@ -117,7 +130,8 @@ const file_metrics_data_proto_rawDesc = "" +
 	"\n" +
 	"disk_usage\x18\x04 \x01(\x02R\tdiskUsage\x12#\n" +
 	"\rnetwork_usage\x18\x05 \x01(\x02R\fnetworkUsage\x12\x1c\n" +
-	"\ttimestamp\x18\x06 \x01(\x03R\ttimestampBCZAgithub.com/RicardoCenci/iot-distributed-architecture/shared/protob\x06proto3"
+	"\ttimestamp\x18\x06 \x01(\x03R\ttimestamp\x12.\n" +
+	"\x04time\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\x04timeBCZAgithub.com/RicardoCenci/iot-distributed-architecture/shared/protob\x06proto3"
 
 var (
 	file_metrics_data_proto_rawDescOnce sync.Once
@@ -133,14 +147,16 @@ func file_metrics_data_proto_rawDescGZIP() []byte {
 
 var file_metrics_data_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
 var file_metrics_data_proto_goTypes = []any{
-	(*MetricsData)(nil), // 0: proto.MetricsData
+	(*MetricsData)(nil),           // 0: proto.MetricsData
+	(*timestamppb.Timestamp)(nil), // 1: google.protobuf.Timestamp
 }
 var file_metrics_data_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: proto.MetricsData.time:type_name -> google.protobuf.Timestamp
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_metrics_data_proto_init() }
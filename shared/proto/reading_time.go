@@ -0,0 +1,47 @@
+package proto
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ReadingTime returns the time the reading was taken: time when set, the
+// timestamp in seconds sent by older clients otherwise. It returns false
+// when the reading has neither.
+func (x *SensorData) ReadingTime() (time.Time, bool) {
+	return readingTime(x.GetTime(), x.GetTimestamp())
+}
+
+// SetReadingTime sets both time and timestamp, so workers that only read
+// timestamp still get the reading time to the second.
+func (x *SensorData) SetReadingTime(t time.Time) {
+	x.Time = timestamppb.New(t)
+	x.Timestamp = t.Unix()
+}
+
+// ReadingTime returns the time the reading was taken: time when set, the
+// timestamp in seconds sent by older clients otherwise. It returns false
+// when the reading has neither.
+func (x *MetricsData) ReadingTime() (time.Time, bool) {
+	return readingTime(x.GetTime(), x.GetTimestamp())
+}
+
+// SetReadingTime sets both time and timestamp, so workers that only read
+// timestamp still get the reading time to the second.
+func (x *MetricsData) SetReadingTime(t time.Time) {
+	x.Time = timestamppb.New(t)
+	x.Timestamp = t.Unix()
+}
+
+func readingTime(t *timestamppb.Timestamp, seconds int64) (time.Time, bool) {
+	if t.IsValid() && (t.GetSeconds() > 0 || t.GetNanos() > 0) {
+		return t.AsTime(), true
+	}
+
+	if seconds > 0 {
+		return time.Unix(seconds, 0), true
+	}
+
+	return time.Time{}, false
+}
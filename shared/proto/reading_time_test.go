@@ -0,0 +1,87 @@
+package proto
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var readAt = time.Date(2026, 1, 1, 12, 0, 0, 123456789, time.UTC)
+
+func TestSensorData_ReadingTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   *SensorData
+		want   time.Time
+		wantOK bool
+	}{
+		{
+			name:   "time",
+			data:   &SensorData{Time: timestamppb.New(readAt), Timestamp: readAt.Unix()},
+			want:   readAt,
+			wantOK: true,
+		},
+		{
+			name:   "seconds from an older client",
+			data:   &SensorData{Timestamp: readAt.Unix()},
+			want:   readAt.Truncate(time.Second),
+			wantOK: true,
+		},
+		{
+			name:   "time without seconds",
+			data:   &SensorData{Time: timestamppb.New(readAt)},
+			want:   readAt,
+			wantOK: true,
+		},
+		{
+			name:   "invalid time",
+			data:   &SensorData{Time: &timestamppb.Timestamp{Seconds: readAt.Unix(), Nanos: -1}, Timestamp: readAt.Unix()},
+			want:   readAt.Truncate(time.Second),
+			wantOK: true,
+		},
+		{
+			name: "missing",
+			data: &SensorData{Time: &timestamppb.Timestamp{}},
+		},
+		{
+			name: "nil",
+			data: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.data.ReadingTime()
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("ReadingTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSetReadingTime_OlderReaders(t *testing.T) {
+	var metrics MetricsData
+	metrics.SetReadingTime(readAt)
+
+	body, err := proto.Marshal(&metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader built before time existed skips it as an unknown field.
+	var legacy MetricsData
+	if err := proto.Unmarshal(body, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Time = nil
+
+	if got, _ := legacy.ReadingTime(); !got.Equal(readAt.Truncate(time.Second)) {
+		t.Errorf("legacy ReadingTime() = %v, want %v", got, readAt.Truncate(time.Second))
+	}
+
+	if got, _ := metrics.ReadingTime(); !got.Equal(readAt) {
+		t.Errorf("ReadingTime() = %v, want %v", got, readAt)
+	}
+}
@@ -9,6 +9,7 @@ package proto
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -22,11 +23,16 @@ const (
 )
 
 type SensorData struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	SensorId      string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
-	Humidity      float32                `protobuf:"fixed32,2,opt,name=humidity,proto3" json:"humidity,omitempty"`
-	Temperature   float32                `protobuf:"fixed32,3,opt,name=temperature,proto3" json:"temperature,omitempty"`
-	Timestamp     int64                  `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	SensorId    string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	Humidity    float32                `protobuf:"fixed32,2,opt,name=humidity,proto3" json:"humidity,omitempty"`
+	Temperature float32                `protobuf:"fixed32,3,opt,name=temperature,proto3" json:"temperature,omitempty"`
+	// timestamp is the time the reading was taken, in seconds since the Unix
+	// epoch. It is kept for workers that do not read time yet.
+	Timestamp int64 `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// time is the time the reading was taken, with sub-second precision. It
+	// takes precedence over timestamp when set.
+	Time          *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=time,proto3" json:"time,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -89,17 +95,25 @@ func (x *SensorData) GetTimestamp() int64 {
 	return 0
 }
 
+func (x *SensorData) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
 var File_sensor_data_proto protoreflect.FileDescriptor
 
 const file_sensor_data_proto_rawDesc = "" +
 	"\n" +
-	"\x11sensor_data.proto\x12\x05proto\"\x85\x01\n" +
+	"\x11sensor_data.proto\x12\x05proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb5\x01\n" +
 	"\n" +
 	"SensorData\x12\x1b\n" +
 	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12\x1a\n" +
 	"\bhumidity\x18\x02 \x01(\x02R\bhumidity\x12 \n" +
 	"\vtemperature\x18\x03 \x01(\x02R\vtemperature\x12\x1c\n" +
-	"\ttimestamp\x18\x04 \x01(\x03R\ttimestampBCZAgithub.com/RicardoCenci/iot-distributed-architecture/shared/protob\x06proto3"
+	"\ttimestamp\x18\x04 \x01(\x03R\ttimestamp\x12.\n" +
+	"\x04time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x04timeBCZAgithub.com/RicardoCenci/iot-distributed-architecture/shared/protob\x06proto3"
 
 var (
 	file_sensor_data_proto_rawDescOnce sync.Once
@@ -115,14 +129,16 @@ func file_sensor_data_proto_rawDescGZIP() []byte {
 
 var file_sensor_data_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
 var file_sensor_data_proto_goTypes = []any{
-	(*SensorData)(nil), // 0: proto.SensorData
+	(*SensorData)(nil),            // 0: proto.SensorData
+	(*timestamppb.Timestamp)(nil), // 1: google.protobuf.Timestamp
 }
 var file_sensor_data_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: proto.SensorData.time:type_name -> google.protobuf.Timestamp
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_sensor_data_proto_init() }
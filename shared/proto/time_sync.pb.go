@@ -0,0 +1,211 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        v3.21.12
+// source: time_sync.proto
+
+package proto
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// TimeRequest is published by a device to learn the offset of its clock,
+// NTP style. client_send_time_ms is the device time the request was sent.
+type TimeRequest struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	SensorId         string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	ClientSendTimeMs int64                  `protobuf:"varint,2,opt,name=client_send_time_ms,json=clientSendTimeMs,proto3" json:"client_send_time_ms,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *TimeRequest) Reset() {
+	*x = TimeRequest{}
+	mi := &file_time_sync_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TimeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimeRequest) ProtoMessage() {}
+
+func (x *TimeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_time_sync_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimeRequest.ProtoReflect.Descriptor instead.
+func (*TimeRequest) Descriptor() ([]byte, []int) {
+	return file_time_sync_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TimeRequest) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+func (x *TimeRequest) GetClientSendTimeMs() int64 {
+	if x != nil {
+		return x.ClientSendTimeMs
+	}
+	return 0
+}
+
+// TimeResponse is published back to the device that sent a TimeRequest.
+// client_send_time_ms is copied from the request, server_receive_time_ms and
+// server_send_time_ms are the server times the request was received and the
+// response sent, all in milliseconds since the Unix epoch.
+type TimeResponse struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	SensorId            string                 `protobuf:"bytes,1,opt,name=sensor_id,json=sensorId,proto3" json:"sensor_id,omitempty"`
+	ClientSendTimeMs    int64                  `protobuf:"varint,2,opt,name=client_send_time_ms,json=clientSendTimeMs,proto3" json:"client_send_time_ms,omitempty"`
+	ServerReceiveTimeMs int64                  `protobuf:"varint,3,opt,name=server_receive_time_ms,json=serverReceiveTimeMs,proto3" json:"server_receive_time_ms,omitempty"`
+	ServerSendTimeMs    int64                  `protobuf:"varint,4,opt,name=server_send_time_ms,json=serverSendTimeMs,proto3" json:"server_send_time_ms,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *TimeResponse) Reset() {
+	*x = TimeResponse{}
+	mi := &file_time_sync_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TimeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TimeResponse) ProtoMessage() {}
+
+func (x *TimeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_time_sync_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TimeResponse.ProtoReflect.Descriptor instead.
+func (*TimeResponse) Descriptor() ([]byte, []int) {
+	return file_time_sync_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TimeResponse) GetSensorId() string {
+	if x != nil {
+		return x.SensorId
+	}
+	return ""
+}
+
+func (x *TimeResponse) GetClientSendTimeMs() int64 {
+	if x != nil {
+		return x.ClientSendTimeMs
+	}
+	return 0
+}
+
+func (x *TimeResponse) GetServerReceiveTimeMs() int64 {
+	if x != nil {
+		return x.ServerReceiveTimeMs
+	}
+	return 0
+}
+
+func (x *TimeResponse) GetServerSendTimeMs() int64 {
+	if x != nil {
+		return x.ServerSendTimeMs
+	}
+	return 0
+}
+
+var File_time_sync_proto protoreflect.FileDescriptor
+
+const file_time_sync_proto_rawDesc = "" +
+	"\n" +
+	"\x0ftime_sync.proto\x12\x05proto\"Y\n" +
+	"\vTimeRequest\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12-\n" +
+	"\x13client_send_time_ms\x18\x02 \x01(\x03R\x10clientSendTimeMs\"\xbe\x01\n" +
+	"\fTimeResponse\x12\x1b\n" +
+	"\tsensor_id\x18\x01 \x01(\tR\bsensorId\x12-\n" +
+	"\x13client_send_time_ms\x18\x02 \x01(\x03R\x10clientSendTimeMs\x123\n" +
+	"\x16server_receive_time_ms\x18\x03 \x01(\x03R\x13serverReceiveTimeMs\x12-\n" +
+	"\x13server_send_time_ms\x18\x04 \x01(\x03R\x10serverSendTimeMsBCZAgithub.com/RicardoCenci/iot-distributed-architecture/shared/protob\x06proto3"
+
+var (
+	file_time_sync_proto_rawDescOnce sync.Once
+	file_time_sync_proto_rawDescData []byte
+)
+
+func file_time_sync_proto_rawDescGZIP() []byte {
+	file_time_sync_proto_rawDescOnce.Do(func() {
+		file_time_sync_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_time_sync_proto_rawDesc), len(file_time_sync_proto_rawDesc)))
+	})
+	return file_time_sync_proto_rawDescData
+}
+
+var file_time_sync_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_time_sync_proto_goTypes = []any{
+	(*TimeRequest)(nil),  // 0: proto.TimeRequest
+	(*TimeResponse)(nil), // 1: proto.TimeResponse
+}
+var file_time_sync_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_time_sync_proto_init() }
+func file_time_sync_proto_init() {
+	if File_time_sync_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_time_sync_proto_rawDesc), len(file_time_sync_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_time_sync_proto_goTypes,
+		DependencyIndexes: file_time_sync_proto_depIdxs,
+		MessageInfos:      file_time_sync_proto_msgTypes,
+	}.Build()
+	File_time_sync_proto = out.File
+	file_time_sync_proto_goTypes = nil
+	file_time_sync_proto_depIdxs = nil
+}
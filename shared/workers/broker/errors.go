@@ -0,0 +1,28 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPublishNacked is returned when the broker refuses a published message.
+	ErrPublishNacked = errors.New("message was nacked by the broker")
+	// ErrConfirmLost is returned when the connection is lost before a
+	// published message is confirmed. The message may or may not be stored.
+	ErrConfirmLost = errors.New("connection lost before the message was confirmed")
+)
+
+// ReturnedError is returned when a mandatory message could not be routed to
+// any queue.
+type ReturnedError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("message to exchange %q with routing key %q was returned: %d %s",
+		e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
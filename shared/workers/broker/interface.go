@@ -1,16 +1,28 @@
 package broker
 
-import (
-	"context"
-
-	amqp "github.com/rabbitmq/amqp091-go"
-)
+import "context"
 
 type MessageBroker interface {
 	Connect() error
 	Close() error
-	Qos(prefetchCount, prefetchSize int, global bool) error
-	ConsumeQueue(ctx context.Context, queue Queue, consumer string) (<-chan amqp.Delivery, error)
+	Qos(queue Queue, prefetchCount, prefetchSize int, global bool) error
+	// ConsumeQueue delivers the messages of queue until ctx is cancelled or
+	// the broker is closed, then closes the returned channel. Messages must be
+	// acknowledged or rejected through their Ack and Nack methods.
+	ConsumeQueue(ctx context.Context, queue Queue, consumer string) (<-chan Message, error)
+}
+
+// MessagePublisher publishes messages to an exchange. Messages are published
+// as mandatory, so a message no queue is bound to receive is returned by the
+// broker instead of being dropped silently.
+type MessagePublisher interface {
+	// Publish sends msg without waiting for the broker to confirm it. Returned
+	// messages are only logged.
+	Publish(ctx context.Context, exchange, routingKey string, msg Message) error
+	// PublishWithConfirm sends msg and blocks until the broker confirms it.
+	// It returns ErrPublishNacked when the broker rejects the message and a
+	// *ReturnedError when it could not be routed to any queue.
+	PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg Message) error
 }
 
 type Queue interface {
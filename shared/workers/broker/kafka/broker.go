@@ -0,0 +1,432 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Headers added to dead-lettered records.
+const (
+	deadLetterTopicHeader = "Iot-Dead-Letter-Topic"
+	deadLetterQueueHeader = "Iot-Dead-Letter-Queue"
+)
+
+var errBrokerClosed = errors.New("broker closed")
+
+// Broker consumes Kafka topics through consumer groups and produces to Kafka
+// topics.
+//
+// Kafka has no per-message acknowledgement, only a committed offset per
+// partition, so acknowledgements are mapped onto offset commits: a record's
+// offset is committed once it and every record before it on its partition
+// are settled. Ack settles a record, Nack with requeue redelivers it in
+// process, and Nack without requeue, or with requeue after the last retry,
+// dead-letters and settles it. The multiple flag settles every earlier
+// unsettled record of the same consumer. Records that are not settled when
+// the consumer stops are redelivered by the next member of the group.
+//
+// The exchange given to Publish is the topic and the routing key is the
+// record key, so records of the same device, keyed by device ID, land on the
+// same partition and are consumed in order.
+type Broker struct {
+	seeds   []string
+	logger  logger.Interface
+	options *BrokerOptions
+
+	// closeCtx is cancelled by Close to stop every consumer.
+	closeCtx    context.Context
+	closeCancel context.CancelFunc
+	groups      sync.WaitGroup
+
+	mu     sync.Mutex
+	client *kgo.Client
+	queues map[string]*queueState
+	closed bool
+}
+
+type queueState struct {
+	queue    *Queue
+	prefetch int
+}
+
+type BrokerOptions struct {
+	commitInterval time.Duration
+	clientOptions  []kgo.Opt
+}
+
+type BrokerOption func(*BrokerOptions)
+
+// WithCommitInterval sets how often the offsets of settled records are
+// committed.
+func WithCommitInterval(commitInterval time.Duration) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.commitInterval = commitInterval
+	}
+}
+
+// WithClientOptions adds options, such as TLS or SASL, to every Kafka client
+// of the broker.
+func WithClientOptions(clientOptions ...kgo.Opt) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.clientOptions = append(options.clientOptions, clientOptions...)
+	}
+}
+
+func NewBroker(seeds []string, logger logger.Interface, options ...BrokerOption) *Broker {
+	defaultOptions := &BrokerOptions{
+		commitInterval: 5 * time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	closeCtx, closeCancel := context.WithCancel(context.Background())
+
+	return &Broker{
+		seeds:       seeds,
+		logger:      logger,
+		options:     defaultOptions,
+		closeCtx:    closeCtx,
+		closeCancel: closeCancel,
+		queues:      make(map[string]*queueState),
+	}
+}
+
+func (b *Broker) clientOptions(options ...kgo.Opt) []kgo.Opt {
+	return append(append([]kgo.Opt{kgo.SeedBrokers(b.seeds...)}, options...), b.options.clientOptions...)
+}
+
+// Connect creates the client used to set up topics and produce, retrying
+// until a seed broker answers. Once connected the Kafka client reconnects on
+// its own.
+func (b *Broker) Connect() error {
+	client, err := kgo.NewClient(b.clientOptions()...)
+	if err != nil {
+		return fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+
+	maxRetries := 30
+
+	for i := 0; i < maxRetries; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := client.Ping(ctx)
+		cancel()
+
+		if err == nil {
+			b.mu.Lock()
+			b.client = client
+			b.mu.Unlock()
+
+			b.logger.Info("Connected to Kafka")
+			return nil
+		}
+
+		if i < maxRetries-1 {
+			b.logger.Warn("Failed to connect to Kafka, retrying", "retry_number", i+1, "max_retries", maxRetries, "error", err)
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	client.Close()
+
+	return fmt.Errorf("failed to connect to Kafka after %d attempts", maxRetries)
+}
+
+// State returns the current connection state. Kafka clients reconnect on
+// demand, so the broker is reported as reconnecting while no broker of the
+// cluster answers.
+func (b *Broker) State() broker.State {
+	b.mu.Lock()
+	client, closed := b.client, b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return broker.StateClosed
+	}
+
+	if client == nil {
+		return broker.StateDisconnected
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx); err != nil {
+		return broker.StateReconnecting
+	}
+
+	return broker.StateConnected
+}
+
+func (b *Broker) kafkaClient() (*kgo.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrokerClosed
+	}
+
+	if b.client == nil {
+		return nil, errors.New("not connected to Kafka")
+	}
+
+	return b.client, nil
+}
+
+// SetupQueue creates the topic of q and its dead-letter topic, if any, unless
+// they already exist.
+func (b *Broker) SetupQueue(ctx context.Context, q *Queue) error {
+	client, err := b.kafkaClient()
+	if err != nil {
+		return err
+	}
+
+	topics := []string{q.topic}
+	if q.options.deadLetterTopic != "" {
+		topics = append(topics, q.options.deadLetterTopic)
+	}
+
+	req := kmsg.NewPtrCreateTopicsRequest()
+	for _, topic := range topics {
+		reqTopic := kmsg.NewCreateTopicsRequestTopic()
+		reqTopic.Topic = topic
+		reqTopic.NumPartitions = -1
+		reqTopic.ReplicationFactor = -1
+		if q.options.partitions > 0 {
+			reqTopic.NumPartitions = int32(q.options.partitions)
+		}
+		if q.options.replicationFactor > 0 {
+			reqTopic.ReplicationFactor = int16(q.options.replicationFactor)
+		}
+		req.Topics = append(req.Topics, reqTopic)
+	}
+
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to create topics %v: %w", topics, err)
+	}
+
+	for _, topic := range resp.Topics {
+		err := kerr.ErrorForCode(topic.ErrorCode)
+		if err != nil && !errors.Is(err, kerr.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create topic %s: %w", topic.Topic, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.queues[q.topic]
+	if !ok {
+		state = &queueState{}
+		b.queues[q.topic] = state
+	}
+	state.queue = q
+
+	return nil
+}
+
+func (b *Broker) queueState(queue broker.Queue) (*queueState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.queues[queue.GetName()]
+	if !ok {
+		return nil, fmt.Errorf("queue %s is not set up, call SetupQueue first", queue.GetName())
+	}
+
+	return state, nil
+}
+
+// Qos sets the maximum number of unsettled records of the consumers started
+// afterwards on queue.
+func (b *Broker) Qos(queue broker.Queue, prefetchCount, prefetchSize int, global bool) error {
+	state, err := b.queueState(queue)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	state.prefetch = prefetchCount
+	b.mu.Unlock()
+
+	return nil
+}
+
+// ConsumeQueue joins the consumer group of queue and delivers its records
+// until ctx is cancelled or the broker is closed. Every call joins the group
+// as a new member, named after consumer in logs, and is assigned its own
+// partitions. Offsets are committed once the delivered records are settled.
+func (b *Broker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan broker.Message, error) {
+	state, err := b.queueState(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrokerClosed
+	}
+
+	g := newGroupConsumer(b, state.queue, consumer, state.prefetch)
+
+	client, err := kgo.NewClient(b.clientOptions(
+		kgo.ConsumerGroup(state.queue.options.group),
+		kgo.ConsumeTopics(state.queue.topic),
+		kgo.AutoCommitMarks(),
+		kgo.AutoCommitInterval(b.options.commitInterval),
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsRevoked(g.revoked),
+		kgo.OnPartitionsLost(g.lost),
+	)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer for %s: %w", state.queue.topic, err)
+	}
+
+	g.client = client
+
+	out := make(chan broker.Message)
+
+	b.groups.Add(1)
+	go func() {
+		defer b.groups.Done()
+		g.run(ctx, out)
+	}()
+
+	return out, nil
+}
+
+// deadLetter produces record to the dead-letter topic of q, if any.
+func (b *Broker) deadLetter(q *Queue, record *kgo.Record) error {
+	if q.options.deadLetterTopic == "" {
+		b.logger.Warn("Dropping rejected record, no dead-letter topic", "topic", record.Topic, "partition", record.Partition, "offset", record.Offset)
+		return nil
+	}
+
+	client, err := b.kafkaClient()
+	if err != nil {
+		return err
+	}
+
+	headers := append([]kgo.RecordHeader{}, record.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: deadLetterTopicHeader, Value: []byte(record.Topic)},
+		kgo.RecordHeader{Key: deadLetterQueueHeader, Value: []byte(q.options.group)},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = client.ProduceSync(ctx, &kgo.Record{
+		Topic:   q.options.deadLetterTopic,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: headers,
+	}).FirstErr()
+	if err != nil {
+		return fmt.Errorf("failed to dead-letter record: %w", err)
+	}
+
+	return nil
+}
+
+func toRecord(exchange, routingKey string, msg broker.Message) *kgo.Record {
+	record := &kgo.Record{
+		Topic: exchange,
+		Key:   []byte(routingKey),
+		Value: msg.Body,
+	}
+
+	if exchange == "" {
+		record.Topic = routingKey
+		record.Key = nil
+	}
+
+	for key, value := range msg.Headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: key, Value: []byte(fmt.Sprint(value))})
+	}
+
+	if msg.ContentType != "" {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: "Content-Type", Value: []byte(msg.ContentType)})
+	}
+
+	return record
+}
+
+// Publish produces msg without waiting for Kafka to acknowledge it. Failures
+// are only logged. Records are always persistent.
+func (b *Broker) Publish(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	client, err := b.kafkaClient()
+	if err != nil {
+		return err
+	}
+
+	client.Produce(ctx, toRecord(exchange, routingKey, msg), func(record *kgo.Record, err error) {
+		if err != nil {
+			b.logger.Error("Failed to produce record", "error", err, "topic", record.Topic)
+		}
+	})
+
+	return nil
+}
+
+// PublishWithConfirm produces msg and waits for Kafka to acknowledge it. It
+// returns a *broker.ReturnedError when the topic does not exist.
+func (b *Broker) PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	client, err := b.kafkaClient()
+	if err != nil {
+		return err
+	}
+
+	record := toRecord(exchange, routingKey, msg)
+
+	err = client.ProduceSync(ctx, record).FirstErr()
+	if errors.Is(err, kerr.UnknownTopicOrPartition) {
+		return &broker.ReturnedError{
+			Exchange:   exchange,
+			RoutingKey: routingKey,
+			ReplyCode:  312,
+			ReplyText:  fmt.Sprintf("NO_ROUTE: topic %s does not exist", record.Topic),
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops every consumer, committing the offsets of the records settled so
+// far, and closes the client.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	client := b.client
+	b.mu.Unlock()
+
+	b.closeCancel()
+	b.groups.Wait()
+
+	if client != nil {
+		client.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,372 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+func newTestBroker(t *testing.T) *Broker {
+	t.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	b := NewBroker(
+		cluster.ListenAddrs(),
+		&mockLogger{},
+		WithCommitInterval(100*time.Millisecond),
+		WithClientOptions(kgo.UnknownTopicRetries(1)),
+	)
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return b
+}
+
+func setupQueue(t *testing.T, b *Broker, q *Queue) {
+	t.Helper()
+	if err := b.SetupQueue(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func publish(t *testing.T, b *Broker, topic, key string, bodies ...string) {
+	t.Helper()
+	for _, body := range bodies {
+		if err := b.PublishWithConfirm(context.Background(), topic, key, broker.Message{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func receive(t *testing.T, messages <-chan broker.Message) broker.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			t.Fatal("messages channel closed")
+		}
+		return msg
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return broker.Message{}
+	}
+}
+
+// committed returns the number of records committed by the group of q, summed
+// over the partitions of its topic.
+func committed(t *testing.T, b *Broker, q *Queue) int64 {
+	t.Helper()
+
+	req := kmsg.NewPtrOffsetFetchRequest()
+	req.Group = q.options.group
+	reqTopic := kmsg.NewOffsetFetchRequestTopic()
+	reqTopic.Topic = q.topic
+	reqTopic.Partitions = []int32{0, 1, 2, 3}
+	req.Topics = append(req.Topics, reqTopic)
+
+	resp, err := req.RequestWith(context.Background(), b.client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	for _, topic := range resp.Topics {
+		for _, partition := range topic.Partitions {
+			if partition.Offset > 0 {
+				total += partition.Offset
+			}
+		}
+	}
+
+	return total
+}
+
+// waitCommitted waits for the group of q to commit want records. Offsets are
+// committed asynchronously, so the broker may see them a little later.
+func waitCommitted(t *testing.T, b *Broker, q *Queue, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := committed(t, b, q)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("committed %d records, want %d", got, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBroker_ConsumeAndAck(t *testing.T) {
+	b := newTestBroker(t)
+	q := NewQueue("data", WithPartitions(1))
+	setupQueue(t, b, q)
+
+	if b.State() != broker.StateConnected {
+		t.Errorf("State() = %v, want %v", b.State(), broker.StateConnected)
+	}
+
+	if err := b.PublishWithConfirm(context.Background(), "data", "device-1", broker.Message{
+		Body:        []byte("one"),
+		ContentType: "application/x-protobuf",
+		Headers:     map[string]any{"sensor": "temperature"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "data", "device-1", "two", "three")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, q, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, messages)
+	if string(first.Body) != "one" || first.RoutingKey != "device-1" {
+		t.Errorf("received %s keyed %s, want one keyed device-1", first.Body, first.RoutingKey)
+	}
+	if first.ContentType != "application/x-protobuf" || first.Headers["sensor"] != "temperature" {
+		t.Errorf("content type = %s, headers = %v", first.ContentType, first.Headers)
+	}
+	if first.Redelivered || first.Timestamp.IsZero() {
+		t.Errorf("redelivered = %v, timestamp = %v", first.Redelivered, first.Timestamp)
+	}
+
+	receive(t, messages)
+	third := receive(t, messages)
+
+	// A multiple ack of the last message settles the two before it.
+	if err := third.Ack(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ack(false); err == nil {
+		t.Error("acking an already settled message succeeded")
+	}
+
+	waitCommitted(t, b, q, 3)
+
+	cancel()
+	for range messages {
+	}
+}
+
+func TestBroker_CommitsContiguousOffsets(t *testing.T) {
+	b := newTestBroker(t)
+	q := NewQueue("data", WithPartitions(1))
+	setupQueue(t, b, q)
+
+	publish(t, b, "data", "device-1", "one", "two", "three")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, q, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, messages)
+	second := receive(t, messages)
+	third := receive(t, messages)
+
+	if err := third.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first record is unsettled, so nothing after it is committed.
+	time.Sleep(300 * time.Millisecond)
+	if got := committed(t, b, q); got != 0 {
+		t.Errorf("committed %d records before the first was acked, want 0", got)
+	}
+
+	if err := first.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCommitted(t, b, q, 3)
+}
+
+func TestBroker_NackRequeueAndDeadLetter(t *testing.T) {
+	b := newTestBroker(t)
+
+	q := NewQueue("data",
+		WithPartitions(1),
+		WithMaxRetries(1),
+		WithDeadLetterTopic("data.dead"),
+	)
+	dead := NewQueue("data.dead")
+	setupQueue(t, b, q)
+	setupQueue(t, b, dead)
+
+	publish(t, b, "data", "device-1", "retry", "reject")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, q, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg := receive(t, messages)
+
+		switch string(msg.Body) {
+		case "retry":
+			if err := msg.Nack(false, true); err != nil {
+				t.Fatal(err)
+			}
+		case "reject":
+			if err := msg.Nack(false, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The requeued record comes back once, then goes to the dead-letter topic
+	// because it used up its retries.
+	redelivered := receive(t, messages)
+	if string(redelivered.Body) != "retry" || !redelivered.Redelivered {
+		t.Fatalf("received %s (redelivered %v), want retry redelivered", redelivered.Body, redelivered.Redelivered)
+	}
+	if err := redelivered.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	waitCommitted(t, b, q, 2)
+
+	deadMessages, err := b.ConsumeQueue(ctx, dead, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg := receive(t, deadMessages)
+		got[string(msg.Body)] = true
+
+		if msg.Headers[deadLetterQueueHeader] != "data" || msg.Headers[deadLetterTopicHeader] != "data" || msg.RoutingKey != "device-1" {
+			t.Errorf("dead letter headers = %v, key = %s", msg.Headers, msg.RoutingKey)
+		}
+		msg.Ack(false)
+	}
+
+	if !got["retry"] || !got["reject"] {
+		t.Errorf("dead letters = %v, want retry and reject", got)
+	}
+
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected redelivery of %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBroker_PublishWithConfirm_UnknownTopic(t *testing.T) {
+	b := newTestBroker(t)
+
+	err := b.PublishWithConfirm(context.Background(), "nowhere", "device-1", broker.Message{Body: []byte("lost")})
+
+	var returned *broker.ReturnedError
+	if !errors.As(err, &returned) {
+		t.Fatalf("error = %v, want *broker.ReturnedError", err)
+	}
+	if returned.Exchange != "nowhere" || returned.RoutingKey != "device-1" {
+		t.Errorf("returned = %+v", returned)
+	}
+}
+
+func TestBroker_WithConsumer(t *testing.T) {
+	b := newTestBroker(t)
+	q := NewQueue("data", WithPartitions(3))
+	setupQueue(t, b, q)
+
+	devices := []string{"device-1", "device-2", "device-3"}
+	for i := 0; i < 5; i++ {
+		for _, device := range devices {
+			publish(t, b, "data", device, fmt.Sprintf("%s/%d", device, i))
+		}
+	}
+
+	var mu sync.Mutex
+	handled := map[string][]string{}
+	done := make(chan struct{}, 15)
+
+	c := consumer.NewConsumer(b, &mockLogger{}, "test",
+		consumer.WithPrefetchCount(4),
+		consumer.WithWorkers(3),
+		consumer.WithShardKey(func(msg broker.Message) string { return msg.RoutingKey }),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Start(ctx, q, func(msg broker.Message) error {
+		mu.Lock()
+		handled[msg.RoutingKey] = append(handled[msg.RoutingKey], string(msg.Body))
+		mu.Unlock()
+		done <- struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 15; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("handled %d messages, want 15", i)
+		}
+	}
+
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer waitCancel()
+
+	if err := c.Wait(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records of a device share a partition, so they are handled in the
+	// order they were published.
+	for _, device := range devices {
+		for i, body := range handled[device] {
+			if want := fmt.Sprintf("%s/%d", device, i); body != want {
+				t.Errorf("%s: message %d = %s, want %s", device, i, body, want)
+			}
+		}
+	}
+
+	waitCommitted(t, b, q, 15)
+}
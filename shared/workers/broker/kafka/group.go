@@ -0,0 +1,376 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// groupConsumer is one member of a consumer group, started by ConsumeQueue.
+//
+// A poll loop hands the polled records to a send loop through the ready
+// queue, so requeued records can be put back in front of it. Each delivery
+// holds a prefetch slot until it is settled, which bounds the unsettled
+// records the way Qos does on the other brokers.
+type groupConsumer struct {
+	broker   *Broker
+	queue    *Queue
+	consumer string
+	client   *kgo.Client
+	slots    chan struct{}
+	pending  broker.Pending[*delivery]
+	inflight sync.WaitGroup
+	wake     chan struct{}
+
+	mu         sync.Mutex
+	ready      []*delivery
+	partitions map[topicPartition]*partitionOffsets
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partitionOffsets tracks the polled records of a partition that are not
+// committed yet, in offset order.
+type partitionOffsets struct {
+	records []*kgo.Record
+	settled map[int64]bool
+	revoked bool
+}
+
+type delivery struct {
+	record  *kgo.Record
+	offsets *partitionOffsets
+	retries int
+}
+
+func newGroupConsumer(b *Broker, q *Queue, consumer string, prefetch int) *groupConsumer {
+	g := &groupConsumer{
+		broker:     b,
+		queue:      q,
+		consumer:   consumer,
+		wake:       make(chan struct{}, 1),
+		partitions: make(map[topicPartition]*partitionOffsets),
+	}
+
+	if prefetch > 0 {
+		g.slots = make(chan struct{}, prefetch)
+	}
+
+	return g
+}
+
+func (g *groupConsumer) run(ctx context.Context, out chan<- broker.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := context.AfterFunc(g.broker.closeCtx, cancel)
+	defer stop()
+
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		g.poll(ctx)
+	}()
+
+	g.send(ctx, out)
+	close(out)
+	<-polled
+
+	// Records already delivered may still be settled, so offsets are only
+	// committed once they are, or once the broker is closed.
+	settled := make(chan struct{})
+	go func() {
+		g.inflight.Wait()
+		close(settled)
+	}()
+
+	select {
+	case <-settled:
+	case <-g.broker.closeCtx.Done():
+	}
+
+	commitCtx, commitCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer commitCancel()
+
+	if err := g.client.CommitMarkedOffsets(commitCtx); err != nil {
+		g.broker.logger.Warn("Failed to commit offsets", "error", err, "queue", g.queue.topic, "consumer", g.consumer)
+	}
+
+	g.client.CloseAllowingRebalance()
+}
+
+func (g *groupConsumer) poll(ctx context.Context) {
+	for {
+		fetches := g.client.PollRecords(ctx, cap(g.slots))
+		if fetches.IsClientClosed() || ctx.Err() != nil {
+			g.client.AllowRebalance()
+			return
+		}
+
+		fetches.EachError(func(topic string, partition int32, err error) {
+			g.broker.logger.Warn("Failed to fetch records from Kafka", "error", err, "topic", topic, "partition", partition, "consumer", g.consumer)
+		})
+
+		// Rebalancing is blocked until every polled record is tracked, so a
+		// revoked partition never gets tracked again.
+		iter := fetches.RecordIter()
+		for !iter.Done() && g.acquire(ctx) {
+			g.push(g.track(iter.Next()), false)
+		}
+
+		g.client.AllowRebalance()
+	}
+}
+
+func (g *groupConsumer) track(record *kgo.Record) *delivery {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := topicPartition{topic: record.Topic, partition: record.Partition}
+
+	offsets, ok := g.partitions[key]
+	if !ok {
+		offsets = &partitionOffsets{settled: make(map[int64]bool)}
+		g.partitions[key] = offsets
+	}
+
+	offsets.records = append(offsets.records, record)
+
+	return &delivery{record: record, offsets: offsets}
+}
+
+func (g *groupConsumer) push(d *delivery, front bool) {
+	g.mu.Lock()
+	if front {
+		g.ready = append([]*delivery{d}, g.ready...)
+	} else {
+		g.ready = append(g.ready, d)
+	}
+	g.mu.Unlock()
+
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (g *groupConsumer) send(ctx context.Context, out chan<- broker.Message) {
+	for {
+		d, ok := g.next(ctx)
+		if !ok {
+			return
+		}
+
+		g.inflight.Add(1)
+
+		select {
+		case out <- g.toMessage(d):
+		case <-ctx.Done():
+			g.inflight.Done()
+			return
+		}
+	}
+}
+
+// next waits for the next record to deliver, skipping the records of revoked
+// partitions.
+func (g *groupConsumer) next(ctx context.Context) (*delivery, bool) {
+	for {
+		g.mu.Lock()
+		for len(g.ready) > 0 {
+			d := g.ready[0]
+			g.ready = g.ready[1:]
+
+			if !d.offsets.revoked {
+				g.mu.Unlock()
+				return d, true
+			}
+
+			g.release()
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-g.wake:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (g *groupConsumer) acquire(ctx context.Context) bool {
+	if g.slots == nil {
+		return ctx.Err() == nil
+	}
+
+	select {
+	case g.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (g *groupConsumer) release() {
+	if g.slots != nil {
+		<-g.slots
+	}
+}
+
+func (g *groupConsumer) toMessage(d *delivery) broker.Message {
+	id := g.pending.Add(d)
+
+	message := broker.Message{
+		Body:        d.record.Value,
+		RoutingKey:  string(d.record.Key),
+		Timestamp:   d.record.Timestamp,
+		Redelivered: d.retries > 0,
+		Persistent:  true,
+		Acknowledger: broker.AckFuncs{
+			AckFunc: func(multiple bool) error {
+				return g.settle(g.pending.Take(id, multiple), func(d *delivery) error {
+					g.commit(d)
+					return nil
+				})
+			},
+			NackFunc: func(multiple, requeue bool) error {
+				return g.settle(g.pending.Take(id, multiple), func(d *delivery) error {
+					return g.nack(d, requeue)
+				})
+			},
+		},
+	}
+
+	if len(d.record.Headers) > 0 {
+		message.Headers = make(map[string]any, len(d.record.Headers))
+		for _, header := range d.record.Headers {
+			if header.Key == "Content-Type" {
+				message.ContentType = string(header.Value)
+			}
+			message.Headers[header.Key] = string(header.Value)
+		}
+	}
+
+	return message
+}
+
+func (g *groupConsumer) settle(deliveries []*delivery, settle func(*delivery) error) error {
+	if deliveries == nil {
+		return errors.New("message already settled")
+	}
+
+	var errs []error
+	for _, d := range deliveries {
+		if err := settle(d); err != nil {
+			errs = append(errs, err)
+		}
+		g.inflight.Done()
+	}
+
+	return errors.Join(errs...)
+}
+
+func (g *groupConsumer) nack(d *delivery, requeue bool) error {
+	if requeue {
+		if g.queue.options.maxRetries < 0 || d.retries < g.queue.options.maxRetries {
+			g.requeue(d)
+			return nil
+		}
+
+		g.broker.logger.Warn("Record reached its maximum retries, dead-lettering", "queue", g.queue.topic, "partition", d.record.Partition, "offset", d.record.Offset)
+	}
+
+	if err := g.broker.deadLetter(g.queue, d.record); err != nil {
+		// The record is retried rather than lost, and its offset is not
+		// committed until it is settled.
+		g.redeliver(d)
+		return err
+	}
+
+	g.commit(d)
+	return nil
+}
+
+func (g *groupConsumer) requeue(d *delivery) {
+	d.retries++
+	g.redeliver(d)
+}
+
+func (g *groupConsumer) redeliver(d *delivery) {
+	if delay := g.queue.options.retryDelay; delay > 0 {
+		time.AfterFunc(delay, func() { g.push(d, true) })
+		return
+	}
+
+	g.push(d, true)
+}
+
+// commit settles the record of d and marks the offset of the last record of
+// its partition that has no unsettled record before it, so the next
+// autocommit commits it.
+func (g *groupConsumer) commit(d *delivery) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	defer g.release()
+
+	offsets := d.offsets
+	if offsets.revoked {
+		return
+	}
+
+	offsets.settled[d.record.Offset] = true
+
+	var last *kgo.Record
+	for len(offsets.records) > 0 && offsets.settled[offsets.records[0].Offset] {
+		last = offsets.records[0]
+		delete(offsets.settled, last.Offset)
+		offsets.records = offsets.records[1:]
+	}
+
+	if last != nil {
+		g.client.MarkCommitRecords(last)
+	}
+}
+
+// revoked commits the offsets marked so far before the partitions are handed
+// to another member of the group, then forgets them.
+func (g *groupConsumer) revoked(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+	g.forget(revoked)
+
+	if err := client.CommitMarkedOffsets(ctx); err != nil {
+		g.broker.logger.Warn("Failed to commit offsets of revoked partitions", "error", err, "queue", g.queue.topic, "consumer", g.consumer)
+	}
+}
+
+// lost forgets partitions that were lost without the chance to commit their
+// offsets. Their records are redelivered to the new owner.
+func (g *groupConsumer) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	g.broker.logger.Warn("Lost Kafka partitions", "queue", g.queue.topic, "consumer", g.consumer, "partitions", lost)
+
+	g.forget(lost)
+}
+
+// forget stops tracking partitions. Records of these partitions settled later
+// no longer mark offsets, and the ones not delivered yet are dropped.
+func (g *groupConsumer) forget(partitions map[string][]int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for topic, ids := range partitions {
+		for _, partition := range ids {
+			key := topicPartition{topic: topic, partition: partition}
+			if offsets, ok := g.partitions[key]; ok {
+				offsets.revoked = true
+				delete(g.partitions, key)
+			}
+		}
+	}
+}
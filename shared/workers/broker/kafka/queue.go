@@ -0,0 +1,94 @@
+package kafka
+
+import "time"
+
+// Queue is a topic consumed by a consumer group. Records are only ordered
+// within a partition, so producers key them by device ID to keep the readings
+// of a device in order.
+type Queue struct {
+	topic   string
+	options *QueueOptions
+}
+
+type QueueOptions struct {
+	group             string
+	partitions        int
+	replicationFactor int
+	maxRetries        int
+	retryDelay        time.Duration
+	deadLetterTopic   string
+}
+
+type Option func(*QueueOptions)
+
+// WithGroup sets the consumer group. It defaults to the topic name.
+func WithGroup(group string) Option {
+	return func(options *QueueOptions) {
+		options.group = group
+	}
+}
+
+// WithPartitions sets the number of partitions SetupQueue creates the topic
+// with. Zero or less uses the broker default. It has no effect on an existing
+// topic.
+func WithPartitions(partitions int) Option {
+	return func(options *QueueOptions) {
+		options.partitions = partitions
+	}
+}
+
+// WithReplicationFactor sets the replication factor SetupQueue creates the
+// topic with. Zero or less uses the broker default.
+func WithReplicationFactor(replicationFactor int) Option {
+	return func(options *QueueOptions) {
+		options.replicationFactor = replicationFactor
+	}
+}
+
+// WithMaxRetries limits how many times a requeued record is redelivered. A
+// record requeued after its last retry is dead-lettered instead. Less than
+// zero means no limit.
+func WithMaxRetries(maxRetries int) Option {
+	return func(options *QueueOptions) {
+		options.maxRetries = maxRetries
+	}
+}
+
+// WithRetryDelay delays the redelivery of requeued records.
+func WithRetryDelay(retryDelay time.Duration) Option {
+	return func(options *QueueOptions) {
+		options.retryDelay = retryDelay
+	}
+}
+
+// WithDeadLetterTopic produces records rejected without requeue, or requeued
+// after their last retry, to topic. Without it such records are dropped.
+func WithDeadLetterTopic(topic string) Option {
+	return func(options *QueueOptions) {
+		options.deadLetterTopic = topic
+	}
+}
+
+func NewQueue(topic string, options ...Option) *Queue {
+	defaultOptions := &QueueOptions{
+		group:             topic,
+		partitions:        -1,
+		replicationFactor: -1,
+		maxRetries:        -1,
+		retryDelay:        0,
+		deadLetterTopic:   "",
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Queue{
+		topic:   topic,
+		options: defaultOptions,
+	}
+}
+
+func (q *Queue) GetName() string {
+	return q.topic
+}
@@ -0,0 +1,375 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+)
+
+var errBrokerClosed = errors.New("broker closed")
+
+// Queue names a queue of the in-memory broker.
+type Queue string
+
+func (q Queue) GetName() string {
+	return string(q)
+}
+
+// Broker is an in-memory broker.MessageBroker and broker.MessagePublisher
+// meant for tests. It keeps the acknowledgement semantics of RabbitMQ:
+// messages stay unacknowledged until acked or nacked, nacked messages can be
+// requeued and are then redelivered first, and Qos limits how many
+// unacknowledged messages a queue hands out.
+//
+// Publishing to the default exchange ("") routes to the queue named by the
+// routing key. Other exchanges route through Bind.
+type Broker struct {
+	mu       sync.Mutex
+	queues   map[string]*queue
+	bindings map[string][]binding
+	closed   bool
+	closing  chan struct{}
+
+	// changed is closed and replaced every time a queue changes, waking up the
+	// consumers waiting for a message or for prefetch room.
+	changed chan struct{}
+}
+
+type binding struct {
+	queue      string
+	routingKey string
+}
+
+type queue struct {
+	ready    []broker.Message
+	unacked  []delivery
+	prefetch int
+	nextTag  uint64
+
+	acked   int
+	dropped int
+}
+
+type delivery struct {
+	tag uint64
+	msg broker.Message
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		queues:   make(map[string]*queue),
+		bindings: make(map[string][]binding),
+		closing:  make(chan struct{}),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (b *Broker) Connect() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errBrokerClosed
+	}
+
+	return nil
+}
+
+// Close stops every consumer. Messages still queued are kept, so they can be
+// inspected after the consumers have stopped.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+	close(b.closing)
+
+	return nil
+}
+
+// DeclareQueue creates the queue if it does not exist yet. Queues are also
+// created by Qos, ConsumeQueue and Bind.
+func (b *Broker) DeclareQueue(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queue(name)
+}
+
+// Bind routes messages published to exchange with routingKey to the queue. A
+// routing key of "#" matches every message of the exchange.
+func (b *Broker) Bind(queueName, exchange, routingKey string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queue(queueName)
+	b.bindings[exchange] = append(b.bindings[exchange], binding{queue: queueName, routingKey: routingKey})
+}
+
+// queue must be called with b.mu held.
+func (b *Broker) queue(name string) *queue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &queue{}
+		b.queues[name] = q
+	}
+
+	return q
+}
+
+// notify must be called with b.mu held.
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Qos limits how many unacknowledged messages the queue hands out across all
+// of its consumers. A prefetch count of zero means no limit.
+func (b *Broker) Qos(queue broker.Queue, prefetchCount, prefetchSize int, global bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queue(queue.GetName()).prefetch = prefetchCount
+	b.notify()
+
+	return nil
+}
+
+func (b *Broker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan broker.Message, error) {
+	name := queue.GetName()
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, errBrokerClosed
+	}
+	b.queue(name)
+	b.mu.Unlock()
+
+	out := make(chan broker.Message)
+
+	go func() {
+		defer close(out)
+
+		for ctx.Err() == nil {
+			b.mu.Lock()
+			msg, tag, ok := b.next(name)
+			changed := b.changed
+			b.mu.Unlock()
+
+			if ok {
+				select {
+				case out <- msg:
+					continue
+				case <-ctx.Done():
+					b.giveBack(name, tag)
+					return
+				case <-b.closing:
+					b.giveBack(name, tag)
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			case <-b.closing:
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// next takes the first ready message of the queue if the prefetch limit
+// allows it. It must be called with b.mu held.
+func (b *Broker) next(name string) (broker.Message, uint64, bool) {
+	q := b.queues[name]
+
+	if len(q.ready) == 0 || (q.prefetch > 0 && len(q.unacked) >= q.prefetch) {
+		return broker.Message{}, 0, false
+	}
+
+	msg := q.ready[0]
+	q.ready = q.ready[1:]
+
+	q.nextTag++
+	tag := q.nextTag
+	q.unacked = append(q.unacked, delivery{tag: tag, msg: msg})
+
+	msg.Acknowledger = broker.AckFuncs{
+		AckFunc: func(multiple bool) error {
+			return b.settle(name, tag, multiple, true, false)
+		},
+		NackFunc: func(multiple, requeue bool) error {
+			return b.settle(name, tag, multiple, false, requeue)
+		},
+	}
+
+	return msg, tag, true
+}
+
+// giveBack returns a message taken by next that was never handed to the
+// consumer to the front of the queue.
+func (b *Broker) giveBack(name string, tag uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queues[name]
+
+	for i, d := range q.unacked {
+		if d.tag == tag {
+			q.unacked = append(q.unacked[:i], q.unacked[i+1:]...)
+			q.ready = append([]broker.Message{d.msg}, q.ready...)
+			b.notify()
+			return
+		}
+	}
+}
+
+func (b *Broker) settle(name string, tag uint64, multiple, ack, requeue bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queues[name]
+
+	i := -1
+	for j, d := range q.unacked {
+		if d.tag == tag {
+			i = j
+			break
+		}
+	}
+
+	if i < 0 {
+		return fmt.Errorf("unknown delivery tag %d on queue %s", tag, name)
+	}
+
+	first := i
+	if multiple {
+		first = 0
+	}
+
+	settled := append([]delivery(nil), q.unacked[first:i+1]...)
+	q.unacked = append(q.unacked[:first], q.unacked[i+1:]...)
+
+	switch {
+	case ack:
+		q.acked += len(settled)
+	case requeue:
+		redelivered := make([]broker.Message, len(settled))
+		for j, d := range settled {
+			d.msg.Redelivered = true
+			redelivered[j] = d.msg
+		}
+		q.ready = append(redelivered, q.ready...)
+	default:
+		q.dropped += len(settled)
+	}
+
+	b.notify()
+
+	return nil
+}
+
+func (b *Broker) Publish(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	_, err := b.publish(exchange, routingKey, msg)
+	return err
+}
+
+// PublishWithConfirm publishes msg and returns a *broker.ReturnedError when no
+// queue receives it.
+func (b *Broker) PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	routed, err := b.publish(exchange, routingKey, msg)
+	if err != nil {
+		return err
+	}
+
+	if !routed {
+		return &broker.ReturnedError{
+			Exchange:   exchange,
+			RoutingKey: routingKey,
+			ReplyCode:  312,
+			ReplyText:  "NO_ROUTE",
+		}
+	}
+
+	return nil
+}
+
+func (b *Broker) publish(exchange, routingKey string, msg broker.Message) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return false, errBrokerClosed
+	}
+
+	var targets []string
+
+	if exchange == "" {
+		if _, ok := b.queues[routingKey]; ok {
+			targets = append(targets, routingKey)
+		}
+	} else {
+		for _, bd := range b.bindings[exchange] {
+			if bd.routingKey == "#" || bd.routingKey == routingKey {
+				targets = append(targets, bd.queue)
+			}
+		}
+	}
+
+	msg.RoutingKey = routingKey
+	msg.Redelivered = false
+	msg.Acknowledger = nil
+
+	for _, name := range targets {
+		copied := msg
+		copied.Headers = maps.Clone(msg.Headers)
+
+		q := b.queues[name]
+		q.ready = append(q.ready, copied)
+	}
+
+	if len(targets) > 0 {
+		b.notify()
+	}
+
+	return len(targets) > 0, nil
+}
+
+// Stats reports how many messages of a queue are waiting to be delivered,
+// delivered but not settled, acknowledged, and rejected without requeueing.
+type Stats struct {
+	Ready   int
+	Unacked int
+	Acked   int
+	Dropped int
+}
+
+func (b *Broker) Stats(queueName string) Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queueName]
+	if !ok {
+		return Stats{}
+	}
+
+	return Stats{
+		Ready:   len(q.ready),
+		Unacked: len(q.unacked),
+		Acked:   q.acked,
+		Dropped: q.dropped,
+	}
+}
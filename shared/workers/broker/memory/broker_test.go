@@ -0,0 +1,181 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+)
+
+func receive(t *testing.T, messages <-chan broker.Message) broker.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			t.Fatal("messages channel closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return broker.Message{}
+	}
+}
+
+func expectNone(t *testing.T, messages <-chan broker.Message) {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		t.Fatalf("unexpected message %s", msg.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroker_PublishAndAck(t *testing.T) {
+	b := NewBroker()
+	b.DeclareQueue("data")
+
+	ctx := context.Background()
+
+	for _, body := range []string{"one", "two"} {
+		if err := b.PublishWithConfirm(ctx, "", "data", broker.Message{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := b.ConsumeQueue(ctx, Queue("data"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, messages)
+	second := receive(t, messages)
+
+	if string(first.Body) != "one" || string(second.Body) != "two" {
+		t.Fatalf("received %s, %s, want one, two", first.Body, second.Body)
+	}
+	if first.RoutingKey != "data" {
+		t.Errorf("routing key = %s, want data", first.RoutingKey)
+	}
+
+	if got := b.Stats("data"); got.Unacked != 2 {
+		t.Errorf("unacked = %d, want 2", got.Unacked)
+	}
+
+	// A multiple ack of the second message settles the first one as well.
+	if err := second.Ack(true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.Stats("data"); got != (Stats{Acked: 2}) {
+		t.Errorf("stats = %+v, want 2 acked", got)
+	}
+
+	if err := first.Ack(false); err == nil {
+		t.Error("acking an already acknowledged message succeeded")
+	}
+}
+
+func TestBroker_NackRequeue(t *testing.T) {
+	b := NewBroker()
+
+	// With a prefetch of one the second message is not taken from the queue
+	// before the first one is settled.
+	ctx := context.Background()
+	b.Qos(Queue("data"), 1, 0, false)
+	b.Publish(ctx, "", "data", broker.Message{Body: []byte("one")})
+	b.Publish(ctx, "", "data", broker.Message{Body: []byte("two")})
+
+	messages, _ := b.ConsumeQueue(ctx, Queue("data"), "test")
+
+	first := receive(t, messages)
+	if err := first.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The requeued message is redelivered before the rest of the queue.
+	redelivered := receive(t, messages)
+	if string(redelivered.Body) != "one" || !redelivered.Redelivered {
+		t.Errorf("received %s (redelivered %v), want one redelivered", redelivered.Body, redelivered.Redelivered)
+	}
+
+	redelivered.Nack(false, false)
+
+	if got := b.Stats("data"); got.Dropped != 1 {
+		t.Errorf("dropped = %d, want 1", got.Dropped)
+	}
+}
+
+func TestBroker_Qos(t *testing.T) {
+	b := NewBroker()
+
+	ctx := context.Background()
+	b.Qos(Queue("data"), 1, 0, false)
+	b.Publish(ctx, "", "data", broker.Message{Body: []byte("one")})
+	b.Publish(ctx, "", "data", broker.Message{Body: []byte("two")})
+
+	messages, _ := b.ConsumeQueue(ctx, Queue("data"), "test")
+
+	first := receive(t, messages)
+	expectNone(t, messages)
+
+	first.Ack(false)
+
+	if second := receive(t, messages); string(second.Body) != "two" {
+		t.Errorf("received %s, want two", second.Body)
+	}
+}
+
+func TestBroker_Routing(t *testing.T) {
+	b := NewBroker()
+	b.Bind("alerts", "events", "alert")
+	b.Bind("audit", "events", "#")
+
+	ctx := context.Background()
+
+	if err := b.PublishWithConfirm(ctx, "events", "alert", broker.Message{Body: []byte("hot")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.PublishWithConfirm(ctx, "events", "status", broker.Message{Body: []byte("online")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.Stats("alerts").Ready; got != 1 {
+		t.Errorf("alerts ready = %d, want 1", got)
+	}
+	if got := b.Stats("audit").Ready; got != 2 {
+		t.Errorf("audit ready = %d, want 2", got)
+	}
+
+	err := b.PublishWithConfirm(ctx, "", "missing", broker.Message{Body: []byte("lost")})
+
+	var returned *broker.ReturnedError
+	if !errors.As(err, &returned) {
+		t.Errorf("error = %v, want *broker.ReturnedError", err)
+	}
+}
+
+func TestBroker_ConsumeStopsOnCancel(t *testing.T) {
+	b := NewBroker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages, _ := b.ConsumeQueue(ctx, Queue("data"), "test")
+
+	cancel()
+
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Fatal("received a message after cancel")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("messages channel was not closed after cancel")
+	}
+
+	b.Publish(context.Background(), "", "data", broker.Message{Body: []byte("one")})
+
+	if got := b.Stats("data").Ready; got != 1 {
+		t.Errorf("ready = %d, want 1", got)
+	}
+}
@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNoAcknowledger = errors.New("message has no acknowledger")
+
+// Acknowledger settles a consumed message with the broker it came from.
+type Acknowledger interface {
+	// Ack acknowledges the message. With multiple set, every earlier
+	// unacknowledged message of the same consumer is acknowledged too.
+	Ack(multiple bool) error
+	// Nack rejects the message, and with multiple set every earlier
+	// unacknowledged message of the same consumer. Rejected messages are
+	// redelivered when requeue is set and discarded otherwise.
+	Nack(multiple, requeue bool) error
+}
+
+// Message is a message consumed from, or published to, a broker, independent
+// of the broker implementation.
+type Message struct {
+	Body        []byte
+	Headers     map[string]any
+	ContentType string
+	RoutingKey  string
+	Timestamp   time.Time
+
+	// Redelivered is set on consumed messages that were delivered before and
+	// not acknowledged.
+	Redelivered bool
+	// Persistent asks the broker to store a published message durably.
+	Persistent bool
+
+	// Acknowledger is set by the broker on consumed messages.
+	Acknowledger Acknowledger
+}
+
+func (m Message) Ack(multiple bool) error {
+	if m.Acknowledger == nil {
+		return ErrNoAcknowledger
+	}
+
+	return m.Acknowledger.Ack(multiple)
+}
+
+func (m Message) Nack(multiple, requeue bool) error {
+	if m.Acknowledger == nil {
+		return ErrNoAcknowledger
+	}
+
+	return m.Acknowledger.Nack(multiple, requeue)
+}
+
+// AckFuncs adapts a pair of functions to an Acknowledger.
+type AckFuncs struct {
+	AckFunc  func(multiple bool) error
+	NackFunc func(multiple, requeue bool) error
+}
+
+func (a AckFuncs) Ack(multiple bool) error {
+	return a.AckFunc(multiple)
+}
+
+func (a AckFuncs) Nack(multiple, requeue bool) error {
+	return a.NackFunc(multiple, requeue)
+}
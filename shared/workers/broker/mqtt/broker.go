@@ -0,0 +1,434 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+var errBrokerClosed = errors.New("broker closed")
+
+// Broker subscribes to MQTT topics and publishes to them, so the workers can
+// run against any MQTT broker without RabbitMQ.
+//
+// MQTT only acknowledges messages, in the order they arrived, so
+// acknowledgements are mapped onto it as follows: Ack settles a message, Nack
+// with requeue redelivers it in process, and Nack without requeue, or with
+// requeue after the last retry, dead-letters and settles it. A PUBACK is sent
+// once a message and every message received before it on the same
+// subscription are settled. The multiple flag settles every earlier unsettled
+// message of the same subscription.
+//
+// Messages that are not settled when the connection drops are redelivered by
+// the MQTT broker after reconnecting, provided the session survives, see
+// WithCleanSession.
+//
+// The exchange given to Publish is used as a topic prefix: publishing to
+// exchange "iot" with routing key "data" publishes to topic "iot/data".
+type Broker struct {
+	url     string
+	logger  logger.Interface
+	options *BrokerOptions
+
+	mu            sync.Mutex
+	client        paho.Client
+	queues        map[string]*queueState
+	subscriptions map[*subscription]struct{}
+	closed        bool
+}
+
+type queueState struct {
+	queue    *Queue
+	prefetch int
+}
+
+type BrokerOptions struct {
+	clientID       string
+	username       string
+	password       string
+	cleanSession   bool
+	connectTimeout time.Duration
+}
+
+type BrokerOption func(*BrokerOptions)
+
+// WithClientID sets the MQTT client ID. It defaults to a random ID, which
+// cannot resume a previous session.
+func WithClientID(clientID string) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.clientID = clientID
+	}
+}
+
+// WithCredentials sets the username and password used to connect.
+func WithCredentials(username, password string) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.username = username
+		options.password = password
+	}
+}
+
+// WithCleanSession sets whether the MQTT broker discards the session on
+// disconnect. With a persistent session and a stable client ID, messages that
+// were not acknowledged are redelivered when the worker connects again.
+func WithCleanSession(cleanSession bool) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.cleanSession = cleanSession
+	}
+}
+
+// WithConnectTimeout sets how long a connection attempt may take.
+func WithConnectTimeout(connectTimeout time.Duration) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.connectTimeout = connectTimeout
+	}
+}
+
+func NewBroker(url string, logger logger.Interface, options ...BrokerOption) *Broker {
+	defaultOptions := &BrokerOptions{
+		cleanSession:   true,
+		connectTimeout: 10 * time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	if defaultOptions.clientID == "" {
+		defaultOptions.clientID = randomClientID()
+	}
+
+	return &Broker{
+		url:           url,
+		logger:        logger,
+		options:       defaultOptions,
+		queues:        make(map[string]*queueState),
+		subscriptions: make(map[*subscription]struct{}),
+	}
+}
+
+func randomClientID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "iot-worker-" + hex.EncodeToString(b)
+}
+
+// Connect connects to the MQTT broker, retrying at startup. Once connected the
+// client reconnects on its own and subscribes again.
+func (b *Broker) Connect() error {
+	clientOptions := paho.NewClientOptions().
+		AddBroker(b.url).
+		SetClientID(b.options.clientID).
+		SetUsername(b.options.username).
+		SetPassword(b.options.password).
+		SetCleanSession(b.options.cleanSession).
+		SetAutoAckDisabled(true).
+		SetAutoReconnect(true).
+		SetConnectTimeout(b.options.connectTimeout).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(b.onConnectionLost)
+
+	client := paho.NewClient(clientOptions)
+
+	maxRetries := 30
+
+	for i := 0; i < maxRetries; i++ {
+		token := client.Connect()
+		token.Wait()
+
+		if token.Error() == nil {
+			b.mu.Lock()
+			b.client = client
+			b.mu.Unlock()
+
+			b.logger.Info("Connected to MQTT broker", "client_id", b.options.clientID)
+			return nil
+		}
+
+		if i < maxRetries-1 {
+			b.logger.Warn("Failed to connect to MQTT broker, retrying", "retry_number", i+1, "max_retries", maxRetries, "error", token.Error())
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	return fmt.Errorf("failed to connect to MQTT broker after %d attempts", maxRetries)
+}
+
+// onConnect subscribes again after a reconnection. A clean session loses its
+// subscriptions, and subscribing again is harmless otherwise.
+func (b *Broker) onConnect(client paho.Client) {
+	b.mu.Lock()
+	subscriptions := make([]*subscription, 0, len(b.subscriptions))
+	for s := range b.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subscriptions {
+		if err := s.subscribe(client); err != nil {
+			b.logger.Error("Failed to subscribe again after reconnecting", "error", err, "topic", s.queue.filter())
+		}
+	}
+
+	if len(subscriptions) > 0 {
+		b.logger.Info("Reconnected to MQTT broker", "subscriptions", len(subscriptions))
+	}
+}
+
+// onConnectionLost forgets the unsettled messages of every subscription:
+// acknowledgements cannot be sent on the new connection, and the MQTT broker
+// redelivers them instead.
+func (b *Broker) onConnectionLost(_ paho.Client, err error) {
+	b.logger.Warn("Connection to MQTT broker lost, reconnecting", "error", err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscriptions {
+		s.reset()
+	}
+}
+
+// State returns the current connection state.
+func (b *Broker) State() broker.State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return broker.StateClosed
+	}
+
+	if b.client == nil {
+		return broker.StateDisconnected
+	}
+
+	if b.client.IsConnectionOpen() {
+		return broker.StateConnected
+	}
+
+	if b.client.IsConnected() {
+		return broker.StateReconnecting
+	}
+
+	return broker.StateDisconnected
+}
+
+func (b *Broker) mqttClient() (paho.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrokerClosed
+	}
+
+	if b.client == nil {
+		return nil, errors.New("not connected to MQTT broker")
+	}
+
+	return b.client, nil
+}
+
+// SetupQueue registers q. MQTT brokers create topics on demand, so nothing is
+// declared.
+func (b *Broker) SetupQueue(q *Queue) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.queues[q.topic]
+	if !ok {
+		state = &queueState{}
+		b.queues[q.topic] = state
+	}
+	state.queue = q
+}
+
+func (b *Broker) queueState(queue broker.Queue) (*queueState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.queues[queue.GetName()]
+	if !ok {
+		return nil, fmt.Errorf("queue %s is not set up, call SetupQueue first", queue.GetName())
+	}
+
+	return state, nil
+}
+
+// Qos sets the maximum number of unsettled messages handed to the consumers
+// started afterwards on queue. The MQTT broker limits how many messages it
+// sends before they are acknowledged on its own, for example with
+// max_inflight_messages in Mosquitto.
+func (b *Broker) Qos(queue broker.Queue, prefetchCount, prefetchSize int, global bool) error {
+	state, err := b.queueState(queue)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	state.prefetch = prefetchCount
+	b.mu.Unlock()
+
+	return nil
+}
+
+// ConsumeQueue subscribes to queue and delivers its messages until ctx is
+// cancelled or the broker is closed, then unsubscribes. The consumer argument
+// is only used in logs.
+func (b *Broker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan broker.Message, error) {
+	client, err := b.mqttClient()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := b.queueState(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	s := newSubscription(b, state.queue, consumer, state.prefetch)
+	b.subscriptions[s] = struct{}{}
+	b.mu.Unlock()
+
+	if err := s.subscribe(client); err != nil {
+		b.removeSubscription(s)
+		return nil, err
+	}
+
+	out := make(chan broker.Message)
+
+	go func() {
+		defer close(out)
+
+		s.send(ctx, out)
+		b.removeSubscription(s)
+
+		if client.IsConnectionOpen() {
+			token := client.Unsubscribe(s.queue.filter())
+			if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+				b.logger.Warn("Failed to unsubscribe", "error", token.Error(), "topic", s.queue.filter(), "consumer", consumer)
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *Broker) removeSubscription(s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscriptions, s)
+}
+
+func topicFor(exchange, routingKey string) string {
+	if exchange == "" {
+		return routingKey
+	}
+	return exchange + "/" + routingKey
+}
+
+// publish publishes a QoS 1 message. MQTT 3.1.1 has no message properties, so
+// only the body of msg is sent.
+func (b *Broker) publish(topic string, msg broker.Message) (paho.Token, error) {
+	client, err := b.mqttClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Publish(topic, 1, false, msg.Body), nil
+}
+
+// Publish publishes msg without waiting for the MQTT broker to acknowledge it.
+// Failures are only logged.
+func (b *Broker) Publish(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	topic := topicFor(exchange, routingKey)
+
+	token, err := b.publish(topic, msg)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-token.Done()
+		if token.Error() != nil {
+			b.logger.Error("Failed to publish message", "error", token.Error(), "topic", topic)
+		}
+	}()
+
+	return nil
+}
+
+// PublishWithConfirm publishes msg and waits for the MQTT broker to
+// acknowledge it. MQTT does not report messages no subscriber receives, so it
+// never returns a *broker.ReturnedError.
+func (b *Broker) PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	token, err := b.publish(topicFor(exchange, routingKey), msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return nil
+}
+
+// deadLetter publishes the payload of msg to the dead-letter topic of q, if
+// any.
+func (b *Broker) deadLetter(q *Queue, msg paho.Message) error {
+	if q.options.deadLetterTopic == "" {
+		b.logger.Warn("Dropping rejected message, no dead-letter topic", "topic", msg.Topic())
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := b.PublishWithConfirm(ctx, "", q.options.deadLetterTopic, broker.Message{Body: msg.Payload()}); err != nil {
+		return fmt.Errorf("failed to dead-letter message: %w", err)
+	}
+
+	return nil
+}
+
+// Close disconnects from the MQTT broker, which stops every subscription.
+// Messages that are not settled yet are redelivered if the session is
+// persistent.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	client := b.client
+	subscriptions := b.subscriptions
+	b.subscriptions = make(map[*subscription]struct{})
+	b.mu.Unlock()
+
+	for s := range subscriptions {
+		s.stop()
+	}
+
+	if client != nil {
+		client.Disconnect(1000)
+	}
+
+	return nil
+}
@@ -0,0 +1,360 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	mqttserver "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+// fakeMessage records the order it is acknowledged in.
+type fakeMessage struct {
+	id    uint16
+	acked *[]uint16
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 1 }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return "iot/data" }
+func (m *fakeMessage) MessageID() uint16 { return m.id }
+func (m *fakeMessage) Payload() []byte   { return []byte(fmt.Sprint(m.id)) }
+func (m *fakeMessage) Ack()              { *m.acked = append(*m.acked, m.id) }
+
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	server := mqttserver.New(&mqttserver.Options{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	return "tcp://" + tcp.Address()
+}
+
+func newTestBroker(t *testing.T, url string) *Broker {
+	t.Helper()
+
+	b := NewBroker(url, &mockLogger{})
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return b
+}
+
+func publish(t *testing.T, b *Broker, topic string, bodies ...string) {
+	t.Helper()
+	for _, body := range bodies {
+		if err := b.PublishWithConfirm(context.Background(), "", topic, broker.Message{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func receive(t *testing.T, messages <-chan broker.Message) broker.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			t.Fatal("messages channel closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return broker.Message{}
+	}
+}
+
+func consume(t *testing.T, ctx context.Context, b *Broker, q *Queue) <-chan broker.Message {
+	t.Helper()
+
+	b.SetupQueue(q)
+
+	messages, err := b.ConsumeQueue(ctx, q, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return messages
+}
+
+func TestSubscription_AcksInArrivalOrder(t *testing.T) {
+	s := newSubscription(NewBroker("", &mockLogger{}), NewQueue("iot/data"), "test", 0)
+
+	var acked []uint16
+	for id := uint16(1); id <= 3; id++ {
+		s.handle(nil, &fakeMessage{id: id, acked: &acked})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := make(chan broker.Message)
+	go s.send(ctx, out)
+
+	first, second, third := <-out, <-out, <-out
+
+	if err := third.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Nack(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(acked) != 0 {
+		t.Fatalf("acknowledged %v before the first message was settled", acked)
+	}
+
+	if err := first.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(acked) != "[1 2 3]" {
+		t.Errorf("acknowledged %v, want [1 2 3]", acked)
+	}
+}
+
+func TestSubscription_ResetForgetsUnsettledMessages(t *testing.T) {
+	s := newSubscription(NewBroker("", &mockLogger{}), NewQueue("iot/data"), "test", 0)
+
+	var acked []uint16
+	s.handle(nil, &fakeMessage{id: 1, acked: &acked})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := make(chan broker.Message)
+	go s.send(ctx, out)
+
+	msg := <-out
+
+	s.reset()
+
+	if err := msg.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(acked) != 0 {
+		t.Errorf("acknowledged %v after the connection dropped", acked)
+	}
+}
+
+func TestBroker_ConsumeAndAck(t *testing.T) {
+	b := newTestBroker(t, startServer(t))
+
+	if b.State() != broker.StateConnected {
+		t.Errorf("State() = %v, want %v", b.State(), broker.StateConnected)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages := consume(t, ctx, b, NewQueue("iot/data", WithGroup("workers")))
+
+	if err := b.PublishWithConfirm(context.Background(), "iot", "data", broker.Message{Body: []byte("one")}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "iot/data", "two", "three")
+
+	first := receive(t, messages)
+	if string(first.Body) != "one" || first.RoutingKey != "iot/data" {
+		t.Errorf("received %s on %s, want one on iot/data", first.Body, first.RoutingKey)
+	}
+	if first.Redelivered || !first.Persistent || !first.Timestamp.IsZero() {
+		t.Errorf("redelivered = %v, persistent = %v, timestamp = %v", first.Redelivered, first.Persistent, first.Timestamp)
+	}
+
+	receive(t, messages)
+	third := receive(t, messages)
+
+	// A multiple ack of the last message settles the two before it.
+	if err := third.Ack(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ack(false); err == nil {
+		t.Error("acking an already settled message succeeded")
+	}
+
+	cancel()
+	for range messages {
+	}
+}
+
+func TestBroker_SharedSubscription(t *testing.T) {
+	url := startServer(t)
+	publisher := newTestBroker(t, url)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	received := map[string]int{}
+	done := make(chan struct{}, 10)
+
+	for i := 0; i < 2; i++ {
+		messages := consume(t, ctx, newTestBroker(t, url), NewQueue("iot/data", WithGroup("workers")))
+
+		go func() {
+			for msg := range messages {
+				mu.Lock()
+				received[string(msg.Body)]++
+				mu.Unlock()
+				msg.Ack(false)
+				done <- struct{}{}
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		publish(t, publisher, "iot/data", fmt.Sprint(i))
+	}
+
+	for i := 0; i < 10; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d messages, want 10", i)
+		}
+	}
+
+	// Members of a group share the messages instead of each receiving them.
+	select {
+	case <-done:
+		t.Error("a message was delivered to more than one member of the group")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for i := 0; i < 10; i++ {
+		if received[fmt.Sprint(i)] != 1 {
+			t.Errorf("message %d received %d times, want 1", i, received[fmt.Sprint(i)])
+		}
+	}
+}
+
+func TestBroker_NackRequeueAndDeadLetter(t *testing.T) {
+	b := newTestBroker(t, startServer(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deadMessages := consume(t, ctx, b, NewQueue("dead/data"))
+	messages := consume(t, ctx, b, NewQueue("iot/data",
+		WithGroup("workers"),
+		WithMaxRetries(1),
+		WithRetryDelay(50*time.Millisecond),
+		WithDeadLetterTopic("dead/data"),
+	))
+
+	publish(t, b, "iot/data", "retry", "reject")
+
+	for i := 0; i < 2; i++ {
+		msg := receive(t, messages)
+
+		switch string(msg.Body) {
+		case "retry":
+			if err := msg.Nack(false, true); err != nil {
+				t.Fatal(err)
+			}
+		case "reject":
+			if err := msg.Nack(false, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The requeued message comes back once, then goes to the dead-letter
+	// topic because it used up its retries.
+	redelivered := receive(t, messages)
+	if string(redelivered.Body) != "retry" || !redelivered.Redelivered {
+		t.Fatalf("received %s (redelivered %v), want retry redelivered", redelivered.Body, redelivered.Redelivered)
+	}
+	if err := redelivered.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg := receive(t, deadMessages)
+		got[string(msg.Body)] = true
+		msg.Ack(false)
+	}
+
+	if !got["retry"] || !got["reject"] {
+		t.Errorf("dead letters = %v, want retry and reject", got)
+	}
+
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected redelivery of %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBroker_WithConsumer(t *testing.T) {
+	b := newTestBroker(t, startServer(t))
+	q := NewQueue("iot/data", WithGroup("workers"))
+	b.SetupQueue(q)
+
+	handled := make(chan string, 5)
+
+	c := consumer.NewConsumer(b, &mockLogger{}, "test", consumer.WithPrefetchCount(2), consumer.WithWorkers(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Start(ctx, q, func(msg broker.Message) error {
+		handled <- string(msg.Body)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	publish(t, b, "iot/data", "1", "2", "3", "4", "5")
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %d messages, want 5", i)
+		}
+	}
+
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+
+	if err := c.Wait(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,92 @@
+package mqtt
+
+import "time"
+
+// Queue is an MQTT topic filter. With a group the broker subscribes through a
+// shared subscription, so the messages of the topic are spread between the
+// workers of the group instead of being delivered to each of them.
+type Queue struct {
+	topic   string
+	options *QueueOptions
+}
+
+type QueueOptions struct {
+	group           string
+	qos             byte
+	maxRetries      int
+	retryDelay      time.Duration
+	deadLetterTopic string
+}
+
+type Option func(*QueueOptions)
+
+// WithGroup subscribes through the shared subscription $share/group/topic.
+func WithGroup(group string) Option {
+	return func(options *QueueOptions) {
+		options.group = group
+	}
+}
+
+// WithQoS sets the QoS of the subscription. Only QoS 1 and 2 messages are
+// redelivered by the MQTT broker when they are not acknowledged.
+func WithQoS(qos byte) Option {
+	return func(options *QueueOptions) {
+		options.qos = qos
+	}
+}
+
+// WithMaxRetries limits how many times a requeued message is redelivered. A
+// message requeued after its last retry is dead-lettered instead. Less than
+// zero means no limit.
+func WithMaxRetries(maxRetries int) Option {
+	return func(options *QueueOptions) {
+		options.maxRetries = maxRetries
+	}
+}
+
+// WithRetryDelay delays the redelivery of requeued messages.
+func WithRetryDelay(retryDelay time.Duration) Option {
+	return func(options *QueueOptions) {
+		options.retryDelay = retryDelay
+	}
+}
+
+// WithDeadLetterTopic publishes messages rejected without requeue, or
+// requeued after their last retry, to topic. Without it such messages are
+// dropped.
+func WithDeadLetterTopic(topic string) Option {
+	return func(options *QueueOptions) {
+		options.deadLetterTopic = topic
+	}
+}
+
+func NewQueue(topic string, options ...Option) *Queue {
+	defaultOptions := &QueueOptions{
+		group:           "",
+		qos:             1,
+		maxRetries:      -1,
+		retryDelay:      0,
+		deadLetterTopic: "",
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Queue{
+		topic:   topic,
+		options: defaultOptions,
+	}
+}
+
+func (q *Queue) GetName() string {
+	return q.topic
+}
+
+// filter returns the topic filter the broker subscribes to.
+func (q *Queue) filter() string {
+	if q.options.group == "" {
+		return q.topic
+	}
+	return "$share/" + q.options.group + "/" + q.topic
+}
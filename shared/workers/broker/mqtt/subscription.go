@@ -0,0 +1,277 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// subscription is a subscription started by ConsumeQueue.
+//
+// Received messages wait in the ready queue until the consumer takes them, so
+// requeued messages can be put back in front of it, and in the window until
+// they are acknowledged, because MQTT requires PUBACKs to be sent in the
+// order the messages arrived.
+type subscription struct {
+	broker   *Broker
+	queue    *Queue
+	consumer string
+	slots    chan struct{}
+	pending  broker.Pending[*delivery]
+	wake     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.Mutex
+	ready  []*delivery
+	window []*delivery
+}
+
+type delivery struct {
+	msg     paho.Message
+	retries int
+	settled bool
+	// lost is set when the connection the message arrived on dropped. The
+	// message can no longer be acknowledged and is redelivered by the MQTT
+	// broker instead.
+	lost bool
+}
+
+func newSubscription(b *Broker, q *Queue, consumer string, prefetch int) *subscription {
+	s := &subscription{
+		broker:   b,
+		queue:    q,
+		consumer: consumer,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	if prefetch > 0 {
+		s.slots = make(chan struct{}, prefetch)
+	}
+
+	return s
+}
+
+func (s *subscription) subscribe(client paho.Client) error {
+	token := client.Subscribe(s.queue.filter(), s.queue.options.qos, s.handle)
+	if !token.WaitTimeout(10 * time.Second) {
+		return fmt.Errorf("timed out subscribing to %s", s.queue.filter())
+	}
+
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", s.queue.filter(), err)
+	}
+
+	return nil
+}
+
+// handle is called by the MQTT client for every message. It must not block,
+// or the client stops reading from the connection.
+func (s *subscription) handle(_ paho.Client, msg paho.Message) {
+	d := &delivery{msg: msg}
+
+	s.mu.Lock()
+	s.window = append(s.window, d)
+	s.mu.Unlock()
+
+	s.push(d, false)
+}
+
+func (s *subscription) push(d *delivery, front bool) {
+	s.mu.Lock()
+	if d.lost {
+		s.mu.Unlock()
+		return
+	}
+	if front {
+		s.ready = append([]*delivery{d}, s.ready...)
+	} else {
+		s.ready = append(s.ready, d)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) send(ctx context.Context, out chan<- broker.Message) {
+	for {
+		if !s.acquire(ctx) {
+			return
+		}
+
+		d, ok := s.next(ctx)
+		if !ok {
+			s.release()
+			return
+		}
+
+		select {
+		case out <- s.toMessage(d):
+		case <-ctx.Done():
+			s.release()
+			return
+		case <-s.done:
+			s.release()
+			return
+		}
+	}
+}
+
+func (s *subscription) next(ctx context.Context) (*delivery, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.ready) > 0 {
+			d := s.ready[0]
+			s.ready = s.ready[1:]
+			s.mu.Unlock()
+			return d, true
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			return nil, false
+		case <-s.done:
+			return nil, false
+		}
+	}
+}
+
+func (s *subscription) acquire(ctx context.Context) bool {
+	if s.slots == nil {
+		return ctx.Err() == nil
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *subscription) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (s *subscription) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+// reset forgets every unsettled message after the connection dropped.
+func (s *subscription) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range s.window {
+		d.lost = true
+	}
+
+	s.window = nil
+	s.ready = nil
+}
+
+func (s *subscription) toMessage(d *delivery) broker.Message {
+	id := s.pending.Add(d)
+
+	// MQTT carries no timestamp or properties. The time the worker received
+	// the message is not one, since a redelivery is received again later.
+	return broker.Message{
+		Body:        d.msg.Payload(),
+		RoutingKey:  d.msg.Topic(),
+		Redelivered: d.msg.Duplicate() || d.retries > 0,
+		Persistent:  d.msg.Qos() > 0,
+		Acknowledger: broker.AckFuncs{
+			AckFunc: func(multiple bool) error {
+				return s.settle(s.pending.Take(id, multiple), func(d *delivery) error {
+					s.ack(d)
+					return nil
+				})
+			},
+			NackFunc: func(multiple, requeue bool) error {
+				return s.settle(s.pending.Take(id, multiple), func(d *delivery) error {
+					return s.nack(d, requeue)
+				})
+			},
+		},
+	}
+}
+
+func (s *subscription) settle(deliveries []*delivery, settle func(*delivery) error) error {
+	if deliveries == nil {
+		return errors.New("message already settled")
+	}
+
+	var errs []error
+	for _, d := range deliveries {
+		if err := settle(d); err != nil {
+			errs = append(errs, err)
+		}
+		s.release()
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *subscription) nack(d *delivery, requeue bool) error {
+	if requeue {
+		if s.queue.options.maxRetries < 0 || d.retries < s.queue.options.maxRetries {
+			d.retries++
+			s.redeliver(d)
+			return nil
+		}
+
+		s.broker.logger.Warn("Message reached its maximum retries, dead-lettering", "topic", d.msg.Topic(), "consumer", s.consumer)
+	}
+
+	if err := s.broker.deadLetter(s.queue, d.msg); err != nil {
+		// The message is retried rather than lost, and it is not acknowledged
+		// until it is settled.
+		s.redeliver(d)
+		return err
+	}
+
+	s.ack(d)
+	return nil
+}
+
+func (s *subscription) redeliver(d *delivery) {
+	if delay := s.queue.options.retryDelay; delay > 0 {
+		time.AfterFunc(delay, func() { s.push(d, true) })
+		return
+	}
+
+	s.push(d, true)
+}
+
+// ack settles d and acknowledges every message at the front of the window
+// that is settled.
+func (s *subscription) ack(d *delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d.lost {
+		return
+	}
+
+	d.settled = true
+
+	for len(s.window) > 0 && s.window[0].settled {
+		s.window[0].msg.Ack()
+		s.window = s.window[1:]
+	}
+}
@@ -0,0 +1,532 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Headers added to dead-lettered messages.
+const (
+	deadLetterSubjectHeader = "Iot-Dead-Letter-Subject"
+	deadLetterQueueHeader   = "Iot-Dead-Letter-Queue"
+)
+
+var errBrokerClosed = errors.New("broker closed")
+
+// Broker consumes JetStream durable consumers and publishes to JetStream
+// streams.
+//
+// Acknowledgements are mapped onto JetStream as follows: Ack acks, Nack with
+// requeue naks so the message is redelivered, and Nack without requeue, or
+// with requeue on the last allowed delivery, dead-letters and terminates the
+// message. JetStream settles one message at a time, so the multiple flag is
+// emulated by settling every earlier unsettled message of the same consumer.
+//
+// The exchange given to Publish is used as a subject prefix: publishing to
+// exchange "iot" with routing key "data" publishes to subject "iot.data".
+type Broker struct {
+	url     string
+	logger  logger.Interface
+	options *BrokerOptions
+
+	mu     sync.Mutex
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	queues map[string]*queueState
+	closed bool
+}
+
+type queueState struct {
+	queue    *Queue
+	prefetch int
+}
+
+type BrokerOptions struct {
+	reconnectWait  time.Duration
+	connectOptions []nats.Option
+}
+
+type BrokerOption func(*BrokerOptions)
+
+// WithReconnectWait sets the delay between reconnection attempts.
+func WithReconnectWait(reconnectWait time.Duration) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.reconnectWait = reconnectWait
+	}
+}
+
+// WithConnectOptions adds options, such as credentials, to the NATS
+// connection.
+func WithConnectOptions(connectOptions ...nats.Option) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.connectOptions = append(options.connectOptions, connectOptions...)
+	}
+}
+
+func NewBroker(url string, logger logger.Interface, options ...BrokerOption) *Broker {
+	defaultOptions := &BrokerOptions{
+		reconnectWait: 2 * time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Broker{
+		url:     url,
+		logger:  logger,
+		options: defaultOptions,
+		queues:  make(map[string]*queueState),
+	}
+}
+
+// Connect connects to NATS, retrying at startup. Once connected the NATS
+// client reconnects on its own and consumers resume where they left off.
+func (b *Broker) Connect() error {
+	connectOptions := append([]nats.Option{
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(b.options.reconnectWait),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			b.logger.Warn("Connection to NATS lost, reconnecting", "error", err)
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			b.logger.Info("Reconnected to NATS", "url", nc.ConnectedUrlRedacted())
+		}),
+	}, b.options.connectOptions...)
+
+	maxRetries := 30
+
+	for i := 0; i < maxRetries; i++ {
+		nc, err := nats.Connect(b.url, connectOptions...)
+		if err == nil {
+			js, err := jetstream.New(nc)
+			if err != nil {
+				nc.Close()
+				return fmt.Errorf("failed to create JetStream context: %w", err)
+			}
+
+			b.mu.Lock()
+			b.nc = nc
+			b.js = js
+			b.mu.Unlock()
+
+			b.logger.Info("Connected to NATS")
+			return nil
+		}
+
+		if i < maxRetries-1 {
+			b.logger.Warn("Failed to connect to NATS, retrying", "retry_number", i+1, "max_retries", maxRetries)
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	return fmt.Errorf("failed to connect to NATS after %d attempts", maxRetries)
+}
+
+// State returns the current connection state.
+func (b *Broker) State() broker.State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return broker.StateClosed
+	}
+
+	if b.nc == nil {
+		return broker.StateDisconnected
+	}
+
+	switch b.nc.Status() {
+	case nats.CONNECTED:
+		return broker.StateConnected
+	case nats.RECONNECTING, nats.CONNECTING:
+		return broker.StateReconnecting
+	case nats.CLOSED:
+		return broker.StateClosed
+	default:
+		return broker.StateDisconnected
+	}
+}
+
+func (b *Broker) jetStream() (jetstream.JetStream, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrokerClosed
+	}
+
+	if b.js == nil {
+		return nil, errors.New("not connected to NATS")
+	}
+
+	return b.js, nil
+}
+
+// SetupQueue creates the stream of q when it declares subjects, and creates
+// or updates its durable consumer.
+func (b *Broker) SetupQueue(ctx context.Context, q *Queue) error {
+	js, err := b.jetStream()
+	if err != nil {
+		return err
+	}
+
+	if len(q.options.subjects) > 0 {
+		_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:     q.options.stream,
+			Subjects: q.options.subjects,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create stream %s: %w", q.options.stream, err)
+		}
+	}
+
+	b.mu.Lock()
+	state, ok := b.queues[q.queueName]
+	if !ok {
+		state = &queueState{queue: q}
+		b.queues[q.queueName] = state
+	}
+	state.queue = q
+	prefetch := state.prefetch
+	b.mu.Unlock()
+
+	return b.updateConsumer(ctx, js, q, prefetch)
+}
+
+func (b *Broker) updateConsumer(ctx context.Context, js jetstream.JetStream, q *Queue, prefetch int) error {
+	config := jetstream.ConsumerConfig{
+		Durable:       q.queueName,
+		FilterSubject: q.options.filterSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       q.options.ackWait,
+		MaxDeliver:    q.options.maxDeliver,
+	}
+
+	if prefetch > 0 {
+		config.MaxAckPending = prefetch
+	}
+
+	if _, err := js.CreateOrUpdateConsumer(ctx, q.options.stream, config); err != nil {
+		return fmt.Errorf("failed to create consumer %s on stream %s: %w", q.queueName, q.options.stream, err)
+	}
+
+	return nil
+}
+
+func (b *Broker) queueState(queue broker.Queue) (*queueState, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state, ok := b.queues[queue.GetName()]
+	if !ok {
+		return nil, fmt.Errorf("queue %s is not set up, call SetupQueue first", queue.GetName())
+	}
+
+	return state, nil
+}
+
+// Qos sets the maximum number of unacknowledged messages of the consumer.
+func (b *Broker) Qos(queue broker.Queue, prefetchCount, prefetchSize int, global bool) error {
+	js, err := b.jetStream()
+	if err != nil {
+		return err
+	}
+
+	state, err := b.queueState(queue)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	state.prefetch = prefetchCount
+	b.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return b.updateConsumer(ctx, js, state.queue, prefetchCount)
+}
+
+// ConsumeQueue pulls messages from the durable consumer of queue until ctx is
+// cancelled or the broker is closed. The consumer argument is only used in
+// logs: every ConsumeQueue call on the same queue shares its durable
+// consumer.
+func (b *Broker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan broker.Message, error) {
+	js, err := b.jetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := b.queueState(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	q, prefetch := state.queue, state.prefetch
+	b.mu.Unlock()
+
+	cons, err := js.Consumer(ctx, q.options.stream, q.queueName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get consumer %s: %w", q.queueName, err)
+	}
+
+	var pullOptions []jetstream.PullMessagesOpt
+	if prefetch > 0 {
+		pullOptions = append(pullOptions, jetstream.PullMaxMessages(prefetch))
+	}
+
+	iter, err := cons.Messages(pullOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume %s: %w", q.queueName, err)
+	}
+
+	out := make(chan broker.Message)
+	pending := &broker.Pending[jetstream.Msg]{}
+
+	// Once ctx is cancelled the iterator stops pulling, and the messages it
+	// already buffered are naked below so they are redelivered right away
+	// instead of after the ack wait.
+	stopDraining := context.AfterFunc(ctx, iter.Drain)
+
+	go func() {
+		defer close(out)
+		defer stopDraining()
+		defer iter.Stop()
+
+		for {
+			msg, err := iter.Next()
+			if err != nil {
+				if errors.Is(err, jetstream.ErrMsgIteratorClosed) || errors.Is(err, nats.ErrConnectionClosed) {
+					return
+				}
+
+				b.logger.Warn("Failed to pull message from NATS", "error", err, "queue", q.queueName, "consumer", consumer)
+				continue
+			}
+
+			if ctx.Err() == nil {
+				select {
+				case out <- b.toMessage(q, msg, pending):
+					continue
+				case <-ctx.Done():
+				}
+			}
+
+			if err := msg.Nak(); err != nil {
+				b.logger.Warn("Failed to nak undelivered message", "error", err, "queue", q.queueName)
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (b *Broker) toMessage(q *Queue, msg jetstream.Msg, pending *broker.Pending[jetstream.Msg]) broker.Message {
+	id := pending.Add(msg)
+
+	message := broker.Message{
+		Body:        msg.Data(),
+		Headers:     fromHeader(msg.Headers()),
+		ContentType: msg.Headers().Get("Content-Type"),
+		RoutingKey:  msg.Subject(),
+		Acknowledger: broker.AckFuncs{
+			AckFunc: func(multiple bool) error {
+				return b.settle(pending.Take(id, multiple), func(m jetstream.Msg) error {
+					return m.Ack()
+				})
+			},
+			NackFunc: func(multiple, requeue bool) error {
+				return b.settle(pending.Take(id, multiple), func(m jetstream.Msg) error {
+					return b.nack(q, m, requeue)
+				})
+			},
+		},
+	}
+
+	if metadata, err := msg.Metadata(); err == nil {
+		message.Timestamp = metadata.Timestamp
+		message.Redelivered = metadata.NumDelivered > 1
+	}
+
+	return message
+}
+
+func (b *Broker) settle(msgs []jetstream.Msg, settle func(jetstream.Msg) error) error {
+	if msgs == nil {
+		return errors.New("message already settled")
+	}
+
+	var errs []error
+	for _, msg := range msgs {
+		if err := settle(msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *Broker) nack(q *Queue, msg jetstream.Msg, requeue bool) error {
+	if requeue {
+		lastDelivery := false
+		if metadata, err := msg.Metadata(); err == nil && q.options.maxDeliver > 0 {
+			lastDelivery = metadata.NumDelivered >= uint64(q.options.maxDeliver)
+		}
+
+		if !lastDelivery {
+			if q.options.nakDelay > 0 {
+				return msg.NakWithDelay(q.options.nakDelay)
+			}
+			return msg.Nak()
+		}
+
+		b.logger.Warn("Message reached its maximum deliveries, dead-lettering", "queue", q.queueName, "subject", msg.Subject())
+	}
+
+	return b.deadLetter(q, msg)
+}
+
+// deadLetter publishes msg to the dead-letter subject of q, if any, and
+// terminates it. If publishing fails the message is left unsettled, so it is
+// redelivered after the ack wait.
+func (b *Broker) deadLetter(q *Queue, msg jetstream.Msg) error {
+	if q.options.deadLetterSubject != "" {
+		js, err := b.jetStream()
+		if err != nil {
+			return err
+		}
+
+		header := nats.Header{}
+		for key, values := range msg.Headers() {
+			header[key] = values
+		}
+		header.Set(deadLetterSubjectHeader, msg.Subject())
+		header.Set(deadLetterQueueHeader, q.queueName)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_, err = js.PublishMsg(ctx, &nats.Msg{
+			Subject: q.options.deadLetterSubject,
+			Header:  header,
+			Data:    msg.Data(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to dead-letter message: %w", err)
+		}
+	}
+
+	return msg.Term()
+}
+
+func subjectFor(exchange, routingKey string) string {
+	if exchange == "" {
+		return routingKey
+	}
+	return exchange + "." + routingKey
+}
+
+func toNATSMsg(subject string, msg broker.Message) *nats.Msg {
+	header := nats.Header{}
+	for key, value := range msg.Headers {
+		header.Set(key, fmt.Sprint(value))
+	}
+
+	if msg.ContentType != "" {
+		header.Set("Content-Type", msg.ContentType)
+	}
+
+	return &nats.Msg{
+		Subject: subject,
+		Header:  header,
+		Data:    msg.Body,
+	}
+}
+
+func fromHeader(header nats.Header) map[string]any {
+	if len(header) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]any, len(header))
+	for key, values := range header {
+		headers[key] = strings.Join(values, ",")
+	}
+
+	return headers
+}
+
+// Publish publishes msg on core NATS without waiting for JetStream to store
+// it. Messages are always persistent once a stream captures their subject.
+func (b *Broker) Publish(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	b.mu.Lock()
+	nc, closed := b.nc, b.closed
+	b.mu.Unlock()
+
+	if closed {
+		return errBrokerClosed
+	}
+
+	if nc == nil {
+		return errors.New("not connected to NATS")
+	}
+
+	if err := nc.PublishMsg(toNATSMsg(subjectFor(exchange, routingKey), msg)); err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return nil
+}
+
+// PublishWithConfirm publishes msg and waits for JetStream to store it. It
+// returns a *broker.ReturnedError when no stream captures the subject.
+func (b *Broker) PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	js, err := b.jetStream()
+	if err != nil {
+		return err
+	}
+
+	_, err = js.PublishMsg(ctx, toNATSMsg(subjectFor(exchange, routingKey), msg))
+	if errors.Is(err, jetstream.ErrNoStreamResponse) {
+		return &broker.ReturnedError{
+			Exchange:   exchange,
+			RoutingKey: routingKey,
+			ReplyCode:  312,
+			ReplyText:  "NO_ROUTE: no stream captures the subject",
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return nil
+}
+
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+
+	b.closed = true
+
+	if b.nc != nil {
+		b.nc.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+func newTestBroker(t *testing.T) *Broker {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+
+	b := NewBroker(s.ClientURL(), &mockLogger{})
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+
+	return b
+}
+
+func setupQueue(t *testing.T, b *Broker, q *Queue) {
+	t.Helper()
+	if err := b.SetupQueue(context.Background(), q); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func publish(t *testing.T, b *Broker, subject string, bodies ...string) {
+	t.Helper()
+	for _, body := range bodies {
+		if err := b.PublishWithConfirm(context.Background(), "", subject, broker.Message{Body: []byte(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func receive(t *testing.T, messages <-chan broker.Message) broker.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-messages:
+		if !ok {
+			t.Fatal("messages channel closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return broker.Message{}
+	}
+}
+
+func consumerInfo(t *testing.T, b *Broker, q *Queue) *jetstream.ConsumerInfo {
+	t.Helper()
+
+	cons, err := b.js.Consumer(context.Background(), q.options.stream, q.queueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := cons.Info(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
+
+// waitSettled waits for every delivered message of q to be settled. Acks are
+// sent asynchronously, so the server may see them a little later.
+func waitSettled(t *testing.T, b *Broker, q *Queue) *jetstream.ConsumerInfo {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info := consumerInfo(t, b, q)
+		if info.NumAckPending == 0 || time.Now().After(deadline) {
+			return info
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBroker_ConsumeAndAck(t *testing.T) {
+	b := newTestBroker(t)
+	q := NewQueue("data", WithStream("IOT"), WithSubjects("iot.>"))
+	setupQueue(t, b, q)
+
+	if b.State() != broker.StateConnected {
+		t.Errorf("State() = %v, want %v", b.State(), broker.StateConnected)
+	}
+
+	if err := b.PublishWithConfirm(context.Background(), "iot", "data", broker.Message{
+		Body:        []byte("one"),
+		ContentType: "application/x-protobuf",
+		Headers:     map[string]any{"device": "device-1"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	publish(t, b, "iot.data", "two", "three")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, q, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, messages)
+	if string(first.Body) != "one" || first.RoutingKey != "iot.data" {
+		t.Errorf("received %s on %s, want one on iot.data", first.Body, first.RoutingKey)
+	}
+	if first.ContentType != "application/x-protobuf" || first.Headers["device"] != "device-1" {
+		t.Errorf("content type = %s, headers = %v", first.ContentType, first.Headers)
+	}
+	if first.Redelivered || first.Timestamp.IsZero() {
+		t.Errorf("redelivered = %v, timestamp = %v", first.Redelivered, first.Timestamp)
+	}
+
+	receive(t, messages)
+	third := receive(t, messages)
+
+	// A multiple ack of the last message settles the two before it.
+	if err := third.Ack(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ack(false); err == nil {
+		t.Error("acking an already settled message succeeded")
+	}
+
+	if info := waitSettled(t, b, q); info.NumAckPending != 0 {
+		t.Errorf("ack pending = %d, want 0", info.NumAckPending)
+	}
+
+	cancel()
+	for range messages {
+	}
+}
+
+func TestBroker_NackRequeueAndDeadLetter(t *testing.T) {
+	b := newTestBroker(t)
+
+	dead := NewQueue("dead", WithStream("DEAD"), WithSubjects("dead.>"))
+	q := NewQueue("data",
+		WithStream("IOT"),
+		WithSubjects("iot.>"),
+		WithMaxDeliver(2),
+		WithDeadLetterSubject("dead.data"),
+	)
+	setupQueue(t, b, dead)
+	setupQueue(t, b, q)
+
+	publish(t, b, "iot.data", "retry", "reject")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, q, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadMessages, err := b.ConsumeQueue(ctx, dead, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		msg := receive(t, messages)
+
+		switch string(msg.Body) {
+		case "retry":
+			if err := msg.Nack(false, true); err != nil {
+				t.Fatal(err)
+			}
+		case "reject":
+			if err := msg.Nack(false, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The requeued message comes back once, then goes to the dead-letter
+	// subject because it used up its deliveries.
+	redelivered := receive(t, messages)
+	if string(redelivered.Body) != "retry" || !redelivered.Redelivered {
+		t.Fatalf("received %s (redelivered %v), want retry redelivered", redelivered.Body, redelivered.Redelivered)
+	}
+	if err := redelivered.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		msg := receive(t, deadMessages)
+		got[string(msg.Body)] = true
+
+		if msg.Headers[deadLetterQueueHeader] != "data" || msg.Headers[deadLetterSubjectHeader] != "iot.data" {
+			t.Errorf("dead letter headers = %v", msg.Headers)
+		}
+		msg.Ack(false)
+	}
+
+	if !got["retry"] || !got["reject"] {
+		t.Errorf("dead letters = %v, want retry and reject", got)
+	}
+
+	select {
+	case msg := <-messages:
+		t.Errorf("unexpected redelivery of %s", msg.Body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBroker_FilterSubject(t *testing.T) {
+	b := newTestBroker(t)
+	data := NewQueue("data", WithStream("IOT"), WithSubjects("iot.>"), WithFilterSubject("iot.data"))
+	metrics := NewQueue("metrics", WithStream("IOT"), WithSubjects("iot.>"), WithFilterSubject("iot.metrics"))
+	setupQueue(t, b, data)
+	setupQueue(t, b, metrics)
+
+	publish(t, b, "iot.metrics", "cpu")
+	publish(t, b, "iot.data", "temperature")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, data, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := receive(t, messages); string(msg.Body) != "temperature" {
+		t.Errorf("received %s, want temperature", msg.Body)
+	}
+}
+
+func TestBroker_PublishWithConfirm_NoStream(t *testing.T) {
+	b := newTestBroker(t)
+
+	err := b.PublishWithConfirm(context.Background(), "nowhere", "data", broker.Message{Body: []byte("lost")})
+
+	var returned *broker.ReturnedError
+	if !errors.As(err, &returned) {
+		t.Fatalf("error = %v, want *broker.ReturnedError", err)
+	}
+	if returned.Exchange != "nowhere" || returned.RoutingKey != "data" {
+		t.Errorf("returned = %+v", returned)
+	}
+}
+
+func TestBroker_WithConsumer(t *testing.T) {
+	b := newTestBroker(t)
+	q := NewQueue("data", WithStream("IOT"), WithSubjects("iot.>"))
+	setupQueue(t, b, q)
+
+	publish(t, b, "iot.data", "1", "2", "3", "4", "5")
+
+	handled := make(chan string, 5)
+
+	c := consumer.NewConsumer(b, &mockLogger{}, "test", consumer.WithPrefetchCount(2), consumer.WithWorkers(2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Start(ctx, q, func(msg broker.Message) error {
+		handled <- string(msg.Body)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("handled %d messages, want 5", i)
+		}
+	}
+
+	cancel()
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+
+	if err := c.Wait(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	info := waitSettled(t, b, q)
+
+	if info.Config.MaxAckPending != 2 {
+		t.Errorf("max ack pending = %d, want 2", info.Config.MaxAckPending)
+	}
+	if info.NumAckPending != 0 || info.NumPending != 0 {
+		t.Errorf("ack pending = %d, pending = %d, want 0 and 0", info.NumAckPending, info.NumPending)
+	}
+}
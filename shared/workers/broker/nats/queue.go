@@ -0,0 +1,106 @@
+package nats
+
+import "time"
+
+// Queue is a durable JetStream consumer. Its name is used as the durable
+// consumer name.
+type Queue struct {
+	queueName string
+	options   *QueueOptions
+}
+
+type QueueOptions struct {
+	stream            string
+	subjects          []string
+	filterSubject     string
+	maxDeliver        int
+	ackWait           time.Duration
+	nakDelay          time.Duration
+	deadLetterSubject string
+}
+
+type Option func(*QueueOptions)
+
+// WithStream sets the stream the consumer reads from. It defaults to the
+// queue name.
+func WithStream(stream string) Option {
+	return func(options *QueueOptions) {
+		options.stream = stream
+	}
+}
+
+// WithSubjects makes SetupQueue create or update the stream with these
+// subjects. Without subjects the stream must already exist. Queues sharing a
+// stream must declare the same subjects or none.
+func WithSubjects(subjects ...string) Option {
+	return func(options *QueueOptions) {
+		options.subjects = subjects
+	}
+}
+
+// WithFilterSubject only delivers the messages of the stream published to
+// subject, which may contain wildcards.
+func WithFilterSubject(subject string) Option {
+	return func(options *QueueOptions) {
+		options.filterSubject = subject
+	}
+}
+
+// WithMaxDeliver limits how many times a message is delivered. A message
+// requeued on its last delivery is dead-lettered instead. Zero or less means
+// no limit.
+func WithMaxDeliver(maxDeliver int) Option {
+	return func(options *QueueOptions) {
+		options.maxDeliver = maxDeliver
+	}
+}
+
+// WithAckWait sets how long JetStream waits for a message to be settled
+// before redelivering it.
+func WithAckWait(ackWait time.Duration) Option {
+	return func(options *QueueOptions) {
+		options.ackWait = ackWait
+	}
+}
+
+// WithNakDelay delays the redelivery of requeued messages.
+func WithNakDelay(nakDelay time.Duration) Option {
+	return func(options *QueueOptions) {
+		options.nakDelay = nakDelay
+	}
+}
+
+// WithDeadLetterSubject publishes messages rejected without requeue, or
+// requeued on their last delivery, to subject before terminating them. The
+// subject must be captured by a stream. Without it such messages are only
+// terminated.
+func WithDeadLetterSubject(subject string) Option {
+	return func(options *QueueOptions) {
+		options.deadLetterSubject = subject
+	}
+}
+
+func NewQueue(queueName string, options ...Option) *Queue {
+	defaultOptions := &QueueOptions{
+		stream:            queueName,
+		subjects:          nil,
+		filterSubject:     "",
+		maxDeliver:        -1,
+		ackWait:           30 * time.Second,
+		nakDelay:          0,
+		deadLetterSubject: "",
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Queue{
+		queueName: queueName,
+		options:   defaultOptions,
+	}
+}
+
+func (q *Queue) GetName() string {
+	return q.queueName
+}
@@ -0,0 +1,70 @@
+package broker
+
+import "sync"
+
+// Pending tracks the unsettled messages of a consumer in delivery order. It
+// lets brokers that only settle one message at a time emulate the multiple
+// flag of Ack and Nack.
+type Pending[T any] struct {
+	mu    sync.Mutex
+	next  uint64
+	items []pendingItem[T]
+}
+
+type pendingItem[T any] struct {
+	id   uint64
+	item T
+}
+
+// Add records a delivered message and returns the ID used to settle it.
+func (p *Pending[T]) Add(item T) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.next++
+	p.items = append(p.items, pendingItem[T]{id: p.next, item: item})
+
+	return p.next
+}
+
+// Take removes the message with the given ID and, when multiple is set, every
+// message delivered before it, and returns them in delivery order. It returns
+// nil if the message was already settled.
+func (p *Pending[T]) Take(id uint64, multiple bool) []T {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := -1
+	for j, pi := range p.items {
+		if pi.id == id {
+			i = j
+			break
+		}
+	}
+
+	if i < 0 {
+		return nil
+	}
+
+	first := i
+	if multiple {
+		first = 0
+	}
+
+	taken := make([]T, 0, i+1-first)
+	for _, pi := range p.items[first : i+1] {
+		taken = append(taken, pi.item)
+	}
+
+	p.items = append(p.items[:first], p.items[i+1:]...)
+
+	return taken
+}
+
+// Len returns the number of unsettled messages.
+func (p *Pending[T]) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.items)
+}
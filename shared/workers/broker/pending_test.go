@@ -0,0 +1,39 @@
+package broker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPending_Take(t *testing.T) {
+	var p Pending[string]
+
+	a := p.Add("a")
+	b := p.Add("b")
+	c := p.Add("c")
+	d := p.Add("d")
+
+	if got := p.Take(b, false); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("Take(b, false) = %v, want [b]", got)
+	}
+
+	if got := p.Take(b, false); got != nil {
+		t.Errorf("Take of a settled message = %v, want nil", got)
+	}
+
+	if got := p.Take(c, true); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("Take(c, true) = %v, want [a c]", got)
+	}
+
+	if got := p.Take(a, true); got != nil {
+		t.Errorf("Take of a settled message = %v, want nil", got)
+	}
+
+	if p.Len() != 1 {
+		t.Errorf("Len() = %d, want 1", p.Len())
+	}
+
+	if got := p.Take(d, true); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Errorf("Take(d, true) = %v, want [d]", got)
+	}
+}
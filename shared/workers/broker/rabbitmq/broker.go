@@ -2,7 +2,9 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
@@ -10,19 +12,91 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// Broker shares one AMQP connection between any number of queues. Every queue
+// set up with SetupQueueChannel gets its own channel, so consumers of
+// different queues have independent QoS and a failure on one channel does not
+// stop the others from being set up.
 type Broker struct {
-	conn   *amqp.Connection
-	ch     *amqp.Channel
-	url    string
-	logger logger.Interface
+	url     string
+	logger  logger.Interface
+	options *BrokerOptions
+	dialer  func(url string) (connection, error)
+
+	mu      sync.Mutex
+	conn    connection
+	state   broker.State
+	ready   chan struct{}
+	closing chan struct{}
+	closed  bool
+
+	// Channels in the order their queues were set up, so they are rebuilt in
+	// the same order after a reconnection.
+	channels []*queueChannel
+
+	// The publishing channel is opened on first use and rebuilt, with the
+	// exchanges declared on it, after a reconnection.
+	publishing bool
+	pub        *publisher
+	exchanges  []*Exchange
+}
+
+// queueChannel holds the channel of a queue and everything needed to rebuild
+// it after a reconnection.
+type queueChannel struct {
+	queue *Queue
+	ch    channel
+	qos   *qosSettings
+}
+
+var errBrokerClosed = errors.New("broker closed")
+
+type BrokerOptions struct {
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
 }
 
-func NewBroker(url string, logger logger.Interface) *Broker {
+type BrokerOption func(*BrokerOptions)
+
+type qosSettings struct {
+	prefetchCount int
+	prefetchSize  int
+	global        bool
+}
+
+// WithReconnectDelay sets the delay before the second reconnection attempt.
+// The delay doubles after every failed attempt.
+func WithReconnectDelay(delay time.Duration) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.reconnectDelay = delay
+	}
+}
+
+// WithMaxReconnectDelay caps the delay between reconnection attempts.
+func WithMaxReconnectDelay(delay time.Duration) BrokerOption {
+	return func(options *BrokerOptions) {
+		options.maxReconnectDelay = delay
+	}
+}
+
+func NewBroker(url string, logger logger.Interface, options ...BrokerOption) *Broker {
+	defaultOptions := &BrokerOptions{
+		reconnectDelay:    time.Second,
+		maxReconnectDelay: 30 * time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
 	return &Broker{
-		conn:   nil,
-		ch:     nil,
-		url:    url,
-		logger: logger,
+		conn:    nil,
+		url:     url,
+		logger:  logger,
+		options: defaultOptions,
+		dialer:  dialAMQP,
+		state:   broker.StateDisconnected,
+		ready:   make(chan struct{}),
+		closing: make(chan struct{}),
 	}
 }
 
@@ -30,10 +104,10 @@ func (r *Broker) Connect() error {
 	maxRetries := 30
 
 	for i := 0; i < maxRetries; i++ {
-		conn, err := amqp.Dial(r.url)
+		conn, err := r.dial()
 		if err == nil {
-			r.conn = conn
 			r.logger.Info("Connected to RabbitMQ")
+			go r.watchConnection(conn)
 			return nil
 		}
 
@@ -46,15 +120,356 @@ func (r *Broker) Connect() error {
 	return fmt.Errorf("failed to connect to RabbitMQ after %d attempts", maxRetries)
 }
 
-func (r *Broker) SetupQueueChannel(q *Queue) error {
+// State returns the current connection state.
+func (r *Broker) State() broker.State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// dial opens a new connection and rebuilds the channels, QoS and queues that
+// were set up on the previous one.
+func (r *Broker) dial() (connection, error) {
+	conn, err := r.dialer(r.url)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		conn.Close()
+		return nil, errBrokerClosed
+	}
+
+	r.conn = conn
+
+	for _, qc := range r.channels {
+		qc.ch = nil
+	}
+
+	for _, qc := range r.channels {
+		if err := r.openChannel(qc); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	r.pub = nil
+
+	if r.publishing {
+		if err := r.openPublisher(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	r.setState(broker.StateConnected)
+
+	return conn, nil
+}
+
+// openChannel opens the channel of qc on the current connection, applies its
+// QoS and declares its queue. It must be called with r.mu held.
+func (r *Broker) openChannel(qc *queueChannel) error {
 	ch, err := r.conn.Channel()
 	if err != nil {
+		return fmt.Errorf("failed to open channel for queue %s: %w", qc.queue.queueName, err)
+	}
+
+	if qc.qos != nil {
+		if err := ch.Qos(qc.qos.prefetchCount, qc.qos.prefetchSize, qc.qos.global); err != nil {
+			ch.Close()
+			return fmt.Errorf("failed to set QoS for queue %s: %w", qc.queue.queueName, err)
+		}
+	}
+
+	if err := declareQueue(ch, qc.queue); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to declare queue %s: %w", qc.queue.queueName, err)
+	}
+
+	qc.ch = ch
+
+	go r.watchChannel(r.conn, ch.NotifyClose(make(chan *amqp.Error, 1)), func() error {
+		if qc.ch != ch {
+			return nil
+		}
+
+		qc.ch = nil
+		return r.openChannel(qc)
+	})
+
+	return nil
+}
+
+// openPublisher opens the publishing channel in confirm mode and declares the
+// exchanges on it. It must be called with r.mu held.
+func (r *Broker) openPublisher() error {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open publishing channel: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to put publishing channel in confirm mode: %w", err)
+	}
+
+	for _, e := range r.exchanges {
+		if err := declareExchange(ch, e); err != nil {
+			ch.Close()
+			return fmt.Errorf("failed to declare exchange %s: %w", e.exchangeName, err)
+		}
+	}
+
+	pub := newPublisher(ch, r.logger)
+
+	go pub.run(
+		ch.NotifyPublish(make(chan amqp.Confirmation)),
+		ch.NotifyReturn(make(chan amqp.Return)),
+	)
+
+	r.pub = pub
+	r.publishing = true
+
+	go r.watchChannel(r.conn, ch.NotifyClose(make(chan *amqp.Error, 1)), func() error {
+		if r.pub != pub {
+			return nil
+		}
+
+		r.pub = nil
+		return r.openPublisher()
+	})
+
+	return nil
+}
+
+// channelFor must be called with r.mu held.
+func (r *Broker) channelFor(queueName string) *queueChannel {
+	for _, qc := range r.channels {
+		if qc.queue.queueName == queueName {
+			return qc
+		}
+	}
+
+	return nil
+}
+
+// setState must be called with r.mu held.
+func (r *Broker) setState(state broker.State) {
+	if r.state == state {
+		return
+	}
+
+	if state == broker.StateConnected {
+		close(r.ready)
+	} else if r.state == broker.StateConnected {
+		r.ready = make(chan struct{})
+	}
+
+	r.logger.Info("RabbitMQ connection state changed", "from", r.state, "to", state)
+
+	r.state = state
+}
+
+func (r *Broker) watchConnection(conn connection) {
+	amqpErr := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.setState(broker.StateReconnecting)
+	r.mu.Unlock()
+
+	r.logger.Warn("Connection to RabbitMQ lost, reconnecting", "error", amqpErr)
+
+	r.reconnect()
+}
+
+// watchChannel recovers from a channel-level exception reported on closed,
+// such as a publish to a missing exchange. It calls reopen with r.mu held to
+// rebuild only that channel, so the other channels keep their consumers and
+// unacknowledged deliveries. Channels closed with their connection are
+// rebuilt by the reconnection instead, which is also the fallback when
+// reopen fails.
+func (r *Broker) watchChannel(conn connection, closed <-chan *amqp.Error, reopen func() error) {
+	amqpErr, ok := <-closed
+	if !ok || amqpErr == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if r.closed || r.conn != conn || conn.IsClosed() {
+		r.mu.Unlock()
+		return
+	}
+	err := reopen()
+	r.mu.Unlock()
+
+	if err == nil {
+		r.logger.Warn("RabbitMQ channel closed, reopened it", "error", amqpErr)
+		return
+	}
+
+	r.logger.Warn("Failed to reopen RabbitMQ channel, reconnecting", "error", err, "channel_error", amqpErr)
+
+	conn.Close()
+}
+
+func (r *Broker) reconnect() {
+	delay := r.options.reconnectDelay
+
+	for attempt := 1; ; attempt++ {
+		conn, err := r.dial()
+		if err == nil {
+			r.logger.Info("Reconnected to RabbitMQ", "attempt", attempt)
+			go r.watchConnection(conn)
+			return
+		}
+
+		r.logger.Warn("Failed to reconnect to RabbitMQ, retrying", "error", err, "attempt", attempt, "retry_in", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-r.closing:
+			return
+		}
+
+		delay = min(delay*2, r.options.maxReconnectDelay)
+	}
+}
+
+// SetupQueueChannel opens a dedicated channel for q and declares the queue on
+// it. Setting up a queue that already has a channel is a no-op.
+func (r *Broker) SetupQueueChannel(q *Queue) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return errors.New("not connected to RabbitMQ")
+	}
+
+	if r.channelFor(q.queueName) != nil {
+		return nil
+	}
+
+	qc := &queueChannel{queue: q}
+
+	if err := r.openChannel(qc); err != nil {
 		return err
 	}
 
-	r.ch = ch
+	r.channels = append(r.channels, qc)
+
+	return nil
+}
+
+// SetupExchange declares e on the publishing channel. The exchange is declared
+// again after a reconnection.
+func (r *Broker) SetupExchange(e *Exchange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return errors.New("not connected to RabbitMQ")
+	}
+
+	if r.pub == nil {
+		if err := r.openPublisher(); err != nil {
+			return err
+		}
+	}
+
+	if err := declareExchange(r.pub.ch, e); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", e.exchangeName, err)
+	}
+
+	for _, existing := range r.exchanges {
+		if existing.exchangeName == e.exchangeName {
+			return nil
+		}
+	}
+
+	r.exchanges = append(r.exchanges, e)
+
+	return nil
+}
 
-	_, err = ch.QueueDeclare(
+func declareExchange(ch channel, e *Exchange) error {
+	return ch.ExchangeDeclare(
+		e.exchangeName,
+		e.options.kind,
+		e.options.durable,
+		e.options.autoDelete,
+		e.options.internal,
+		e.options.noWait,
+		e.options.arguments,
+	)
+}
+
+func (r *Broker) Publish(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	pub, err := r.publisher(ctx)
+	if err != nil {
+		return err
+	}
+
+	return pub.publish(ctx, exchange, routingKey, toPublishing(msg), false)
+}
+
+// PublishWithConfirm publishes msg and waits for RabbitMQ to confirm it. The
+// message carries an extra x-publish-id header used to match returns.
+func (r *Broker) PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	pub, err := r.publisher(ctx)
+	if err != nil {
+		return err
+	}
+
+	return pub.publish(ctx, exchange, routingKey, toPublishing(msg), true)
+}
+
+// publisher returns the publisher of the current connection, waiting for a
+// reconnection to finish if needed.
+func (r *Broker) publisher(ctx context.Context) (*publisher, error) {
+	for {
+		r.mu.Lock()
+
+		if r.closed {
+			r.mu.Unlock()
+			return nil, errBrokerClosed
+		}
+
+		if r.state == broker.StateConnected {
+			if r.pub == nil {
+				if err := r.openPublisher(); err != nil {
+					r.mu.Unlock()
+					return nil, err
+				}
+			}
+
+			pub := r.pub
+			r.mu.Unlock()
+			return pub, nil
+		}
+
+		ready := r.ready
+		r.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.closing:
+			return nil, errBrokerClosed
+		}
+	}
+}
+
+func declareQueue(ch channel, q *Queue) error {
+	_, err := ch.QueueDeclare(
 		q.queueName,
 		q.options.durable,
 		q.options.deleteWhenUnused,
@@ -63,45 +478,172 @@ func (r *Broker) SetupQueueChannel(q *Queue) error {
 		q.options.arguments,
 	)
 
-	if err != nil {
-		ch.Close()
-		r.conn.Close()
-		return err
-	}
-
-	return nil
+	return err
 }
 
-func (r *Broker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan amqp.Delivery, error) {
+// ConsumeQueue returns a channel of deliveries that survives reconnections:
+// when the connection is lost the consumer is re-established on the new
+// channel. The returned channel is closed once ctx is cancelled or the broker
+// is closed. Deliveries received before a reconnection can no longer be
+// acknowledged; RabbitMQ redelivers them.
+func (r *Broker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan broker.Message, error) {
 	q, ok := queue.(*Queue)
 	if !ok {
 		return nil, fmt.Errorf("rabbitmq broker expects *rabbitmq.Queue got %T", queue)
 	}
 
-	return r.ch.ConsumeWithContext(
+	deliveries, err := r.consume(ctx, q, consumer)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan broker.Message)
+
+	go func() {
+		defer close(out)
+
+		for {
+			for delivery := range deliveries {
+				select {
+				case out <- toMessage(delivery):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			deliveries = r.resume(ctx, q, consumer)
+			if deliveries == nil {
+				return
+			}
+
+			r.logger.Info("Resumed consuming queue", "queue", q.queueName, "consumer", consumer)
+		}
+	}()
+
+	return out, nil
+}
+
+func (r *Broker) consume(ctx context.Context, q *Queue, consumer string) (<-chan amqp.Delivery, error) {
+	r.mu.Lock()
+	var ch channel
+	if qc := r.channelFor(q.queueName); qc != nil {
+		ch = qc.ch
+	}
+	r.mu.Unlock()
+
+	if ch == nil {
+		return nil, fmt.Errorf("no channel set up for queue %s, call SetupQueueChannel first", q.queueName)
+	}
+
+	return ch.ConsumeWithContext(
 		ctx,
 		q.queueName,
 		consumer,
-		q.options.exclusive,
-		q.options.noWait,
 		false,
+		q.options.exclusive,
 		false,
+		q.options.noWait,
 		nil,
 	)
 }
 
+// resume waits until the broker is connected again and re-establishes the
+// consumer. It returns nil when ctx is cancelled or the broker is closed.
+func (r *Broker) resume(ctx context.Context, q *Queue, consumer string) <-chan amqp.Delivery {
+	for {
+		r.mu.Lock()
+		ready := r.ready
+		r.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil
+		case <-r.closing:
+			return nil
+		}
+
+		deliveries, err := r.consume(ctx, q, consumer)
+		if err == nil {
+			return deliveries
+		}
+
+		r.logger.Warn("Failed to resume consuming queue, retrying", "error", err, "queue", q.queueName, "consumer", consumer)
+
+		select {
+		case <-time.After(r.options.reconnectDelay):
+		case <-ctx.Done():
+			return nil
+		case <-r.closing:
+			return nil
+		}
+	}
+}
+
 func (r *Broker) Close() error {
-	if r.ch != nil {
-		return r.ch.Close()
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
 	}
 
-	if r.conn != nil {
-		return r.conn.Close()
+	r.closed = true
+	close(r.closing)
+	r.setState(broker.StateClosed)
+
+	channels := make([]channel, 0, len(r.channels)+1)
+	for _, qc := range r.channels {
+		if qc.ch != nil {
+			channels = append(channels, qc.ch)
+		}
+	}
+	if r.pub != nil {
+		channels = append(channels, r.pub.ch)
 	}
+	conn := r.conn
+	r.mu.Unlock()
 
-	return nil
+	var errs []error
+
+	for _, ch := range channels {
+		if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	if conn != nil {
+		if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
-func (r *Broker) Qos(prefetchCount, prefetchSize int, global bool) error {
-	return r.ch.Qos(prefetchCount, prefetchSize, global)
+// Qos sets the prefetch limits of the channel of queue. The settings are
+// reapplied when the channel is rebuilt after a reconnection.
+func (r *Broker) Qos(queue broker.Queue, prefetchCount, prefetchSize int, global bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	qc := r.channelFor(queue.GetName())
+	if qc == nil {
+		return fmt.Errorf("no channel set up for queue %s, call SetupQueueChannel first", queue.GetName())
+	}
+
+	qc.qos = &qosSettings{
+		prefetchCount: prefetchCount,
+		prefetchSize:  prefetchSize,
+		global:        global,
+	}
+
+	if qc.ch == nil {
+		return nil
+	}
+
+	return qc.ch.Qos(prefetchCount, prefetchSize, global)
 }
@@ -0,0 +1,690 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fakeServer hands out fake connections and records, in order, every channel
+// and connection that is closed.
+type fakeServer struct {
+	mu      sync.Mutex
+	dials   int
+	fail    bool
+	conns   []*fakeConnection
+	closed  []string
+	dialled chan struct{}
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{dialled: make(chan struct{}, 16)}
+}
+
+func (s *fakeServer) dial(url string) (connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dials++
+	defer func() {
+		select {
+		case s.dialled <- struct{}{}:
+		default:
+		}
+	}()
+
+	if s.fail {
+		return nil, errors.New("connection refused")
+	}
+
+	conn := &fakeConnection{server: s, id: len(s.conns)}
+	s.conns = append(s.conns, conn)
+
+	return conn, nil
+}
+
+func (s *fakeServer) setFail(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = fail
+}
+
+func (s *fakeServer) dialCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *fakeServer) conn(i int) *fakeConnection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[i]
+}
+
+func (s *fakeServer) closeLog() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.closed)
+}
+
+func (s *fakeServer) record(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = append(s.closed, event)
+}
+
+type fakeConnection struct {
+	server *fakeServer
+	id     int
+
+	mu       sync.Mutex
+	closed   bool
+	notify   []chan *amqp.Error
+	channels []*fakeChannel
+}
+
+func (c *fakeConnection) Channel() (channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	ch := &fakeChannel{server: c.server}
+	c.channels = append(c.channels, ch)
+
+	return ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Like amqp, a receiver registered after the close is closed right away.
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+
+	c.notify = append(c.notify, receiver)
+	return receiver
+}
+
+func (c *fakeConnection) IsClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConnection) Close() error {
+	c.server.record(fmt.Sprintf("connection %d", c.id))
+	c.shutdown(nil)
+	return nil
+}
+
+// drop simulates RabbitMQ going away: every channel and the connection are
+// closed with an error.
+func (c *fakeConnection) drop() {
+	c.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED"})
+}
+
+func (c *fakeConnection) shutdown(amqpErr *amqp.Error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	channels := slices.Clone(c.channels)
+	notify := c.notify
+	c.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(amqpErr)
+	}
+
+	for _, receiver := range notify {
+		if amqpErr != nil {
+			receiver <- amqpErr
+		}
+		close(receiver)
+	}
+}
+
+// queueChannels returns the open channels that declared a queue, in the order
+// they were opened.
+func (c *fakeConnection) queueChannels() []*fakeChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var channels []*fakeChannel
+	for _, ch := range c.channels {
+		if ch.queueName() != "" && !ch.isClosed() {
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels
+}
+
+// publishChannels returns the open channels in confirm mode, in the order
+// they were opened.
+func (c *fakeConnection) publishChannels() []*fakeChannel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var channels []*fakeChannel
+	for _, ch := range c.channels {
+		if ch.isConfirming() && !ch.isClosed() {
+			channels = append(channels, ch)
+		}
+	}
+
+	return channels
+}
+
+type fakeChannel struct {
+	server *fakeServer
+
+	mu         sync.Mutex
+	closed     bool
+	confirming bool
+	queue      string
+	prefetch   int
+	exchanges  []string
+	notify     []chan *amqp.Error
+	deliveries []chan amqp.Delivery
+}
+
+func (ch *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.queue = name
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.exchanges = append(ch.exchanges, name)
+	return nil
+}
+
+func (ch *fakeChannel) Confirm(noWait bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirming = true
+	return nil
+}
+
+func (ch *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	return confirm
+}
+
+func (ch *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	return c
+}
+
+func (ch *fakeChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		close(c)
+		return c
+	}
+
+	ch.notify = append(ch.notify, c)
+	return c
+}
+
+func (ch *fakeChannel) GetNextPublishSeqNo() uint64 {
+	return 1
+}
+
+func (ch *fakeChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return nil
+}
+
+func (ch *fakeChannel) ConsumeWithContext(ctx context.Context, queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	deliveries := make(chan amqp.Delivery, 1)
+	ch.deliveries = append(ch.deliveries, deliveries)
+
+	return deliveries, nil
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.server.record("channel " + ch.queueName())
+	ch.shutdown(nil)
+	return nil
+}
+
+func (ch *fakeChannel) shutdown(amqpErr *amqp.Error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+
+	for _, deliveries := range ch.deliveries {
+		close(deliveries)
+	}
+
+	for _, receiver := range ch.notify {
+		if amqpErr != nil {
+			receiver <- amqpErr
+		}
+		close(receiver)
+	}
+}
+
+// fail simulates a channel-level exception: RabbitMQ closes only this
+// channel.
+func (ch *fakeChannel) fail() {
+	ch.shutdown(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange 'missing'"})
+}
+
+func (ch *fakeChannel) isClosed() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.closed
+}
+
+func (ch *fakeChannel) isConfirming() bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.confirming
+}
+
+func (ch *fakeChannel) declaredExchanges() []string {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return slices.Clone(ch.exchanges)
+}
+
+func (ch *fakeChannel) queueName() string {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.queue
+}
+
+func (ch *fakeChannel) prefetchCount() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.prefetch
+}
+
+func (ch *fakeChannel) deliver(body string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	for _, deliveries := range ch.deliveries {
+		deliveries <- amqp.Delivery{Body: []byte(body)}
+	}
+}
+
+func (ch *fakeChannel) consumers() int {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return len(ch.deliveries)
+}
+
+func newTestBroker(t *testing.T, server *fakeServer) *Broker {
+	t.Helper()
+
+	b := NewBroker("amqp://test", &mockLogger{}, WithReconnectDelay(time.Millisecond), WithMaxReconnectDelay(5*time.Millisecond))
+	b.dialer = server.dial
+
+	if err := b.Connect(); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	return b
+}
+
+// setupQueues sets up a channel with its own prefetch count for every name.
+func setupQueues(t *testing.T, b *Broker, names ...string) []*Queue {
+	t.Helper()
+
+	queues := make([]*Queue, 0, len(names))
+	for i, name := range names {
+		q := NewQueue(name)
+		if err := b.SetupQueueChannel(q); err != nil {
+			t.Fatalf("SetupQueueChannel(%s) error = %v", name, err)
+		}
+		if err := b.Qos(q, (i+1)*10, 0, false); err != nil {
+			t.Fatalf("Qos(%s) error = %v", name, err)
+		}
+		queues = append(queues, q)
+	}
+
+	return queues
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func assertQueueChannels(t *testing.T, conn *fakeConnection, names ...string) {
+	t.Helper()
+
+	channels := conn.queueChannels()
+	if len(channels) != len(names) {
+		t.Fatalf("connection %d has %d queue channels, want %d", conn.id, len(channels), len(names))
+	}
+
+	for i, ch := range channels {
+		if ch.queueName() != names[i] {
+			t.Errorf("channel %d declared %q, want %q", i, ch.queueName(), names[i])
+		}
+		if ch.prefetchCount() != (i+1)*10 {
+			t.Errorf("channel %d prefetch = %d, want %d", i, ch.prefetchCount(), (i+1)*10)
+		}
+	}
+}
+
+func TestBroker_SetupQueueChannel_OpensChannelPerQueue(t *testing.T) {
+	server := newFakeServer()
+	b := newTestBroker(t, server)
+	defer b.Close()
+
+	queues := setupQueues(t, b, "sensor_data", "metrics", "alerts")
+
+	// Setting up a queue again keeps its channel and QoS.
+	if err := b.SetupQueueChannel(NewQueue("metrics")); err != nil {
+		t.Fatalf("SetupQueueChannel(metrics) again error = %v", err)
+	}
+
+	assertQueueChannels(t, server.conn(0), "sensor_data", "metrics", "alerts")
+
+	// Every queue consumes on its own channel.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i, q := range queues {
+		msgs, err := b.ConsumeQueue(ctx, q, "test")
+		if err != nil {
+			t.Fatalf("ConsumeQueue(%s) error = %v", q.GetName(), err)
+		}
+
+		channels := server.conn(0).queueChannels()
+		channels[i].deliver(q.GetName())
+
+		select {
+		case msg := <-msgs:
+			if string(msg.Body) != q.GetName() {
+				t.Errorf("queue %s received %q", q.GetName(), msg.Body)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("queue %s received nothing", q.GetName())
+		}
+
+		for j, ch := range channels {
+			want := 0
+			if j <= i {
+				want = 1
+			}
+			if ch.consumers() != want {
+				t.Errorf("after consuming %s, channel %d has %d consumers, want %d", q.GetName(), j, ch.consumers(), want)
+			}
+		}
+	}
+}
+
+func TestBroker_Reconnect_ReopensAllQueueChannels(t *testing.T) {
+	server := newFakeServer()
+	b := newTestBroker(t, server)
+	defer b.Close()
+
+	queues := setupQueues(t, b, "sensor_data", "metrics")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumers := make([]<-chan broker.Message, len(queues))
+	for i, q := range queues {
+		msgs, err := b.ConsumeQueue(ctx, q, "test")
+		if err != nil {
+			t.Fatalf("ConsumeQueue(%s) error = %v", q.GetName(), err)
+		}
+		consumers[i] = msgs
+	}
+
+	// The first attempt fails, so the channels are rebuilt by the retry.
+	server.setFail(true)
+	server.conn(0).drop()
+	<-server.dialled
+	<-server.dialled
+	server.setFail(false)
+
+	waitFor(t, "reconnection", func() bool { return b.State() == broker.StateConnected && server.dialCount() >= 3 })
+
+	conn := server.conn(1)
+	assertQueueChannels(t, conn, "sensor_data", "metrics")
+
+	// The consumers resume on the new channels.
+	channels := conn.queueChannels()
+	for i, q := range queues {
+		waitFor(t, "consumer of "+q.GetName(), func() bool { return channels[i].consumers() == 1 })
+
+		channels[i].deliver("after reconnect")
+
+		select {
+		case msg, ok := <-consumers[i]:
+			if !ok {
+				t.Fatalf("consumer of %s closed on reconnection", q.GetName())
+			}
+			if string(msg.Body) != "after reconnect" {
+				t.Errorf("queue %s received %q", q.GetName(), msg.Body)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("queue %s received nothing after the reconnection", q.GetName())
+		}
+	}
+}
+
+func TestBroker_ChannelException_ReopensOnlyThatChannel(t *testing.T) {
+	server := newFakeServer()
+	b := newTestBroker(t, server)
+	defer b.Close()
+
+	queues := setupQueues(t, b, "sensor_data", "metrics")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	consumers := make([]<-chan broker.Message, len(queues))
+	for i, q := range queues {
+		msgs, err := b.ConsumeQueue(ctx, q, "test")
+		if err != nil {
+			t.Fatalf("ConsumeQueue(%s) error = %v", q.GetName(), err)
+		}
+		consumers[i] = msgs
+	}
+
+	conn := server.conn(0)
+	before := conn.queueChannels()
+	before[1].fail()
+
+	waitFor(t, "metrics channel to be reopened", func() bool {
+		channels := conn.queueChannels()
+		return len(channels) == 2 && channels[1] != before[1] && channels[1].consumers() == 1
+	})
+
+	if conn.IsClosed() || server.dialCount() != 1 {
+		t.Fatalf("connection closed = %v after %d dials, want the connection kept", conn.IsClosed(), server.dialCount())
+	}
+
+	// The reopened channel keeps its QoS, and the other one is untouched.
+	assertQueueChannels(t, conn, "sensor_data", "metrics")
+
+	after := conn.queueChannels()
+	if after[0] != before[0] || before[0].consumers() != 1 {
+		t.Fatal("sensor_data channel was replaced")
+	}
+
+	for i, q := range queues {
+		after[i].deliver("after channel exception")
+
+		select {
+		case msg, ok := <-consumers[i]:
+			if !ok {
+				t.Fatalf("consumer of %s closed on the channel exception", q.GetName())
+			}
+			if string(msg.Body) != "after channel exception" {
+				t.Errorf("queue %s received %q", q.GetName(), msg.Body)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("queue %s received nothing after the channel exception", q.GetName())
+		}
+	}
+}
+
+func TestBroker_ChannelException_ReopensPublisher(t *testing.T) {
+	server := newFakeServer()
+	b := newTestBroker(t, server)
+	defer b.Close()
+
+	if err := b.SetupExchange(NewExchange("iot.readings")); err != nil {
+		t.Fatalf("SetupExchange() error = %v", err)
+	}
+
+	conn := server.conn(0)
+	before := conn.publishChannels()
+	if len(before) != 1 {
+		t.Fatalf("connection has %d publishing channels, want 1", len(before))
+	}
+
+	before[0].fail()
+
+	waitFor(t, "publishing channel to be reopened", func() bool {
+		channels := conn.publishChannels()
+		return len(channels) == 1 && channels[0] != before[0]
+	})
+
+	if got := conn.publishChannels()[0].declaredExchanges(); !slices.Equal(got, []string{"iot.readings"}) {
+		t.Errorf("reopened channel declared %v, want [iot.readings]", got)
+	}
+
+	if conn.IsClosed() || server.dialCount() != 1 {
+		t.Errorf("connection closed = %v after %d dials, want the connection kept", conn.IsClosed(), server.dialCount())
+	}
+}
+
+func TestBroker_Close_ClosesChannelsBeforeConnection(t *testing.T) {
+	server := newFakeServer()
+	b := newTestBroker(t, server)
+
+	queues := setupQueues(t, b, "sensor_data", "metrics")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs, err := b.ConsumeQueue(ctx, queues[0], "test")
+	if err != nil {
+		t.Fatalf("ConsumeQueue() error = %v", err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	want := []string{"channel sensor_data", "channel metrics", "connection 0"}
+	if got := server.closeLog(); !slices.Equal(got, want) {
+		t.Errorf("close order = %v, want %v", got, want)
+	}
+
+	if b.State() != broker.StateClosed {
+		t.Errorf("State() = %v, want %v", b.State(), broker.StateClosed)
+	}
+
+	select {
+	case _, ok := <-msgs:
+		if ok {
+			t.Error("consumer received a message after Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("consumer channel not closed after Close")
+	}
+
+	// Closing the connection must not be taken for a lost connection.
+	time.Sleep(20 * time.Millisecond)
+	if n := server.dialCount(); n != 1 {
+		t.Errorf("dialled %d times, want 1", n)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Errorf("second Close() error = %v", err)
+	}
+}
+
+func TestBroker_Close_StopsReconnecting(t *testing.T) {
+	server := newFakeServer()
+	b := newTestBroker(t, server)
+
+	setupQueues(t, b, "sensor_data")
+
+	server.setFail(true)
+	server.conn(0).drop()
+	<-server.dialled
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// At most one attempt can have been in flight when Close was called.
+	attempts := server.dialCount()
+	server.setFail(false)
+	time.Sleep(20 * time.Millisecond)
+
+	if n := server.dialCount(); n > attempts+1 {
+		t.Errorf("dialled %d times after Close, want at most %d", n, attempts+1)
+	}
+
+	server.mu.Lock()
+	conns := len(server.conns)
+	server.mu.Unlock()
+
+	// A connection opened by an attempt racing Close is closed right away.
+	for i := 1; i < conns; i++ {
+		if !server.conn(i).IsClosed() {
+			t.Errorf("connection %d opened after Close is still open", i)
+		}
+	}
+
+	if b.State() != broker.StateClosed {
+		t.Errorf("State() = %v, want %v", b.State(), broker.StateClosed)
+	}
+}
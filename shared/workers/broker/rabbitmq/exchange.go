@@ -0,0 +1,78 @@
+package rabbitmq
+
+type Exchange struct {
+	exchangeName string
+	options      *ExchangeOptions
+}
+
+type ExchangeOptions struct {
+	kind       string
+	durable    bool
+	autoDelete bool
+	internal   bool
+	noWait     bool
+	arguments  map[string]interface{}
+}
+
+type ExchangeOption func(*ExchangeOptions)
+
+// WithKind sets the exchange type: direct, fanout, topic or headers.
+func WithKind(kind string) ExchangeOption {
+	return func(options *ExchangeOptions) {
+		options.kind = kind
+	}
+}
+
+func WithExchangeDurable(durable bool) ExchangeOption {
+	return func(options *ExchangeOptions) {
+		options.durable = durable
+	}
+}
+
+func WithAutoDelete(autoDelete bool) ExchangeOption {
+	return func(options *ExchangeOptions) {
+		options.autoDelete = autoDelete
+	}
+}
+
+func WithInternal(internal bool) ExchangeOption {
+	return func(options *ExchangeOptions) {
+		options.internal = internal
+	}
+}
+
+func WithExchangeNoWait(noWait bool) ExchangeOption {
+	return func(options *ExchangeOptions) {
+		options.noWait = noWait
+	}
+}
+
+func WithExchangeArguments(arguments map[string]interface{}) ExchangeOption {
+	return func(options *ExchangeOptions) {
+		options.arguments = arguments
+	}
+}
+
+func NewExchange(exchangeName string, options ...ExchangeOption) *Exchange {
+	defaultOptions := &ExchangeOptions{
+		kind:       "topic",
+		durable:    true,
+		autoDelete: false,
+		internal:   false,
+		noWait:     false,
+		arguments:  nil,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Exchange{
+		exchangeName: exchangeName,
+		options:      defaultOptions,
+	}
+}
+
+func (e *Exchange) GetName() string {
+	return e.exchangeName
+}
@@ -0,0 +1,42 @@
+package rabbitmq
+
+import (
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func toMessage(delivery amqp.Delivery) broker.Message {
+	return broker.Message{
+		Body:        delivery.Body,
+		Headers:     delivery.Headers,
+		ContentType: delivery.ContentType,
+		RoutingKey:  delivery.RoutingKey,
+		Timestamp:   delivery.Timestamp,
+		Redelivered: delivery.Redelivered,
+		Persistent:  delivery.DeliveryMode == amqp.Persistent,
+		Acknowledger: broker.AckFuncs{
+			AckFunc: func(multiple bool) error {
+				return delivery.Ack(multiple)
+			},
+			NackFunc: func(multiple, requeue bool) error {
+				return delivery.Nack(multiple, requeue)
+			},
+		},
+	}
+}
+
+func toPublishing(msg broker.Message) amqp.Publishing {
+	publishing := amqp.Publishing{
+		Headers:      msg.Headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Transient,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	}
+
+	if msg.Persistent {
+		publishing.DeliveryMode = amqp.Persistent
+	}
+
+	return publishing
+}
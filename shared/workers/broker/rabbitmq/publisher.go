@@ -0,0 +1,190 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publishIDHeader tags messages published with a confirm, so a message
+// returned by RabbitMQ can be matched to the publish waiting for it.
+const publishIDHeader = "x-publish-id"
+
+// publisher publishes on a channel in confirm mode. Confirmations and returns
+// are read by a single goroutine: RabbitMQ sends the return of a message
+// before its confirmation, so by the time a confirmation is handled the
+// return, if any, has already been recorded.
+type publisher struct {
+	ch     channel
+	logger logger.Interface
+
+	// publishMu keeps reading the next sequence number and publishing atomic.
+	publishMu sync.Mutex
+
+	mu       sync.Mutex
+	pending  map[uint64]*pendingConfirm
+	returned map[string]amqp.Return
+}
+
+type pendingConfirm struct {
+	id   string
+	done chan error
+}
+
+func newPublisher(ch channel, logger logger.Interface) *publisher {
+	return &publisher{
+		ch:       ch,
+		logger:   logger,
+		pending:  make(map[uint64]*pendingConfirm),
+		returned: make(map[string]amqp.Return),
+	}
+}
+
+func (p *publisher) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing, confirm bool) error {
+	p.publishMu.Lock()
+
+	seq := p.ch.GetNextPublishSeqNo()
+
+	var pc *pendingConfirm
+	if confirm {
+		pc = p.register(seq)
+
+		headers := make(amqp.Table, len(msg.Headers)+1)
+		maps.Copy(headers, msg.Headers)
+		headers[publishIDHeader] = pc.id
+		msg.Headers = headers
+	}
+
+	err := p.ch.PublishWithContext(ctx, exchange, routingKey, true, false, msg)
+	p.publishMu.Unlock()
+
+	if err != nil {
+		if pc != nil {
+			p.unregister(seq)
+		}
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	if pc == nil {
+		return nil
+	}
+
+	select {
+	case err := <-pc.done:
+		return err
+	case <-ctx.Done():
+		p.unregister(seq)
+		return ctx.Err()
+	}
+}
+
+func (p *publisher) register(seq uint64) *pendingConfirm {
+	pc := &pendingConfirm{
+		id:   strconv.FormatUint(seq, 10),
+		done: make(chan error, 1),
+	}
+
+	p.mu.Lock()
+	p.pending[seq] = pc
+	p.mu.Unlock()
+
+	return pc
+}
+
+func (p *publisher) unregister(seq uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pc, ok := p.pending[seq]; ok {
+		delete(p.returned, pc.id)
+		delete(p.pending, seq)
+	}
+}
+
+// run handles confirmations and returns until the channel is closed, then
+// fails every publish still waiting for a confirmation.
+func (p *publisher) run(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			p.handleReturn(ret)
+		case confirmation, ok := <-confirms:
+			if !ok {
+				p.failPending()
+				return
+			}
+			p.handleConfirm(confirmation)
+		}
+	}
+}
+
+func (p *publisher) handleReturn(ret amqp.Return) {
+	id, _ := ret.Headers[publishIDHeader].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, pc := range p.pending {
+		if pc.id == id {
+			p.returned[id] = ret
+			return
+		}
+	}
+
+	p.logger.Warn("Message returned by RabbitMQ",
+		"exchange", ret.Exchange,
+		"routing_key", ret.RoutingKey,
+		"reply_code", ret.ReplyCode,
+		"reply_text", ret.ReplyText,
+	)
+}
+
+func (p *publisher) handleConfirm(confirmation amqp.Confirmation) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc, ok := p.pending[confirmation.DeliveryTag]
+	if !ok {
+		return
+	}
+	delete(p.pending, confirmation.DeliveryTag)
+
+	ret, returned := p.returned[pc.id]
+	delete(p.returned, pc.id)
+
+	switch {
+	case !confirmation.Ack:
+		pc.done <- broker.ErrPublishNacked
+	case returned:
+		pc.done <- &broker.ReturnedError{
+			Exchange:   ret.Exchange,
+			RoutingKey: ret.RoutingKey,
+			ReplyCode:  ret.ReplyCode,
+			ReplyText:  ret.ReplyText,
+		}
+	default:
+		pc.done <- nil
+	}
+}
+
+func (p *publisher) failPending() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for seq, pc := range p.pending {
+		pc.done <- broker.ErrConfirmLost
+		delete(p.pending, seq)
+	}
+
+	clear(p.returned)
+}
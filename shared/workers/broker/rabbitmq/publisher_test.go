@@ -0,0 +1,105 @@
+package rabbitmq
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+func waitConfirm(t *testing.T, pc *pendingConfirm) error {
+	t.Helper()
+	select {
+	case err := <-pc.done:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for confirmation")
+		return nil
+	}
+}
+
+func TestPublisher_Run(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+
+	p := newPublisher(nil, &mockLogger{})
+	go p.run(confirms, returns)
+
+	acked := p.register(1)
+	nacked := p.register(2)
+	unroutable := p.register(3)
+
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	if err := waitConfirm(t, acked); err != nil {
+		t.Errorf("acked publish error = %v, want nil", err)
+	}
+
+	confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: false}
+	if err := waitConfirm(t, nacked); !errors.Is(err, broker.ErrPublishNacked) {
+		t.Errorf("nacked publish error = %v, want %v", err, broker.ErrPublishNacked)
+	}
+
+	// A message without the publish ID header was sent with Publish, so the
+	// return is only logged.
+	returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+
+	returns <- amqp.Return{
+		Headers:    amqp.Table{publishIDHeader: unroutable.id},
+		Exchange:   "events",
+		RoutingKey: "nowhere",
+		ReplyCode:  312,
+		ReplyText:  "NO_ROUTE",
+	}
+	confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+
+	var returnedErr *broker.ReturnedError
+	if err := waitConfirm(t, unroutable); !errors.As(err, &returnedErr) {
+		t.Fatalf("unroutable publish error = %v, want *broker.ReturnedError", err)
+	}
+	if returnedErr.RoutingKey != "nowhere" || returnedErr.ReplyCode != 312 {
+		t.Errorf("returned error = %+v", returnedErr)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.pending) != 0 || len(p.returned) != 0 {
+		t.Errorf("pending = %d, returned = %d, want both empty", len(p.pending), len(p.returned))
+	}
+}
+
+func TestPublisher_Run_FailsPendingOnClose(t *testing.T) {
+	confirms := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+
+	p := newPublisher(nil, &mockLogger{})
+	done := make(chan struct{})
+	go func() {
+		p.run(confirms, returns)
+		close(done)
+	}()
+
+	pc := p.register(1)
+
+	close(returns)
+	close(confirms)
+
+	if err := waitConfirm(t, pc); !errors.Is(err, broker.ErrConfirmLost) {
+		t.Errorf("error = %v, want %v", err, broker.ErrConfirmLost)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("run did not return after the channel closed")
+	}
+}
@@ -0,0 +1,11 @@
+package broker
+
+// State is the connection state of a broker as seen by the workers.
+type State string
+
+const (
+	StateDisconnected State = "disconnected"
+	StateConnected    State = "connected"
+	StateReconnecting State = "reconnecting"
+	StateClosed       State = "closed"
+)
@@ -0,0 +1,273 @@
+package clockskew
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+)
+
+// Modes of a Tracker, deciding what happens to the timestamps of a device
+// whose clock is skewed.
+const (
+	// ModeOff leaves timestamps alone and does not track devices.
+	ModeOff = "off"
+	// ModeFlag tracks the skew of every device and reports skewed timestamps
+	// without changing them.
+	ModeFlag = "flag"
+	// ModeCorrect shifts the timestamps of skewed devices by their skew.
+	ModeCorrect = "correct"
+)
+
+// ArrivalHeader is the header RabbitMQ sets to the time, in milliseconds, a
+// message reached the broker when the set_header_timestamp message
+// interceptor is enabled.
+const ArrivalHeader = "timestamp_in_ms"
+
+// Gauge is set to the estimated skew of a device in seconds. It is satisfied
+// by prometheus.Gauge.
+type Gauge interface {
+	Set(value float64)
+}
+
+type Options struct {
+	mode       string
+	window     int
+	minSamples int
+	threshold  time.Duration
+	gauge      func(deviceID string) Gauge
+}
+
+type Option func(*Options)
+
+// WithMode sets the mode of the tracker, ModeFlag by default.
+func WithMode(mode string) Option {
+	return func(options *Options) {
+		options.mode = mode
+	}
+}
+
+// WithWindow sets how many of the latest readings of a device the skew is
+// estimated from.
+func WithWindow(window int) Option {
+	return func(options *Options) {
+		options.window = window
+	}
+}
+
+// WithMinSamples sets how many readings of a device are needed before its
+// skew is trusted. Until then its timestamps are never flagged or corrected.
+func WithMinSamples(minSamples int) Option {
+	return func(options *Options) {
+		options.minSamples = minSamples
+	}
+}
+
+// WithThreshold sets how far a device clock may drift before its timestamps
+// are flagged or corrected.
+func WithThreshold(threshold time.Duration) Option {
+	return func(options *Options) {
+		options.threshold = threshold
+	}
+}
+
+// WithGauge sets a function returning the gauge the skew of a device is
+// exported to.
+func WithGauge(gauge func(deviceID string) Gauge) Option {
+	return func(options *Options) {
+		options.gauge = gauge
+	}
+}
+
+// Result is the outcome of observing a reading.
+type Result struct {
+	// Timestamp is the timestamp of the reading, shifted by Skew when
+	// Corrected is set.
+	Timestamp time.Time
+	// Skew is the estimated offset of the device clock: the arrival time
+	// minus the device time, positive when the device clock is behind.
+	Skew time.Duration
+	// Skewed is set when Skew is trusted and beyond the threshold.
+	Skewed bool
+	// Corrected is set when Timestamp was shifted by Skew.
+	Corrected bool
+}
+
+// Tracker estimates the clock skew of every device from the time its readings
+// reach the broker.
+//
+// The difference between the arrival time and the device timestamp is the
+// skew plus the delivery delay, so the skew is estimated as the smallest
+// difference over the latest readings of the device: the reading with the
+// least delay. Readings buffered by the device while it was offline arrive
+// late and do not raise the estimate as long as fresh readings are in the
+// window.
+type Tracker struct {
+	mu      sync.Mutex
+	devices map[string]*device
+	options *Options
+}
+
+type device struct {
+	samples []time.Duration
+	next    int
+}
+
+func NewTracker(options ...Option) (*Tracker, error) {
+	defaultOptions := &Options{
+		mode:       ModeFlag,
+		window:     20,
+		minSamples: 5,
+		threshold:  time.Minute,
+		gauge:      nil,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	switch defaultOptions.mode {
+	case ModeOff, ModeFlag, ModeCorrect:
+	default:
+		return nil, fmt.Errorf("unknown clock skew mode %q, expected off, flag or correct", defaultOptions.mode)
+	}
+
+	if defaultOptions.window < 1 {
+		return nil, fmt.Errorf("clock skew window must be at least 1, got %d", defaultOptions.window)
+	}
+
+	if defaultOptions.minSamples > defaultOptions.window {
+		return nil, fmt.Errorf("clock skew min samples %d is above the window %d", defaultOptions.minSamples, defaultOptions.window)
+	}
+
+	return &Tracker{
+		devices: make(map[string]*device),
+		options: defaultOptions,
+	}, nil
+}
+
+// Mode returns the mode of the tracker.
+func (t *Tracker) Mode() string {
+	return t.options.mode
+}
+
+// Observe records the skew of a reading of a device and returns its
+// timestamp, corrected in ModeCorrect when the device clock is skewed.
+// Readings without a device ID are not tracked.
+func (t *Tracker) Observe(deviceID string, timestamp, arrival time.Time) Result {
+	result := Result{Timestamp: timestamp}
+
+	if t.options.mode == ModeOff || deviceID == "" {
+		return result
+	}
+
+	skew, trusted := t.observe(deviceID, arrival.Sub(timestamp))
+	result.Skew = skew
+
+	if t.options.gauge != nil {
+		t.options.gauge(deviceID).Set(skew.Seconds())
+	}
+
+	if !trusted || (skew <= t.options.threshold && -skew <= t.options.threshold) {
+		return result
+	}
+
+	result.Skewed = true
+
+	if t.options.mode == ModeCorrect {
+		result.Timestamp = timestamp.Add(skew)
+		result.Corrected = true
+	}
+
+	return result
+}
+
+// Skew returns the estimated skew of a device, and whether enough of its
+// readings were observed to trust it.
+func (t *Tracker) Skew(deviceID string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.devices[deviceID]
+	if !ok {
+		return 0, false
+	}
+
+	return d.estimate(), len(d.samples) >= t.options.minSamples
+}
+
+func (t *Tracker) observe(deviceID string, sample time.Duration) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.devices[deviceID]
+	if !ok {
+		d = &device{samples: make([]time.Duration, 0, t.options.window)}
+		t.devices[deviceID] = d
+	}
+
+	if len(d.samples) < t.options.window {
+		d.samples = append(d.samples, sample)
+	} else {
+		d.samples[d.next] = sample
+		d.next = (d.next + 1) % t.options.window
+	}
+
+	return d.estimate(), len(d.samples) >= t.options.minSamples
+}
+
+func (d *device) estimate() time.Duration {
+	estimate := d.samples[0]
+	for _, sample := range d.samples[1:] {
+		estimate = min(estimate, sample)
+	}
+	return estimate
+}
+
+// ArrivalTime returns the time msg reached the broker: the BrokerTime, or the
+// current time for messages without one.
+func ArrivalTime(msg broker.Message) time.Time {
+	if t, ok := BrokerTime(msg); ok {
+		return t
+	}
+
+	return time.Now()
+}
+
+// BrokerTime returns the time msg reached the broker as recorded in the
+// message: the ArrivalHeader when the broker sets it, the message timestamp
+// otherwise. Unlike the current time, it is the same for every redelivery of
+// the message. MQTT messages have neither.
+func BrokerTime(msg broker.Message) (time.Time, bool) {
+	if ms, ok := headerMillis(msg.Headers[ArrivalHeader]); ok {
+		return time.UnixMilli(ms), true
+	}
+
+	if !msg.Timestamp.IsZero() {
+		return msg.Timestamp, true
+	}
+
+	return time.Time{}, false
+}
+
+// headerMillis reads a header holding milliseconds. AMQP headers keep their
+// integer type while Kafka headers are strings.
+func headerMillis(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	case string:
+		ms, err := strconv.ParseInt(v, 10, 64)
+		return ms, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,232 @@
+package clockskew
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+)
+
+var arrival = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type mockGauge struct {
+	value float64
+}
+
+func (m *mockGauge) Set(value float64) {
+	m.value = value
+}
+
+func TestTracker_Observe(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          string
+		delays        []time.Duration
+		offset        time.Duration
+		wantSkew      time.Duration
+		wantSkewed    bool
+		wantCorrected bool
+	}{
+		{
+			name:     "accurate clock",
+			mode:     ModeCorrect,
+			delays:   []time.Duration{time.Second, 200 * time.Millisecond, 3 * time.Second, time.Second, time.Second},
+			wantSkew: 200 * time.Millisecond,
+		},
+		{
+			name:          "clock behind",
+			mode:          ModeCorrect,
+			delays:        []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second},
+			offset:        -2 * time.Hour,
+			wantSkew:      2*time.Hour + time.Second,
+			wantSkewed:    true,
+			wantCorrected: true,
+		},
+		{
+			name:          "clock ahead",
+			mode:          ModeCorrect,
+			delays:        []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second},
+			offset:        10 * time.Minute,
+			wantSkew:      -10*time.Minute + time.Second,
+			wantSkewed:    true,
+			wantCorrected: true,
+		},
+		{
+			name:       "flag only",
+			mode:       ModeFlag,
+			delays:     []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second},
+			offset:     -2 * time.Hour,
+			wantSkew:   2*time.Hour + time.Second,
+			wantSkewed: true,
+		},
+		{
+			name:     "too few samples",
+			mode:     ModeCorrect,
+			delays:   []time.Duration{time.Second, time.Second},
+			offset:   -2 * time.Hour,
+			wantSkew: 2*time.Hour + time.Second,
+		},
+		{
+			name:   "off",
+			mode:   ModeOff,
+			delays: []time.Duration{time.Second, time.Second, time.Second, time.Second, time.Second},
+			offset: -2 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gauge := &mockGauge{}
+
+			tracker, err := NewTracker(
+				WithMode(tt.mode),
+				WithGauge(func(string) Gauge { return gauge }),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var result Result
+			var timestamp time.Time
+
+			for i, delay := range tt.delays {
+				at := arrival.Add(time.Duration(i) * time.Minute)
+				timestamp = at.Add(-delay).Add(tt.offset)
+				result = tracker.Observe("device-1", timestamp, at)
+			}
+
+			if result.Skew != tt.wantSkew {
+				t.Errorf("Observe() skew = %v, want %v", result.Skew, tt.wantSkew)
+			}
+			if result.Skewed != tt.wantSkewed {
+				t.Errorf("Observe() skewed = %v, want %v", result.Skewed, tt.wantSkewed)
+			}
+			if result.Corrected != tt.wantCorrected {
+				t.Errorf("Observe() corrected = %v, want %v", result.Corrected, tt.wantCorrected)
+			}
+
+			wantTimestamp := timestamp
+			if tt.wantCorrected {
+				wantTimestamp = timestamp.Add(tt.wantSkew)
+			}
+			if !result.Timestamp.Equal(wantTimestamp) {
+				t.Errorf("Observe() timestamp = %v, want %v", result.Timestamp, wantTimestamp)
+			}
+
+			if gauge.value != tt.wantSkew.Seconds() {
+				t.Errorf("gauge = %v, want %v", gauge.value, tt.wantSkew.Seconds())
+			}
+		})
+	}
+}
+
+func TestTracker_Observe_IgnoresLateReadings(t *testing.T) {
+	tracker, err := NewTracker(WithMode(ModeCorrect), WithWindow(4), WithMinSamples(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker.Observe("device-1", arrival.Add(-time.Second), arrival)
+
+	// A reading buffered for an hour while the device was offline.
+	result := tracker.Observe("device-1", arrival.Add(-time.Hour), arrival)
+	if result.Skewed || result.Skew != time.Second {
+		t.Errorf("Observe() = %+v, want the skew of the fresh reading", result)
+	}
+}
+
+func TestTracker_Observe_WindowSlides(t *testing.T) {
+	tracker, err := NewTracker(WithWindow(3), WithMinSamples(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The device clock is an hour behind, then gets fixed.
+	for range 3 {
+		tracker.Observe("device-1", arrival.Add(-time.Hour), arrival)
+	}
+	for range 3 {
+		tracker.Observe("device-1", arrival.Add(time.Hour), arrival)
+	}
+
+	skew, trusted := tracker.Skew("device-1")
+	if skew != -time.Hour || !trusted {
+		t.Errorf("Skew() = %v, %v, want %v, true", skew, trusted, -time.Hour)
+	}
+
+	if _, trusted := tracker.Skew("device-2"); trusted {
+		t.Error("Skew() trusted a device without readings")
+	}
+}
+
+func TestTracker_Observe_NoDeviceID(t *testing.T) {
+	tracker, err := NewTracker(WithMode(ModeCorrect), WithMinSamples(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := tracker.Observe("", arrival.Add(-time.Hour), arrival)
+	if result.Skewed || !result.Timestamp.Equal(arrival.Add(-time.Hour)) {
+		t.Errorf("Observe() = %+v, want the reading untouched", result)
+	}
+}
+
+func TestNewTracker_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+	}{
+		{name: "unknown mode", options: []Option{WithMode("drop")}},
+		{name: "empty window", options: []Option{WithWindow(0), WithMinSamples(0)}},
+		{name: "min samples above window", options: []Option{WithWindow(3), WithMinSamples(5)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTracker(tt.options...); err == nil {
+				t.Error("NewTracker() error = nil")
+			}
+		})
+	}
+}
+
+func TestArrivalTime(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  broker.Message
+		want time.Time
+	}{
+		{
+			name: "amqp header",
+			msg:  broker.Message{Headers: map[string]any{ArrivalHeader: arrival.UnixMilli()}, Timestamp: arrival.Add(time.Hour)},
+			want: arrival,
+		},
+		{
+			name: "string header",
+			msg:  broker.Message{Headers: map[string]any{ArrivalHeader: "1767268800000"}},
+			want: arrival,
+		},
+		{
+			name: "message timestamp",
+			msg:  broker.Message{Headers: map[string]any{ArrivalHeader: "soon"}, Timestamp: arrival},
+			want: arrival,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ArrivalTime(tt.msg); !got.Equal(tt.want) {
+				t.Errorf("ArrivalTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	before := time.Now()
+	if got := ArrivalTime(broker.Message{}); got.Before(before) {
+		t.Errorf("ArrivalTime() = %v, want the current time", got)
+	}
+
+	if got, ok := BrokerTime(broker.Message{}); ok {
+		t.Errorf("BrokerTime() = %v, true, want no time for a message without one", got)
+	}
+}
@@ -2,24 +2,145 @@ package consumer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 type Consumer struct {
 	broker       broker.MessageBroker
 	logger       logger.Interface
 	consumerName string
+	options      *Options
+	done         chan struct{}
 }
 
-func NewConsumer(broker broker.MessageBroker, logger logger.Interface, consumerName string) *Consumer {
+type Options struct {
+	prefetchCount int
+	workers       int
+	shardKey      func(message broker.Message) string
+	batchSize     int
+	batchTimeout  time.Duration
+}
+
+type Option func(*Options)
+
+// WithPrefetchCount sets how many unacknowledged deliveries the broker may
+// push to this consumer at once.
+func WithPrefetchCount(prefetchCount int) Option {
+	return func(options *Options) {
+		options.prefetchCount = prefetchCount
+	}
+}
+
+// WithWorkers sets the number of goroutines running the handler concurrently.
+func WithWorkers(workers int) Option {
+	return func(options *Options) {
+		options.workers = workers
+	}
+}
+
+// WithShardKey routes every delivery with the same key to the same worker, so
+// deliveries sharing a key (e.g. a device ID) are handled in arrival order.
+func WithShardKey(shardKey func(message broker.Message) string) Option {
+	return func(options *Options) {
+		options.shardKey = shardKey
+	}
+}
+
+// WithBatchSize sets the maximum number of deliveries passed to a batch
+// handler at once.
+func WithBatchSize(batchSize int) Option {
+	return func(options *Options) {
+		options.batchSize = batchSize
+	}
+}
+
+// WithBatchTimeout sets how long a partial batch waits for more deliveries
+// before it is handed to the batch handler.
+func WithBatchTimeout(batchTimeout time.Duration) Option {
+	return func(options *Options) {
+		options.batchTimeout = batchTimeout
+	}
+}
+
+// BatchError is returned by a batch handler when only some deliveries of the
+// batch failed. Failed holds the indexes in the batch of the deliveries to
+// requeue and Rejected those to reject without requeue; the remaining
+// deliveries are acknowledged.
+type BatchError struct {
+	Failed   []int
+	Rejected []int
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d deliveries of the batch failed: %v", len(e.Failed)+len(e.Rejected), e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// RejectError is returned by a handler for a delivery that can never be
+// handled, such as a malformed payload. The delivery is nacked without
+// requeue, so the broker dead-letters or drops it instead of redelivering it.
+type RejectError struct {
+	Err error
+}
+
+// Reject wraps err in a *RejectError.
+func Reject(err error) error {
+	return &RejectError{Err: err}
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("rejected: %v", e.Err)
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+func NewConsumer(broker broker.MessageBroker, logger logger.Interface, consumerName string, options ...Option) *Consumer {
+	defaultOptions := &Options{
+		prefetchCount: 1,
+		workers:       1,
+		shardKey:      nil,
+		batchSize:     100,
+		batchTimeout:  time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	if defaultOptions.prefetchCount < 1 {
+		defaultOptions.prefetchCount = 1
+	}
+
+	if defaultOptions.workers < 1 {
+		defaultOptions.workers = 1
+	}
+
+	if defaultOptions.batchSize < 1 {
+		defaultOptions.batchSize = 1
+	}
+
+	if defaultOptions.batchTimeout <= 0 {
+		defaultOptions.batchTimeout = time.Second
+	}
+
 	return &Consumer{
 		broker:       broker,
 		logger:       logger,
 		consumerName: consumerName,
+		options:      defaultOptions,
 	}
 }
 
@@ -33,9 +154,16 @@ func (c *Consumer) Connect() error {
 
 func (c *Consumer) Start(
 	ctx context.Context, queue broker.Queue,
-	handler func(delivery amqp.Delivery) error,
+	handler func(delivery broker.Message) error,
 ) error {
-	if err := c.broker.Qos(1, 0, false); err != nil {
+	if c.options.prefetchCount < c.options.workers {
+		c.logger.Warn("Prefetch count is lower than the number of workers, some workers will stay idle",
+			"prefetch_count", c.options.prefetchCount,
+			"workers", c.options.workers,
+		)
+	}
+
+	if err := c.broker.Qos(queue, c.options.prefetchCount, 0, false); err != nil {
 		return fmt.Errorf("failed to set QoS: %w", err)
 	}
 
@@ -49,19 +177,298 @@ func (c *Consumer) Start(
 		return fmt.Errorf("failed to consume queue: %w", err)
 	}
 
+	c.dispatch(msgChan, func(deliveries <-chan broker.Message) {
+		for delivery := range deliveries {
+			c.handle(delivery, handler)
+		}
+	})
+
+	c.logger.Info("Consumer started",
+		"consumer", c.consumerName,
+		"queue", queue.GetName(),
+		"prefetch_count", c.options.prefetchCount,
+		"workers", c.options.workers,
+		"sharded", c.options.shardKey != nil,
+	)
+
+	return nil
+}
+
+// dispatch runs the configured number of workers, each calling run with the
+// deliveries routed to it, and closes c.done once msgChan is closed and every
+// worker returned. Without a shard key the workers share a single channel.
+func (c *Consumer) dispatch(msgChan <-chan broker.Message, run func(deliveries <-chan broker.Message)) {
+	c.done = make(chan struct{})
+
+	workerChans := make([]chan broker.Message, c.options.workers)
+
+	if c.options.shardKey == nil {
+		shared := make(chan broker.Message)
+		for i := range workerChans {
+			workerChans[i] = shared
+		}
+	} else {
+		for i := range workerChans {
+			workerChans[i] = make(chan broker.Message)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(c.options.workers)
+
+	for i := range workerChans {
+		go func(deliveries <-chan broker.Message) {
+			defer wg.Done()
+			run(deliveries)
+		}(workerChans[i])
+	}
+
 	go func() {
+		defer close(c.done)
+
 		for delivery := range msgChan {
-			if err := handler(delivery); err != nil {
-				delivery.Nack(false, true)
-			} else {
-				delivery.Ack(true)
+			workerChans[c.shardFor(delivery)] <- delivery
+		}
+
+		if c.options.shardKey == nil {
+			close(workerChans[0])
+		} else {
+			for _, ch := range workerChans {
+				close(ch)
 			}
 		}
+
+		wg.Wait()
 	}()
+}
+
+func (c *Consumer) handle(delivery broker.Message, handler func(delivery broker.Message) error) {
+	if err := handler(delivery); err != nil {
+		var rejectErr *RejectError
+		requeue := !errors.As(err, &rejectErr)
+
+		if err := delivery.Nack(false, requeue); err != nil {
+			c.logger.Error("Failed to nack delivery", "error", err)
+		}
+		return
+	}
+
+	// Deliveries are handled concurrently, so acknowledging with multiple=true
+	// could ack a delivery another worker has not finished yet.
+	if err := delivery.Ack(false); err != nil {
+		c.logger.Error("Failed to ack delivery", "error", err)
+	}
+}
+
+func (c *Consumer) shardFor(delivery broker.Message) int {
+	if c.options.shardKey == nil || c.options.workers == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(c.options.shardKey(delivery)))
+
+	return int(h.Sum32() % uint32(c.options.workers))
+}
+
+// StartBatch consumes the queue collecting up to the configured batch size or
+// batch timeout worth of deliveries, whichever comes first, and hands them to
+// handler. Each of the configured workers collects and handles its own
+// batches, one at a time and in delivery order; with a shard key, deliveries
+// sharing a key go to the batches of the same worker.
+//
+// When handler succeeds the whole batch is acknowledged, with a single
+// multiple ack when there is one worker and delivery by delivery otherwise,
+// since a multiple ack would also cover the batches of the other workers. A
+// *BatchError nacks only the failed and rejected deliveries and acks the
+// rest; any other error nacks the whole batch. Nacked deliveries are
+// requeued, except rejected ones and whole batches failing with a
+// *RejectError.
+func (c *Consumer) StartBatch(
+	ctx context.Context, queue broker.Queue,
+	handler func(deliveries []broker.Message) error,
+) error {
+	// A prefetch lower than the batch size of every worker would never fill
+	// their batches and every batch would wait for the timeout.
+	prefetchCount := max(c.options.prefetchCount, c.options.batchSize*c.options.workers)
+
+	if err := c.broker.Qos(queue, prefetchCount, 0, false); err != nil {
+		return fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	msgChan, err := c.broker.ConsumeQueue(
+		ctx,
+		queue,
+		c.consumerName,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to consume queue: %w", err)
+	}
+
+	multiple := c.options.workers == 1
+
+	c.dispatch(msgChan, func(deliveries <-chan broker.Message) {
+		c.collect(deliveries, handler, multiple)
+	})
+
+	c.logger.Info("Batch consumer started",
+		"consumer", c.consumerName,
+		"queue", queue.GetName(),
+		"prefetch_count", prefetchCount,
+		"workers", c.options.workers,
+		"sharded", c.options.shardKey != nil,
+		"batch_size", c.options.batchSize,
+		"batch_timeout", c.options.batchTimeout,
+	)
 
 	return nil
 }
 
+// collect batches deliveries until the channel is closed, flushing the last
+// partial batch.
+func (c *Consumer) collect(deliveries <-chan broker.Message, handler func(deliveries []broker.Message) error, multiple bool) {
+	batch := make([]broker.Message, 0, c.options.batchSize)
+
+	timer := time.NewTimer(c.options.batchTimeout)
+	timer.Stop()
+
+	flush := func() {
+		timer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+		c.handleBatch(batch, handler, multiple)
+		batch = make([]broker.Message, 0, c.options.batchSize)
+	}
+
+	for {
+		select {
+		case delivery, ok := <-deliveries:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, delivery)
+
+			if len(batch) == 1 {
+				timer.Reset(c.options.batchTimeout)
+			}
+
+			if len(batch) >= c.options.batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (c *Consumer) handleBatch(batch []broker.Message, handler func(deliveries []broker.Message) error, multiple bool) {
+	err := handler(batch)
+	if err == nil {
+		if err := c.ackBatch(batch, multiple); err != nil {
+			c.logger.Error("Failed to ack batch", "error", err, "size", len(batch))
+		}
+		return
+	}
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		var rejectErr *RejectError
+		if errors.As(err, &rejectErr) {
+			c.logger.Error("Batch handler rejected batch", "error", err, "size", len(batch))
+			if err := c.nackBatch(batch, multiple, false); err != nil {
+				c.logger.Error("Failed to nack batch", "error", err, "size", len(batch))
+			}
+			return
+		}
+
+		c.logger.Error("Batch handler failed, requeueing batch", "error", err, "size", len(batch))
+		if err := c.nackBatch(batch, multiple, true); err != nil {
+			c.logger.Error("Failed to nack batch", "error", err, "size", len(batch))
+		}
+		return
+	}
+
+	// requeue holds the failed deliveries, true to requeue them and false to
+	// reject them.
+	requeue := make(map[int]bool, len(batchErr.Failed)+len(batchErr.Rejected))
+	for _, i := range batchErr.Rejected {
+		requeue[i] = false
+	}
+	for _, i := range batchErr.Failed {
+		requeue[i] = true
+	}
+
+	c.logger.Warn("Batch partially failed, requeueing failed deliveries and rejecting the others",
+		"error", batchErr.Err,
+		"failed", len(batchErr.Failed),
+		"rejected", len(batchErr.Rejected),
+		"size", len(batch),
+	)
+
+	for i, delivery := range batch {
+		if r, ok := requeue[i]; ok {
+			if err := delivery.Nack(false, r); err != nil {
+				c.logger.Error("Failed to nack delivery", "error", err)
+			}
+			continue
+		}
+
+		if err := delivery.Ack(false); err != nil {
+			c.logger.Error("Failed to ack delivery", "error", err)
+		}
+	}
+}
+
+// ackBatch acks every delivery of the batch, with a single multiple ack of
+// the last one when multiple is set.
+func (c *Consumer) ackBatch(batch []broker.Message, multiple bool) error {
+	if multiple {
+		return batch[len(batch)-1].Ack(true)
+	}
+
+	var errs []error
+	for _, delivery := range batch {
+		errs = append(errs, delivery.Ack(false))
+	}
+	return errors.Join(errs...)
+}
+
+// nackBatch nacks every delivery of the batch, with a single multiple nack of
+// the last one when multiple is set.
+func (c *Consumer) nackBatch(batch []broker.Message, multiple, requeue bool) error {
+	if multiple {
+		return batch[len(batch)-1].Nack(true, requeue)
+	}
+
+	var errs []error
+	for _, delivery := range batch {
+		errs = append(errs, delivery.Nack(false, requeue))
+	}
+	return errors.Join(errs...)
+}
+
+// Wait blocks until the consumer stops, which happens once the context given
+// to Start or StartBatch is cancelled and every delivery already received has
+// been handled and acknowledged. It returns ctx.Err() if ctx is done first.
+func (c *Consumer) Wait(ctx context.Context) error {
+	if c.done == nil {
+		return nil
+	}
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close closes the underlying broker. When several consumers share a broker,
+// close the broker once instead.
 func (c *Consumer) Close() error {
 	return c.broker.Close()
 }
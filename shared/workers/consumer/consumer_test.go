@@ -0,0 +1,658 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+type mockQueue struct{}
+
+func (q *mockQueue) GetName() string { return "test-queue" }
+
+type mockAcknowledger struct {
+	mu     sync.Mutex
+	acked  []uint64
+	nacked []uint64
+	done   chan struct{}
+	total  int
+}
+
+func newMockAcknowledger(total int) *mockAcknowledger {
+	return &mockAcknowledger{done: make(chan struct{}), total: total}
+}
+
+func (m *mockAcknowledger) record(list *[]uint64, tag uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*list = append(*list, tag)
+	if len(m.acked)+len(m.nacked) == m.total {
+		close(m.done)
+	}
+}
+
+func (m *mockAcknowledger) Ack(tag uint64, multiple bool) error {
+	if multiple {
+		return errors.New("unexpected multiple ack")
+	}
+	m.record(&m.acked, tag)
+	return nil
+}
+
+func (m *mockAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	m.record(&m.nacked, tag)
+	return nil
+}
+
+func (m *mockAcknowledger) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-m.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for deliveries to be acknowledged")
+	}
+}
+
+type mockBroker struct {
+	deliveries    chan broker.Message
+	prefetchCount int
+}
+
+func (m *mockBroker) Connect() error { return nil }
+func (m *mockBroker) Close() error   { return nil }
+
+func (m *mockBroker) Qos(queue broker.Queue, prefetchCount, prefetchSize int, global bool) error {
+	m.prefetchCount = prefetchCount
+	return nil
+}
+
+func (m *mockBroker) ConsumeQueue(ctx context.Context, queue broker.Queue, consumer string) (<-chan broker.Message, error) {
+	return m.deliveries, nil
+}
+
+// tagAcknowledger records acknowledgements by tag, like a broker tracking
+// delivery tags.
+type tagAcknowledger interface {
+	Ack(tag uint64, multiple bool) error
+	Nack(tag uint64, multiple bool, requeue bool) error
+}
+
+func newDelivery(ack tagAcknowledger, tag uint64, key string) broker.Message {
+	return broker.Message{
+		Body:    []byte(key),
+		Headers: map[string]any{"tag": tag},
+		Acknowledger: broker.AckFuncs{
+			AckFunc: func(multiple bool) error {
+				return ack.Ack(tag, multiple)
+			},
+			NackFunc: func(multiple, requeue bool) error {
+				return ack.Nack(tag, multiple, requeue)
+			},
+		},
+	}
+}
+
+func tagOf(m broker.Message) uint64 {
+	return m.Headers["tag"].(uint64)
+}
+
+func TestNewConsumer_Defaults(t *testing.T) {
+	c := NewConsumer(&mockBroker{}, &mockLogger{}, "test", WithWorkers(0), WithPrefetchCount(-1))
+
+	if c.options.workers != 1 {
+		t.Errorf("workers = %v, want %v", c.options.workers, 1)
+	}
+	if c.options.prefetchCount != 1 {
+		t.Errorf("prefetchCount = %v, want %v", c.options.prefetchCount, 1)
+	}
+}
+
+func TestConsumer_Start_SetsPrefetch(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message)}
+	c := NewConsumer(b, &mockLogger{}, "test", WithPrefetchCount(20), WithWorkers(4))
+
+	if err := c.Start(context.Background(), &mockQueue{}, func(broker.Message) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	close(b.deliveries)
+
+	if b.prefetchCount != 20 {
+		t.Errorf("Qos prefetch = %v, want %v", b.prefetchCount, 20)
+	}
+}
+
+func TestConsumer_Start_AcksAndNacks(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := newMockAcknowledger(10)
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithPrefetchCount(10), WithWorkers(3))
+
+	err := c.Start(context.Background(), &mockQueue{}, func(d broker.Message) error {
+		if tagOf(d)%2 == 0 {
+			return errors.New("handler failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 10; i++ {
+		b.deliveries <- newDelivery(ack, uint64(i), "key")
+	}
+	close(b.deliveries)
+
+	ack.wait(t)
+
+	if len(ack.acked) != 5 || len(ack.nacked) != 5 {
+		t.Errorf("acked = %v, nacked = %v, want 5 and 5", len(ack.acked), len(ack.nacked))
+	}
+}
+
+func TestConsumer_Start_RejectsWithoutRequeue(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test")
+
+	err := c.Start(context.Background(), &mockQueue{}, func(d broker.Message) error {
+		if tagOf(d) == 1 {
+			return Reject(errors.New("bad payload"))
+		}
+		return errors.New("insert failed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.deliveries <- newDelivery(ack, 1, "key")
+	b.deliveries <- newDelivery(ack, 2, "key")
+	close(b.deliveries)
+
+	want := []ackCall{
+		{tag: 1, requeue: false},
+		{tag: 2, requeue: true},
+	}
+
+	calls := ack.waitCalls(t, len(want))
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("ack call %d = %+v, want %+v", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestConsumer_Start_RunsConcurrently(t *testing.T) {
+	const workers = 4
+
+	b := &mockBroker{deliveries: make(chan broker.Message, workers)}
+	ack := newMockAcknowledger(workers)
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	running := 0
+	allRunning := make(chan struct{})
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithPrefetchCount(workers), WithWorkers(workers))
+
+	err := c.Start(context.Background(), &mockQueue{}, func(d broker.Message) error {
+		mu.Lock()
+		running++
+		if running == workers {
+			close(allRunning)
+		}
+		mu.Unlock()
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= workers; i++ {
+		b.deliveries <- newDelivery(ack, uint64(i), fmt.Sprintf("key-%d", i))
+	}
+
+	select {
+	case <-allRunning:
+	case <-time.After(2 * time.Second):
+		t.Fatal("handlers did not run concurrently")
+	}
+
+	close(release)
+	close(b.deliveries)
+	ack.wait(t)
+}
+
+func TestConsumer_Start_ShardKeyPreservesOrder(t *testing.T) {
+	const perKey = 50
+	keys := []string{"device-1", "device-2", "device-3"}
+
+	b := &mockBroker{deliveries: make(chan broker.Message, perKey*len(keys))}
+	ack := newMockAcknowledger(perKey * len(keys))
+
+	c := NewConsumer(b, &mockLogger{}, "test",
+		WithPrefetchCount(10),
+		WithWorkers(4),
+		WithShardKey(func(d broker.Message) string { return string(d.Body) }),
+	)
+
+	var mu sync.Mutex
+	seen := make(map[string][]uint64)
+
+	err := c.Start(context.Background(), &mockQueue{}, func(d broker.Message) error {
+		mu.Lock()
+		seen[string(d.Body)] = append(seen[string(d.Body)], tagOf(d))
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tag := uint64(0)
+	for i := 0; i < perKey; i++ {
+		for _, key := range keys {
+			tag++
+			b.deliveries <- newDelivery(ack, tag, key)
+		}
+	}
+	close(b.deliveries)
+
+	ack.wait(t)
+
+	for _, key := range keys {
+		tags := seen[key]
+		if len(tags) != perKey {
+			t.Fatalf("key %s handled %d deliveries, want %d", key, len(tags), perKey)
+		}
+		for i := 1; i < len(tags); i++ {
+			if tags[i] < tags[i-1] {
+				t.Errorf("key %s handled out of order: %v before %v", key, tags[i-1], tags[i])
+			}
+		}
+	}
+}
+
+type ackCall struct {
+	tag      uint64
+	multiple bool
+	ack      bool
+	requeue  bool
+}
+
+type recordingAcknowledger struct {
+	mu    sync.Mutex
+	calls []ackCall
+}
+
+func (r *recordingAcknowledger) record(call ackCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func (r *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	r.record(ackCall{tag: tag, multiple: multiple, ack: true})
+	return nil
+}
+
+func (r *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	r.record(ackCall{tag: tag, multiple: multiple, ack: false, requeue: requeue})
+	return nil
+}
+
+func (r *recordingAcknowledger) snapshot() []ackCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ackCall(nil), r.calls...)
+}
+
+func (r *recordingAcknowledger) waitCalls(t *testing.T, n int) []ackCall {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if calls := r.snapshot(); len(calls) >= n {
+			return calls
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d ack calls, got %+v", n, r.snapshot())
+	return nil
+}
+
+func startBatch(t *testing.T, c *Consumer, b *mockBroker, handler func([]broker.Message) error) <-chan []uint64 {
+	t.Helper()
+
+	batches := make(chan []uint64, 10)
+
+	err := c.StartBatch(context.Background(), &mockQueue{}, func(deliveries []broker.Message) error {
+		tags := make([]uint64, len(deliveries))
+		for i, d := range deliveries {
+			tags[i] = tagOf(d)
+		}
+		err := handler(deliveries)
+		batches <- tags
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return batches
+}
+
+func waitBatch(t *testing.T, batches <-chan []uint64) []uint64 {
+	t.Helper()
+	select {
+	case batch := <-batches:
+		return batch
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for batch")
+		return nil
+	}
+}
+
+func TestConsumer_StartBatch_FlushesOnSize(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithBatchSize(3), WithBatchTimeout(time.Hour))
+	batches := startBatch(t, c, b, func([]broker.Message) error { return nil })
+
+	for i := 1; i <= 3; i++ {
+		b.deliveries <- newDelivery(ack, uint64(i), "key")
+	}
+
+	if got := waitBatch(t, batches); len(got) != 3 {
+		t.Fatalf("batch size = %v, want %v", len(got), 3)
+	}
+
+	if b.prefetchCount != 3 {
+		t.Errorf("Qos prefetch = %v, want %v", b.prefetchCount, 3)
+	}
+
+	calls := ack.waitCalls(t, 1)
+	if len(calls) != 1 || !calls[0].ack || !calls[0].multiple || calls[0].tag != 3 {
+		t.Errorf("ack calls = %+v, want a single multiple ack of tag 3", calls)
+	}
+}
+
+func TestConsumer_StartBatch_FlushesOnTimeout(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithBatchSize(10), WithBatchTimeout(50*time.Millisecond))
+	batches := startBatch(t, c, b, func([]broker.Message) error { return nil })
+
+	b.deliveries <- newDelivery(ack, 1, "key")
+	b.deliveries <- newDelivery(ack, 2, "key")
+
+	if got := waitBatch(t, batches); len(got) != 2 {
+		t.Fatalf("batch size = %v, want %v", len(got), 2)
+	}
+}
+
+func TestConsumer_StartBatch_NacksWholeBatchOnError(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithBatchSize(2), WithBatchTimeout(time.Hour))
+	batches := startBatch(t, c, b, func([]broker.Message) error { return errors.New("insert failed") })
+
+	b.deliveries <- newDelivery(ack, 1, "key")
+	b.deliveries <- newDelivery(ack, 2, "key")
+	waitBatch(t, batches)
+	close(b.deliveries)
+
+	calls := ack.waitCalls(t, 1)
+	if len(calls) != 1 || calls[0].ack || !calls[0].multiple || !calls[0].requeue || calls[0].tag != 2 {
+		t.Errorf("ack calls = %+v, want a single multiple nack of tag 2", calls)
+	}
+}
+
+func TestConsumer_StartBatch_RejectsWholeBatch(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithBatchSize(2), WithBatchTimeout(time.Hour))
+	batches := startBatch(t, c, b, func([]broker.Message) error { return Reject(errors.New("bad payload")) })
+
+	b.deliveries <- newDelivery(ack, 1, "key")
+	b.deliveries <- newDelivery(ack, 2, "key")
+	waitBatch(t, batches)
+	close(b.deliveries)
+
+	calls := ack.waitCalls(t, 1)
+	want := ackCall{tag: 2, multiple: true}
+	if len(calls) != 1 || calls[0] != want {
+		t.Errorf("ack calls = %+v, want %+v", calls, want)
+	}
+}
+
+func TestConsumer_StartBatch_PartialFailure(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithBatchSize(4), WithBatchTimeout(time.Hour))
+	batches := startBatch(t, c, b, func([]broker.Message) error {
+		return &BatchError{Failed: []int{1}, Rejected: []int{3}, Err: errors.New("bad payload")}
+	})
+
+	for i := 1; i <= 4; i++ {
+		b.deliveries <- newDelivery(ack, uint64(i), "key")
+	}
+	waitBatch(t, batches)
+	close(b.deliveries)
+
+	want := []ackCall{
+		{tag: 1, ack: true},
+		{tag: 2, ack: false, requeue: true},
+		{tag: 3, ack: true},
+		{tag: 4, ack: false, requeue: false},
+	}
+
+	calls := ack.waitCalls(t, len(want))
+	if len(calls) != len(want) {
+		t.Fatalf("ack calls = %+v, want %+v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("ack call %d = %+v, want %+v", i, calls[i], want[i])
+		}
+	}
+}
+
+func TestConsumer_StartBatch_ShardedWorkers(t *testing.T) {
+	const perKey = 20
+	keys := []string{"device-1", "device-2", "device-3"}
+
+	b := &mockBroker{deliveries: make(chan broker.Message, perKey*len(keys))}
+	// Fails on multiple acks, which would cover the batches of other workers.
+	ack := newMockAcknowledger(perKey * len(keys))
+
+	c := NewConsumer(b, &mockLogger{}, "test",
+		WithWorkers(4),
+		WithBatchSize(5),
+		WithBatchTimeout(20*time.Millisecond),
+		WithShardKey(func(d broker.Message) string { return string(d.Body) }),
+	)
+
+	var mu sync.Mutex
+	seen := make(map[string][]uint64)
+
+	err := c.StartBatch(context.Background(), &mockQueue{}, func(deliveries []broker.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, d := range deliveries {
+			seen[string(d.Body)] = append(seen[string(d.Body)], tagOf(d))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b.prefetchCount != 20 {
+		t.Errorf("Qos prefetch = %v, want a batch for each of the 4 workers", b.prefetchCount)
+	}
+
+	tag := uint64(0)
+	for i := 0; i < perKey; i++ {
+		for _, key := range keys {
+			tag++
+			b.deliveries <- newDelivery(ack, tag, key)
+		}
+	}
+	close(b.deliveries)
+
+	ack.wait(t)
+
+	if len(ack.nacked) != 0 {
+		t.Errorf("nacked = %v, want none", ack.nacked)
+	}
+
+	for _, key := range keys {
+		tags := seen[key]
+		if len(tags) != perKey {
+			t.Fatalf("key %s handled %d deliveries, want %d", key, len(tags), perKey)
+		}
+		for i := 1; i < len(tags); i++ {
+			if tags[i] < tags[i-1] {
+				t.Errorf("key %s handled out of order: %v before %v", key, tags[i-1], tags[i])
+			}
+		}
+	}
+}
+
+func TestConsumer_StartBatch_WorkersRunConcurrently(t *testing.T) {
+	const workers = 2
+
+	b := &mockBroker{deliveries: make(chan broker.Message, 10)}
+	ack := &recordingAcknowledger{}
+	release := make(chan struct{})
+
+	var mu sync.Mutex
+	running := 0
+	allRunning := make(chan struct{})
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithWorkers(workers), WithBatchSize(2), WithBatchTimeout(time.Hour))
+
+	err := c.StartBatch(context.Background(), &mockQueue{}, func(deliveries []broker.Message) error {
+		mu.Lock()
+		running++
+		if running == workers {
+			close(allRunning)
+		}
+		mu.Unlock()
+		<-release
+		return errors.New("insert failed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 4; i++ {
+		b.deliveries <- newDelivery(ack, uint64(i), "key")
+	}
+
+	select {
+	case <-allRunning:
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch handlers did not run concurrently")
+	}
+
+	close(release)
+	close(b.deliveries)
+
+	for _, call := range ack.waitCalls(t, 4) {
+		if call.ack || call.multiple || !call.requeue {
+			t.Errorf("ack call = %+v, want a single requeued nack", call)
+		}
+	}
+}
+
+func TestConsumer_Wait_DrainsInFlightHandlers(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 2)}
+	ack := newMockAcknowledger(2)
+	release := make(chan struct{})
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithPrefetchCount(2), WithWorkers(2))
+
+	err := c.Start(context.Background(), &mockQueue{}, func(broker.Message) error {
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.deliveries <- newDelivery(ack, 1, "key")
+	b.deliveries <- newDelivery(ack, 2, "key")
+	close(b.deliveries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := c.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() with blocked handlers error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+
+	if err := c.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	if len(ack.acked) != 2 {
+		t.Errorf("acked = %v, want %v", len(ack.acked), 2)
+	}
+}
+
+func TestConsumer_Wait_FlushesPartialBatch(t *testing.T) {
+	b := &mockBroker{deliveries: make(chan broker.Message, 2)}
+	ack := &recordingAcknowledger{}
+
+	c := NewConsumer(b, &mockLogger{}, "test", WithBatchSize(10), WithBatchTimeout(time.Hour))
+
+	err := c.StartBatch(context.Background(), &mockQueue{}, func([]broker.Message) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.deliveries <- newDelivery(ack, 1, "key")
+	b.deliveries <- newDelivery(ack, 2, "key")
+	close(b.deliveries)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := c.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	calls := ack.snapshot()
+	if len(calls) != 1 || !calls[0].ack || calls[0].tag != 2 {
+		t.Errorf("ack calls = %+v, want a multiple ack of tag 2", calls)
+	}
+}
+
+func TestConsumer_Wait_NotStarted(t *testing.T) {
+	c := NewConsumer(&mockBroker{}, &mockLogger{}, "test")
+
+	if err := c.Wait(context.Background()); err != nil {
+		t.Errorf("Wait() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Check reports whether a dependency is healthy; a nil error means healthy.
+type Check func() error
+
+type response struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Handler runs every check on each request and responds with 200 when all of
+// them pass or 503 otherwise, listing the result of each check.
+func Handler(checks map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := response{
+			Status: "ok",
+			Checks: make(map[string]string, len(checks)),
+		}
+
+		status := http.StatusOK
+
+		for name, check := range checks {
+			if err := check(); err != nil {
+				res.Checks[name] = err.Error()
+				res.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+				continue
+			}
+
+			res.Checks[name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(res)
+	})
+}
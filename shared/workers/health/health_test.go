@@ -0,0 +1,67 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]Check
+		wantStatus int
+		wantChecks map[string]string
+	}{
+		{
+			name: "all healthy",
+			checks: map[string]Check{
+				"broker": func() error { return nil },
+			},
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"broker": "ok"},
+		},
+		{
+			name: "one unhealthy",
+			checks: map[string]Check{
+				"broker":   func() error { return errors.New("reconnecting") },
+				"database": func() error { return nil },
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"broker": "reconnecting", "database": "ok"},
+		},
+		{
+			name:       "no checks",
+			checks:     nil,
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			Handler(tt.checks).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+
+			var res response
+			if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(res.Checks) != len(tt.wantChecks) {
+				t.Fatalf("checks = %v, want %v", res.Checks, tt.wantChecks)
+			}
+			for name, want := range tt.wantChecks {
+				if res.Checks[name] != want {
+					t.Errorf("check %s = %v, want %v", name, res.Checks[name], want)
+				}
+			}
+		})
+	}
+}
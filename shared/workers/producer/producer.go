@@ -0,0 +1,115 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encoder turns a message into the body of a publishing.
+type Encoder[T any] func(message T) ([]byte, error)
+
+// JSONEncoder encodes messages as JSON.
+func JSONEncoder[T any](message T) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+// ProtoEncoder encodes protobuf messages in their binary wire format.
+func ProtoEncoder[T proto.Message](message T) ([]byte, error) {
+	return proto.Marshal(message)
+}
+
+// Producer publishes messages of type T to a single exchange.
+type Producer[T any] struct {
+	publisher broker.MessagePublisher
+	logger    logger.Interface
+	exchange  string
+	encode    Encoder[T]
+	options   *Options
+}
+
+type Options struct {
+	contentType string
+	persistent  bool
+	confirm     bool
+}
+
+type Option func(*Options)
+
+// WithContentType sets the content type of published messages.
+func WithContentType(contentType string) Option {
+	return func(options *Options) {
+		options.contentType = contentType
+	}
+}
+
+// WithPersistent marks published messages as persistent, so they survive a
+// broker restart when routed to durable queues.
+func WithPersistent(persistent bool) Option {
+	return func(options *Options) {
+		options.persistent = persistent
+	}
+}
+
+// WithConfirm makes Publish wait for the broker to confirm every message.
+func WithConfirm(confirm bool) Option {
+	return func(options *Options) {
+		options.confirm = confirm
+	}
+}
+
+func NewProducer[T any](
+	publisher broker.MessagePublisher, logger logger.Interface,
+	exchange string, encode Encoder[T], options ...Option,
+) *Producer[T] {
+	defaultOptions := &Options{
+		contentType: "application/json",
+		persistent:  true,
+		confirm:     true,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Producer[T]{
+		publisher: publisher,
+		logger:    logger,
+		exchange:  exchange,
+		encode:    encode,
+		options:   defaultOptions,
+	}
+}
+
+func (p *Producer[T]) Publish(ctx context.Context, routingKey string, message T) error {
+	body, err := p.encode(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	publishing := broker.Message{
+		Body:        body,
+		ContentType: p.options.contentType,
+		Timestamp:   time.Now(),
+		Persistent:  p.options.persistent,
+	}
+
+	if p.options.confirm {
+		err = p.publisher.PublishWithConfirm(ctx, p.exchange, routingKey, publishing)
+	} else {
+		err = p.publisher.Publish(ctx, p.exchange, routingKey, publishing)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to publish to exchange %s: %w", p.exchange, err)
+	}
+
+	p.logger.Debug("Published message", "exchange", p.exchange, "routing_key", routingKey, "size", len(body))
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+type published struct {
+	exchange   string
+	routingKey string
+	msg        broker.Message
+	confirmed  bool
+}
+
+type mockPublisher struct {
+	published []published
+	err       error
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	m.published = append(m.published, published{exchange, routingKey, msg, false})
+	return m.err
+}
+
+func (m *mockPublisher) PublishWithConfirm(ctx context.Context, exchange, routingKey string, msg broker.Message) error {
+	m.published = append(m.published, published{exchange, routingKey, msg, true})
+	return m.err
+}
+
+type alert struct {
+	DeviceID string `json:"device_id"`
+	Reason   string `json:"reason"`
+}
+
+func TestProducer_Publish(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []Option
+		confirmed  bool
+		persistent bool
+	}{
+		{"defaults", nil, true, true},
+		{"without confirm", []Option{WithConfirm(false)}, false, true},
+		{"transient", []Option{WithPersistent(false)}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &mockPublisher{}
+			p := NewProducer(pub, &mockLogger{}, "alerts", JSONEncoder[alert], tt.options...)
+
+			if err := p.Publish(context.Background(), "alerts.device-1", alert{DeviceID: "device-1", Reason: "overheat"}); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(pub.published) != 1 {
+				t.Fatalf("published %d messages, want 1", len(pub.published))
+			}
+
+			got := pub.published[0]
+			if got.exchange != "alerts" || got.routingKey != "alerts.device-1" {
+				t.Errorf("published to %s/%s, want alerts/alerts.device-1", got.exchange, got.routingKey)
+			}
+			if got.confirmed != tt.confirmed {
+				t.Errorf("confirmed = %v, want %v", got.confirmed, tt.confirmed)
+			}
+			if got.msg.Persistent != tt.persistent {
+				t.Errorf("persistent = %v, want %v", got.msg.Persistent, tt.persistent)
+			}
+			if string(got.msg.Body) != `{"device_id":"device-1","reason":"overheat"}` {
+				t.Errorf("body = %s", got.msg.Body)
+			}
+		})
+	}
+}
+
+func TestProducer_Publish_Proto(t *testing.T) {
+	pub := &mockPublisher{}
+	p := NewProducer(pub, &mockLogger{}, "sensor", ProtoEncoder[*proto.SensorData], WithContentType("application/x-protobuf"))
+
+	if err := p.Publish(context.Background(), "sensor.data", &proto.SensorData{SensorId: "device-1", Temperature: 21.5}); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded proto.SensorData
+	if err := protobuf.Unmarshal(pub.published[0].msg.Body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.SensorId != "device-1" || decoded.Temperature != 21.5 {
+		t.Errorf("decoded = %v", &decoded)
+	}
+	if pub.published[0].msg.ContentType != "application/x-protobuf" {
+		t.Errorf("content type = %s", pub.published[0].msg.ContentType)
+	}
+}
+
+func TestProducer_Publish_Error(t *testing.T) {
+	returned := &broker.ReturnedError{Exchange: "alerts", RoutingKey: "nowhere", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	p := NewProducer(&mockPublisher{err: returned}, &mockLogger{}, "alerts", JSONEncoder[alert])
+
+	err := p.Publish(context.Background(), "nowhere", alert{})
+
+	var returnedErr *broker.ReturnedError
+	if !errors.As(err, &returnedErr) {
+		t.Errorf("error = %v, want *broker.ReturnedError", err)
+	}
+}
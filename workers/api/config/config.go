@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+)
+
+type Config struct {
+	TimescaleDB            TimescaleDBConfig `json:"timescaledb"`
+	ListenAddress          string            `json:"listen_address"`
+	DefaultPageSize        int               `json:"default_page_size"`
+	MaxPageSize            int               `json:"max_page_size"`
+	DefaultRangeHours      int               `json:"default_range_hours"`
+	ShutdownTimeoutSeconds int               `json:"shutdown_timeout_seconds"`
+	Log                    logger.Config     `json:"log"`
+}
+
+type TimescaleDBConfig struct {
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Password string
+	Database string `json:"database"`
+	SSLMode  string `json:"ssl_mode"`
+}
+
+var DEFAULT_SECRET_PATH = getStringEnv("DEFAULT_SECRET_PATH", "/run/secrets/")
+
+func NewConfig() *Config {
+	fileConfig, err := getFromFile("config.json")
+	if err != nil {
+		log.Fatalf("Failed to read config file: %v", err)
+		return nil
+	}
+
+	password, err := getFromSecret("TIMESCALEDB_PASSWORD")
+	if err != nil {
+		log.Fatalf("Failed to read secret TIMESCALEDB_PASSWORD: %v", err)
+	}
+
+	return &Config{
+		TimescaleDB: TimescaleDBConfig{
+			Host:     getStringEnv("TIMESCALEDB_HOST", fileConfig.TimescaleDB.Host),
+			Port:     getStringEnv("TIMESCALEDB_PORT", fileConfig.TimescaleDB.Port),
+			User:     getStringEnv("TIMESCALEDB_USER", fileConfig.TimescaleDB.User),
+			Password: password,
+			Database: getStringEnv("TIMESCALEDB_DATABASE", fileConfig.TimescaleDB.Database),
+			SSLMode:  getStringEnv("TIMESCALEDB_SSL_MODE", fileConfig.TimescaleDB.SSLMode),
+		},
+		ListenAddress:          getStringEnv("LISTEN_ADDRESS", fileConfig.ListenAddress),
+		DefaultPageSize:        getIntEnv("DEFAULT_PAGE_SIZE", fileConfig.DefaultPageSize),
+		MaxPageSize:            getIntEnv("MAX_PAGE_SIZE", fileConfig.MaxPageSize),
+		DefaultRangeHours:      getIntEnv("DEFAULT_RANGE_HOURS", fileConfig.DefaultRangeHours),
+		ShutdownTimeoutSeconds: getIntEnv("SHUTDOWN_TIMEOUT_SECONDS", fileConfig.ShutdownTimeoutSeconds),
+		Log: logger.Config{
+			Level: getStringEnv("LOG_LEVEL", "info"),
+			Source: logger.SourceConfig{
+				Enabled:  getBoolEnv("LOG_SOURCE_ENABLED", true),
+				Relative: getBoolEnv("LOG_SOURCE_RELATIVE", true),
+				AsJSON:   getBoolEnv("LOG_SOURCE_AS_JSON", false),
+			},
+		},
+	}
+}
+
+func getFromFile(path string) (*Config, error) {
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configData := Config{
+		ListenAddress:          ":8080",
+		DefaultPageSize:        1000,
+		MaxPageSize:            10000,
+		DefaultRangeHours:      24,
+		ShutdownTimeoutSeconds: 10,
+	}
+	json.Unmarshal(config, &configData)
+
+	return &configData, nil
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value == "true"
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+func getStringEnv(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getFromSecret(name string) (string, error) {
+	path := filepath.Join(DEFAULT_SECRET_PATH, name)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+}
+
+func (c *Config) DefaultRange() time.Duration {
+	return time.Duration(c.DefaultRangeHours) * time.Hour
+}
+
+func (c *TimescaleDBConfig) ConnectionString() string {
+	return fmt.Sprintf(
+		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		c.Host,
+		c.Port,
+		c.User,
+		c.Password,
+		c.Database,
+		c.SSLMode,
+	)
+}
@@ -0,0 +1,10 @@
+module github.com/RicardoCenci/iot-distributed-architecture/workers/api
+
+go 1.24.0
+
+require (
+	github.com/RicardoCenci/iot-distributed-architecture/shared v0.0.0
+	github.com/lib/pq v1.10.9
+)
+
+replace github.com/RicardoCenci/iot-distributed-architecture/shared => ../../shared
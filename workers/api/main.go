@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/api/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/api/server"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/api/store"
+)
+
+func main() {
+	config := config.NewConfig()
+
+	loggerConfig := logger.Config{
+		Level: config.Log.Level,
+		Source: logger.SourceConfig{
+			Enabled:  config.Log.Source.Enabled,
+			Relative: config.Log.Source.Relative,
+			AsJSON:   config.Log.Source.AsJSON,
+		},
+	}
+
+	logger := logger.NewSlogLogger(loggerConfig)
+
+	logger.Debug("Connecting to TimescaleDB", "host", config.TimescaleDB.Host, "database", config.TimescaleDB.Database)
+
+	sensorStore, err := store.NewPostgres(config.TimescaleDB.ConnectionString())
+	if err != nil {
+		logger.Error("Failed to connect to TimescaleDB", "error", err)
+		os.Exit(1)
+	}
+
+	apiServer := server.NewServer(
+		sensorStore,
+		logger,
+		server.WithDefaultLimit(config.DefaultPageSize),
+		server.WithMaxLimit(config.MaxPageSize),
+		server.WithDefaultRange(config.DefaultRange()),
+	)
+
+	httpServer := &http.Server{
+		Addr:              config.ListenAddress,
+		Handler:           apiServer,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to start API server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	logger.Info("API server is running", "address", config.ListenAddress)
+	<-c
+	logger.Info("Shutting down API server", "timeout", config.ShutdownTimeout())
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Warn("Requests were not finished before the shutdown timeout", "error", err)
+	}
+
+	if err := sensorStore.Close(); err != nil {
+		logger.Error("Failed to close store", "error", err)
+	}
+
+	logger.Info("API server stopped")
+}
@@ -0,0 +1,473 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/health"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/api/store"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Server serves the stored sensor data over HTTP. Its routes are described
+// by openapi.yaml, served at /openapi.yaml.
+type Server struct {
+	store   store.Store
+	logger  logger.Interface
+	options *Options
+	mux     *http.ServeMux
+}
+
+type Options struct {
+	defaultLimit int
+	maxLimit     int
+	defaultRange time.Duration
+	now          func() time.Time
+}
+
+type Option func(*Options)
+
+// WithDefaultLimit sets the page size when a request has no limit.
+func WithDefaultLimit(limit int) Option {
+	return func(options *Options) {
+		options.defaultLimit = limit
+	}
+}
+
+// WithMaxLimit sets the largest page size a request can ask for.
+func WithMaxLimit(limit int) Option {
+	return func(options *Options) {
+		options.maxLimit = limit
+	}
+}
+
+// WithDefaultRange sets how far back readings are returned when a request
+// has no from.
+func WithDefaultRange(defaultRange time.Duration) Option {
+	return func(options *Options) {
+		options.defaultRange = defaultRange
+	}
+}
+
+// page is the JSON body of the list endpoints. NextCursor is also sent in
+// the X-Next-Cursor header, which is the only place CSV responses have it.
+type page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// badRequest is an error caused by the request parameters.
+type badRequest struct {
+	message string
+}
+
+func (e *badRequest) Error() string {
+	return e.message
+}
+
+func badRequestf(format string, args ...any) error {
+	return &badRequest{message: fmt.Sprintf(format, args...)}
+}
+
+func NewServer(store store.Store, logger logger.Interface, options ...Option) *Server {
+	defaultOptions := &Options{
+		defaultLimit: 1000,
+		maxLimit:     10000,
+		defaultRange: 24 * time.Hour,
+		now:          time.Now,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	s := &Server{
+		store:   store,
+		logger:  logger,
+		options: defaultOptions,
+		mux:     http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /devices", s.handleDevices)
+	s.mux.HandleFunc("GET /devices/{id}/readings", s.handleReadings)
+	s.mux.HandleFunc("GET /devices/{id}/latest", s.handleLatest)
+	s.mux.HandleFunc("GET /latest", s.handleLatestAll)
+	s.mux.HandleFunc("GET /openapi.yaml", s.handleOpenAPI)
+	s.mux.Handle("GET /healthz", health.Handler(map[string]health.Check{
+		"store": store.Ping,
+	}))
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	limit, err := s.limit(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	after, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	devices, err := s.store.ListDevices(r.Context(), after, limit+1)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	var next string
+	if len(devices) > limit {
+		devices = devices[:limit]
+		next = encodeCursor(devices[limit-1].ID)
+	}
+
+	records := make([][]string, len(devices))
+	for i, device := range devices {
+		records[i] = []string{device.ID, formatTime(device.LastSeen)}
+	}
+
+	s.writePage(w, r, page[store.Device]{Data: devices, NextCursor: next}, next, []string{"id", "last_seen"}, records)
+}
+
+// handleReadings returns the readings of a device in [from, to), raw or
+// aggregated by step. Pages continue from the cursor, which encodes the
+// last raw reading of the page or the start of the next bucket.
+func (s *Server) handleReadings(w http.ResponseWriter, r *http.Request) {
+	query, err := s.readingsQuery(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	limit := query.Limit
+	query.Limit++
+
+	readings, err := s.store.Readings(r.Context(), query)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	var next string
+	if len(readings) > limit {
+		readings = readings[:limit]
+
+		// Raw readings can share their time, so their cursor also has the
+		// message ID of the last one. Buckets have distinct starts.
+		last := readings[limit-1]
+		if query.Step > 0 {
+			next = encodeCursor(formatTime(last.Time.Add(query.Step)))
+		} else {
+			next = encodeCursor(formatTime(last.Time) + " " + last.MessageID)
+		}
+	}
+
+	records := make([][]string, len(readings))
+	for i, reading := range readings {
+		records[i] = []string{
+			formatTime(reading.Time),
+			formatFloat(reading.Humidity),
+			formatFloat(reading.Temperature),
+			strconv.FormatInt(reading.Count, 10),
+		}
+	}
+
+	s.writePage(w, r, page[store.Reading]{Data: readings, NextCursor: next}, next, []string{"time", "humidity", "temperature", "count"}, records)
+}
+
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request) {
+	quality, err := qualityParam(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	reading, err := s.store.Latest(r.Context(), r.PathValue("id"), quality)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if wantsCSV(r) {
+		writeCSV(w, []string{"device_id", "time", "humidity", "temperature"}, [][]string{latestRecord(reading)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, reading)
+}
+
+func (s *Server) handleLatestAll(w http.ResponseWriter, r *http.Request) {
+	limit, err := s.limit(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	after, err := decodeCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	quality, err := qualityParam(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	readings, err := s.store.LatestAll(r.Context(), after, quality, limit+1)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	var next string
+	if len(readings) > limit {
+		readings = readings[:limit]
+		next = encodeCursor(readings[limit-1].DeviceID)
+	}
+
+	records := make([][]string, len(readings))
+	for i, reading := range readings {
+		records[i] = latestRecord(reading)
+	}
+
+	s.writePage(w, r, page[store.Reading]{Data: readings, NextCursor: next}, next, []string{"device_id", "time", "humidity", "temperature"}, records)
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
+}
+
+func (s *Server) readingsQuery(r *http.Request) (store.ReadingsQuery, error) {
+	params := r.URL.Query()
+
+	query := store.ReadingsQuery{
+		DeviceID: r.PathValue("id"),
+		To:       s.options.now(),
+	}
+
+	var err error
+
+	if query.Limit, err = s.limit(r); err != nil {
+		return query, err
+	}
+
+	if value := params.Get("to"); value != "" {
+		if query.To, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return query, badRequestf("to must be an RFC 3339 time: %q", value)
+		}
+	}
+
+	query.From = query.To.Add(-s.options.defaultRange)
+	if value := params.Get("from"); value != "" {
+		if query.From, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return query, badRequestf("from must be an RFC 3339 time: %q", value)
+		}
+	}
+
+	if value := params.Get("step"); value != "" {
+		if query.Step, err = parseStep(value); err != nil {
+			return query, err
+		}
+
+		query.Aggregate = store.AggregateAvg
+	}
+
+	if value := params.Get("agg"); value != "" {
+		if query.Step == 0 {
+			return query, badRequestf("agg requires a step")
+		}
+
+		query.Aggregate = store.Aggregate(value)
+		if !query.Aggregate.Valid() {
+			return query, badRequestf("agg must be min, max or avg: %q", value)
+		}
+	}
+
+	if query.Quality, err = qualityParam(r); err != nil {
+		return query, err
+	}
+
+	if params.Has("quality") && query.Step > 0 {
+		return query, badRequestf("quality requires raw readings, aggregates only combine good readings")
+	}
+
+	cursor, err := decodeCursor(params.Get("cursor"))
+	if err != nil {
+		return query, err
+	}
+
+	if cursor != "" {
+		value, messageID, raw := strings.Cut(cursor, " ")
+		if raw != (query.Step <= 0) {
+			return query, badRequestf("invalid cursor")
+		}
+
+		if query.From, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return query, badRequestf("invalid cursor")
+		}
+
+		if raw {
+			query.After = &store.Cursor{Time: query.From, MessageID: messageID}
+		}
+	}
+
+	if !query.From.Before(query.To) {
+		return query, badRequestf("from must be before to")
+	}
+
+	return query, nil
+}
+
+func (s *Server) limit(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return s.options.defaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > s.options.maxLimit {
+		return 0, badRequestf("limit must be between 1 and %d: %q", s.options.maxLimit, value)
+	}
+
+	return limit, nil
+}
+
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, body any, next string, header []string, records [][]string) {
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
+
+	if wantsCSV(r) {
+		writeCSV(w, header, records)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var badRequest *badRequest
+
+	switch {
+	case errors.As(err, &badRequest):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+	case errors.Is(err, store.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "no readings found"})
+	default:
+		s.logger.Error("Failed to serve request", "error", err, "path", r.URL.Path)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "internal error"})
+	}
+}
+
+// parseStep parses a Go duration such as "15m", or a number of days such as
+// "7d".
+func parseStep(value string) (time.Duration, error) {
+	var step time.Duration
+	var err error
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		step = time.Duration(n) * 24 * time.Hour
+	} else {
+		step, err = time.ParseDuration(value)
+	}
+
+	if err != nil || step < time.Second {
+		return 0, badRequestf("step must be a duration of at least 1s, such as 15m, 1h or 7d: %q", value)
+	}
+
+	return step, nil
+}
+
+func wantsCSV(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/csv")
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeCSV(w http.ResponseWriter, header []string, records [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	writer.WriteAll(records)
+}
+
+func latestRecord(reading store.Reading) []string {
+	return []string{
+		reading.DeviceID,
+		formatTime(reading.Time),
+		formatFloat(reading.Humidity),
+		formatFloat(reading.Temperature),
+	}
+}
+
+// qualityParam returns the quality of the readings a request selects, all of
+// them by default.
+func qualityParam(r *http.Request) (store.Quality, error) {
+	value := r.URL.Query().Get("quality")
+	if value == "" {
+		return store.QualityAll, nil
+	}
+
+	quality := store.Quality(value)
+	if !quality.Valid() {
+		return "", badRequestf("quality must be all or good: %q", value)
+	}
+
+	return quality, nil
+}
+
+func encodeCursor(value string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", badRequestf("invalid cursor")
+	}
+	return string(value), nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/api/store"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+var now = time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+
+// mockStore serves perMinute readings (one by default) of sensor-1 every
+// minute from now-1h, and devices sensor-1 to sensor-3.
+type mockStore struct {
+	queries   []store.ReadingsQuery
+	qualities []store.Quality
+	perMinute int
+	err       error
+}
+
+func (m *mockStore) ListDevices(ctx context.Context, after string, limit int) ([]store.Device, error) {
+	var devices []store.Device
+	for _, id := range []string{"sensor-1", "sensor-2", "sensor-3"} {
+		if id > after && len(devices) < limit {
+			devices = append(devices, store.Device{ID: id, LastSeen: now})
+		}
+	}
+	return devices, m.err
+}
+
+func (m *mockStore) Readings(ctx context.Context, query store.ReadingsQuery) ([]store.Reading, error) {
+	m.queries = append(m.queries, query)
+
+	perMinute := max(m.perMinute, 1)
+
+	var readings []store.Reading
+	for t := now.Add(-time.Hour); t.Before(now); t = t.Add(time.Minute) {
+		for i := range perMinute {
+			messageID := fmt.Sprintf("message-%d", i)
+
+			if after := query.After; after != nil && (t.Before(after.Time) || t.Equal(after.Time) && messageID <= after.MessageID) {
+				continue
+			}
+
+			if !t.Before(query.From) && t.Before(query.To) && len(readings) < query.Limit {
+				readings = append(readings, store.Reading{DeviceID: query.DeviceID, Time: t, Humidity: 40, Temperature: 21.5, Count: 1, MessageID: messageID})
+			}
+		}
+	}
+	return readings, m.err
+}
+
+func (m *mockStore) Latest(ctx context.Context, deviceID string, quality store.Quality) (store.Reading, error) {
+	m.qualities = append(m.qualities, quality)
+
+	if deviceID != "sensor-1" {
+		return store.Reading{}, store.ErrNotFound
+	}
+	return store.Reading{DeviceID: deviceID, Time: now, Humidity: 40, Temperature: 21.5}, m.err
+}
+
+func (m *mockStore) LatestAll(ctx context.Context, after string, quality store.Quality, limit int) ([]store.Reading, error) {
+	m.qualities = append(m.qualities, quality)
+
+	devices, err := m.ListDevices(ctx, after, limit)
+
+	readings := make([]store.Reading, len(devices))
+	for i, device := range devices {
+		readings[i] = store.Reading{DeviceID: device.ID, Time: now, Humidity: 40, Temperature: 21.5}
+	}
+	return readings, err
+}
+
+func (m *mockStore) Ping() error  { return m.err }
+func (m *mockStore) Close() error { return nil }
+
+func newTestServer(s *mockStore) *Server {
+	return NewServer(s, &mockLogger{}, func(options *Options) {
+		options.now = func() time.Time { return now }
+	})
+}
+
+func get(t *testing.T, s *Server, target string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_Readings(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantQuery  store.ReadingsQuery
+		wantRows   int
+	}{
+		{
+			name:       "defaults to the last day",
+			target:     "/devices/sensor-1/readings",
+			wantStatus: http.StatusOK,
+			wantQuery:  store.ReadingsQuery{DeviceID: "sensor-1", From: now.Add(-24 * time.Hour), To: now, Quality: store.QualityAll, Limit: 1001},
+			wantRows:   60,
+		},
+		{
+			name:       "good readings only",
+			target:     "/devices/sensor-1/readings?quality=good",
+			wantStatus: http.StatusOK,
+			wantQuery:  store.ReadingsQuery{DeviceID: "sensor-1", From: now.Add(-24 * time.Hour), To: now, Quality: store.QualityGood, Limit: 1001},
+			wantRows:   60,
+		},
+		{
+			name:       "aggregates by step",
+			target:     "/devices/sensor-1/readings?from=2026-10-19T12:00:00Z&to=2026-10-19T13:00:00Z&step=15m&agg=max",
+			wantStatus: http.StatusOK,
+			wantQuery: store.ReadingsQuery{
+				DeviceID: "sensor-1", From: now.Add(-time.Hour), To: now,
+				Step: 15 * time.Minute, Aggregate: store.AggregateMax, Quality: store.QualityAll, Limit: 1001,
+			},
+			wantRows: 60,
+		},
+		{
+			name:       "averages by default",
+			target:     "/devices/sensor-1/readings?step=7d",
+			wantStatus: http.StatusOK,
+			wantQuery: store.ReadingsQuery{
+				DeviceID: "sensor-1", From: now.Add(-24 * time.Hour), To: now,
+				Step: 7 * 24 * time.Hour, Aggregate: store.AggregateAvg, Quality: store.QualityAll, Limit: 1001,
+			},
+			wantRows: 60,
+		},
+		{name: "invalid from", target: "/devices/sensor-1/readings?from=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "from after to", target: "/devices/sensor-1/readings?from=2026-10-20T00:00:00Z", wantStatus: http.StatusBadRequest},
+		{name: "invalid step", target: "/devices/sensor-1/readings?step=1ms", wantStatus: http.StatusBadRequest},
+		{name: "agg without step", target: "/devices/sensor-1/readings?agg=max", wantStatus: http.StatusBadRequest},
+		{name: "invalid agg", target: "/devices/sensor-1/readings?step=1h&agg=median", wantStatus: http.StatusBadRequest},
+		{name: "invalid quality", target: "/devices/sensor-1/readings?quality=flagged", wantStatus: http.StatusBadRequest},
+		{name: "quality with step", target: "/devices/sensor-1/readings?step=1h&quality=all", wantStatus: http.StatusBadRequest},
+		{name: "invalid limit", target: "/devices/sensor-1/readings?limit=0", wantStatus: http.StatusBadRequest},
+		{name: "invalid cursor", target: "/devices/sensor-1/readings?cursor=!", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &mockStore{}
+			rec := get(t, newTestServer(s), tt.target)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				var body errorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Error == "" {
+					t.Errorf("body = %s, want an error message", rec.Body)
+				}
+				return
+			}
+
+			if len(s.queries) != 1 {
+				t.Fatalf("store received %d queries, want 1", len(s.queries))
+			}
+			if got := s.queries[0]; !got.From.Equal(tt.wantQuery.From) || !got.To.Equal(tt.wantQuery.To) ||
+				got.DeviceID != tt.wantQuery.DeviceID || got.Step != tt.wantQuery.Step ||
+				got.Aggregate != tt.wantQuery.Aggregate || got.Quality != tt.wantQuery.Quality || got.Limit != tt.wantQuery.Limit {
+				t.Errorf("query = %+v, want %+v", got, tt.wantQuery)
+			}
+
+			var body page[store.Reading]
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if len(body.Data) != tt.wantRows || body.NextCursor != "" {
+				t.Errorf("got %d rows and cursor %q, want %d rows and no cursor", len(body.Data), body.NextCursor, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestServer_ReadingsPagination(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+	}{
+		{name: "one reading per time", perMinute: 1},
+		{name: "pages ending between readings with the same time", perMinute: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			times := pageReadings(t, newTestServer(&mockStore{perMinute: tt.perMinute}), "/devices/sensor-1/readings?limit=25")
+
+			if want := 60 * tt.perMinute; len(times) != want {
+				t.Fatalf("paged through %d readings, want %d", len(times), want)
+			}
+			for i := 1; i < len(times); i++ {
+				if times[i].Before(times[i-1]) {
+					t.Fatalf("reading %d at %s is before %s", i, times[i], times[i-1])
+				}
+			}
+		})
+	}
+}
+
+func TestServer_ReadingsPaginationByStep(t *testing.T) {
+	times := pageReadings(t, newTestServer(&mockStore{}), "/devices/sensor-1/readings?limit=25&step=1m")
+
+	if len(times) != 60 {
+		t.Fatalf("paged through %d readings, want 60", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if !times[i].After(times[i-1]) {
+			t.Fatalf("reading %d at %s is not after %s", i, times[i], times[i-1])
+		}
+	}
+}
+
+func TestServer_ReadingsCursorOfAnotherResolution(t *testing.T) {
+	s := newTestServer(&mockStore{})
+
+	rec := get(t, s, "/devices/sensor-1/readings?limit=25")
+
+	var body page[store.Reading]
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	rec = get(t, s, "/devices/sensor-1/readings?limit=25&step=1m&cursor="+body.NextCursor)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+// pageReadings follows the cursors from target and returns the time of every
+// reading.
+func pageReadings(t *testing.T, s *Server, target string) []time.Time {
+	t.Helper()
+
+	var times []time.Time
+	next := target
+
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not end")
+		}
+
+		rec := get(t, s, next)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+
+		var body page[store.Reading]
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON: %v", err)
+		}
+
+		for _, reading := range body.Data {
+			times = append(times, reading.Time)
+		}
+
+		if rec.Header().Get("X-Next-Cursor") != body.NextCursor {
+			t.Errorf("X-Next-Cursor = %q, want %q", rec.Header().Get("X-Next-Cursor"), body.NextCursor)
+		}
+
+		if body.NextCursor == "" {
+			return times
+		}
+		next = target + "&cursor=" + body.NextCursor
+	}
+}
+
+func TestServer_Devices(t *testing.T) {
+	s := newTestServer(&mockStore{})
+
+	rec := get(t, s, "/devices?limit=2")
+
+	var first page[store.Device]
+	if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(first.Data) != 2 || first.NextCursor == "" {
+		t.Fatalf("first page = %+v, want 2 devices and a cursor", first)
+	}
+
+	rec = get(t, s, "/devices?limit=2&cursor="+first.NextCursor)
+
+	var second page[store.Device]
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(second.Data) != 1 || second.Data[0].ID != "sensor-3" || second.NextCursor != "" {
+		t.Errorf("second page = %+v, want sensor-3 and no cursor", second)
+	}
+}
+
+func TestServer_CSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers []string
+		want    string
+	}{
+		{
+			name:   "format parameter",
+			target: "/devices?format=csv",
+			want:   "id,last_seen\nsensor-1,2026-10-19T13:00:00Z\nsensor-2,2026-10-19T13:00:00Z\nsensor-3,2026-10-19T13:00:00Z\n",
+		},
+		{
+			name:    "accept header",
+			target:  "/devices/sensor-1/latest",
+			headers: []string{"Accept", "text/csv"},
+			want:    "device_id,time,humidity,temperature\nsensor-1,2026-10-19T13:00:00Z,40,21.5\n",
+		},
+		{
+			name:   "readings",
+			target: "/devices/sensor-1/readings?from=2026-10-19T12:58:00Z&format=csv",
+			want:   "time,humidity,temperature,count\n2026-10-19T12:58:00Z,40,21.5,1\n2026-10-19T12:59:00Z,40,21.5,1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(t, newTestServer(&mockStore{}), tt.target, tt.headers...)
+
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/csv") {
+				t.Errorf("Content-Type = %q, want text/csv", got)
+			}
+			if rec.Body.String() != tt.want {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Latest(t *testing.T) {
+	s := newTestServer(&mockStore{})
+
+	rec := get(t, s, "/devices/sensor-1/latest")
+	var reading store.Reading
+	if err := json.Unmarshal(rec.Body.Bytes(), &reading); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if reading.DeviceID != "sensor-1" || !reading.Time.Equal(now) {
+		t.Errorf("latest = %+v, want the reading of sensor-1 at %s", reading, now)
+	}
+
+	if rec := get(t, s, "/devices/unknown/latest"); rec.Code != http.StatusNotFound {
+		t.Errorf("status of an unknown device = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = get(t, s, "/latest")
+	var all page[store.Reading]
+	if err := json.Unmarshal(rec.Body.Bytes(), &all); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(all.Data) != 3 {
+		t.Errorf("latest of %d devices, want 3", len(all.Data))
+	}
+}
+
+func TestServer_LatestQuality(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		wantStatus  int
+		wantQuality store.Quality
+	}{
+		{name: "every reading by default", target: "/devices/sensor-1/latest", wantStatus: http.StatusOK, wantQuality: store.QualityAll},
+		{name: "good reading of a device", target: "/devices/sensor-1/latest?quality=good", wantStatus: http.StatusOK, wantQuality: store.QualityGood},
+		{name: "good readings of every device", target: "/latest?quality=good", wantStatus: http.StatusOK, wantQuality: store.QualityGood},
+		{name: "invalid quality of a device", target: "/devices/sensor-1/latest?quality=bad", wantStatus: http.StatusBadRequest},
+		{name: "invalid quality of every device", target: "/latest?quality=bad", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &mockStore{}
+			rec := get(t, newTestServer(s), tt.target)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if len(s.qualities) != 0 {
+					t.Errorf("store was queried with %v", s.qualities)
+				}
+				return
+			}
+
+			if len(s.qualities) != 1 || s.qualities[0] != tt.wantQuality {
+				t.Errorf("store qualities = %v, want [%s]", s.qualities, tt.wantQuality)
+			}
+		})
+	}
+}
+
+func TestServer_StoreError(t *testing.T) {
+	s := newTestServer(&mockStore{err: errors.New("connection refused")})
+
+	rec := get(t, s, "/devices")
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(rec.Body.String(), "connection refused") {
+		t.Errorf("body %s leaks the store error", rec.Body)
+	}
+
+	if rec := get(t, s, "/healthz"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("health status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	rec := get(t, newTestServer(&mockStore{}), "/openapi.yaml")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	for _, path := range []string{"/devices:", "/devices/{id}/readings:", "/devices/{id}/latest:", "/latest:", "/healthz:"} {
+		if !strings.Contains(rec.Body.String(), "\n  "+path) {
+			t.Errorf("OpenAPI spec does not describe %s", path)
+		}
+	}
+}
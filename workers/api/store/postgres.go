@@ -0,0 +1,282 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// continuousAggregate is a view created by the data worker migrations, with
+// the min, max and average of every device per bucket.
+type continuousAggregate struct {
+	bucket time.Duration
+	view   string
+}
+
+// continuousAggregates are ordered from the largest bucket down, so the
+// coarsest view that can serve a step is used.
+var continuousAggregates = []continuousAggregate{
+	{bucket: 24 * time.Hour, view: "sensor_data_1d"},
+	{bucket: time.Hour, view: "sensor_data_1h"},
+	{bucket: time.Minute, view: "sensor_data_1m"},
+}
+
+// Postgres reads sensor_data and its continuous aggregates from TimescaleDB.
+// It does not run migrations, the data worker owns the schema.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(connectionString string) (*Postgres, error) {
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &Postgres{db: db}, nil
+}
+
+// devicesQuery lists the device IDs from sensor_data_1d, which has one row
+// per device and day instead of one per reading, with the start of the last
+// day each device has readings for.
+const devicesQuery = `
+		SELECT device_id, max(bucket) AS last_bucket
+		FROM sensor_data_1d
+		WHERE device_id > $1
+		GROUP BY device_id
+`
+
+// ListDevices reads the time of the last reading of each device with a
+// single lookup of the (device_id, time) index. Devices whose raw rows were
+// all dropped by the retention policy are reported with the start of their
+// last day.
+func (p *Postgres) ListDevices(ctx context.Context, after string, limit int) ([]Device, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT devices.device_id, coalesce(latest.time, devices.last_bucket)
+		FROM (`+devicesQuery+`
+			ORDER BY device_id
+			LIMIT $2
+		) devices
+		LEFT JOIN LATERAL (
+			SELECT time
+			FROM sensor_data
+			WHERE sensor_data.device_id = devices.device_id
+			ORDER BY time DESC
+			LIMIT 1
+		) latest ON true
+		ORDER BY devices.device_id
+	`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		var device Device
+		if err := rows.Scan(&device.ID, &device.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+func (p *Postgres) Readings(ctx context.Context, query ReadingsQuery) ([]Reading, error) {
+	statement, args := readingsStatement(query)
+
+	rows, err := p.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+	defer rows.Close()
+
+	var readings []Reading
+	for rows.Next() {
+		reading := Reading{DeviceID: query.DeviceID}
+
+		dest := []any{&reading.Time, &reading.Humidity, &reading.Temperature, &reading.Count}
+		if query.Step <= 0 {
+			dest = append(dest, &reading.MessageID)
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		readings = append(readings, reading)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query readings: %w", err)
+	}
+
+	return readings, nil
+}
+
+func (p *Postgres) Latest(ctx context.Context, deviceID string, quality Quality) (Reading, error) {
+	reading := Reading{DeviceID: deviceID}
+
+	err := p.db.QueryRowContext(ctx, `
+		SELECT time, humidity, temperature
+		FROM sensor_data
+		WHERE device_id = $1`+qualityCondition(quality)+`
+		ORDER BY time DESC
+		LIMIT 1
+	`, deviceID).Scan(&reading.Time, &reading.Humidity, &reading.Temperature)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Reading{}, ErrNotFound
+	}
+	if err != nil {
+		return Reading{}, fmt.Errorf("failed to query latest reading: %w", err)
+	}
+
+	return reading, nil
+}
+
+// LatestAll reads the most recent reading of each device with a single
+// lookup of the (device_id, time) index, instead of sorting every row of the
+// devices. Devices without raw rows left are skipped.
+func (p *Postgres) LatestAll(ctx context.Context, after string, quality Quality, limit int) ([]Reading, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT devices.device_id, latest.time, latest.humidity, latest.temperature
+		FROM (`+devicesQuery+`
+		) devices
+		CROSS JOIN LATERAL (
+			SELECT time, humidity, temperature
+			FROM sensor_data
+			WHERE sensor_data.device_id = devices.device_id`+qualityCondition(quality)+`
+			ORDER BY time DESC
+			LIMIT 1
+		) latest
+		ORDER BY devices.device_id
+		LIMIT $2
+	`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest readings: %w", err)
+	}
+	defer rows.Close()
+
+	var readings []Reading
+	for rows.Next() {
+		var reading Reading
+		if err := rows.Scan(&reading.DeviceID, &reading.Time, &reading.Humidity, &reading.Temperature); err != nil {
+			return nil, fmt.Errorf("failed to scan reading: %w", err)
+		}
+		readings = append(readings, reading)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query latest readings: %w", err)
+	}
+
+	return readings, nil
+}
+
+func (p *Postgres) Ping() error {
+	return p.db.Ping()
+}
+
+func (p *Postgres) Close() error {
+	return p.db.Close()
+}
+
+// readingsStatement builds the query of a ReadingsQuery. Raw readings come
+// from sensor_data in the order of its (device_id, time, message_id) index,
+// so a page can end between readings with the same time. Aggregated ones come from the coarsest continuous
+// aggregate whose bucket divides the step, re-bucketed to the step with
+// averages weighted by their counts, or from sensor_data when none does,
+// with the good readings only like the aggregates.
+func readingsStatement(query ReadingsQuery) (string, []any) {
+	args := []any{query.DeviceID, query.From, query.To, query.Limit}
+
+	if query.Step <= 0 {
+		if query.After == nil {
+			return `
+		SELECT time, humidity, temperature, 1, message_id
+		FROM sensor_data
+		WHERE device_id = $1 AND time >= $2 AND time < $3` + qualityCondition(query.Quality) + `
+		ORDER BY time, message_id
+		LIMIT $4
+	`, args
+		}
+
+		args[1] = query.After.Time
+		args = append(args, query.After.MessageID)
+
+		return `
+		SELECT time, humidity, temperature, 1, message_id
+		FROM sensor_data
+		WHERE device_id = $1 AND time >= $2 AND (time, message_id) > ($2, $5) AND time < $3` + qualityCondition(query.Quality) + `
+		ORDER BY time, message_id
+		LIMIT $4
+	`, args
+	}
+
+	args = append(args, fmt.Sprintf("%d microseconds", query.Step.Microseconds()))
+
+	view, ok := aggregateView(query.Step)
+	if !ok {
+		return fmt.Sprintf(`
+		SELECT time_bucket($5::interval, time) AS bucket, %s(humidity), %s(temperature), count(*)
+		FROM sensor_data
+		WHERE device_id = $1 AND time >= $2 AND time < $3%s
+		GROUP BY bucket
+		ORDER BY bucket
+		LIMIT $4
+	`, query.Aggregate, query.Aggregate, qualityCondition(QualityGood)), args
+	}
+
+	humidity, temperature := "min(min_humidity)", "min(min_temperature)"
+	switch query.Aggregate {
+	case AggregateMax:
+		humidity, temperature = "max(max_humidity)", "max(max_temperature)"
+	case AggregateAvg:
+		humidity = "sum(avg_humidity * readings) / sum(readings)"
+		temperature = "sum(avg_temperature * readings) / sum(readings)"
+	}
+
+	return fmt.Sprintf(`
+		SELECT time_bucket($5::interval, bucket) AS step_bucket, %s, %s, sum(readings)::bigint
+		FROM %s
+		WHERE device_id = $1 AND bucket >= $2 AND bucket < $3
+		GROUP BY step_bucket
+		ORDER BY step_bucket
+		LIMIT $4
+	`, humidity, temperature, view), args
+}
+
+// qualityCondition is the condition selecting the raw rows with the quality,
+// to append to a WHERE clause.
+func qualityCondition(quality Quality) string {
+	if quality == QualityGood {
+		return " AND quality = 0"
+	}
+	return ""
+}
+
+func aggregateView(step time.Duration) (string, bool) {
+	for _, aggregate := range continuousAggregates {
+		if step%aggregate.bucket == 0 {
+			return aggregate.view, true
+		}
+	}
+	return "", false
+}
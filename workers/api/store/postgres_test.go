@@ -0,0 +1,79 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadingsStatement(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     ReadingsQuery
+		wantFrom  string
+		wantParts []string
+	}{
+		{
+			name:      "raw readings",
+			query:     ReadingsQuery{},
+			wantFrom:  "FROM sensor_data",
+			wantParts: []string{"time >= $2", "ORDER BY time, message_id"},
+		},
+		{
+			name:      "raw readings after a cursor",
+			query:     ReadingsQuery{After: &Cursor{Time: time.Unix(0, 0), MessageID: "a"}},
+			wantFrom:  "FROM sensor_data",
+			wantParts: []string{"(time, message_id) > ($2, $5)", "ORDER BY time, message_id"},
+		},
+		{
+			name:      "good raw readings",
+			query:     ReadingsQuery{Quality: QualityGood},
+			wantFrom:  "FROM sensor_data",
+			wantParts: []string{"time < $3 AND quality = 0"},
+		},
+		{
+			name:      "step of a continuous aggregate",
+			query:     ReadingsQuery{Step: time.Hour, Aggregate: AggregateMax},
+			wantFrom:  "FROM sensor_data_1h",
+			wantParts: []string{"max(max_humidity)", "max(max_temperature)"},
+		},
+		{
+			name:      "multiple of a continuous aggregate",
+			query:     ReadingsQuery{Step: 15 * time.Minute, Aggregate: AggregateAvg},
+			wantFrom:  "FROM sensor_data_1m",
+			wantParts: []string{"sum(avg_humidity * readings) / sum(readings)"},
+		},
+		{
+			name:      "days",
+			query:     ReadingsQuery{Step: 7 * 24 * time.Hour, Aggregate: AggregateMin},
+			wantFrom:  "FROM sensor_data_1d",
+			wantParts: []string{"min(min_humidity)"},
+		},
+		{
+			name:      "step finer than every continuous aggregate",
+			query:     ReadingsQuery{Step: 30 * time.Second, Aggregate: AggregateAvg},
+			wantFrom:  "FROM sensor_data\n",
+			wantParts: []string{"time_bucket($5::interval, time)", "avg(humidity)", "AND quality = 0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statement, args := readingsStatement(tt.query)
+
+			for _, part := range append([]string{tt.wantFrom}, tt.wantParts...) {
+				if !strings.Contains(statement, part) {
+					t.Errorf("statement does not contain %q:\n%s", part, statement)
+				}
+			}
+
+			wantArgs := 4
+			if tt.query.Step > 0 || tt.query.After != nil {
+				wantArgs = 5
+			}
+			if len(args) != wantArgs {
+				t.Errorf("len(args) = %d, want %d", len(args), wantArgs)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Aggregate is how the readings of a bucket are combined.
+type Aggregate string
+
+const (
+	AggregateMin Aggregate = "min"
+	AggregateMax Aggregate = "max"
+	AggregateAvg Aggregate = "avg"
+)
+
+func (a Aggregate) Valid() bool {
+	return a == AggregateMin || a == AggregateMax || a == AggregateAvg
+}
+
+// Quality selects raw readings by the validation rules they failed. The
+// aggregates only hold good readings.
+type Quality string
+
+const (
+	// QualityAll selects every reading, flagged or not.
+	QualityAll Quality = "all"
+	// QualityGood selects the readings with quality 0.
+	QualityGood Quality = "good"
+)
+
+func (q Quality) Valid() bool {
+	return q == QualityAll || q == QualityGood
+}
+
+type Device struct {
+	ID       string    `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Reading is a stored reading or, for aggregated queries, the aggregate of
+// the Count readings of the bucket starting at Time.
+type Reading struct {
+	DeviceID    string    `json:"device_id"`
+	Time        time.Time `json:"time"`
+	Humidity    float64   `json:"humidity"`
+	Temperature float64   `json:"temperature"`
+	Count       int64     `json:"count,omitempty"`
+	// MessageID tells apart raw readings of a device with the same time.
+	MessageID string `json:"-"`
+}
+
+// Cursor identifies the raw reading a page ended at.
+type Cursor struct {
+	Time      time.Time
+	MessageID string
+}
+
+// ReadingsQuery selects the readings of a device with From <= time < To, in
+// ascending time. With a Step the readings are combined by Aggregate into
+// buckets of that size, and From and To select the buckets by their start.
+// Raw readings are ordered by time and message ID, selected by Quality, and
+// with an After cursor start after that reading instead of at From.
+// Aggregated readings only combine good readings.
+type ReadingsQuery struct {
+	DeviceID  string
+	From      time.Time
+	To        time.Time
+	Step      time.Duration
+	Aggregate Aggregate
+	Quality   Quality
+	After     *Cursor
+	Limit     int
+}
+
+// Store reads back the sensor data written by the data worker.
+type Store interface {
+	// ListDevices returns up to limit devices with an ID greater than after,
+	// ordered by ID.
+	ListDevices(ctx context.Context, after string, limit int) ([]Device, error)
+	Readings(ctx context.Context, query ReadingsQuery) ([]Reading, error)
+	// Latest returns the most recent reading of a device with the quality,
+	// or ErrNotFound.
+	Latest(ctx context.Context, deviceID string, quality Quality) (Reading, error)
+	// LatestAll returns the most recent reading with the quality of up to
+	// limit devices with an ID greater than after, ordered by device ID.
+	LatestAll(ctx context.Context, after string, quality Quality, limit int) ([]Reading, error)
+	Ping() error
+	Close() error
+}
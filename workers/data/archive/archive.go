@@ -0,0 +1,486 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+)
+
+const hourLayout = "2006-01-02T15"
+
+var ErrArchiveClosed = errors.New("archive closed")
+
+// Archive writes sensor data to Parquet files in a local directory,
+// partitioned by device and hour:
+//
+//	<dir>/device_id=<id>/hour=2006-01-02T15/part-<nanos>-<seq>.parquet
+//
+// Every flush writes new immutable files, so an hour usually ends up split
+// across many small files. Once the hour is over, compaction merges them into
+// files of at most the maximum number of rows, sorted by time and with
+// redelivered rows removed.
+//
+// Archive implements database.Copier so it can be wrapped in a
+// database.BatchWriter like any other store.
+type Archive struct {
+	dir     string
+	logger  logger.Interface
+	options *Options
+
+	mu       sync.Mutex
+	manifest *Manifest
+	sequence uint64
+	closed   bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+type Options struct {
+	maxRowsPerFile     int
+	compactionInterval time.Duration
+	compression        compress.Codec
+	now                func() time.Time
+}
+
+type Option func(*Options)
+
+// WithMaxRowsPerFile sets how many rows a file holds before the rows are
+// rotated into a new one.
+func WithMaxRowsPerFile(maxRows int) Option {
+	return func(options *Options) {
+		options.maxRowsPerFile = maxRows
+	}
+}
+
+// WithCompactionInterval sets how often the partitions of past hours are
+// compacted. Zero disables background compaction, Compact can still be
+// called directly.
+func WithCompactionInterval(interval time.Duration) Option {
+	return func(options *Options) {
+		options.compactionInterval = interval
+	}
+}
+
+// WithCompression sets the codec of the Parquet pages, parquet.Zstd by
+// default.
+func WithCompression(codec compress.Codec) Option {
+	return func(options *Options) {
+		options.compression = codec
+	}
+}
+
+// record is a row of an archive file. Files written before the quality
+// column existed read it as 0, good readings, and files written before the
+// message_id column existed read it as empty.
+type record struct {
+	Time        time.Time `parquet:"time,timestamp(nanosecond)"`
+	DeviceID    string    `parquet:"device_id"`
+	Humidity    float32   `parquet:"humidity"`
+	Temperature float32   `parquet:"temperature"`
+	Quality     int32     `parquet:"quality"`
+	MessageID   string    `parquet:"message_id"`
+}
+
+type partition struct {
+	deviceID string
+	hour     time.Time
+}
+
+// NewArchive opens the archive in dir, creating it if needed. Files left
+// behind by a crash that are not in the manifest are removed.
+func NewArchive(dir string, logger logger.Interface, options ...Option) (*Archive, error) {
+	defaultOptions := &Options{
+		maxRowsPerFile:     100000,
+		compactionInterval: 10 * time.Minute,
+		compression:        &parquet.Zstd,
+		now:                time.Now,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	if defaultOptions.maxRowsPerFile < 1 {
+		defaultOptions.maxRowsPerFile = 1
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	manifest, err := loadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		dir:      dir,
+		logger:   logger,
+		options:  defaultOptions,
+		manifest: manifest,
+		stop:     make(chan struct{}),
+	}
+
+	if err := a.removeOrphans(); err != nil {
+		return nil, err
+	}
+
+	if defaultOptions.compactionInterval > 0 {
+		a.wg.Add(1)
+		go a.run()
+	}
+
+	return a, nil
+}
+
+// CopySensorData writes the rows to new files of their partitions and adds
+// them to the manifest. Rows are appended as they come, so redeliveries are
+// only dropped by compaction, and every row counts as inserted.
+func (a *Archive) CopySensorData(data []database.SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return 0, ErrArchiveClosed
+	}
+
+	partitions := make(map[partition][]record)
+	for _, row := range data {
+		key := partition{deviceID: row.DeviceID, hour: row.Timestamp.UTC().Truncate(time.Hour)}
+		partitions[key] = append(partitions[key], record{
+			Time:        row.Timestamp.UTC(),
+			DeviceID:    row.DeviceID,
+			Humidity:    row.Humidity,
+			Temperature: row.Temperature,
+			Quality:     int32(row.Quality),
+			MessageID:   row.MessageID,
+		})
+	}
+
+	var written []File
+	for key, records := range partitions {
+		files, err := a.writeFiles(key, records)
+		written = append(written, files...)
+		if err != nil {
+			a.removeFiles(written)
+			return 0, err
+		}
+	}
+
+	a.manifest.Files = append(a.manifest.Files, written...)
+
+	if err := a.manifest.save(a.dir); err != nil {
+		a.manifest.Files = a.manifest.Files[:len(a.manifest.Files)-len(written)]
+		a.removeFiles(written)
+		return 0, err
+	}
+
+	return int64(len(data)), nil
+}
+
+// ListSensorData returns the rows of a device with from <= time < to, ordered
+// by time and without redeliveries.
+func (a *Archive) ListSensorData(deviceID string, from, to time.Time) ([]database.SensorData, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var files []File
+	for _, file := range a.manifest.Files {
+		if file.DeviceID == deviceID && file.MaxTime.Compare(from) >= 0 && file.MinTime.Before(to) {
+			files = append(files, file)
+		}
+	}
+
+	records, err := a.readFiles(files)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]database.SensorData, 0, len(records))
+	for _, r := range records {
+		if r.Time.Compare(from) >= 0 && r.Time.Before(to) {
+			data = append(data, database.SensorData{
+				DeviceID:    r.DeviceID,
+				Timestamp:   r.Time,
+				Humidity:    r.Humidity,
+				Temperature: r.Temperature,
+				Quality:     database.Quality(r.Quality),
+				MessageID:   r.MessageID,
+			})
+		}
+	}
+
+	return data, nil
+}
+
+// Files returns a copy of the manifest entries.
+func (a *Archive) Files() []File {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]File(nil), a.manifest.Files...)
+}
+
+// Compact merges the small files of every partition whose hour is over. The
+// merged files replace the old ones in the manifest before the old ones are
+// deleted, so a crash in between only leaves orphans behind.
+func (a *Archive) Compact() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrArchiveClosed
+	}
+
+	currentHour := a.options.now().UTC().Truncate(time.Hour)
+
+	small := make(map[partition][]File)
+	for _, file := range a.manifest.Files {
+		if file.Rows >= int64(a.options.maxRowsPerFile) || !file.Hour.Before(currentHour) {
+			continue
+		}
+
+		key := partition{deviceID: file.DeviceID, hour: file.Hour}
+		small[key] = append(small[key], file)
+	}
+
+	var compacted, removed []File
+	for key, files := range small {
+		if len(files) < 2 {
+			continue
+		}
+
+		records, err := a.readFiles(files)
+		if err != nil {
+			a.removeFiles(compacted)
+			return err
+		}
+
+		merged, err := a.writeFiles(key, records)
+		compacted = append(compacted, merged...)
+		if err != nil {
+			a.removeFiles(compacted)
+			return err
+		}
+
+		removed = append(removed, files...)
+	}
+
+	if len(removed) == 0 {
+		return nil
+	}
+
+	previous := a.manifest.Files
+	a.manifest.Files = append(withoutFiles(previous, removed), compacted...)
+
+	if err := a.manifest.save(a.dir); err != nil {
+		a.manifest.Files = previous
+		a.removeFiles(compacted)
+		return err
+	}
+
+	a.removeFiles(removed)
+
+	a.logger.Info("Compacted archive", "files", len(removed), "into", len(compacted))
+	return nil
+}
+
+// Close stops the background compaction.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mu.Unlock()
+
+	close(a.stop)
+	a.wg.Wait()
+
+	return nil
+}
+
+func (a *Archive) run() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.options.compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Compact(); err != nil && !errors.Is(err, ErrArchiveClosed) {
+				a.logger.Error("Failed to compact archive", "error", err, "dir", a.dir)
+			}
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// writeFiles sorts the records of a partition by time and writes them to as
+// many files as the maximum number of rows per file requires.
+func (a *Archive) writeFiles(key partition, records []record) ([]File, error) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	partitionDir := filepath.Join(
+		"device_id="+url.PathEscape(key.deviceID),
+		"hour="+key.hour.Format(hourLayout),
+	)
+
+	if err := os.MkdirAll(filepath.Join(a.dir, partitionDir), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create partition directory: %w", err)
+	}
+
+	var files []File
+	for start := 0; start < len(records); start += a.options.maxRowsPerFile {
+		end := min(start+a.options.maxRowsPerFile, len(records))
+		chunk := records[start:end]
+
+		a.sequence++
+		name := fmt.Sprintf("part-%d-%d.parquet", a.options.now().UnixNano(), a.sequence)
+		path := filepath.Join(partitionDir, name)
+
+		if err := writeFileAtomic(filepath.Join(a.dir, path), func(f *os.File) error {
+			writer := parquet.NewGenericWriter[record](f, parquet.Compression(a.options.compression))
+			if _, err := writer.Write(chunk); err != nil {
+				return err
+			}
+			return writer.Close()
+		}); err != nil {
+			return files, fmt.Errorf("failed to write archive file: %w", err)
+		}
+
+		info, err := os.Stat(filepath.Join(a.dir, path))
+		if err != nil {
+			return files, fmt.Errorf("failed to stat archive file: %w", err)
+		}
+
+		files = append(files, File{
+			Path:     filepath.ToSlash(path),
+			DeviceID: key.deviceID,
+			Hour:     key.hour,
+			Rows:     int64(len(chunk)),
+			Size:     info.Size(),
+			MinTime:  chunk[0].Time,
+			MaxTime:  chunk[len(chunk)-1].Time,
+			Created:  a.options.now().UTC(),
+		})
+	}
+
+	return files, nil
+}
+
+// readFiles returns the records of the files sorted by time, keeping the
+// first record of every device, time and message ID.
+func (a *Archive) readFiles(files []File) ([]record, error) {
+	var records []record
+	for _, file := range files {
+		rows, err := parquet.ReadFile[record](filepath.Join(a.dir, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive file %s: %w", file.Path, err)
+		}
+		records = append(records, rows...)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	type key struct {
+		deviceID  string
+		timestamp int64
+		messageID string
+	}
+
+	seen := make(map[key]struct{}, len(records))
+	unique := records[:0]
+	for _, r := range records {
+		k := key{deviceID: r.DeviceID, timestamp: r.Time.UnixNano(), messageID: r.MessageID}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		unique = append(unique, r)
+	}
+
+	return unique, nil
+}
+
+func (a *Archive) removeFiles(files []File) {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(a.dir, filepath.FromSlash(file.Path))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			a.logger.Warn("Failed to remove archive file", "error", err, "path", file.Path)
+		}
+	}
+}
+
+// removeOrphans deletes temporary files and Parquet files that are not in the
+// manifest.
+func (a *Archive) removeOrphans() error {
+	known := make(map[string]struct{}, len(a.manifest.Files))
+	for _, file := range a.manifest.Files {
+		known[file.Path] = struct{}{}
+	}
+
+	err := filepath.WalkDir(a.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		relative, err := filepath.Rel(a.dir, path)
+		if err != nil {
+			return err
+		}
+
+		_, inManifest := known[filepath.ToSlash(relative)]
+		orphan := strings.HasSuffix(path, ".tmp") || (strings.HasSuffix(path, ".parquet") && !inManifest)
+		if !orphan {
+			return nil
+		}
+
+		a.logger.Warn("Removing file missing from the archive manifest", "path", relative)
+		return os.Remove(path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove orphaned archive files: %w", err)
+	}
+
+	return nil
+}
+
+func withoutFiles(files, removed []File) []File {
+	skip := make(map[string]struct{}, len(removed))
+	for _, file := range removed {
+		skip[file.Path] = struct{}{}
+	}
+
+	kept := make([]File, 0, len(files))
+	for _, file := range files {
+		if _, ok := skip[file.Path]; !ok {
+			kept = append(kept, file)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,285 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+var baseTime = time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+
+func withNow(now time.Time) Option {
+	return func(options *Options) {
+		options.now = func() time.Time { return now }
+	}
+}
+
+func readings(deviceID string, start time.Time, n int) []database.SensorData {
+	data := make([]database.SensorData, n)
+	for i := range data {
+		data[i] = database.SensorData{
+			DeviceID:    deviceID,
+			Timestamp:   start.Add(time.Duration(i) * time.Second),
+			Humidity:    float32(i),
+			Temperature: 20,
+		}
+	}
+	return data
+}
+
+func newTestArchive(t *testing.T, dir string, options ...Option) *Archive {
+	t.Helper()
+
+	options = append([]Option{WithCompactionInterval(0), withNow(baseTime.Add(2 * time.Hour))}, options...)
+
+	a, err := NewArchive(dir, &mockLogger{}, options...)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	t.Cleanup(func() { a.Close() })
+
+	return a
+}
+
+func TestArchive_Partitions(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []database.SensorData
+		maxRows   int
+		wantFiles map[string]int
+	}{
+		{
+			name:    "one file per device and hour",
+			data:    append(readings("device-1", baseTime.Add(59*time.Minute+59*time.Second), 2), readings("device-2", baseTime, 1)...),
+			maxRows: 100,
+			wantFiles: map[string]int{
+				"device_id=device-1/hour=2026-10-19T13": 1,
+				"device_id=device-1/hour=2026-10-19T14": 1,
+				"device_id=device-2/hour=2026-10-19T13": 1,
+			},
+		},
+		{
+			name:    "rotates full files",
+			data:    readings("device-1", baseTime, 5),
+			maxRows: 2,
+			wantFiles: map[string]int{
+				"device_id=device-1/hour=2026-10-19T13": 3,
+			},
+		},
+		{
+			name:    "escapes device IDs",
+			data:    readings("../device/1", baseTime, 1),
+			maxRows: 100,
+			wantFiles: map[string]int{
+				"device_id=..%2Fdevice%2F1/hour=2026-10-19T13": 1,
+			},
+		},
+		{
+			name:    "groups other time zones by UTC hour",
+			data:    readings("device-1", baseTime.In(time.FixedZone("UTC-3", -3*60*60)), 1),
+			maxRows: 100,
+			wantFiles: map[string]int{
+				"device_id=device-1/hour=2026-10-19T13": 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestArchive(t, t.TempDir(), WithMaxRowsPerFile(tt.maxRows))
+
+			inserted, err := a.CopySensorData(tt.data)
+			if err != nil {
+				t.Fatalf("CopySensorData() error = %v", err)
+			}
+			if inserted != int64(len(tt.data)) {
+				t.Errorf("CopySensorData() = %d, want %d", inserted, len(tt.data))
+			}
+
+			files := make(map[string]int)
+			for _, file := range a.Files() {
+				files[filepath.ToSlash(filepath.Dir(file.Path))]++
+
+				if _, err := os.Stat(filepath.Join(a.dir, file.Path)); err != nil {
+					t.Errorf("file %s is in the manifest but not on disk: %v", file.Path, err)
+				}
+			}
+
+			if len(files) != len(tt.wantFiles) {
+				t.Fatalf("partitions = %v, want %v", files, tt.wantFiles)
+			}
+			for dir, want := range tt.wantFiles {
+				if files[dir] != want {
+					t.Errorf("partition %s has %d files, want %d", dir, files[dir], want)
+				}
+			}
+		})
+	}
+}
+
+func TestArchive_ListSensorData(t *testing.T) {
+	a := newTestArchive(t, t.TempDir())
+
+	data := readings("device-1", baseTime, 4)
+	data[2].Quality = database.QualityOutOfRange
+
+	// Another message of the device at the same time is not a redelivery.
+	sameTime := data[1]
+	sameTime.Humidity = 99
+	sameTime.MessageID = "other-message"
+
+	// Flushed out of order, with a redelivery and another device.
+	for _, batch := range [][]database.SensorData{
+		{data[2], data[0]},
+		{data[1], data[2], data[3]},
+		{sameTime},
+		readings("device-2", baseTime, 2),
+	} {
+		if _, err := a.CopySensorData(batch); err != nil {
+			t.Fatalf("CopySensorData() error = %v", err)
+		}
+	}
+
+	got, err := a.ListSensorData("device-1", baseTime.Add(time.Second), baseTime.Add(3*time.Second))
+	if err != nil {
+		t.Fatalf("ListSensorData() error = %v", err)
+	}
+
+	want := []database.SensorData{data[1], sameTime, data[2]}
+	if len(got) != len(want) {
+		t.Fatalf("ListSensorData() returned %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Timestamp.Equal(want[i].Timestamp) || got[i].Humidity != want[i].Humidity || got[i].DeviceID != want[i].DeviceID || got[i].Quality != want[i].Quality || got[i].MessageID != want[i].MessageID {
+			t.Errorf("ListSensorData()[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestArchive_Compact(t *testing.T) {
+	a := newTestArchive(t, t.TempDir(), WithMaxRowsPerFile(3))
+
+	pastHour := readings("device-1", baseTime, 4)
+	currentHour := readings("device-1", baseTime.Add(2*time.Hour), 2)
+
+	for _, batch := range [][]database.SensorData{
+		pastHour[:1], pastHour[1:2], pastHour[1:], currentHour[:1], currentHour[1:],
+	} {
+		if _, err := a.CopySensorData(batch); err != nil {
+			t.Fatalf("CopySensorData() error = %v", err)
+		}
+	}
+
+	before := a.Files()
+
+	if err := a.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+
+	var pastFiles, currentFiles int
+	var pastRows int64
+	for _, file := range a.Files() {
+		switch {
+		case file.Hour.Equal(baseTime):
+			pastFiles++
+			pastRows += file.Rows
+		case file.Hour.Equal(baseTime.Add(2 * time.Hour)):
+			currentFiles++
+		}
+	}
+
+	// The full file of the past hour is kept and the two single-row files
+	// are merged.
+	if pastFiles != 2 {
+		t.Errorf("past hour has %d files after compaction, want 2", pastFiles)
+	}
+	if pastRows != 5 {
+		t.Errorf("past hour has %d rows after compaction, want 5", pastRows)
+	}
+	if currentFiles != 2 {
+		t.Errorf("current hour has %d files after compaction, want 2", currentFiles)
+	}
+
+	for _, file := range withoutFiles(before, a.Files()) {
+		if _, err := os.Stat(filepath.Join(a.dir, file.Path)); !os.IsNotExist(err) {
+			t.Errorf("compacted file %s still exists", file.Path)
+		}
+	}
+
+	got, err := a.ListSensorData("device-1", baseTime, baseTime.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ListSensorData() error = %v", err)
+	}
+	if len(got) != len(pastHour) {
+		t.Errorf("ListSensorData() returned %d rows after compaction, want %d", len(got), len(pastHour))
+	}
+}
+
+func TestArchive_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	a := newTestArchive(t, dir)
+	if _, err := a.CopySensorData(readings("device-1", baseTime, 3)); err != nil {
+		t.Fatalf("CopySensorData() error = %v", err)
+	}
+	a.Close()
+
+	partitionDir := filepath.Join(dir, "device_id=device-1", "hour=2026-10-19T13")
+	orphans := []string{
+		filepath.Join(partitionDir, "part-1-1.parquet"),
+		filepath.Join(partitionDir, "part-2-2.parquet.123.tmp"),
+	}
+	for _, orphan := range orphans {
+		if err := os.WriteFile(orphan, []byte("partial"), 0o644); err != nil {
+			t.Fatalf("failed to write orphan: %v", err)
+		}
+	}
+
+	reopened := newTestArchive(t, dir)
+
+	for _, orphan := range orphans {
+		if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+			t.Errorf("orphan %s was not removed", orphan)
+		}
+	}
+
+	got, err := reopened.ListSensorData("device-1", baseTime, baseTime.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ListSensorData() error = %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("ListSensorData() returned %d rows after reopening, want 3", len(got))
+	}
+}
+
+func TestArchive_BatchWriter(t *testing.T) {
+	a := newTestArchive(t, t.TempDir())
+
+	writer := database.NewBatchWriter(a, &mockLogger{}, database.WithMaxRows(2))
+
+	if err := writer.Write(context.Background(), readings("device-1", baseTime, 2)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	writer.Close()
+
+	if files := a.Files(); len(files) != 1 || files[0].Rows != 2 {
+		t.Errorf("Files() = %+v, want a single file with 2 rows", files)
+	}
+
+	a.Close()
+
+	if _, err := a.CopySensorData(readings("device-1", baseTime, 1)); err != ErrArchiveClosed {
+		t.Errorf("CopySensorData() after Close() error = %v, want %v", err, ErrArchiveClosed)
+	}
+}
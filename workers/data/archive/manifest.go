@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const manifestName = "manifest.json"
+
+// Manifest lists every file of the archive. Files that are not in it, left
+// behind by a crash before the manifest was saved, are not part of the
+// archive and are removed when it is opened.
+type Manifest struct {
+	Version int    `json:"version"`
+	Files   []File `json:"files"`
+}
+
+// File describes a Parquet file of the archive.
+type File struct {
+	// Path is relative to the archive directory.
+	Path     string    `json:"path"`
+	DeviceID string    `json:"device_id"`
+	Hour     time.Time `json:"hour"`
+	Rows     int64     `json:"rows"`
+	Size     int64     `json:"size"`
+	MinTime  time.Time `json:"min_time"`
+	MaxTime  time.Time `json:"max_time"`
+	Created  time.Time `json:"created"`
+}
+
+func loadManifest(dir string) (*Manifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{Version: 1}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// save replaces the manifest atomically, so a crash leaves either the old or
+// the new one.
+func (m *Manifest) save(dir string) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, manifestName), func(f *os.File) error {
+		_, err := f.Write(content)
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to save manifest: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes path through a temporary file that is synced and
+// renamed over it.
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
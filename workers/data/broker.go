@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/kafka"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/mqtt"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/nats"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/rabbitmq"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/config"
+)
+
+// messageBroker is a broker backend that also reports its connection state
+// for the health check and publishes for the republish sink.
+type messageBroker interface {
+	broker.MessageBroker
+	broker.MessagePublisher
+	State() broker.State
+}
+
+// connectBroker connects to the broker selected by the configuration and sets
+// up the queue the worker consumes.
+func connectBroker(cfg *config.Config, log logger.Interface) (messageBroker, broker.Queue, error) {
+	switch cfg.Broker {
+	case "kafka":
+		log.Debug("Connecting to Kafka", "brokers", cfg.Kafka.Brokers)
+
+		kafkaBroker := kafka.NewBroker(cfg.Kafka.Brokers, log)
+
+		if err := kafkaBroker.Connect(); err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+		}
+
+		queue := kafka.NewQueue(
+			cfg.Kafka.Topic,
+			kafka.WithGroup(cfg.QueueName),
+			kafka.WithPartitions(cfg.Kafka.Partitions),
+			kafka.WithMaxRetries(cfg.Kafka.MaxRetries),
+			kafka.WithDeadLetterTopic(cfg.Kafka.DeadLetterTopic),
+		)
+
+		log.Debug("Setting up Kafka topic", "topic", queue.GetName(), "group", cfg.QueueName)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := kafkaBroker.SetupQueue(ctx, queue); err != nil {
+			kafkaBroker.Close()
+			return nil, nil, fmt.Errorf("failed to set up Kafka topic: %w", err)
+		}
+
+		return kafkaBroker, queue, nil
+	case "mqtt":
+		log.Debug("Connecting to MQTT broker", "url", cfg.MQTT.URL)
+
+		brokerOptions := []mqtt.BrokerOption{
+			mqtt.WithCredentials(cfg.MQTT.User, cfg.MQTT.Password),
+			mqtt.WithCleanSession(cfg.MQTT.CleanSession),
+		}
+
+		if cfg.MQTT.ClientID != "" {
+			brokerOptions = append(brokerOptions, mqtt.WithClientID(cfg.MQTT.ClientID))
+		}
+
+		mqttBroker := mqtt.NewBroker(cfg.MQTT.URL, log, brokerOptions...)
+
+		if err := mqttBroker.Connect(); err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+		}
+
+		queue := mqtt.NewQueue(
+			cfg.MQTT.Topic,
+			mqtt.WithGroup(cfg.QueueName),
+			mqtt.WithQoS(byte(cfg.MQTT.QoS)),
+			mqtt.WithMaxRetries(cfg.MQTT.MaxRetries),
+			mqtt.WithDeadLetterTopic(cfg.MQTT.DeadLetterTopic),
+		)
+
+		mqttBroker.SetupQueue(queue)
+
+		return mqttBroker, queue, nil
+	case "nats":
+		log.Debug("Connecting to NATS", "url", cfg.NATS.URL)
+
+		natsBroker := nats.NewBroker(cfg.NATS.URL, log)
+
+		if err := natsBroker.Connect(); err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to NATS: %w", err)
+		}
+
+		queue := nats.NewQueue(
+			cfg.QueueName,
+			nats.WithStream(cfg.NATS.Stream),
+			nats.WithSubjects(cfg.NATS.Subjects...),
+			nats.WithFilterSubject(cfg.NATS.FilterSubject),
+			nats.WithMaxDeliver(cfg.NATS.MaxDeliver),
+			nats.WithDeadLetterSubject(cfg.NATS.DeadLetterSubject),
+		)
+
+		log.Debug("Setting up JetStream consumer", "queue", queue.GetName(), "stream", cfg.NATS.Stream)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := natsBroker.SetupQueue(ctx, queue); err != nil {
+			natsBroker.Close()
+			return nil, nil, fmt.Errorf("failed to set up JetStream consumer: %w", err)
+		}
+
+		return natsBroker, queue, nil
+	case "rabbitmq":
+		url := fmt.Sprintf(
+			"amqp://%s:%s@%s:%s",
+			cfg.User,
+			cfg.Password,
+			cfg.Domain,
+			cfg.Port,
+		)
+
+		log.Debug("Connecting to RabbitMQ with URL", "url", url)
+
+		rabbitMQ := rabbitmq.NewBroker(url, log)
+
+		if err := rabbitMQ.Connect(); err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		}
+
+		queue := rabbitmq.NewQueue(cfg.QueueName)
+
+		log.Debug("Setting up queue channel", "queue", queue.GetName())
+
+		if err := rabbitMQ.SetupQueueChannel(queue); err != nil {
+			rabbitMQ.Close()
+			return nil, nil, fmt.Errorf("failed to set up queue channel: %w", err)
+		}
+
+		return rabbitMQ, queue, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown broker %q, expected rabbitmq, nats, kafka or mqtt", cfg.Broker)
+	}
+}
+
+// timeSyncSupported reports whether the broker carries the device topics
+// time requests and responses go through.
+func timeSyncSupported(messageBroker messageBroker) bool {
+	switch messageBroker.(type) {
+	case *rabbitmq.Broker, *mqtt.Broker:
+		return true
+	default:
+		return false
+	}
+}
+
+// setupTimeSyncQueue sets up the queue of device time requests on the broker
+// the worker consumes from, and returns it with the exchange time responses
+// are published to. Only RabbitMQ and MQTT carry the device topics.
+func setupTimeSyncQueue(cfg *config.Config, messageBroker messageBroker) (broker.Queue, string, error) {
+	switch b := messageBroker.(type) {
+	case *rabbitmq.Broker:
+		queue := rabbitmq.NewQueue(cfg.TimeSync.QueueName)
+
+		if err := b.SetupQueueChannel(queue); err != nil {
+			return nil, "", fmt.Errorf("failed to set up time request queue channel: %w", err)
+		}
+
+		return queue, cfg.TimeSync.Exchange, nil
+	case *mqtt.Broker:
+		queue := mqtt.NewQueue(
+			cfg.TimeSync.RequestTopic,
+			mqtt.WithGroup(cfg.TimeSync.QueueName),
+			mqtt.WithQoS(byte(cfg.MQTT.QoS)),
+		)
+
+		b.SetupQueue(queue)
+
+		return queue, "", nil
+	default:
+		return nil, "", fmt.Errorf("time sync is not supported with %s, expected rabbitmq or mqtt", cfg.Broker)
+	}
+}
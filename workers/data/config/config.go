@@ -2,26 +2,148 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 )
 
 type Config struct {
-	User        string `json:"rabbitmq_user"`
-	Password    string
-	Domain      string            `json:"rabbitmq_domain"`
-	Port        string            `json:"rabbitmq_port"`
-	QueueName   string            `json:"rabbitmq_queue_name"`
-	TimescaleDB TimescaleDBConfig `json:"timescaledb"`
-	Log         logger.Config     `json:"log"`
+	Broker                 string      `json:"broker"`
+	NATS                   NATSConfig  `json:"nats"`
+	Kafka                  KafkaConfig `json:"kafka"`
+	MQTT                   MQTTConfig  `json:"mqtt"`
+	User                   string      `json:"rabbitmq_user"`
+	Password               string
+	Domain                 string           `json:"rabbitmq_domain"`
+	Port                   string           `json:"rabbitmq_port"`
+	QueueName              string           `json:"rabbitmq_queue_name"`
+	Store                  string           `json:"store"`
+	TimescaleDB            PostgresConfig   `json:"timescaledb"`
+	TimescaleDBPolicies    PoliciesConfig   `json:"timescaledb_policies"`
+	Postgres               PostgresConfig   `json:"postgres"`
+	SQLite                 SQLiteConfig     `json:"sqlite"`
+	Archive                ArchiveConfig    `json:"archive"`
+	Influx                 InfluxConfig     `json:"influx"`
+	Webhook                WebhookConfig    `json:"webhook"`
+	Republish              RepublishConfig  `json:"republish"`
+	Consumer               ConsumerConfig   `json:"consumer"`
+	Writer                 WriterConfig     `json:"writer"`
+	Validation             ValidationConfig `json:"validation"`
+	ClockSkew              ClockSkewConfig  `json:"clock_skew"`
+	TimeSync               TimeSyncConfig   `json:"time_sync"`
+	MetricsAddress         string           `json:"metrics_address"`
+	ShutdownTimeoutSeconds int              `json:"shutdown_timeout_seconds"`
+	Log                    logger.Config    `json:"log"`
 }
 
-type TimescaleDBConfig struct {
+type WriterConfig struct {
+	MaxRows         int `json:"max_rows"`
+	FlushIntervalMs int `json:"flush_interval_ms"`
+}
+
+// ValidationConfig sets the rules sensor data is checked against. With Mode
+// "reject" readings failing a rule are rejected to the dead-letter queue of
+// the broker, with "flag" they are stored with the failed rules in their
+// quality column and with "off" nothing is checked. Timestamps further than
+// the skews from the server time fail, zero disables a skew. Ranges bound
+// the values of each metric, humidity and temperature.
+type ValidationConfig struct {
+	Mode                 string                 `json:"mode"`
+	RequireSensorID      bool                   `json:"require_sensor_id"`
+	RequireTimestamp     bool                   `json:"require_timestamp"`
+	MaxPastSkewSeconds   int                    `json:"max_past_skew_seconds"`
+	MaxFutureSkewSeconds int                    `json:"max_future_skew_seconds"`
+	Ranges               map[string]RangeConfig `json:"ranges"`
+}
+
+// ClockSkewConfig sets how the clock of every device is compared with the
+// time its messages reach the broker. With Mode "flag" readings of a device
+// whose clock is further than ThresholdSeconds from the arrival time are
+// flagged with clock_skew, with "correct" their timestamps are shifted by the
+// skew and flagged with timestamp_corrected, and with "off" devices are not
+// tracked. The skew is the smallest difference over the last Window
+// messages of a device, trusted once MinSamples were received.
+type ClockSkewConfig struct {
+	Mode             string `json:"mode"`
+	ThresholdSeconds int    `json:"threshold_seconds"`
+	Window           int    `json:"window"`
+	MinSamples       int    `json:"min_samples"`
+}
+
+// TimeSyncConfig enables answering the time requests of devices, so they can
+// correct their clock. Requests are consumed from QueueName, a queue bound by
+// the RabbitMQ definitions, or with the MQTT broker the shared subscription
+// group of RequestTopic. Responses are published to Exchange, unused with
+// MQTT, with ResponseTopic as routing key where {device_id} is replaced by
+// the device ID.
+type TimeSyncConfig struct {
+	Enabled       bool   `json:"enabled"`
+	QueueName     string `json:"queue_name"`
+	RequestTopic  string `json:"request_topic"`
+	Exchange      string `json:"exchange"`
+	ResponseTopic string `json:"response_topic"`
+}
+
+// RangeConfig bounds the values of a metric. A missing bound is open.
+type RangeConfig struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// NATSConfig is used when Broker is "nats". The queue name is used as the
+// durable consumer name.
+type NATSConfig struct {
+	URL               string   `json:"url"`
+	Stream            string   `json:"stream"`
+	Subjects          []string `json:"subjects"`
+	FilterSubject     string   `json:"filter_subject"`
+	MaxDeliver        int      `json:"max_deliver"`
+	DeadLetterSubject string   `json:"dead_letter_subject"`
+}
+
+// KafkaConfig is used when Broker is "kafka". The queue name is used as the
+// consumer group.
+type KafkaConfig struct {
+	Brokers         []string `json:"brokers"`
+	Topic           string   `json:"topic"`
+	Partitions      int      `json:"partitions"`
+	MaxRetries      int      `json:"max_retries"`
+	DeadLetterTopic string   `json:"dead_letter_topic"`
+}
+
+// MQTTConfig is used when Broker is "mqtt". The queue name is used as the
+// shared subscription group.
+type MQTTConfig struct {
+	URL             string `json:"url"`
+	User            string `json:"user"`
+	Password        string
+	Topic           string `json:"topic"`
+	ClientID        string `json:"client_id"`
+	CleanSession    bool   `json:"clean_session"`
+	QoS             int    `json:"qos"`
+	MaxRetries      int    `json:"max_retries"`
+	DeadLetterTopic string `json:"dead_letter_topic"`
+}
+
+type ConsumerConfig struct {
+	PrefetchCount  int  `json:"prefetch_count"`
+	Workers        int  `json:"workers"`
+	OrderByDevice  bool `json:"order_by_device"`
+	BatchSize      int  `json:"batch_size"`
+	BatchTimeoutMs int  `json:"batch_timeout_ms"`
+}
+
+// PostgresConfig is the connection to TimescaleDB when Store is
+// "timescaledb", or to plain PostgreSQL when Store is "postgres".
+type PostgresConfig struct {
 	Host     string `json:"host"`
 	Port     string `json:"port"`
 	User     string `json:"user"`
@@ -30,6 +152,79 @@ type TimescaleDBConfig struct {
 	SSLMode  string `json:"ssl_mode"`
 }
 
+// PoliciesConfig sets the TimescaleDB policies of sensor_data on startup.
+// Intervals are PostgreSQL intervals such as "7 days", empty ones keep the
+// policies created by the migrations. With Verify set the worker refuses to
+// start when a continuous aggregate or policy is missing.
+type PoliciesConfig struct {
+	CompressAfter string `json:"compress_after"`
+	Retention     string `json:"retention"`
+	Verify        bool   `json:"verify"`
+}
+
+// SQLiteConfig is used when Store is "sqlite".
+type SQLiteConfig struct {
+	Path string `json:"path"`
+}
+
+// SinkConfig is the routing policy of a destination besides the store.
+// Readings are only routed to it when their device matches one of Devices,
+// path.Match patterns, or always when Devices is empty. Messages are
+// acknowledged once every required destination stored them; failures of the
+// others are only logged and counted. MaxRows and FlushIntervalMs override
+// the writer settings for the destination.
+type SinkConfig struct {
+	Required        bool     `json:"required"`
+	Devices         []string `json:"devices"`
+	MaxRows         int      `json:"max_rows"`
+	FlushIntervalMs int      `json:"flush_interval_ms"`
+}
+
+// ArchiveConfig enables a second destination that writes sensor data to
+// Parquet files partitioned by device and hour.
+type ArchiveConfig struct {
+	SinkConfig
+	Enabled                   bool   `json:"enabled"`
+	Dir                       string `json:"dir"`
+	MaxRowsPerFile            int    `json:"max_rows_per_file"`
+	CompactionIntervalSeconds int    `json:"compaction_interval_seconds"`
+}
+
+// InfluxConfig enables a second destination that writes sensor data in
+// InfluxDB line protocol, to the /api/v2/write endpoint when Output is "http"
+// or appended to Path when Output is "file".
+type InfluxConfig struct {
+	SinkConfig
+	Enabled    bool   `json:"enabled"`
+	Output     string `json:"output"`
+	URL        string `json:"url"`
+	Org        string `json:"org"`
+	Bucket     string `json:"bucket"`
+	Token      string
+	Path       string `json:"path"`
+	Gzip       bool   `json:"gzip"`
+	MaxRetries int    `json:"max_retries"`
+}
+
+// WebhookConfig enables a destination that posts every batch of readings as
+// a JSON array to URL.
+type WebhookConfig struct {
+	SinkConfig
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	Token   string
+}
+
+// RepublishConfig enables a destination that publishes every batch of
+// readings as a JSON array to Exchange with RoutingKey on the broker the
+// worker consumes from.
+type RepublishConfig struct {
+	SinkConfig
+	Enabled    bool   `json:"enabled"`
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+}
+
 var DEFAULT_SECRET_PATH = getStringEnv("DEFAULT_SECRET_PATH", "/run/secrets/")
 
 func NewConfig() *Config {
@@ -39,31 +234,188 @@ func NewConfig() *Config {
 		return nil
 	}
 
-	password, err := getFromSecret("RABBITMQ_DATA_WORKER_PASSWORD")
+	brokerType := getStringEnv("BROKER", fileConfig.Broker)
 
-	if err != nil {
-		log.Fatalf("Failed to read secret RABBITMQ_DATA_WORKER_PASSWORD: %v", err)
+	var password string
+	if brokerType == "rabbitmq" {
+		password, err = getFromSecret("RABBITMQ_DATA_WORKER_PASSWORD")
+		if err != nil {
+			log.Fatalf("Failed to read secret RABBITMQ_DATA_WORKER_PASSWORD: %v", err)
+		}
 	}
 
-	dbPassword, err := getFromSecret("TIMESCALEDB_PASSWORD")
-	if err != nil {
-		log.Fatalf("Failed to read secret TIMESCALEDB_PASSWORD: %v", err)
+	// MQTT brokers often allow anonymous clients, so the password is optional.
+	var mqttPassword string
+	if brokerType == "mqtt" {
+		mqttPassword, err = getFromSecret("MQTT_DATA_WORKER_PASSWORD")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Failed to read secret MQTT_DATA_WORKER_PASSWORD: %v", err)
+		}
+	}
+
+	store := getStringEnv("STORE", fileConfig.Store)
+
+	var timescaleDBPassword string
+	if store == "timescaledb" {
+		timescaleDBPassword, err = getFromSecret("TIMESCALEDB_PASSWORD")
+		if err != nil {
+			log.Fatalf("Failed to read secret TIMESCALEDB_PASSWORD: %v", err)
+		}
+	}
+
+	var postgresPassword string
+	if store == "postgres" {
+		postgresPassword, err = getFromSecret("POSTGRES_PASSWORD")
+		if err != nil {
+			log.Fatalf("Failed to read secret POSTGRES_PASSWORD: %v", err)
+		}
+	}
+
+	influxEnabled := getBoolEnv("INFLUX_ENABLED", fileConfig.Influx.Enabled)
+	influxOutput := getStringEnv("INFLUX_OUTPUT", fileConfig.Influx.Output)
+
+	// InfluxDB 1.x compatible endpoints may not require a token.
+	var influxToken string
+	if influxEnabled && influxOutput == "http" {
+		influxToken, err = getFromSecret("INFLUX_TOKEN")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Failed to read secret INFLUX_TOKEN: %v", err)
+		}
+	}
+
+	webhookEnabled := getBoolEnv("WEBHOOK_ENABLED", fileConfig.Webhook.Enabled)
+
+	var webhookToken string
+	if webhookEnabled {
+		webhookToken, err = getFromSecret("WEBHOOK_TOKEN")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Failed to read secret WEBHOOK_TOKEN: %v", err)
+		}
 	}
 
 	return &Config{
+		Broker: brokerType,
+		NATS: NATSConfig{
+			URL:               getStringEnv("NATS_URL", fileConfig.NATS.URL),
+			Stream:            getStringEnv("NATS_STREAM", fileConfig.NATS.Stream),
+			Subjects:          getListEnv("NATS_SUBJECTS", fileConfig.NATS.Subjects),
+			FilterSubject:     getStringEnv("NATS_FILTER_SUBJECT", fileConfig.NATS.FilterSubject),
+			MaxDeliver:        getIntEnv("NATS_MAX_DELIVER", fileConfig.NATS.MaxDeliver),
+			DeadLetterSubject: getStringEnv("NATS_DEAD_LETTER_SUBJECT", fileConfig.NATS.DeadLetterSubject),
+		},
+		Kafka: KafkaConfig{
+			Brokers:         getListEnv("KAFKA_BROKERS", fileConfig.Kafka.Brokers),
+			Topic:           getStringEnv("KAFKA_TOPIC", fileConfig.Kafka.Topic),
+			Partitions:      getIntEnv("KAFKA_PARTITIONS", fileConfig.Kafka.Partitions),
+			MaxRetries:      getIntEnv("KAFKA_MAX_RETRIES", fileConfig.Kafka.MaxRetries),
+			DeadLetterTopic: getStringEnv("KAFKA_DEAD_LETTER_TOPIC", fileConfig.Kafka.DeadLetterTopic),
+		},
+		MQTT: MQTTConfig{
+			URL:             getStringEnv("MQTT_URL", fileConfig.MQTT.URL),
+			User:            getStringEnv("MQTT_USER", fileConfig.MQTT.User),
+			Password:        mqttPassword,
+			Topic:           getStringEnv("MQTT_TOPIC", fileConfig.MQTT.Topic),
+			ClientID:        getStringEnv("MQTT_CLIENT_ID", fileConfig.MQTT.ClientID),
+			CleanSession:    getBoolEnv("MQTT_CLEAN_SESSION", fileConfig.MQTT.CleanSession),
+			QoS:             getIntEnv("MQTT_QOS", fileConfig.MQTT.QoS),
+			MaxRetries:      getIntEnv("MQTT_MAX_RETRIES", fileConfig.MQTT.MaxRetries),
+			DeadLetterTopic: getStringEnv("MQTT_DEAD_LETTER_TOPIC", fileConfig.MQTT.DeadLetterTopic),
+		},
 		User:      getStringEnv("RABBITMQ_DATA_WORKER_USER", fileConfig.User),
 		Password:  password,
 		Domain:    getStringEnv("RABBITMQ_DOMAIN", fileConfig.Domain),
 		Port:      getStringEnv("RABBITMQ_AMQP_PORT", fileConfig.Port),
 		QueueName: getStringEnv("RABBITMQ_DATA_WORKER_QUEUE_NAME", fileConfig.QueueName),
-		TimescaleDB: TimescaleDBConfig{
+		Store:     store,
+		TimescaleDB: PostgresConfig{
 			Host:     getStringEnv("TIMESCALEDB_HOST", fileConfig.TimescaleDB.Host),
 			Port:     getStringEnv("TIMESCALEDB_PORT", fileConfig.TimescaleDB.Port),
 			User:     getStringEnv("TIMESCALEDB_USER", fileConfig.TimescaleDB.User),
-			Password: dbPassword,
+			Password: timescaleDBPassword,
 			Database: getStringEnv("TIMESCALEDB_DATABASE", fileConfig.TimescaleDB.Database),
 			SSLMode:  getStringEnv("TIMESCALEDB_SSL_MODE", fileConfig.TimescaleDB.SSLMode),
 		},
+		TimescaleDBPolicies: PoliciesConfig{
+			CompressAfter: getStringEnv("TIMESCALEDB_COMPRESS_AFTER", fileConfig.TimescaleDBPolicies.CompressAfter),
+			Retention:     getStringEnv("TIMESCALEDB_RETENTION", fileConfig.TimescaleDBPolicies.Retention),
+			Verify:        getBoolEnv("TIMESCALEDB_VERIFY_POLICIES", fileConfig.TimescaleDBPolicies.Verify),
+		},
+		Postgres: PostgresConfig{
+			Host:     getStringEnv("POSTGRES_HOST", fileConfig.Postgres.Host),
+			Port:     getStringEnv("POSTGRES_PORT", fileConfig.Postgres.Port),
+			User:     getStringEnv("POSTGRES_USER", fileConfig.Postgres.User),
+			Password: postgresPassword,
+			Database: getStringEnv("POSTGRES_DATABASE", fileConfig.Postgres.Database),
+			SSLMode:  getStringEnv("POSTGRES_SSL_MODE", fileConfig.Postgres.SSLMode),
+		},
+		SQLite: SQLiteConfig{
+			Path: getStringEnv("SQLITE_PATH", fileConfig.SQLite.Path),
+		},
+		Archive: ArchiveConfig{
+			SinkConfig:                getSinkEnv("ARCHIVE", fileConfig.Archive.SinkConfig),
+			Enabled:                   getBoolEnv("ARCHIVE_ENABLED", fileConfig.Archive.Enabled),
+			Dir:                       getStringEnv("ARCHIVE_DIR", fileConfig.Archive.Dir),
+			MaxRowsPerFile:            getIntEnv("ARCHIVE_MAX_ROWS_PER_FILE", fileConfig.Archive.MaxRowsPerFile),
+			CompactionIntervalSeconds: getIntEnv("ARCHIVE_COMPACTION_INTERVAL_SECONDS", fileConfig.Archive.CompactionIntervalSeconds),
+		},
+		Influx: InfluxConfig{
+			SinkConfig: getSinkEnv("INFLUX", fileConfig.Influx.SinkConfig),
+			Enabled:    influxEnabled,
+			Output:     influxOutput,
+			URL:        getStringEnv("INFLUX_URL", fileConfig.Influx.URL),
+			Org:        getStringEnv("INFLUX_ORG", fileConfig.Influx.Org),
+			Bucket:     getStringEnv("INFLUX_BUCKET", fileConfig.Influx.Bucket),
+			Token:      influxToken,
+			Path:       getStringEnv("INFLUX_PATH", fileConfig.Influx.Path),
+			Gzip:       getBoolEnv("INFLUX_GZIP", fileConfig.Influx.Gzip),
+			MaxRetries: getIntEnv("INFLUX_MAX_RETRIES", fileConfig.Influx.MaxRetries),
+		},
+		Webhook: WebhookConfig{
+			SinkConfig: getSinkEnv("WEBHOOK", fileConfig.Webhook.SinkConfig),
+			Enabled:    webhookEnabled,
+			URL:        getStringEnv("WEBHOOK_URL", fileConfig.Webhook.URL),
+			Token:      webhookToken,
+		},
+		Republish: RepublishConfig{
+			SinkConfig: getSinkEnv("REPUBLISH", fileConfig.Republish.SinkConfig),
+			Enabled:    getBoolEnv("REPUBLISH_ENABLED", fileConfig.Republish.Enabled),
+			Exchange:   getStringEnv("REPUBLISH_EXCHANGE", fileConfig.Republish.Exchange),
+			RoutingKey: getStringEnv("REPUBLISH_ROUTING_KEY", fileConfig.Republish.RoutingKey),
+		},
+		Consumer: ConsumerConfig{
+			PrefetchCount:  getIntEnv("CONSUMER_PREFETCH_COUNT", fileConfig.Consumer.PrefetchCount),
+			Workers:        getIntEnv("CONSUMER_WORKERS", fileConfig.Consumer.Workers),
+			OrderByDevice:  getBoolEnv("CONSUMER_ORDER_BY_DEVICE", fileConfig.Consumer.OrderByDevice),
+			BatchSize:      getIntEnv("CONSUMER_BATCH_SIZE", fileConfig.Consumer.BatchSize),
+			BatchTimeoutMs: getIntEnv("CONSUMER_BATCH_TIMEOUT_MS", fileConfig.Consumer.BatchTimeoutMs),
+		},
+		Writer: WriterConfig{
+			MaxRows:         getIntEnv("WRITER_MAX_ROWS", fileConfig.Writer.MaxRows),
+			FlushIntervalMs: getIntEnv("WRITER_FLUSH_INTERVAL_MS", fileConfig.Writer.FlushIntervalMs),
+		},
+		Validation: ValidationConfig{
+			Mode:                 getStringEnv("VALIDATION_MODE", fileConfig.Validation.Mode),
+			RequireSensorID:      getBoolEnv("VALIDATION_REQUIRE_SENSOR_ID", fileConfig.Validation.RequireSensorID),
+			RequireTimestamp:     getBoolEnv("VALIDATION_REQUIRE_TIMESTAMP", fileConfig.Validation.RequireTimestamp),
+			MaxPastSkewSeconds:   getIntEnv("VALIDATION_MAX_PAST_SKEW_SECONDS", fileConfig.Validation.MaxPastSkewSeconds),
+			MaxFutureSkewSeconds: getIntEnv("VALIDATION_MAX_FUTURE_SKEW_SECONDS", fileConfig.Validation.MaxFutureSkewSeconds),
+			Ranges:               fileConfig.Validation.Ranges,
+		},
+		ClockSkew: ClockSkewConfig{
+			Mode:             getStringEnv("CLOCK_SKEW_MODE", fileConfig.ClockSkew.Mode),
+			ThresholdSeconds: getIntEnv("CLOCK_SKEW_THRESHOLD_SECONDS", fileConfig.ClockSkew.ThresholdSeconds),
+			Window:           getIntEnv("CLOCK_SKEW_WINDOW", fileConfig.ClockSkew.Window),
+			MinSamples:       getIntEnv("CLOCK_SKEW_MIN_SAMPLES", fileConfig.ClockSkew.MinSamples),
+		},
+		TimeSync: TimeSyncConfig{
+			Enabled:       getBoolEnv("TIME_SYNC_ENABLED", fileConfig.TimeSync.Enabled),
+			QueueName:     getStringEnv("RABBITMQ_TIME_SYNC_QUEUE_NAME", fileConfig.TimeSync.QueueName),
+			RequestTopic:  getStringEnv("TIME_SYNC_REQUEST_TOPIC", fileConfig.TimeSync.RequestTopic),
+			Exchange:      getStringEnv("TIME_SYNC_EXCHANGE", fileConfig.TimeSync.Exchange),
+			ResponseTopic: getStringEnv("TIME_SYNC_RESPONSE_TOPIC", fileConfig.TimeSync.ResponseTopic),
+		},
+		MetricsAddress:         getStringEnv("METRICS_ADDRESS", fileConfig.MetricsAddress),
+		ShutdownTimeoutSeconds: getIntEnv("SHUTDOWN_TIMEOUT_SECONDS", fileConfig.ShutdownTimeoutSeconds),
 		Log: logger.Config{
 			Level: getStringEnv("LOG_LEVEL", "debug"),
 			Source: logger.SourceConfig{
@@ -81,12 +433,107 @@ func getFromFile(path string) (*Config, error) {
 		return nil, err
 	}
 
-	var configData Config
+	configData := Config{
+		Broker: "rabbitmq",
+		Store:  "timescaledb",
+		TimescaleDBPolicies: PoliciesConfig{
+			CompressAfter: "7 days",
+			Retention:     "365 days",
+			Verify:        true,
+		},
+		SQLite: SQLiteConfig{
+			Path: "sensor_data.db",
+		},
+		Archive: ArchiveConfig{
+			SinkConfig:                SinkConfig{Required: true},
+			Dir:                       "archive",
+			MaxRowsPerFile:            100000,
+			CompactionIntervalSeconds: 600,
+		},
+		Influx: InfluxConfig{
+			Output:     "http",
+			URL:        "http://influxdb:8086",
+			Path:       "sensor_data.lp",
+			Gzip:       true,
+			MaxRetries: 3,
+		},
+		Republish: RepublishConfig{
+			RoutingKey: "iot.sensor.readings",
+		},
+		NATS: NATSConfig{
+			URL:           "nats://nats:4222",
+			Stream:        "IOT",
+			Subjects:      []string{"iot.device.>"},
+			FilterSubject: "iot.device.data.binary",
+		},
+		Kafka: KafkaConfig{
+			Brokers:    []string{"kafka:9092"},
+			Topic:      "iot.device.data.binary",
+			MaxRetries: -1,
+		},
+		MQTT: MQTTConfig{
+			URL:          "tcp://mosquitto:1883",
+			Topic:        "iot.device.data.binary",
+			CleanSession: true,
+			QoS:          1,
+			MaxRetries:   -1,
+		},
+		Consumer: ConsumerConfig{
+			PrefetchCount:  1,
+			Workers:        1,
+			BatchSize:      1,
+			BatchTimeoutMs: 1000,
+		},
+		Writer: WriterConfig{
+			MaxRows:         500,
+			FlushIntervalMs: 200,
+		},
+		Validation: ValidationConfig{
+			Mode:                 "flag",
+			RequireSensorID:      true,
+			MaxPastSkewSeconds:   7 * 24 * 60 * 60,
+			MaxFutureSkewSeconds: 300,
+			Ranges: map[string]RangeConfig{
+				"humidity":    {Min: float64Pointer(0), Max: float64Pointer(100)},
+				"temperature": {Min: float64Pointer(-40), Max: float64Pointer(85)},
+			},
+		},
+		ClockSkew: ClockSkewConfig{
+			Mode:             "flag",
+			ThresholdSeconds: 60,
+			Window:           20,
+			MinSamples:       5,
+		},
+		TimeSync: TimeSyncConfig{
+			QueueName:     "iot.device.time.request",
+			RequestTopic:  "iot.device.time.request",
+			Exchange:      "amq.topic",
+			ResponseTopic: "iot.device.time.response.{device_id}",
+		},
+		MetricsAddress:         ":2113",
+		ShutdownTimeoutSeconds: 20,
+	}
 	json.Unmarshal(config, &configData)
 
 	return &configData, nil
 }
 
+// getSinkEnv overrides the routing policy of a destination with the
+// <PREFIX>_REQUIRED, <PREFIX>_DEVICES, <PREFIX>_MAX_ROWS and
+// <PREFIX>_FLUSH_INTERVAL_MS variables.
+func getSinkEnv(prefix string, sink SinkConfig) SinkConfig {
+	return SinkConfig{
+		Required:        getBoolEnv(prefix+"_REQUIRED", sink.Required),
+		Devices:         getListEnv(prefix+"_DEVICES", sink.Devices),
+		MaxRows:         getIntEnv(prefix+"_MAX_ROWS", sink.MaxRows),
+		FlushIntervalMs: getIntEnv(prefix+"_FLUSH_INTERVAL_MS", sink.FlushIntervalMs),
+	}
+}
+
+func float64Pointer(v float64) *float64 {
+	return &v
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	value := os.Getenv(key)
 	if value == "" {
@@ -95,6 +542,28 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	return value == "true"
 }
 
+func getIntEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+// getListEnv reads a comma-separated list.
+func getListEnv(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return strings.Split(value, ",")
+}
+
 func getStringEnv(key string, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -120,7 +589,27 @@ func getFromSecret(name string) (string, error) {
 	return string(content), nil
 }
 
-func (c *TimescaleDBConfig) ConnectionString() string {
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+}
+
+func (c *ValidationConfig) MaxPastSkew() time.Duration {
+	return time.Duration(c.MaxPastSkewSeconds) * time.Second
+}
+
+func (c *ValidationConfig) MaxFutureSkew() time.Duration {
+	return time.Duration(c.MaxFutureSkewSeconds) * time.Second
+}
+
+func (c *ClockSkewConfig) Threshold() time.Duration {
+	return time.Duration(c.ThresholdSeconds) * time.Second
+}
+
+func (c *ArchiveConfig) CompactionInterval() time.Duration {
+	return time.Duration(c.CompactionIntervalSeconds) * time.Second
+}
+
+func (c *PostgresConfig) ConnectionString() string {
 	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		c.Host,
@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -10,9 +11,24 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+const (
+	migrationsSource         = "file://migrations"
+	postgresMigrationsSource = "file://migrations/postgres"
+)
+
+// goodReadingsAggregatesVersion is the TimescaleDB migration recreating the
+// continuous aggregates over the readings with quality 0, empty.
+const goodReadingsAggregatesVersion = 12
+
+// TimestampPrecision is the precision of the time column, that of
+// TIMESTAMPTZ.
+const TimestampPrecision = time.Microsecond
+
+// Database stores sensor data in TimescaleDB or, without the extension, in
+// plain PostgreSQL.
 type Database struct {
 	db *sql.DB
 }
@@ -22,9 +38,27 @@ type SensorData struct {
 	Timestamp   time.Time
 	Humidity    float32
 	Temperature float32
+	Quality     Quality
+	// MessageID identifies the message the row came from. Rows are unique on
+	// device, time and message ID, so a redelivered message is skipped while
+	// distinct readings of a device at the same time are all stored.
+	MessageID string
 }
 
+// NewDatabase connects to TimescaleDB and runs its migrations, which store
+// sensor_data in a hypertable.
 func NewDatabase(connectionString string) (*Database, error) {
+	return openDatabase(connectionString, migrationsSource)
+}
+
+// NewPostgres connects to a PostgreSQL server without the TimescaleDB
+// extension and runs the migrations in migrations/postgres, which store
+// sensor_data in a regular table.
+func NewPostgres(connectionString string) (*Database, error) {
+	return openDatabase(connectionString, postgresMigrationsSource)
+}
+
+func openDatabase(connectionString string, migrationsSource string) (*Database, error) {
 	db, err := sql.Open("postgres", connectionString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -42,7 +76,7 @@ func NewDatabase(connectionString string) (*Database, error) {
 
 	database := &Database{db: db}
 
-	if err := database.runMigrations(connectionString); err != nil {
+	if err := database.runMigrations(connectionString, migrationsSource); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
@@ -50,14 +84,14 @@ func NewDatabase(connectionString string) (*Database, error) {
 	return database, nil
 }
 
-func (d *Database) runMigrations(connectionString string) error {
+func (d *Database) runMigrations(connectionString string, migrationsSource string) error {
 	migrationURL, err := convertToMigrationURL(connectionString)
 	if err != nil {
 		return fmt.Errorf("failed to convert connection string: %w", err)
 	}
 
 	m, err := migrate.New(
-		"file://migrations",
+		migrationsSource,
 		migrationURL,
 	)
 	if err != nil {
@@ -65,10 +99,39 @@ func (d *Database) runMigrations(connectionString string) error {
 	}
 	defer m.Close()
 
+	before, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("failed to read migration version: %w", err)
+	}
+
 	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	after, _, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return fmt.Errorf("failed to read migration version: %w", err)
+	}
+
+	if before < goodReadingsAggregatesVersion && after >= goodReadingsAggregatesVersion {
+		if err := d.refreshContinuousAggregates(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// refreshContinuousAggregates materializes every bucket of the continuous
+// aggregates from the raw rows. Refreshes cannot run inside a transaction,
+// so the migration recreating them cannot fill them itself.
+func (d *Database) refreshContinuousAggregates() error {
+	for _, view := range ContinuousAggregates {
+		if _, err := d.db.Exec(fmt.Sprintf(`CALL refresh_continuous_aggregate(%s, NULL, NULL)`, pq.QuoteLiteral(view))); err != nil {
+			return fmt.Errorf("failed to refresh %s: %w", view, err)
+		}
+	}
+
 	return nil
 }
 
@@ -116,15 +179,160 @@ func convertToMigrationURL(connectionString string) (string, error) {
 	return u.String(), nil
 }
 
+// InsertSensorData stores a single row. A row with the same device, time and
+// message ID as an existing one is a redelivery and is silently skipped.
 func (d *Database) InsertSensorData(data SensorData) error {
-	query := `INSERT INTO sensor_data (time, device_id, humidity, temperature) VALUES ($1, $2, $3, $4)`
-	_, err := d.db.Exec(query, data.Timestamp, data.DeviceID, data.Humidity, data.Temperature)
+	query := `INSERT INTO sensor_data (time, device_id, humidity, temperature, quality, message_id) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (device_id, time, message_id) DO NOTHING`
+	_, err := d.db.Exec(query, data.Timestamp, data.DeviceID, data.Humidity, data.Temperature, data.Quality, data.MessageID)
 	if err != nil {
 		return fmt.Errorf("failed to insert sensor data: %w", err)
 	}
 	return nil
 }
 
+// InsertSensorDataBatch inserts all rows with a single multi-row INSERT, so
+// either every row is stored or none is. Duplicates are skipped and the
+// number of rows actually inserted is returned.
+func (d *Database) InsertSensorDataBatch(data []SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	var query strings.Builder
+	query.WriteString(`INSERT INTO sensor_data (time, device_id, humidity, temperature, quality, message_id) VALUES `)
+
+	args := make([]any, 0, len(data)*6)
+
+	for i, row := range data {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+
+		n := i * 6
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
+
+		args = append(args, row.Timestamp, row.DeviceID, row.Humidity, row.Temperature, row.Quality, row.MessageID)
+	}
+
+	query.WriteString(` ON CONFLICT (device_id, time, message_id) DO NOTHING`)
+
+	result, err := d.db.Exec(query.String(), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert sensor data batch: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read inserted rows: %w", err)
+	}
+
+	return inserted, nil
+}
+
+// CopySensorData stores all rows in a single transaction using COPY FROM
+// STDIN, which is considerably faster than INSERT for large batches. COPY
+// cannot skip conflicting rows, so the batch is copied into a temporary
+// staging table and moved into sensor_data with ON CONFLICT DO NOTHING. The
+// number of rows actually inserted is returned; the rest were duplicates.
+func (d *Database) CopySensorData(data []SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`CREATE TEMP TABLE sensor_data_staging (LIKE sensor_data INCLUDING DEFAULTS) ON COMMIT DROP`); err != nil {
+		return 0, fmt.Errorf("failed to create staging table: %w", err)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("sensor_data_staging", "time", "device_id", "humidity", "temperature", "quality", "message_id"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare copy statement: %w", err)
+	}
+
+	for _, row := range data {
+		if _, err := stmt.Exec(row.Timestamp, row.DeviceID, row.Humidity, row.Temperature, row.Quality, row.MessageID); err != nil {
+			stmt.Close()
+			return 0, fmt.Errorf("failed to copy sensor data: %w", err)
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return 0, fmt.Errorf("failed to flush copy statement: %w", err)
+	}
+
+	if err := stmt.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close copy statement: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO sensor_data (time, device_id, humidity, temperature, quality, message_id)
+		SELECT time, device_id, humidity, temperature, quality, message_id FROM sensor_data_staging
+		ON CONFLICT (device_id, time, message_id) DO NOTHING`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move staged sensor data: %w", err)
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read inserted rows: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit sensor data: %w", err)
+	}
+
+	return inserted, nil
+}
+
+func (d *Database) ListSensorData(deviceID string, from, to time.Time) ([]SensorData, error) {
+	rows, err := d.db.Query(
+		`SELECT time, device_id, humidity, temperature, quality FROM sensor_data WHERE device_id = $1 AND time >= $2 AND time < $3 ORDER BY time`,
+		deviceID, from, to,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor data: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSensorData(rows)
+}
+
+func (d *Database) CountSensorData() (int64, error) {
+	var count int64
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM sensor_data`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count sensor data: %w", err)
+	}
+	return count, nil
+}
+
+func scanSensorData(rows *sql.Rows) ([]SensorData, error) {
+	var data []SensorData
+
+	for rows.Next() {
+		var row SensorData
+		if err := rows.Scan(&row.Timestamp, &row.DeviceID, &row.Humidity, &row.Temperature, &row.Quality); err != nil {
+			return nil, fmt.Errorf("failed to scan sensor data: %w", err)
+		}
+		data = append(data, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sensor data: %w", err)
+	}
+
+	return data, nil
+}
+
+func (d *Database) Ping() error {
+	return d.db.Ping()
+}
+
 func (d *Database) Close() error {
 	return d.db.Close()
 }
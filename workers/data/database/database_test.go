@@ -0,0 +1,88 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// The benchmarks need a running TimescaleDB, e.g.
+// TIMESCALEDB_TEST_CONNECTION_STRING="host=localhost port=5432 user=postgres password=... dbname=iot_data sslmode=disable"
+func openTestDatabase(b *testing.B) *Database {
+	b.Helper()
+
+	connectionString := os.Getenv("TIMESCALEDB_TEST_CONNECTION_STRING")
+	if connectionString == "" {
+		b.Skip("TIMESCALEDB_TEST_CONNECTION_STRING is not set")
+	}
+
+	db, err := openDatabase(connectionString, "file://../migrations")
+	if err != nil {
+		b.Fatalf("failed to open database: %v", err)
+	}
+
+	b.Cleanup(func() {
+		db.db.Exec(`DELETE FROM sensor_data WHERE device_id LIKE 'bench-%'`)
+		db.Close()
+	})
+
+	return db
+}
+
+func benchmarkRows(batch, size int) []SensorData {
+	data := make([]SensorData, size)
+	base := time.Now()
+	for i := range data {
+		data[i] = SensorData{
+			DeviceID:    fmt.Sprintf("bench-%d", i%10),
+			Timestamp:   base.Add(time.Duration(batch*size+i) * time.Millisecond),
+			Humidity:    50,
+			Temperature: 25,
+		}
+	}
+	return data
+}
+
+func benchmarkWrite(b *testing.B, size int, write func(db *Database, data []SensorData) error) {
+	db := openTestDatabase(b)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		data := benchmarkRows(i, size)
+		b.StartTimer()
+
+		if err := write(db, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "rows/sec")
+}
+
+func BenchmarkInsertSensorData(b *testing.B) {
+	benchmarkWrite(b, 500, func(db *Database, data []SensorData) error {
+		for _, row := range data {
+			if err := db.InsertSensorData(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func BenchmarkInsertSensorDataBatch(b *testing.B) {
+	benchmarkWrite(b, 500, func(db *Database, data []SensorData) error {
+		_, err := db.InsertSensorDataBatch(data)
+		return err
+	})
+}
+
+func BenchmarkCopySensorData(b *testing.B) {
+	benchmarkWrite(b, 500, func(db *Database, data []SensorData) error {
+		_, err := db.CopySensorData(data)
+		return err
+	})
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ContinuousAggregates are the views the TimescaleDB migrations create over
+// sensor_data, with the min, max and average of every device per bucket.
+var ContinuousAggregates = []string{"sensor_data_1m", "sensor_data_1h", "sensor_data_1d"}
+
+// minRetention is the start of the widest refresh window of the continuous
+// aggregates. Raw rows must be kept at least that long, or a refresh would
+// erase buckets of dropped chunks.
+const minRetention = "3 days"
+
+// Policies are the intervals of the TimescaleDB background jobs of
+// sensor_data, as PostgreSQL intervals such as "7 days". Empty intervals keep
+// the ones set by the migrations.
+type Policies struct {
+	// CompressAfter is the age of the chunks that are compressed.
+	CompressAfter string
+	// Retention is the age of the chunks of raw rows that are dropped.
+	Retention string
+}
+
+// policyState is what VerifyPolicies finds in TimescaleDB.
+type policyState struct {
+	// aggregates maps the continuous aggregates of sensor_data to whether
+	// they have a refresh policy.
+	aggregates map[string]bool
+	// jobs are the policies of sensor_data, by procedure name.
+	jobs map[string]bool
+}
+
+// ApplyPolicies replaces the compression and retention policies of
+// sensor_data with the given intervals.
+func (d *Database) ApplyPolicies(policies Policies) error {
+	if policies.Retention != "" {
+		var longEnough bool
+		if err := d.db.QueryRow(`SELECT $1::interval >= $2::interval`, policies.Retention, minRetention).Scan(&longEnough); err != nil {
+			return fmt.Errorf("failed to parse retention %q: %w", policies.Retention, err)
+		}
+
+		if !longEnough {
+			return fmt.Errorf("retention %q is shorter than the %s refreshed by the continuous aggregates", policies.Retention, minRetention)
+		}
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if policies.CompressAfter != "" {
+		if _, err := tx.Exec(`SELECT remove_compression_policy('sensor_data', if_exists => TRUE)`); err != nil {
+			return fmt.Errorf("failed to remove compression policy: %w", err)
+		}
+
+		if _, err := tx.Exec(`SELECT add_compression_policy('sensor_data', $1::interval)`, policies.CompressAfter); err != nil {
+			return fmt.Errorf("failed to add compression policy: %w", err)
+		}
+	}
+
+	if policies.Retention != "" {
+		if _, err := tx.Exec(`SELECT remove_retention_policy('sensor_data', if_exists => TRUE)`); err != nil {
+			return fmt.Errorf("failed to remove retention policy: %w", err)
+		}
+
+		if _, err := tx.Exec(`SELECT add_retention_policy('sensor_data', $1::interval)`, policies.Retention); err != nil {
+			return fmt.Errorf("failed to add retention policy: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit policies: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyPolicies checks that the continuous aggregates, their refresh
+// policies and the compression and retention policies of sensor_data exist.
+func (d *Database) VerifyPolicies() error {
+	state := policyState{
+		aggregates: make(map[string]bool),
+		jobs:       make(map[string]bool),
+	}
+
+	rows, err := d.db.Query(`
+		SELECT ca.view_name, EXISTS (
+			SELECT 1 FROM timescaledb_information.jobs j
+			WHERE j.proc_name = 'policy_refresh_continuous_aggregate'
+				AND j.hypertable_schema = ca.materialization_hypertable_schema
+				AND j.hypertable_name = ca.materialization_hypertable_name
+		)
+		FROM timescaledb_information.continuous_aggregates ca
+		WHERE ca.hypertable_name = 'sensor_data'
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query continuous aggregates: %w", err)
+	}
+
+	for rows.Next() {
+		var view string
+		var refreshed bool
+		if err := rows.Scan(&view, &refreshed); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan continuous aggregate: %w", err)
+		}
+		state.aggregates[view] = refreshed
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query continuous aggregates: %w", err)
+	}
+
+	rows, err = d.db.Query(`SELECT proc_name FROM timescaledb_information.jobs WHERE hypertable_name = 'sensor_data'`)
+	if err != nil {
+		return fmt.Errorf("failed to query policies: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var procName string
+		if err := rows.Scan(&procName); err != nil {
+			return fmt.Errorf("failed to scan policy: %w", err)
+		}
+		state.jobs[procName] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query policies: %w", err)
+	}
+
+	if missing := state.missing(); len(missing) > 0 {
+		return fmt.Errorf("missing TimescaleDB policies: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (s policyState) missing() []string {
+	var missing []string
+
+	for _, view := range ContinuousAggregates {
+		refreshed, ok := s.aggregates[view]
+		switch {
+		case !ok:
+			missing = append(missing, "continuous aggregate "+view)
+		case !refreshed:
+			missing = append(missing, "refresh policy of "+view)
+		}
+	}
+
+	if !s.jobs["policy_compression"] {
+		missing = append(missing, "compression policy of sensor_data")
+	}
+
+	if !s.jobs["policy_retention"] {
+		missing = append(missing, "retention policy of sensor_data")
+	}
+
+	sort.Strings(missing)
+	return missing
+}
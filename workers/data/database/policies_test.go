@@ -0,0 +1,93 @@
+package database
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPolicyState_Missing(t *testing.T) {
+	allAggregates := map[string]bool{"sensor_data_1m": true, "sensor_data_1h": true, "sensor_data_1d": true}
+	allJobs := map[string]bool{"policy_compression": true, "policy_retention": true}
+
+	tests := []struct {
+		name  string
+		state policyState
+		want  []string
+	}{
+		{
+			name:  "complete",
+			state: policyState{aggregates: allAggregates, jobs: allJobs},
+		},
+		{
+			name: "missing aggregate and refresh policy",
+			state: policyState{
+				aggregates: map[string]bool{"sensor_data_1m": true, "sensor_data_1h": false},
+				jobs:       allJobs,
+			},
+			want: []string{"continuous aggregate sensor_data_1d", "refresh policy of sensor_data_1h"},
+		},
+		{
+			name:  "missing compression and retention",
+			state: policyState{aggregates: allAggregates, jobs: map[string]bool{}},
+			want:  []string{"compression policy of sensor_data", "retention policy of sensor_data"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.missing(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatabase_Policies(t *testing.T) {
+	connectionString := os.Getenv("TIMESCALEDB_TEST_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("TIMESCALEDB_TEST_CONNECTION_STRING is not set")
+	}
+
+	db, err := openDatabase(connectionString, "file://../migrations")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.VerifyPolicies(); err != nil {
+		t.Fatalf("VerifyPolicies() after migrations error = %v", err)
+	}
+
+	if err := db.ApplyPolicies(Policies{CompressAfter: "14 days", Retention: "1 day"}); err == nil {
+		t.Error("ApplyPolicies() accepted a retention shorter than the aggregate refresh window")
+	}
+
+	if err := db.ApplyPolicies(Policies{CompressAfter: "14 days", Retention: "30 days"}); err != nil {
+		t.Fatalf("ApplyPolicies() error = %v", err)
+	}
+
+	var compressAfter, dropAfter string
+	err = db.db.QueryRow(`
+		SELECT
+			max(config->>'compress_after') FILTER (WHERE proc_name = 'policy_compression'),
+			max(config->>'drop_after') FILTER (WHERE proc_name = 'policy_retention')
+		FROM timescaledb_information.jobs
+		WHERE hypertable_name = 'sensor_data'
+	`).Scan(&compressAfter, &dropAfter)
+	if err != nil {
+		t.Fatalf("failed to query policies: %v", err)
+	}
+
+	if compressAfter != "14 days" || dropAfter != "30 days" {
+		t.Errorf("policies = compress after %q, drop after %q, want 14 days and 30 days", compressAfter, dropAfter)
+	}
+
+	if err := db.VerifyPolicies(); err != nil {
+		t.Errorf("VerifyPolicies() after ApplyPolicies() error = %v", err)
+	}
+
+	if err := db.ApplyPolicies(Policies{CompressAfter: "7 days", Retention: "365 days"}); err != nil {
+		t.Errorf("failed to restore the default policies: %v", err)
+	}
+}
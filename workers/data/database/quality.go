@@ -0,0 +1,58 @@
+package database
+
+import "strings"
+
+// Quality flags the validation rules a stored reading failed, as a bitmask in
+// the quality column. Zero means the reading passed every rule.
+type Quality uint16
+
+const (
+	// QualityMissingSensorID is set when the reading has no sensor ID.
+	QualityMissingSensorID Quality = 1 << iota
+	// QualityMissingTimestamp is set when the reading has no timestamp and
+	// the time it was received was stored instead.
+	QualityMissingTimestamp
+	// QualityClockSkew is set when the timestamp is too far from the time
+	// the reading was received, or when the clock of the device is skewed.
+	QualityClockSkew
+	// QualityOutOfRange is set when a value is outside its physical range.
+	QualityOutOfRange
+	// QualityTimestampCorrected is set when the timestamp was shifted by the
+	// estimated skew of the device clock.
+	QualityTimestampCorrected
+)
+
+var qualityNames = []struct {
+	flag Quality
+	name string
+}{
+	{QualityMissingSensorID, "missing_sensor_id"},
+	{QualityMissingTimestamp, "missing_timestamp"},
+	{QualityClockSkew, "clock_skew"},
+	{QualityOutOfRange, "out_of_range"},
+	{QualityTimestampCorrected, "timestamp_corrected"},
+}
+
+// Good reports whether no flag is set.
+func (q Quality) Good() bool {
+	return q == 0
+}
+
+// Names returns the names of the flags that are set.
+func (q Quality) Names() []string {
+	var names []string
+	for _, n := range qualityNames {
+		if q&n.flag != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// String lists the flags that are set, separated by "|", or "good".
+func (q Quality) String() string {
+	if q.Good() {
+		return "good"
+	}
+	return strings.Join(q.Names(), "|")
+}
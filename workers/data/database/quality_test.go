@@ -0,0 +1,21 @@
+package database
+
+import "testing"
+
+func TestQuality_String(t *testing.T) {
+	tests := []struct {
+		quality Quality
+		want    string
+	}{
+		{0, "good"},
+		{QualityMissingTimestamp, "missing_timestamp"},
+		{QualityMissingSensorID | QualityOutOfRange, "missing_sensor_id|out_of_range"},
+		{QualityTimestampCorrected, "timestamp_corrected"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.quality.String(); got != tt.want {
+			t.Errorf("Quality(%d).String() = %q, want %q", tt.quality, got, tt.want)
+		}
+	}
+}
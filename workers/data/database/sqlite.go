@@ -0,0 +1,188 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS sensor_data (
+	time        TIMESTAMP NOT NULL,
+	device_id   TEXT      NOT NULL,
+	humidity    REAL,
+	temperature REAL,
+	quality     INTEGER   NOT NULL DEFAULT 0,
+	message_id  TEXT      NOT NULL DEFAULT '',
+	PRIMARY KEY (device_id, time, message_id)
+)`
+
+// sqliteQualityColumn adds the quality column to files created before it
+// existed. SQLite has no ADD COLUMN IF NOT EXISTS.
+const sqliteQualityColumn = `ALTER TABLE sensor_data ADD COLUMN quality INTEGER NOT NULL DEFAULT 0`
+
+// sqliteMessageIDMigration rebuilds the table of files created before the
+// message_id column existed, since SQLite cannot change a primary key.
+var sqliteMessageIDMigration = []string{
+	`ALTER TABLE sensor_data RENAME TO sensor_data_old`,
+	sqliteSchema,
+	`INSERT INTO sensor_data (time, device_id, humidity, temperature, quality) SELECT time, device_id, humidity, temperature, quality FROM sensor_data_old`,
+	`DROP TABLE sensor_data_old`,
+}
+
+// SQLite stores sensor data in a SQLite file, so the data worker can run
+// without TimescaleDB during local development. The table has the same
+// columns and uniqueness as sensor_data in TimescaleDB.
+type SQLite struct {
+	db *sql.DB
+}
+
+// NewSQLite opens the SQLite database at path, creating it and the
+// sensor_data table if needed. ":memory:" opens a private in-memory
+// database.
+func NewSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// SQLite allows a single writer at a time, and every connection to
+	// ":memory:" would get its own database.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sensor_data table: %w", err)
+	}
+
+	var hasQuality bool
+	if err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info('sensor_data') WHERE name = 'quality'`).Scan(&hasQuality); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read sensor_data columns: %w", err)
+	}
+
+	if !hasQuality {
+		if _, err := db.Exec(sqliteQualityColumn); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to add quality column: %w", err)
+		}
+	}
+
+	var hasMessageID bool
+	if err := db.QueryRow(`SELECT COUNT(*) > 0 FROM pragma_table_info('sensor_data') WHERE name = 'message_id'`).Scan(&hasMessageID); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read sensor_data columns: %w", err)
+	}
+
+	if !hasMessageID {
+		if err := migrateSQLite(db, sqliteMessageIDMigration); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to add message_id column: %w", err)
+		}
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+func migrateSQLite(db *sql.DB, statements []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// InsertSensorData stores a single row. A row with the same device, time and
+// message ID as an existing one is a redelivery and is silently skipped.
+func (s *SQLite) InsertSensorData(data SensorData) error {
+	if _, err := s.CopySensorData([]SensorData{data}); err != nil {
+		return fmt.Errorf("failed to insert sensor data: %w", err)
+	}
+	return nil
+}
+
+// InsertSensorDataBatch stores all rows in a single transaction, skipping
+// duplicates, and returns the number of rows actually inserted.
+func (s *SQLite) InsertSensorDataBatch(data []SensorData) (int64, error) {
+	return s.CopySensorData(data)
+}
+
+// CopySensorData stores all rows in a single transaction, skipping
+// duplicates, and returns the number of rows actually inserted.
+func (s *SQLite) CopySensorData(data []SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO sensor_data (time, device_id, humidity, temperature, quality, message_id) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (device_id, time, message_id) DO NOTHING`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare insert statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var inserted int64
+
+	for _, row := range data {
+		result, err := stmt.Exec(row.Timestamp.UTC(), row.DeviceID, row.Humidity, row.Temperature, row.Quality, row.MessageID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to insert sensor data: %w", err)
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("failed to read inserted rows: %w", err)
+		}
+
+		inserted += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit sensor data: %w", err)
+	}
+
+	return inserted, nil
+}
+
+func (s *SQLite) ListSensorData(deviceID string, from, to time.Time) ([]SensorData, error) {
+	rows, err := s.db.Query(
+		`SELECT time, device_id, humidity, temperature, quality FROM sensor_data WHERE device_id = ? AND time >= ? AND time < ? ORDER BY time`,
+		deviceID, from.UTC(), to.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sensor data: %w", err)
+	}
+	defer rows.Close()
+
+	return scanSensorData(rows)
+}
+
+func (s *SQLite) CountSensorData() (int64, error) {
+	var count int64
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM sensor_data`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count sensor data: %w", err)
+	}
+	return count, nil
+}
+
+func (s *SQLite) Ping() error {
+	return s.db.Ping()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,32 @@
+package database
+
+import "time"
+
+// SensorStore stores sensor data. It is implemented by *Database, for
+// TimescaleDB and plain PostgreSQL, by *SQLite and by *Memory, which all
+// pass the conformance tests in store_test.go.
+//
+// A store keeps a single row per device, time and message ID: rows that are
+// already stored are redeliveries and are skipped without an error.
+type SensorStore interface {
+	Copier
+
+	// InsertSensorData stores a single row.
+	InsertSensorData(data SensorData) error
+	// InsertSensorDataBatch stores all rows or none and returns the number
+	// of rows actually inserted.
+	InsertSensorDataBatch(data []SensorData) (int64, error)
+	// ListSensorData returns the rows of a device with from <= time < to,
+	// oldest first.
+	ListSensorData(deviceID string, from, to time.Time) ([]SensorData, error)
+	// CountSensorData returns the number of stored rows.
+	CountSensorData() (int64, error)
+	Ping() error
+	Close() error
+}
+
+var (
+	_ SensorStore = (*Database)(nil)
+	_ SensorStore = (*SQLite)(nil)
+	_ SensorStore = (*Memory)(nil)
+)
@@ -0,0 +1,420 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The TimescaleDB and PostgreSQL stores are only tested when a server is
+// available, e.g.
+// TIMESCALEDB_TEST_CONNECTION_STRING="host=localhost port=5432 user=postgres password=... dbname=iot_data sslmode=disable"
+// POSTGRES_TEST_CONNECTION_STRING="host=localhost port=5433 user=postgres password=... dbname=iot_data sslmode=disable"
+var stores = []struct {
+	name string
+	open func(t *testing.T) SensorStore
+}{
+	{
+		name: "memory",
+		open: func(t *testing.T) SensorStore { return NewMemory() },
+	},
+	{
+		name: "sqlite",
+		open: func(t *testing.T) SensorStore {
+			store, err := NewSQLite(filepath.Join(t.TempDir(), "sensor_data.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	},
+	{
+		name: "timescaledb",
+		open: func(t *testing.T) SensorStore {
+			return openTestServer(t, "TIMESCALEDB_TEST_CONNECTION_STRING", "file://../migrations")
+		},
+	},
+	{
+		name: "postgres",
+		open: func(t *testing.T) SensorStore {
+			return openTestServer(t, "POSTGRES_TEST_CONNECTION_STRING", "file://../migrations/postgres")
+		},
+	},
+}
+
+func openTestServer(t *testing.T, env, migrationsSource string) SensorStore {
+	t.Helper()
+
+	connectionString := os.Getenv(env)
+	if connectionString == "" {
+		t.Skipf("%s is not set", env)
+	}
+
+	db, err := openDatabase(connectionString, migrationsSource)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	// The conformance tests count every row, so they need an empty table.
+	if _, err := db.db.Exec(`DELETE FROM sensor_data WHERE device_id LIKE 'conformance-%'`); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int64
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM sensor_data`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count > 0 {
+		db.Close()
+		t.Skipf("sensor_data is not empty (%d rows)", count)
+	}
+
+	t.Cleanup(func() {
+		db.db.Exec(`DELETE FROM sensor_data WHERE device_id LIKE 'conformance-%'`)
+	})
+
+	return db
+}
+
+var conformanceBase = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func reading(device int, second int) SensorData {
+	data := SensorData{
+		DeviceID:    "conformance-" + string(rune('a'+device)),
+		Timestamp:   conformanceBase.Add(time.Duration(second) * time.Second),
+		Humidity:    float32(40 + second),
+		Temperature: float32(20 + device),
+	}
+
+	if second%2 == 1 {
+		data.Quality = QualityOutOfRange | QualityClockSkew
+	}
+
+	return data
+}
+
+func TestSensorStore_Conformance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, store SensorStore)
+	}{
+		{name: "insert skips duplicates", run: testInsertSkipsDuplicates},
+		{name: "batch counts inserted rows", run: testBatchCountsInsertedRows},
+		{name: "copy counts inserted rows", run: testCopyCountsInsertedRows},
+		{name: "empty batches", run: testEmptyBatches},
+		{name: "same instant in another time zone", run: testSameInstantInAnotherTimeZone},
+		{name: "list", run: testList},
+		{name: "sub-second readings", run: testSubSecondReadings},
+		{name: "same time from another message", run: testSameTimeFromAnotherMessage},
+		{name: "ping", run: testPing},
+	}
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					s := store.open(t)
+					defer s.Close()
+
+					tt.run(t, s)
+				})
+			}
+		})
+	}
+}
+
+func assertCount(t *testing.T, store SensorStore, want int64) {
+	t.Helper()
+
+	count, err := store.CountSensorData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != want {
+		t.Errorf("CountSensorData() = %d, want %d", count, want)
+	}
+}
+
+func testInsertSkipsDuplicates(t *testing.T, store SensorStore) {
+	for i := 0; i < 2; i++ {
+		if err := store.InsertSensorData(reading(0, 0)); err != nil {
+			t.Fatalf("InsertSensorData() error = %v", err)
+		}
+	}
+
+	assertCount(t, store, 1)
+}
+
+func testBatchCountsInsertedRows(t *testing.T, store SensorStore) {
+	if err := store.InsertSensorData(reading(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, err := store.InsertSensorDataBatch([]SensorData{reading(0, 0), reading(0, 1), reading(1, 0), reading(1, 0)})
+	if err != nil {
+		t.Fatalf("InsertSensorDataBatch() error = %v", err)
+	}
+	if inserted != 2 {
+		t.Errorf("InsertSensorDataBatch() inserted %d rows, want 2", inserted)
+	}
+
+	assertCount(t, store, 3)
+}
+
+func testCopyCountsInsertedRows(t *testing.T, store SensorStore) {
+	if err := store.InsertSensorData(reading(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	inserted, err := store.CopySensorData([]SensorData{reading(0, 0), reading(0, 1), reading(1, 0), reading(1, 0)})
+	if err != nil {
+		t.Fatalf("CopySensorData() error = %v", err)
+	}
+	if inserted != 2 {
+		t.Errorf("CopySensorData() inserted %d rows, want 2", inserted)
+	}
+
+	assertCount(t, store, 3)
+}
+
+func testEmptyBatches(t *testing.T, store SensorStore) {
+	if inserted, err := store.InsertSensorDataBatch(nil); err != nil || inserted != 0 {
+		t.Errorf("InsertSensorDataBatch(nil) = %d, %v, want 0, nil", inserted, err)
+	}
+
+	if inserted, err := store.CopySensorData(nil); err != nil || inserted != 0 {
+		t.Errorf("CopySensorData(nil) = %d, %v, want 0, nil", inserted, err)
+	}
+
+	assertCount(t, store, 0)
+}
+
+func testSameInstantInAnotherTimeZone(t *testing.T, store SensorStore) {
+	row := reading(0, 0)
+	if err := store.InsertSensorData(row); err != nil {
+		t.Fatal(err)
+	}
+
+	row.Timestamp = row.Timestamp.In(time.FixedZone("UTC+3", 3*60*60))
+
+	inserted, err := store.CopySensorData([]SensorData{row})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 0 {
+		t.Errorf("CopySensorData() inserted %d rows, want the same instant skipped", inserted)
+	}
+}
+
+func testList(t *testing.T, store SensorStore) {
+	if _, err := store.CopySensorData([]SensorData{reading(0, 2), reading(0, 0), reading(1, 1), reading(0, 1), reading(0, 3)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// from is inclusive and to is exclusive.
+	data, err := store.ListSensorData(reading(0, 0).DeviceID, reading(0, 1).Timestamp, reading(0, 3).Timestamp)
+	if err != nil {
+		t.Fatalf("ListSensorData() error = %v", err)
+	}
+
+	want := []SensorData{reading(0, 1), reading(0, 2)}
+
+	if len(data) != len(want) {
+		t.Fatalf("ListSensorData() returned %d rows, want %d", len(data), len(want))
+	}
+
+	for i := range want {
+		got := data[i]
+		if got.DeviceID != want[i].DeviceID ||
+			!got.Timestamp.Equal(want[i].Timestamp) ||
+			got.Humidity != want[i].Humidity ||
+			got.Temperature != want[i].Temperature ||
+			got.Quality != want[i].Quality {
+			t.Errorf("ListSensorData()[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	data, err = store.ListSensorData("conformance-unknown", conformanceBase, conformanceBase.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("ListSensorData() of an unknown device returned %d rows, want 0", len(data))
+	}
+}
+
+func testSubSecondReadings(t *testing.T, store SensorStore) {
+	var rows []SensorData
+	for _, offset := range []time.Duration{time.Second, 999999 * time.Microsecond, 0, 500 * time.Millisecond, time.Microsecond} {
+		row := reading(0, 0)
+		row.Timestamp = row.Timestamp.Add(offset)
+		rows = append(rows, row)
+	}
+
+	inserted, err := store.CopySensorData(append(rows, rows[2], rows[4]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != int64(len(rows)) {
+		t.Errorf("CopySensorData() inserted %d rows, want %d", inserted, len(rows))
+	}
+
+	data, err := store.ListSensorData(rows[0].DeviceID, conformanceBase, conformanceBase.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []time.Duration{0, time.Microsecond, 500 * time.Millisecond, 999999 * time.Microsecond, time.Second}
+
+	if len(data) != len(want) {
+		t.Fatalf("ListSensorData() returned %d rows, want %d", len(data), len(want))
+	}
+
+	for i, offset := range want {
+		if !data[i].Timestamp.Equal(conformanceBase.Add(offset)) {
+			t.Errorf("ListSensorData()[%d] time = %v, want %v", i, data[i].Timestamp, conformanceBase.Add(offset))
+		}
+	}
+}
+
+func testSameTimeFromAnotherMessage(t *testing.T, store SensorStore) {
+	first := reading(0, 0)
+	first.MessageID = "message-1"
+
+	second := first
+	second.Humidity++
+	second.MessageID = "message-2"
+
+	inserted, err := store.CopySensorData([]SensorData{first, second, first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inserted != 2 {
+		t.Errorf("CopySensorData() inserted %d rows, want both messages and not the redelivery", inserted)
+	}
+
+	if err := store.InsertSensorData(second); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCount(t, store, 2)
+}
+
+func testPing(t *testing.T, store SensorStore) {
+	if err := store.Ping(); err != nil {
+		t.Errorf("Ping() error = %v", err)
+	}
+}
+
+func TestSQLite_AddsQualityColumn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensor_data.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The table as created before the quality column existed.
+	_, err = db.Exec(`CREATE TABLE sensor_data (
+		time        TIMESTAMP NOT NULL,
+		device_id   TEXT      NOT NULL,
+		humidity    REAL,
+		temperature REAL,
+		PRIMARY KEY (device_id, time)
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := reading(0, 0)
+	if _, err := db.Exec(`INSERT INTO sensor_data (time, device_id, humidity, temperature) VALUES (?, ?, ?, ?)`,
+		old.Timestamp, old.DeviceID, old.Humidity, old.Temperature); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLite(path)
+		if err != nil {
+			t.Fatalf("NewSQLite() error = %v", err)
+		}
+
+		if err := store.InsertSensorData(reading(0, 1)); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := store.ListSensorData(old.DeviceID, conformanceBase, conformanceBase.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 2 || data[0].Quality != 0 || data[1].Quality != reading(0, 1).Quality {
+			t.Errorf("ListSensorData() = %+v, want the old row good and the new one flagged", data)
+		}
+
+		store.Close()
+	}
+}
+
+func TestSQLite_RebuildsPrimaryKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sensor_data.db")
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The table as created before the message_id column existed.
+	_, err = db.Exec(`CREATE TABLE sensor_data (
+		time        TIMESTAMP NOT NULL,
+		device_id   TEXT      NOT NULL,
+		humidity    REAL,
+		temperature REAL,
+		quality     INTEGER   NOT NULL DEFAULT 0,
+		PRIMARY KEY (device_id, time)
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := reading(0, 1)
+	if _, err := db.Exec(`INSERT INTO sensor_data (time, device_id, humidity, temperature, quality) VALUES (?, ?, ?, ?, ?)`,
+		old.Timestamp.UTC(), old.DeviceID, old.Humidity, old.Temperature, old.Quality); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLite(path)
+		if err != nil {
+			t.Fatalf("NewSQLite() error = %v", err)
+		}
+
+		other := old
+		other.MessageID = "message-2"
+
+		inserted, err := store.CopySensorData([]SensorData{old, other})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := int64(1)
+		if i > 0 {
+			want = 0
+		}
+		if inserted != want {
+			t.Errorf("CopySensorData() inserted %d rows, want %d", inserted, want)
+		}
+
+		data, err := store.ListSensorData(old.DeviceID, conformanceBase, conformanceBase.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 2 || data[0].Quality != old.Quality {
+			t.Errorf("ListSensorData() = %+v, want the old row kept next to the new message", data)
+		}
+
+		store.Close()
+	}
+}
@@ -0,0 +1,310 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/lib/pq"
+)
+
+var ErrWriterClosed = errors.New("batch writer closed")
+
+// Copier stores a batch of rows, skipping the ones already stored, and
+// returns how many were inserted. It is satisfied by *Database, *SQLite and
+// *Memory.
+type Copier interface {
+	CopySensorData(data []SensorData) (int64, error)
+}
+
+// ContextCopier is a Copier whose writes can be cancelled, such as one that
+// retries over the network. The BatchWriter uses it when the sink implements
+// it, so a flush stops waiting once the writer is shut down.
+type ContextCopier interface {
+	CopySensorDataContext(ctx context.Context, data []SensorData) (int64, error)
+}
+
+// Counter is satisfied by prometheus.Counter.
+type Counter interface {
+	Add(float64)
+}
+
+type pendingWrite struct {
+	rows []SensorData
+	done chan error
+}
+
+// BatchWriter buffers rows from concurrent callers and stores them with a
+// single COPY once enough rows are pending or the flush interval elapses.
+// Write only returns after the rows it was given are committed, so callers
+// can acknowledge the originating messages as soon as it returns nil.
+// When a flush merging several writes fails, every write is retried on its
+// own, so rows the store refuses only fail the write they came from.
+type BatchWriter struct {
+	db      Copier
+	logger  logger.Interface
+	options *WriterOptions
+
+	mu          sync.Mutex
+	pending     []pendingWrite
+	pendingRows int
+	closed      bool
+
+	flush chan struct{}
+	stop  chan struct{}
+	wg    sync.WaitGroup
+
+	// ctx is passed to the sink and cancelled when Shutdown gives up on the
+	// last flush.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type WriterOptions struct {
+	maxRows       int
+	flushInterval time.Duration
+	duplicates    Counter
+}
+
+type WriterOption func(*WriterOptions)
+
+// WithMaxRows sets how many pending rows trigger an immediate flush.
+func WithMaxRows(maxRows int) WriterOption {
+	return func(options *WriterOptions) {
+		options.maxRows = maxRows
+	}
+}
+
+// WithFlushInterval sets how often pending rows are flushed when fewer than
+// the maximum number of rows are waiting.
+func WithFlushInterval(flushInterval time.Duration) WriterOption {
+	return func(options *WriterOptions) {
+		options.flushInterval = flushInterval
+	}
+}
+
+// WithDuplicatesCounter sets a counter incremented by the number of rows
+// dropped because they were already stored.
+func WithDuplicatesCounter(duplicates Counter) WriterOption {
+	return func(options *WriterOptions) {
+		options.duplicates = duplicates
+	}
+}
+
+func NewBatchWriter(db Copier, logger logger.Interface, options ...WriterOption) *BatchWriter {
+	defaultOptions := &WriterOptions{
+		maxRows:       500,
+		flushInterval: 200 * time.Millisecond,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	if defaultOptions.maxRows < 1 {
+		defaultOptions.maxRows = 1
+	}
+
+	if defaultOptions.flushInterval <= 0 {
+		defaultOptions.flushInterval = 200 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	w := &BatchWriter{
+		db:      db,
+		logger:  logger,
+		options: defaultOptions,
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w
+}
+
+// Write queues rows for the next flush and blocks until they are committed,
+// the flush fails, or ctx is done. When ctx is done first the rows may still
+// be committed later.
+func (w *BatchWriter) Write(ctx context.Context, rows []SensorData) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	done := make(chan error, 1)
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrWriterClosed
+	}
+
+	w.pending = append(w.pending, pendingWrite{rows: rows, done: done})
+	w.pendingRows += len(rows)
+	full := w.pendingRows >= w.options.maxRows
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.flush <- struct{}{}:
+		default:
+		}
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the rows still pending and stops the background flusher.
+func (w *BatchWriter) Close() error {
+	return w.Shutdown(context.Background())
+}
+
+// Shutdown flushes the rows still pending and stops the background flusher.
+// When ctx is done first the write in progress is cancelled, if the sink is a
+// ContextCopier, and Shutdown returns once the flusher has stopped.
+func (w *BatchWriter) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+
+	stopped := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-stopped
+		return ctx.Err()
+	}
+}
+
+func (w *BatchWriter) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(w.options.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.flush:
+			w.flushPending()
+		case <-ticker.C:
+			w.flushPending()
+		case <-w.stop:
+			w.flushPending()
+			return
+		}
+	}
+}
+
+func (w *BatchWriter) flushPending() {
+	w.mu.Lock()
+	pending := w.pending
+	rowCount := w.pendingRows
+	w.pending = nil
+	w.pendingRows = 0
+	w.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	rows := make([]SensorData, 0, rowCount)
+	for _, write := range pending {
+		rows = append(rows, write.rows...)
+	}
+
+	startTime := time.Now()
+	duplicates, err := w.copy(rows)
+
+	if err != nil && len(pending) > 1 && refusedData(err) {
+		// A single row the store refuses fails the whole COPY, so the
+		// writes are retried on their own and only the callers whose rows
+		// are refused get the error. Other errors would fail every retry
+		// too, so they fail the whole batch at once.
+		w.logger.Warn("Failed to flush sensor data, retrying every write on its own", "error", err, "rows", len(rows), "writes", len(pending))
+
+		for _, write := range pending {
+			_, err := w.copy(write.rows)
+			if err != nil {
+				w.logger.Error("Failed to flush sensor data", "error", err, "rows", len(write.rows))
+			}
+			write.done <- err
+		}
+		return
+	}
+
+	if err != nil {
+		w.logger.Error("Failed to flush sensor data", "error", err, "rows", len(rows))
+	} else {
+		w.logger.Debug("Flushed sensor data",
+			"rows", len(rows),
+			"duplicates", duplicates,
+			"writes", len(pending),
+			"duration", time.Since(startTime),
+		)
+	}
+
+	for _, write := range pending {
+		write.done <- err
+	}
+}
+
+// refusedData reports whether err is PostgreSQL refusing the data of a row: a
+// data exception (class 22) or an integrity constraint violation (class 23).
+// Errors such as a lost connection or a cancelled context are not.
+func refusedData(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+
+	class := pqErr.Code.Class()
+	return class == "22" || class == "23"
+}
+
+// copy stores rows and counts the ones dropped as already stored.
+func (w *BatchWriter) copy(rows []SensorData) (int64, error) {
+	var inserted int64
+	var err error
+
+	if copier, ok := w.db.(ContextCopier); ok {
+		inserted, err = copier.CopySensorDataContext(w.ctx, rows)
+	} else {
+		inserted, err = w.db.CopySensorData(rows)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	duplicates := int64(len(rows)) - inserted
+
+	if duplicates > 0 && w.options.duplicates != nil {
+		w.options.duplicates.Add(float64(duplicates))
+	}
+
+	return duplicates, nil
+}
@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+type mockCopier struct {
+	mu         sync.Mutex
+	batches    [][]SensorData
+	duplicates int64
+	err        error
+	// refused fails every batch holding a row of this device.
+	refused string
+}
+
+func (m *mockCopier) CopySensorData(data []SensorData) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, data)
+	if m.err != nil {
+		return 0, m.err
+	}
+	for _, row := range data {
+		if m.refused != "" && row.DeviceID == m.refused {
+			return 0, fmt.Errorf("failed to copy sensor data: %w", &pq.Error{Code: "22P02", Message: "invalid input value"})
+		}
+	}
+	return int64(len(data)) - m.duplicates, nil
+}
+
+type mockCounter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (m *mockCounter) Add(v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.value += v
+}
+
+func (m *mockCopier) batchSizes() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sizes := make([]int, len(m.batches))
+	for i, batch := range m.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func rows(n int) []SensorData {
+	data := make([]SensorData, n)
+	for i := range data {
+		data[i] = SensorData{DeviceID: "device", Timestamp: time.Unix(int64(i), 0)}
+	}
+	return data
+}
+
+func TestBatchWriter_FlushesOnMaxRows(t *testing.T) {
+	db := &mockCopier{}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(4), WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Write(context.Background(), rows(2)); err != nil {
+				t.Errorf("Write() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	sizes := db.batchSizes()
+	if len(sizes) != 1 || sizes[0] != 4 {
+		t.Errorf("flushed batches = %v, want a single batch of 4 rows", sizes)
+	}
+}
+
+func TestBatchWriter_FlushesOnInterval(t *testing.T) {
+	db := &mockCopier{}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(100), WithFlushInterval(20*time.Millisecond))
+	defer w.Close()
+
+	if err := w.Write(context.Background(), rows(3)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	sizes := db.batchSizes()
+	if len(sizes) != 1 || sizes[0] != 3 {
+		t.Errorf("flushed batches = %v, want a single batch of 3 rows", sizes)
+	}
+}
+
+func TestBatchWriter_PropagatesFlushError(t *testing.T) {
+	db := &mockCopier{err: errors.New("connection reset")}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(1))
+	defer w.Close()
+
+	if err := w.Write(context.Background(), rows(1)); err == nil {
+		t.Error("Write() error = nil, want flush error")
+	}
+}
+
+func TestBatchWriter_RefusedRowsOnlyFailTheirWrite(t *testing.T) {
+	db := &mockCopier{refused: "bad-device"}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(4), WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	bad := rows(2)
+	bad[1].DeviceID = "bad-device"
+
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	for i, data := range [][]SensorData{rows(2), bad} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.Write(context.Background(), data)
+		}()
+	}
+	wg.Wait()
+
+	if errs[0] != nil {
+		t.Errorf("Write() of valid rows error = %v, want nil", errs[0])
+	}
+	if errs[1] == nil {
+		t.Error("Write() of refused rows error = nil")
+	}
+
+	sizes := db.batchSizes()
+	if len(sizes) != 3 || sizes[0] != 4 {
+		t.Errorf("flushed batches = %v, want the merged batch then each write on its own", sizes)
+	}
+}
+
+func TestBatchWriter_ConnectionErrorFailsEveryWriteAtOnce(t *testing.T) {
+	db := &mockCopier{err: &pq.Error{Code: "08006", Message: "connection failure"}}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(4), WithFlushInterval(time.Hour))
+	defer w.Close()
+
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = w.Write(context.Background(), rows(2))
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("Write() %d error = nil, want the connection error", i)
+		}
+	}
+
+	if sizes := db.batchSizes(); len(sizes) != 1 || sizes[0] != 4 {
+		t.Errorf("flushed batches = %v, want only the merged batch", sizes)
+	}
+}
+
+func TestBatchWriter_CloseFlushesPending(t *testing.T) {
+	db := &mockCopier{}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(100), WithFlushInterval(time.Hour))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Write(context.Background(), rows(5))
+	}()
+
+	// Give the write a chance to be queued before closing.
+	time.Sleep(20 * time.Millisecond)
+	w.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if err := w.Write(context.Background(), rows(1)); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("Write() after Close error = %v, want %v", err, ErrWriterClosed)
+	}
+}
+
+func TestBatchWriter_CountsDuplicates(t *testing.T) {
+	db := &mockCopier{duplicates: 2}
+	counter := &mockCounter{}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(5), WithDuplicatesCounter(counter))
+	defer w.Close()
+
+	if err := w.Write(context.Background(), rows(5)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	if counter.value != 2 {
+		t.Errorf("duplicates counter = %v, want %v", counter.value, 2)
+	}
+}
+
+// blockingCopier never stores a batch until its context is cancelled, like a
+// sink retrying an unavailable server.
+type blockingCopier struct {
+	started chan struct{}
+}
+
+func (b *blockingCopier) CopySensorData(data []SensorData) (int64, error) {
+	return b.CopySensorDataContext(context.Background(), data)
+}
+
+func (b *blockingCopier) CopySensorDataContext(ctx context.Context, data []SensorData) (int64, error) {
+	close(b.started)
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestBatchWriter_ShutdownCancelsBlockedFlush(t *testing.T) {
+	db := &blockingCopier{started: make(chan struct{})}
+	w := NewBatchWriter(db, &mockLogger{}, WithMaxRows(1))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.Write(context.Background(), rows(1))
+	}()
+
+	<-db.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := w.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Write() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write() still blocked after Shutdown")
+	}
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/parser"
+)
+
+// Writer stores sensor data. It is satisfied by *database.BatchWriter and
+// *sink.Pipeline.
+type Writer interface {
+	Write(ctx context.Context, rows []database.SensorData) error
+}
+
+// Handler turns sensor data messages into rows and stores them. Messages
+// that do not parse or fail validation are rejected, so the broker
+// dead-letters them instead of redelivering them.
+type Handler struct {
+	writer Writer
+	parser *parser.Parser
+	logger logger.Interface
+}
+
+func NewHandler(writer Writer, parser *parser.Parser, logger logger.Interface) *Handler {
+	return &Handler{
+		writer: writer,
+		parser: parser,
+		logger: logger,
+	}
+}
+
+func (h *Handler) Handle(msg broker.Message) error {
+	h.logger.Debug("Received message", "message", string(msg.Body))
+
+	sensorData, err := h.parse(msg)
+	if err != nil {
+		return consumer.Reject(err)
+	}
+
+	if err := h.writer.Write(context.Background(), []database.SensorData{sensorData}); err != nil {
+		h.logger.Error("Failed to insert sensor data", "error", err)
+		return err
+	}
+
+	h.logger.Info("Inserted sensor data", "data", sensorData)
+	return nil
+}
+
+// HandleBatch stores every message of the batch that parses and passes
+// validation. The others are reported in a *consumer.BatchError so only they
+// are rejected.
+func (h *Handler) HandleBatch(msgs []broker.Message) error {
+	rows := make([]database.SensorData, 0, len(msgs))
+
+	var rejected []int
+	var parseErr error
+
+	for i, msg := range msgs {
+		sensorData, err := h.parse(msg)
+		if err != nil {
+			rejected = append(rejected, i)
+			parseErr = err
+			continue
+		}
+
+		rows = append(rows, sensorData)
+	}
+
+	if err := h.writer.Write(context.Background(), rows); err != nil {
+		h.logger.Error("Failed to insert sensor data batch", "error", err, "size", len(rows))
+		return err
+	}
+
+	h.logger.Info("Inserted sensor data batch", "size", len(rows))
+
+	if len(rejected) > 0 {
+		return &consumer.BatchError{Rejected: rejected, Err: parseErr}
+	}
+
+	return nil
+}
+
+func (h *Handler) parse(msg broker.Message) (database.SensorData, error) {
+	received, _ := clockskew.BrokerTime(msg)
+
+	sensorData, err := h.parser.Parse(msg.Body, received)
+	if err != nil {
+		var validationErr *parser.ValidationError
+		if errors.As(err, &validationErr) {
+			h.logger.Warn("Rejecting invalid sensor data", "error", err, "quality", validationErr.Quality)
+		} else {
+			h.logger.Error("Failed to parse message", "error", err, "message", string(msg.Body))
+		}
+		return database.SensorData{}, err
+	}
+
+	if !sensorData.Quality.Good() {
+		h.logger.Warn("Storing flagged sensor data", "device_id", sensorData.DeviceID, "quality", sensorData.Quality)
+	}
+
+	return sensorData, nil
+}
+
+// DeviceID is a consumer shard key that keeps the messages of a device in
+// order. The device ID only lives inside the payload, so the message is parsed
+// once to pick the worker and again by the handler.
+func DeviceID(msg broker.Message) string {
+	sensorData, err := parser.ParseMessage(msg.Body)
+	if err != nil {
+		return ""
+	}
+	return sensorData.DeviceID
+}
@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/memory"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/parser"
+	"google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+type mockWriter struct {
+	mu   sync.Mutex
+	rows []database.SensorData
+	err  error
+}
+
+func (m *mockWriter) Write(ctx context.Context, rows []database.SensorData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.rows = append(m.rows, rows...)
+	return nil
+}
+
+func (m *mockWriter) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.rows)
+}
+
+func newParser(t *testing.T, mode string) *parser.Parser {
+	t.Helper()
+
+	maxHumidity := 100.0
+
+	p, err := parser.NewParser(parser.Rules{
+		Mode:            mode,
+		RequireSensorID: true,
+		Ranges:          map[string]parser.Range{parser.MetricHumidity: {Max: &maxHumidity}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func encode(t *testing.T, deviceID string, timestamp int64) []byte {
+	return encodeHumidity(t, deviceID, timestamp, 40)
+}
+
+func encodeHumidity(t *testing.T, deviceID string, timestamp int64, humidity float32) []byte {
+	t.Helper()
+
+	data, err := proto.Marshal(&protosensor.SensorData{
+		SensorId:    deviceID,
+		Humidity:    humidity,
+		Temperature: 21.5,
+		Timestamp:   timestamp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(data))
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func TestHandler_ConsumesFromBroker(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+	}{
+		{"single", 1},
+		{"batch", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := memory.NewBroker()
+			b.DeclareQueue("data-queue")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			for i := int64(1); i <= 3; i++ {
+				msg := broker.Message{Body: encode(t, "device-1", 1700000000+i)}
+				if err := b.PublishWithConfirm(ctx, "", "data-queue", msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writer := &mockWriter{}
+			h := NewHandler(writer, newParser(t, parser.ModeReject), &mockLogger{})
+			c := consumer.NewConsumer(b, &mockLogger{}, "test",
+				consumer.WithBatchSize(tt.batchSize),
+				consumer.WithBatchTimeout(50*time.Millisecond),
+				consumer.WithShardKey(DeviceID),
+			)
+
+			var err error
+			if tt.batchSize > 1 {
+				err = c.StartBatch(ctx, memory.Queue("data-queue"), h.HandleBatch)
+			} else {
+				err = c.Start(ctx, memory.Queue("data-queue"), h.Handle)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			waitFor(t, func() bool { return b.Stats("data-queue").Acked == 3 })
+
+			cancel()
+			if err := c.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if writer.count() != 3 {
+				t.Errorf("stored %d rows, want 3", writer.count())
+			}
+			if writer.rows[0].DeviceID != "device-1" || writer.rows[0].Timestamp.Unix() != 1700000001 {
+				t.Errorf("first row = %+v", writer.rows[0])
+			}
+		})
+	}
+}
+
+func TestHandler_Handle_Errors(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       []byte
+		writeErr   error
+		wantReject bool
+	}{
+		{name: "malformed", body: []byte("not base64!"), wantReject: true},
+		{name: "invalid", body: encodeHumidity(t, "device-1", 1700000000, 300), wantReject: true},
+		{name: "missing sensor id", body: encode(t, "", 1700000000), wantReject: true},
+		{name: "write failure", body: encode(t, "device-1", 1700000000), writeErr: errors.New("database unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(&mockWriter{err: tt.writeErr}, newParser(t, parser.ModeReject), &mockLogger{})
+
+			err := h.Handle(broker.Message{Body: tt.body})
+			if err == nil {
+				t.Fatal("Handle() error = nil")
+			}
+
+			var rejectErr *consumer.RejectError
+			if errors.As(err, &rejectErr) != tt.wantReject {
+				t.Errorf("Handle() error = %v, want rejected %v", err, tt.wantReject)
+			}
+			if tt.writeErr != nil && !errors.Is(err, tt.writeErr) {
+				t.Errorf("Handle() error = %v, want %v", err, tt.writeErr)
+			}
+		})
+	}
+}
+
+func TestHandler_Handle_FlagsInvalidReadings(t *testing.T) {
+	writer := &mockWriter{}
+	h := NewHandler(writer, newParser(t, parser.ModeFlag), &mockLogger{})
+
+	if err := h.Handle(broker.Message{Body: encodeHumidity(t, "device-1", 1700000000, 300)}); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if writer.count() != 1 || writer.rows[0].Quality != database.QualityOutOfRange {
+		t.Errorf("stored %+v, want a single row flagged out of range", writer.rows)
+	}
+}
+
+func TestHandler_Handle_CorrectsSkewedClocks(t *testing.T) {
+	tracker, err := clockskew.NewTracker(clockskew.WithMode(clockskew.ModeCorrect), clockskew.WithMinSamples(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := parser.NewParser(parser.Rules{Mode: parser.ModeFlag}, parser.WithSkewTracker(tracker))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer := &mockWriter{}
+	h := NewHandler(writer, p, &mockLogger{})
+
+	// The device clock is an hour behind the time the broker received the
+	// reading.
+	msg := broker.Message{
+		Body:    encode(t, "device-1", 1700000000),
+		Headers: map[string]any{clockskew.ArrivalHeader: int64(1700003600000)},
+	}
+
+	if err := h.Handle(msg); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if writer.count() != 1 || writer.rows[0].Timestamp.Unix() != 1700003600 || writer.rows[0].Quality != database.QualityTimestampCorrected {
+		t.Errorf("stored %+v, want a single row at the arrival time flagged as corrected", writer.rows)
+	}
+}
+
+func TestHandler_HandleBatch_RejectsInvalidMessages(t *testing.T) {
+	writer := &mockWriter{}
+	h := NewHandler(writer, newParser(t, parser.ModeReject), &mockLogger{})
+
+	err := h.HandleBatch([]broker.Message{
+		{Body: encode(t, "device-1", 1700000001)},
+		{Body: []byte("not base64!")},
+		{Body: encode(t, "device-1", 1700000002)},
+		{Body: encodeHumidity(t, "device-1", 1700000003, 300)},
+	})
+
+	var batchErr *consumer.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("error = %v, want *consumer.BatchError", err)
+	}
+	if len(batchErr.Failed) != 0 || len(batchErr.Rejected) != 2 || batchErr.Rejected[0] != 1 || batchErr.Rejected[1] != 3 {
+		t.Errorf("failed = %v, rejected = %v, want none and [1 3]", batchErr.Failed, batchErr.Rejected)
+	}
+	if writer.count() != 2 {
+		t.Errorf("stored %d rows, want 2", writer.count())
+	}
+}
+
+func TestHandler_DeadLettersInvalidMessages(t *testing.T) {
+	b := memory.NewBroker()
+	b.DeclareQueue("data-queue")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, body := range [][]byte{
+		encode(t, "device-1", 1700000001),
+		encodeHumidity(t, "device-1", 1700000002, 300),
+	} {
+		if err := b.PublishWithConfirm(ctx, "", "data-queue", broker.Message{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writer := &mockWriter{}
+	h := NewHandler(writer, newParser(t, parser.ModeReject), &mockLogger{})
+	c := consumer.NewConsumer(b, &mockLogger{}, "test")
+
+	if err := c.Start(ctx, memory.Queue("data-queue"), h.Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		stats := b.Stats("data-queue")
+		return stats.Acked == 1 && stats.Dropped == 1
+	})
+
+	if writer.count() != 1 {
+		t.Errorf("stored %d rows, want 1", writer.count())
+	}
+}
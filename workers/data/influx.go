@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/influx"
+)
+
+type influxSink interface {
+	database.Copier
+	Close() error
+}
+
+// openInflux opens the line protocol destination selected by the
+// configuration.
+func openInflux(cfg *config.Config, log logger.Interface) (influxSink, error) {
+	options := []influx.Option{
+		influx.WithGzip(cfg.Influx.Gzip),
+		influx.WithMaxRetries(cfg.Influx.MaxRetries),
+	}
+
+	switch cfg.Influx.Output {
+	case "http":
+		log.Debug("Writing line protocol to InfluxDB", "url", cfg.Influx.URL, "org", cfg.Influx.Org, "bucket", cfg.Influx.Bucket)
+
+		sink, err := influx.NewHTTPSink(cfg.Influx.URL, cfg.Influx.Org, cfg.Influx.Bucket, cfg.Influx.Token, log, options...)
+		if err != nil {
+			return nil, err
+		}
+
+		return sink, nil
+	case "file":
+		log.Debug("Writing line protocol to file", "path", cfg.Influx.Path)
+
+		sink, err := influx.NewFileSink(cfg.Influx.Path, options...)
+		if err != nil {
+			return nil, err
+		}
+
+		return sink, nil
+	default:
+		return nil, fmt.Errorf("unknown InfluxDB output %q, expected http or file", cfg.Influx.Output)
+	}
+}
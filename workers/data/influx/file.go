@@ -0,0 +1,68 @@
+package influx
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// FileSink appends readings in line protocol to a file, which can be loaded
+// with `influx write --file`. With gzip every batch is appended as its own
+// gzip member, which gzip readers decode as a single stream.
+type FileSink struct {
+	path    string
+	options *Options
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it if needed. Only the gzip
+// option applies to files.
+func NewFileSink(path string, options ...Option) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open line protocol file: %w", err)
+	}
+
+	return &FileSink{
+		path:    path,
+		options: newOptions(options),
+		file:    file,
+	}, nil
+}
+
+// CopySensorData appends the rows and syncs the file, so they are on disk
+// once it returns.
+func (s *FileSink) CopySensorData(data []database.SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	body, err := encodeBody(Encode(data), s.options.gzip)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(body); err != nil {
+		return 0, fmt.Errorf("failed to write line protocol file: %w", err)
+	}
+
+	if err := s.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync line protocol file: %w", err)
+	}
+
+	return int64(len(data)), nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
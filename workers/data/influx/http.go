@@ -0,0 +1,244 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// HTTPSink writes readings to the /api/v2/write endpoint of InfluxDB, or of
+// any store that accepts its line protocol.
+//
+// InfluxDB keeps a single point per series and timestamp, so redelivered
+// readings overwrite themselves and every row counts as inserted.
+type HTTPSink struct {
+	endpoint string
+	token    string
+	logger   logger.Interface
+	options  *Options
+}
+
+type Options struct {
+	gzip         bool
+	maxRetries   int
+	retryBackoff time.Duration
+	httpClient   *http.Client
+}
+
+type Option func(*Options)
+
+// WithGzip compresses the request bodies, or the lines appended to the file.
+func WithGzip(enabled bool) Option {
+	return func(options *Options) {
+		options.gzip = enabled
+	}
+}
+
+// WithMaxRetries sets how many times a write rejected with 429 or 5xx, or
+// that could not reach the server, is retried.
+func WithMaxRetries(maxRetries int) Option {
+	return func(options *Options) {
+		options.maxRetries = maxRetries
+	}
+}
+
+// WithRetryBackoff sets the delay before the first retry, doubled on every
+// following one. A Retry-After header from the server takes precedence.
+func WithRetryBackoff(backoff time.Duration) Option {
+	return func(options *Options) {
+		options.retryBackoff = backoff
+	}
+}
+
+// WithHTTPClient sets the client used for the writes.
+func WithHTTPClient(client *http.Client) Option {
+	return func(options *Options) {
+		options.httpClient = client
+	}
+}
+
+// StatusError is returned when the server rejects a write.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *StatusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func newOptions(options []Option) *Options {
+	defaultOptions := &Options{
+		gzip:         true,
+		maxRetries:   3,
+		retryBackoff: 500 * time.Millisecond,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return defaultOptions
+}
+
+// NewHTTPSink writes to the bucket of the organization at serverURL, for
+// example http://influxdb:8086. The token is optional.
+func NewHTTPSink(serverURL, org, bucket, token string, logger logger.Interface, options ...Option) (*HTTPSink, error) {
+	endpoint, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse InfluxDB URL: %w", err)
+	}
+
+	endpoint = endpoint.JoinPath("api", "v2", "write")
+	endpoint.RawQuery = url.Values{
+		"org":       {org},
+		"bucket":    {bucket},
+		"precision": {"ns"},
+	}.Encode()
+
+	return &HTTPSink{
+		endpoint: endpoint.String(),
+		token:    token,
+		logger:   logger,
+		options:  newOptions(options),
+	}, nil
+}
+
+// CopySensorData writes the rows in a single request, retrying it while the
+// server is unavailable.
+func (s *HTTPSink) CopySensorData(data []database.SensorData) (int64, error) {
+	return s.CopySensorDataContext(context.Background(), data)
+}
+
+// CopySensorDataContext is CopySensorData that gives up, without waiting for
+// the next retry, once ctx is done.
+func (s *HTTPSink) CopySensorDataContext(ctx context.Context, data []database.SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	// Readings without a finite value have no line to send.
+	lines := Encode(data)
+	if len(lines) == 0 {
+		return int64(len(data)), nil
+	}
+
+	body, err := encodeBody(lines, s.options.gzip)
+	if err != nil {
+		return 0, err
+	}
+
+	backoff := s.options.retryBackoff
+
+	for attempt := 0; ; attempt++ {
+		delay, err := s.write(ctx, body)
+		if err == nil {
+			return int64(len(data)), nil
+		}
+
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("failed to write to InfluxDB: %w", ctx.Err())
+		}
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && !statusErr.retryable() {
+			return 0, fmt.Errorf("failed to write to InfluxDB: %w", err)
+		}
+
+		if attempt >= s.options.maxRetries {
+			return 0, fmt.Errorf("failed to write to InfluxDB after %d attempts: %w", attempt+1, err)
+		}
+
+		if delay == 0 {
+			delay = backoff
+			backoff *= 2
+		}
+
+		s.logger.Warn("Retrying InfluxDB write", "error", err, "attempt", attempt+1, "delay", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, fmt.Errorf("failed to write to InfluxDB after %d attempts: %w", attempt+1, ctx.Err())
+		}
+	}
+}
+
+// Close releases idle connections.
+func (s *HTTPSink) Close() error {
+	s.options.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// write sends a single request and returns the delay asked for by a
+// Retry-After header, if any.
+func (s *HTTPSink) write(ctx context.Context, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.options.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+
+	resp, err := s.options.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return 0, nil
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		delay = time.Duration(seconds) * time.Second
+	}
+
+	return delay, &StatusError{StatusCode: resp.StatusCode, Body: string(bytes.TrimSpace(message))}
+}
+
+func encodeBody(lines []byte, compress bool) ([]byte, error) {
+	if !compress {
+		return lines, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+
+	if _, err := zw.Write(lines); err != nil {
+		return nil, fmt.Errorf("failed to compress line protocol: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress line protocol: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
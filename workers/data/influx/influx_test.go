@@ -0,0 +1,346 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+var readings = []database.SensorData{
+	{DeviceID: "sensor-1", Timestamp: time.Unix(1700000000, 0), Humidity: 40, Temperature: 21.5},
+	{DeviceID: "sensor-2", Timestamp: time.Unix(1700000001, 500), Humidity: 35.25, Temperature: -3},
+}
+
+const readingLines = "sensor_data,device_id=sensor-1 humidity=40,temperature=21.5 1700000000000000000\n" +
+	"sensor_data,device_id=sensor-2 humidity=35.25,temperature=-3 1700000001000000500\n"
+
+func TestAppendLine(t *testing.T) {
+	tests := []struct {
+		name string
+		row  database.SensorData
+		want string
+	}{
+		{
+			name: "reading",
+			row:  readings[0],
+			want: "sensor_data,device_id=sensor-1 humidity=40,temperature=21.5 1700000000000000000\n",
+		},
+		{
+			name: "escapes the device ID",
+			row:  database.SensorData{DeviceID: `room 1,a=b\c`, Timestamp: time.Unix(0, 1), Humidity: 0.1, Temperature: 1e6},
+			want: `sensor_data,device_id=room\ 1\,a\=b\\c humidity=0.1,temperature=1000000 1` + "\n",
+		},
+		{
+			name: "flagged reading",
+			row:  database.SensorData{DeviceID: "sensor-1", Timestamp: time.Unix(0, 1), Humidity: 300, Temperature: 20, Quality: database.QualityOutOfRange},
+			want: "sensor_data,device_id=sensor-1 humidity=300,temperature=20,quality=8i 1\n",
+		},
+		{
+			name: "leaves out a NaN field",
+			row:  database.SensorData{DeviceID: "sensor-1", Timestamp: time.Unix(0, 1), Humidity: float32(math.NaN()), Temperature: 20},
+			want: "sensor_data,device_id=sensor-1 temperature=20 1\n",
+		},
+		{
+			name: "leaves out an infinite field",
+			row:  database.SensorData{DeviceID: "sensor-1", Timestamp: time.Unix(0, 1), Humidity: 40, Temperature: float32(math.Inf(-1))},
+			want: "sensor_data,device_id=sensor-1 humidity=40 1\n",
+		},
+		{
+			name: "keeps the quality of a flagged reading without finite fields",
+			row:  database.SensorData{DeviceID: "sensor-1", Timestamp: time.Unix(0, 1), Humidity: float32(math.NaN()), Temperature: float32(math.Inf(1)), Quality: database.QualityOutOfRange},
+			want: "sensor_data,device_id=sensor-1 quality=8i 1\n",
+		},
+		{
+			name: "skips a reading without finite fields",
+			row:  database.SensorData{DeviceID: "sensor-1", Timestamp: time.Unix(0, 1), Humidity: float32(math.NaN()), Temperature: float32(math.NaN())},
+			want: "",
+		},
+		{
+			name: "skips a reading without a device ID",
+			row:  database.SensorData{Timestamp: time.Unix(0, 1), Humidity: 40, Temperature: 20, Quality: database.QualityOutOfRange},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(AppendLine(nil, tt.row)); got != tt.want {
+				t.Errorf("AppendLine() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// influxServer is a stand-in for the /api/v2/write endpoint that answers
+// with the given statuses in turn, then with 204.
+type influxServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newInfluxServer(t *testing.T, statuses ...int) *influxServer {
+	t.Helper()
+
+	s := &influxServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		content, _ := io.ReadAll(body)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, string(content))
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestHTTPSink_CopySensorData(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		options      []Option
+		wantErr      bool
+		wantStatus   int
+		wantRequests int
+	}{
+		{
+			name:         "writes compressed lines",
+			wantRequests: 1,
+		},
+		{
+			name:         "writes uncompressed lines",
+			options:      []Option{WithGzip(false)},
+			wantRequests: 1,
+		},
+		{
+			name:         "retries unavailable server",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			wantRequests: 3,
+		},
+		{
+			name:         "gives up after the maximum retries",
+			statuses:     []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError},
+			options:      []Option{WithMaxRetries(2)},
+			wantErr:      true,
+			wantStatus:   http.StatusInternalServerError,
+			wantRequests: 3,
+		},
+		{
+			name:         "does not retry rejected lines",
+			statuses:     []int{http.StatusBadRequest},
+			wantErr:      true,
+			wantStatus:   http.StatusBadRequest,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newInfluxServer(t, tt.statuses...)
+
+			options := append([]Option{WithRetryBackoff(time.Millisecond)}, tt.options...)
+			sink, err := NewHTTPSink(server.URL, "iot", "sensors", "secret", &mockLogger{}, options...)
+			if err != nil {
+				t.Fatalf("NewHTTPSink() error = %v", err)
+			}
+			defer sink.Close()
+
+			inserted, err := sink.CopySensorData(readings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CopySensorData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var statusErr *StatusError
+			if tt.wantStatus != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantStatus) {
+				t.Errorf("CopySensorData() error = %v, want status %d", err, tt.wantStatus)
+			}
+
+			if !tt.wantErr && inserted != int64(len(readings)) {
+				t.Errorf("CopySensorData() = %d, want %d", inserted, len(readings))
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+
+			if len(server.requests) != tt.wantRequests {
+				t.Fatalf("server received %d requests, want %d", len(server.requests), tt.wantRequests)
+			}
+
+			r := server.requests[0]
+			if r.Method != http.MethodPost || r.URL.Path != "/api/v2/write" {
+				t.Errorf("request = %s %s, want POST /api/v2/write", r.Method, r.URL.Path)
+			}
+			if query := r.URL.Query(); query.Get("org") != "iot" || query.Get("bucket") != "sensors" || query.Get("precision") != "ns" {
+				t.Errorf("query = %v, want org, bucket and ns precision", query)
+			}
+			if got := r.Header.Get("Authorization"); got != "Token secret" {
+				t.Errorf("Authorization = %q, want %q", got, "Token secret")
+			}
+			for _, body := range server.bodies {
+				if body != readingLines {
+					t.Errorf("body = %q, want %q", body, readingLines)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPSink_Unreachable(t *testing.T) {
+	server := newInfluxServer(t)
+	server.Close()
+
+	sink, err := NewHTTPSink(server.URL, "iot", "sensors", "", &mockLogger{}, WithMaxRetries(1), WithRetryBackoff(time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewHTTPSink() error = %v", err)
+	}
+
+	if _, err := sink.CopySensorData(readings); err == nil {
+		t.Error("CopySensorData() succeeded against a closed server")
+	}
+}
+
+func TestHTTPSink_CopySensorDataContext_StopsRetryingWhenCancelled(t *testing.T) {
+	server := newInfluxServer(t, http.StatusServiceUnavailable)
+
+	sink, err := NewHTTPSink(server.URL, "iot", "sensors", "", &mockLogger{}, WithRetryBackoff(time.Hour))
+	if err != nil {
+		t.Fatalf("NewHTTPSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sink.CopySensorDataContext(ctx, readings)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("CopySensorDataContext() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("CopySensorDataContext() kept waiting to retry after the context was done")
+	}
+}
+
+func TestHTTPSink_SkipsReadingsWithoutFiniteValues(t *testing.T) {
+	server := newInfluxServer(t)
+
+	sink, err := NewHTTPSink(server.URL, "iot", "sensors", "", &mockLogger{}, WithGzip(false))
+	if err != nil {
+		t.Fatalf("NewHTTPSink() error = %v", err)
+	}
+	defer sink.Close()
+
+	nan := float32(math.NaN())
+	invalid := database.SensorData{DeviceID: "sensor-3", Timestamp: time.Unix(1700000002, 0), Humidity: nan, Temperature: nan}
+
+	if _, err := sink.CopySensorData([]database.SensorData{invalid}); err != nil {
+		t.Fatalf("CopySensorData() error = %v", err)
+	}
+
+	if _, err := sink.CopySensorData(append([]database.SensorData{invalid}, readings...)); err != nil {
+		t.Fatalf("CopySensorData() error = %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.bodies) != 1 || server.bodies[0] != readingLines {
+		t.Errorf("bodies = %q, want only %q", server.bodies, readingLines)
+	}
+}
+
+func TestFileSink_CopySensorData(t *testing.T) {
+	tests := []struct {
+		name string
+		gzip bool
+	}{
+		{name: "plain", gzip: false},
+		{name: "gzip", gzip: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sensor_data.lp")
+
+			sink, err := NewFileSink(path, WithGzip(tt.gzip))
+			if err != nil {
+				t.Fatalf("NewFileSink() error = %v", err)
+			}
+
+			for _, batch := range [][]database.SensorData{readings[:1], readings[1:]} {
+				if _, err := sink.CopySensorData(batch); err != nil {
+					t.Fatalf("CopySensorData() error = %v", err)
+				}
+			}
+
+			if err := sink.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.gzip {
+				zr, err := gzip.NewReader(bytes.NewReader(content))
+				if err != nil {
+					t.Fatalf("file is not gzip: %v", err)
+				}
+				content, err = io.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("failed to decompress file: %v", err)
+				}
+			}
+
+			if string(content) != readingLines {
+				t.Errorf("file = %q, want %q", content, readingLines)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package influx
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// Measurement is the name the readings are written under, matching the
+// sensor_data table.
+const Measurement = "sensor_data"
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+
+// AppendLine appends a reading as a line of InfluxDB line protocol with a
+// nanosecond timestamp:
+//
+//	sensor_data,device_id=sensor-1 humidity=40,temperature=21.5 1700000000000000000
+//
+// Flagged readings also have an integer quality field, good readings omit it.
+//
+// Line protocol has no NaN or infinity, and a single such value makes the
+// server reject the whole batch, so non-finite fields are left out. A reading
+// left without any field is not appended at all, and neither is one without a
+// device ID, since a tag cannot have an empty value.
+func AppendLine(buf []byte, row database.SensorData) []byte {
+	humidity := finite(row.Humidity)
+	temperature := finite(row.Temperature)
+	if row.DeviceID == "" || !humidity && !temperature && row.Quality.Good() {
+		return buf
+	}
+
+	buf = append(buf, Measurement...)
+	buf = append(buf, ",device_id="...)
+	buf = append(buf, tagEscaper.Replace(row.DeviceID)...)
+
+	separator := byte(' ')
+	if humidity {
+		buf = append(buf, separator)
+		buf = append(buf, "humidity="...)
+		buf = strconv.AppendFloat(buf, float64(row.Humidity), 'f', -1, 32)
+		separator = ','
+	}
+	if temperature {
+		buf = append(buf, separator)
+		buf = append(buf, "temperature="...)
+		buf = strconv.AppendFloat(buf, float64(row.Temperature), 'f', -1, 32)
+		separator = ','
+	}
+	if !row.Quality.Good() {
+		buf = append(buf, separator)
+		buf = append(buf, "quality="...)
+		buf = strconv.AppendUint(buf, uint64(row.Quality), 10)
+		buf = append(buf, 'i')
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, row.Timestamp.UnixNano(), 10)
+	return append(buf, '\n')
+}
+
+func finite(value float32) bool {
+	return !math.IsNaN(float64(value)) && !math.IsInf(float64(value), 0)
+}
+
+// Encode returns the rows in line protocol, one line per row.
+func Encode(rows []database.SensorData) []byte {
+	buf := make([]byte, 0, len(rows)*80)
+	for _, row := range rows {
+		buf = AppendLine(buf, row)
+	}
+	return buf
+}
@@ -6,14 +6,16 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
-	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/rabbitmq"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/health"
 	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/config"
-	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
-	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/parser"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/handler"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/metrics"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/timesync"
 )
 
 func main() {
@@ -30,76 +32,106 @@ func main() {
 
 	logger := logger.NewSlogLogger(loggerConfig)
 
-	url := fmt.Sprintf(
-		"amqp://%s:%s@%s:%s",
-		config.User,
-		config.Password,
-		config.Domain,
-		config.Port,
-	)
-
-	logger.Debug("Connecting to RabbitMQ with URL", "url", url)
-
-	rabbitMQ := rabbitmq.NewBroker(
-		url,
-		logger,
-	)
+	messageBroker, queue, err := connectBroker(config, logger)
+	if err != nil {
+		logger.Error("Failed to set up broker", "error", err, "broker", config.Broker)
+		os.Exit(1)
+	}
 
-	if err := rabbitMQ.Connect(); err != nil {
-		logger.Error("Failed to connect to RabbitMQ", "error", err)
+	store, err := openStore(config, logger)
+	if err != nil {
+		logger.Error("Failed to open store", "error", err, "store", config.Store)
 		os.Exit(1)
 	}
 
-	defer rabbitMQ.Close()
+	logger.Info("Opened sensor data store", "store", config.Store)
 
-	logger.Debug("Connecting to TimescaleDB")
+	metricsServer := metrics.NewServer(logger, config.MetricsAddress)
 
-	connectionString := config.TimescaleDB.ConnectionString()
+	metricsServer.Handle("/healthz", health.Handler(map[string]health.Check{
+		"broker": func() error {
+			if state := messageBroker.State(); state != broker.StateConnected {
+				return fmt.Errorf("%s is %s", config.Broker, state)
+			}
+			return nil
+		},
+		"store": store.Ping,
+	}))
 
-	logger.Debug("TimescaleDB connection string", "connectionString", connectionString)
+	if err := metricsServer.Start(); err != nil {
+		logger.Error("Failed to start metrics server", "error", err)
+		os.Exit(1)
+	}
 
-	db, err := database.NewDatabase(connectionString)
+	pipeline, err := buildPipeline(config, logger, store, messageBroker, metricsServer)
 	if err != nil {
-		logger.Error("Failed to connect to TimescaleDB", "error", err)
+		logger.Error("Failed to set up sinks", "error", err)
 		os.Exit(1)
 	}
 
-	defer db.Close()
+	sensorParser, err := buildParser(config, metricsServer)
+	if err != nil {
+		logger.Error("Failed to set up validation", "error", err)
+		os.Exit(1)
+	}
 
-	logger.Info("Connected to TimescaleDB")
+	logger.Info("Validating sensor data", "mode", config.Validation.Mode, "clock_skew", config.ClockSkew.Mode)
 
-	consumer := consumer.NewConsumer(rabbitMQ, logger, "data-consumer")
+	consumerOptions := []consumer.Option{
+		consumer.WithPrefetchCount(config.Consumer.PrefetchCount),
+		consumer.WithWorkers(config.Consumer.Workers),
+		consumer.WithBatchSize(config.Consumer.BatchSize),
+		consumer.WithBatchTimeout(time.Duration(config.Consumer.BatchTimeoutMs) * time.Millisecond),
+	}
 
-	queue := rabbitmq.NewQueue(config.QueueName)
+	if config.Consumer.OrderByDevice {
+		consumerOptions = append(consumerOptions, consumer.WithShardKey(handler.DeviceID))
+	}
 
-	logger.Debug("Setting up queue channel", "queue", queue.GetName())
+	dataConsumer := consumer.NewConsumer(messageBroker, logger, "data-consumer", consumerOptions...)
 
-	if err := rabbitMQ.SetupQueueChannel(queue); err != nil {
-		logger.Error("Failed to setup queue channel", "error", err)
-		os.Exit(1)
+	logger.Info("Starting consumer")
+
+	// Cancelling ctx cancels the broker consumer, so no new deliveries arrive
+	// while the ones already received are drained.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dataHandler := handler.NewHandler(pipeline, sensorParser, logger)
+
+	if config.Consumer.BatchSize > 1 {
+		err = dataConsumer.StartBatch(ctx, queue, dataHandler.HandleBatch)
+	} else {
+		err = dataConsumer.Start(ctx, queue, dataHandler.Handle)
 	}
 
-	logger.Info("Starting consumer")
+	if err != nil {
+		logger.Error("Failed to start consumer", "error", err)
+		os.Exit(1)
+	}
 
-	if err := consumer.Start(context.Background(), queue, func(delivery amqp.Delivery) error {
-		logger.Debug("Received message", "message", string(delivery.Body))
+	var timeSyncConsumer *consumer.Consumer
 
-		sensorData, err := parser.ParseMessage(delivery.Body)
+	if config.TimeSync.Enabled && !timeSyncSupported(messageBroker) {
+		logger.Warn("Time sync is only supported with the rabbitmq and mqtt brokers, device time requests are not answered", "broker", config.Broker)
+	} else if config.TimeSync.Enabled {
+		timeSyncQueue, exchange, err := setupTimeSyncQueue(config, messageBroker)
 		if err != nil {
-			logger.Error("Failed to parse message", "error", err, "message", string(delivery.Body))
-			return err
+			logger.Error("Failed to set up time sync", "error", err)
+			os.Exit(1)
 		}
 
-		if err := db.InsertSensorData(sensorData); err != nil {
-			logger.Error("Failed to insert sensor data", "error", err)
-			return err
+		responder := timesync.NewResponder(messageBroker, exchange, config.TimeSync.ResponseTopic, logger)
+		timeSyncConsumer = consumer.NewConsumer(messageBroker, logger, "time-sync-consumer",
+			consumer.WithPrefetchCount(config.Consumer.PrefetchCount),
+		)
+
+		if err := timeSyncConsumer.Start(ctx, timeSyncQueue, responder.Handle); err != nil {
+			logger.Error("Failed to start time sync consumer", "error", err)
+			os.Exit(1)
 		}
 
-		logger.Info("Inserted sensor data", "data", sensorData)
-		return nil
-	}); err != nil {
-		logger.Error("Failed to start consumer", "error", err)
-		os.Exit(1)
+		logger.Info("Answering device time requests", "queue", timeSyncQueue.GetName(), "response_topic", config.TimeSync.ResponseTopic)
 	}
 
 	c := make(chan os.Signal, 1)
@@ -107,5 +139,36 @@ func main() {
 
 	logger.Info("Data worker is running. Press Ctrl+C to stop.")
 	<-c
-	logger.Info("Shutting down data worker")
+	logger.Info("Shutting down data worker", "timeout", config.ShutdownTimeout())
+
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer shutdownCancel()
+
+	if err := dataConsumer.Wait(shutdownCtx); err != nil {
+		logger.Warn("In-flight messages were not drained before the shutdown timeout, they will be redelivered", "error", err)
+	}
+
+	if timeSyncConsumer != nil {
+		if err := timeSyncConsumer.Wait(shutdownCtx); err != nil {
+			logger.Warn("In-flight time requests were not answered before the shutdown timeout", "error", err)
+		}
+	}
+
+	logger.Debug("Flushing buffered sensor data")
+
+	if err := pipeline.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to flush buffered sensor data", "error", err)
+	}
+
+	if err := messageBroker.Close(); err != nil {
+		logger.Error("Failed to close broker connection", "error", err)
+	}
+
+	if err := metricsServer.Close(); err != nil {
+		logger.Error("Failed to close metrics server", "error", err)
+	}
+
+	logger.Info("Data worker stopped")
 }
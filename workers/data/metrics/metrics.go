@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Server struct {
+	registry   *prometheus.Registry
+	logger     logger.Interface
+	mux        *http.ServeMux
+	httpServer *http.Server
+
+	DuplicatesDropped prometheus.Counter
+	SinkFailures      *prometheus.CounterVec
+	SinkDropped       *prometheus.CounterVec
+	InvalidReadings   *prometheus.CounterVec
+	ClockSkew         *prometheus.GaugeVec
+}
+
+func NewServer(logger logger.Interface, listenAddress string) *Server {
+	registry := prometheus.NewRegistry()
+
+	duplicatesDropped := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "iot_sensor_data_duplicates_dropped_total",
+		Help: "Sensor data rows dropped because the same device, time and message was already stored",
+	})
+
+	sinkFailures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_sensor_data_sink_failures_total",
+		Help: "Sensor data rows a destination failed to store",
+	}, []string{"sink"})
+
+	sinkDropped := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_sensor_data_sink_dropped_total",
+		Help: "Sensor data rows dropped because an optional destination was falling behind",
+	}, []string{"sink"})
+
+	invalidReadings := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "iot_sensor_data_invalid_total",
+		Help: "Sensor data readings failing a validation rule, by rule and by whether they were flagged or rejected",
+	}, []string{"reason", "action"})
+
+	clockSkew := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "iot_device_clock_skew_seconds",
+		Help: "Estimated offset of the device clock from the time its readings reach the broker, positive when the device clock is behind",
+	}, []string{"device_id"})
+
+	registry.MustRegister(duplicatesDropped, sinkFailures, sinkDropped, invalidReadings, clockSkew)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: mux,
+	}
+
+	return &Server{
+		registry:          registry,
+		logger:            logger,
+		mux:               mux,
+		httpServer:        server,
+		DuplicatesDropped: duplicatesDropped,
+		SinkFailures:      sinkFailures,
+		SinkDropped:       sinkDropped,
+		InvalidReadings:   invalidReadings,
+		ClockSkew:         clockSkew,
+	}
+}
+
+// Handle registers an extra handler, such as a health check, on the metrics
+// HTTP server.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Start() error {
+	go func() {
+		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Failed to start metrics HTTP server", "error", err)
+		}
+	}()
+
+	s.logger.Info("Metrics server started", "address", s.httpServer.Addr)
+	return nil
+}
+
+func (s *Server) Close() error {
+	if s.httpServer != nil {
+		return s.httpServer.Close()
+	}
+	return nil
+}
+
+func (s *Server) GetMetricsEndpoint() string {
+	return fmt.Sprintf("http://%s/metrics", s.httpServer.Addr)
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_sensor_data_device_id_time;
+
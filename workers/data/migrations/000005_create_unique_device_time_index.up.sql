@@ -0,0 +1,10 @@
+-- Remove rows stored more than once by redelivered messages before enforcing
+-- uniqueness. Duplicates share the same time, so they live in the same chunk.
+DELETE FROM sensor_data a
+	USING sensor_data b
+	WHERE a.device_id = b.device_id
+		AND a.time = b.time
+		AND a.ctid < b.ctid;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sensor_data_device_id_time ON sensor_data (device_id, time);
+
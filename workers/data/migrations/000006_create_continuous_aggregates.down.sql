@@ -0,0 +1,3 @@
+DROP MATERIALIZED VIEW IF EXISTS sensor_data_1d;
+DROP MATERIALIZED VIEW IF EXISTS sensor_data_1h;
+DROP MATERIALIZED VIEW IF EXISTS sensor_data_1m;
@@ -0,0 +1,69 @@
+-- Per-device aggregates of sensor_data. Every view is computed from the raw
+-- rows, so the averages are exact. Refresh windows end before the current
+-- bucket and start well inside the retention period, so dropping old chunks
+-- never erases materialized buckets.
+CREATE MATERIALIZED VIEW IF NOT EXISTS sensor_data_1m
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 minute', time) AS bucket,
+	device_id,
+	count(*) AS readings,
+	min(humidity) AS min_humidity,
+	max(humidity) AS max_humidity,
+	avg(humidity) AS avg_humidity,
+	min(temperature) AS min_temperature,
+	max(temperature) AS max_temperature,
+	avg(temperature) AS avg_temperature
+FROM sensor_data
+GROUP BY bucket, device_id
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS sensor_data_1h
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 hour', time) AS bucket,
+	device_id,
+	count(*) AS readings,
+	min(humidity) AS min_humidity,
+	max(humidity) AS max_humidity,
+	avg(humidity) AS avg_humidity,
+	min(temperature) AS min_temperature,
+	max(temperature) AS max_temperature,
+	avg(temperature) AS avg_temperature
+FROM sensor_data
+GROUP BY bucket, device_id
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS sensor_data_1d
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 day', time) AS bucket,
+	device_id,
+	count(*) AS readings,
+	min(humidity) AS min_humidity,
+	max(humidity) AS max_humidity,
+	avg(humidity) AS avg_humidity,
+	min(temperature) AS min_temperature,
+	max(temperature) AS max_temperature,
+	avg(temperature) AS avg_temperature
+FROM sensor_data
+GROUP BY bucket, device_id
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('sensor_data_1m',
+	start_offset => INTERVAL '1 hour',
+	end_offset => INTERVAL '1 minute',
+	schedule_interval => INTERVAL '1 minute',
+	if_not_exists => TRUE);
+
+SELECT add_continuous_aggregate_policy('sensor_data_1h',
+	start_offset => INTERVAL '1 day',
+	end_offset => INTERVAL '1 hour',
+	schedule_interval => INTERVAL '30 minutes',
+	if_not_exists => TRUE);
+
+SELECT add_continuous_aggregate_policy('sensor_data_1d',
+	start_offset => INTERVAL '3 days',
+	end_offset => INTERVAL '1 day',
+	schedule_interval => INTERVAL '1 hour',
+	if_not_exists => TRUE);
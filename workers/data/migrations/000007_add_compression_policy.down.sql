@@ -0,0 +1,6 @@
+SELECT remove_compression_policy('sensor_data', if_exists => TRUE);
+
+SELECT decompress_chunk(chunk, if_compressed => TRUE)
+	FROM show_chunks('sensor_data') AS chunk;
+
+ALTER TABLE sensor_data SET (timescaledb.compress = FALSE);
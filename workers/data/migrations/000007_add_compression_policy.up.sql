@@ -0,0 +1,10 @@
+-- Compressed chunks keep the rows of a device together, ordered like the
+-- queries read them. Late rows can still be inserted into them.
+ALTER TABLE sensor_data SET (
+	timescaledb.compress,
+	timescaledb.compress_segmentby = 'device_id',
+	timescaledb.compress_orderby = 'time DESC'
+);
+
+-- The interval is replaced on startup by timescaledb_policies.compress_after.
+SELECT add_compression_policy('sensor_data', INTERVAL '7 days', if_not_exists => TRUE);
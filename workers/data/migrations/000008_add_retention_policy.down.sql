@@ -0,0 +1 @@
+SELECT remove_retention_policy('sensor_data', if_exists => TRUE);
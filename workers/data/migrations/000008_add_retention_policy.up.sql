@@ -0,0 +1,3 @@
+-- Only raw rows are dropped, the continuous aggregates keep their buckets.
+-- The interval is replaced on startup by timescaledb_policies.retention.
+SELECT add_retention_policy('sensor_data', INTERVAL '365 days', if_not_exists => TRUE);
@@ -0,0 +1,3 @@
+ALTER MATERIALIZED VIEW sensor_data_1m SET (timescaledb.materialized_only = TRUE);
+ALTER MATERIALIZED VIEW sensor_data_1h SET (timescaledb.materialized_only = TRUE);
+ALTER MATERIALIZED VIEW sensor_data_1d SET (timescaledb.materialized_only = TRUE);
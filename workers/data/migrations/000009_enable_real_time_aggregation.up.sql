@@ -0,0 +1,5 @@
+-- Combine the materialized buckets with the raw rows not refreshed yet, so
+-- queries of the continuous aggregates include the latest readings.
+ALTER MATERIALIZED VIEW sensor_data_1m SET (timescaledb.materialized_only = FALSE);
+ALTER MATERIALIZED VIEW sensor_data_1h SET (timescaledb.materialized_only = FALSE);
+ALTER MATERIALIZED VIEW sensor_data_1d SET (timescaledb.materialized_only = FALSE);
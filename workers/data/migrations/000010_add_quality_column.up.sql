@@ -0,0 +1,4 @@
+-- Bitmask of the validation rules a reading failed, 0 for good readings.
+-- Compressed hypertables only accept new columns without constraints, so the
+-- column is nullable, but every row gets the default.
+ALTER TABLE sensor_data ADD COLUMN IF NOT EXISTS quality SMALLINT DEFAULT 0;
@@ -0,0 +1,10 @@
+-- Readings sharing a device and time are merged back into the first one.
+DELETE FROM sensor_data a
+	USING sensor_data b
+	WHERE a.device_id = b.device_id
+		AND a.time = b.time
+		AND a.ctid < b.ctid;
+
+DROP INDEX IF EXISTS idx_sensor_data_device_id_time_message_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sensor_data_device_id_time ON sensor_data (device_id, time);
+ALTER TABLE sensor_data DROP COLUMN IF EXISTS message_id;
@@ -0,0 +1,8 @@
+-- Readings were unique on device and time, so two readings of a device taken
+-- in the same second were stored once. Redeliveries are now told apart by the
+-- message they came from. Compressed hypertables only accept new columns
+-- without constraints, so the column is nullable, but every row gets the
+-- default.
+ALTER TABLE sensor_data ADD COLUMN IF NOT EXISTS message_id TEXT DEFAULT '';
+DROP INDEX IF EXISTS idx_sensor_data_device_id_time;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sensor_data_device_id_time_message_id ON sensor_data (device_id, time, message_id);
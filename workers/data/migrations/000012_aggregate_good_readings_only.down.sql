@@ -0,0 +1,75 @@
+-- The aggregates summarize every reading again, flagged or not. They are
+-- recreated empty, call refresh_continuous_aggregate to fill in the past.
+DROP MATERIALIZED VIEW IF EXISTS sensor_data_1d;
+DROP MATERIALIZED VIEW IF EXISTS sensor_data_1h;
+DROP MATERIALIZED VIEW IF EXISTS sensor_data_1m;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS sensor_data_1m
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 minute', time) AS bucket,
+	device_id,
+	count(*) AS readings,
+	min(humidity) AS min_humidity,
+	max(humidity) AS max_humidity,
+	avg(humidity) AS avg_humidity,
+	min(temperature) AS min_temperature,
+	max(temperature) AS max_temperature,
+	avg(temperature) AS avg_temperature
+FROM sensor_data
+GROUP BY bucket, device_id
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS sensor_data_1h
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 hour', time) AS bucket,
+	device_id,
+	count(*) AS readings,
+	min(humidity) AS min_humidity,
+	max(humidity) AS max_humidity,
+	avg(humidity) AS avg_humidity,
+	min(temperature) AS min_temperature,
+	max(temperature) AS max_temperature,
+	avg(temperature) AS avg_temperature
+FROM sensor_data
+GROUP BY bucket, device_id
+WITH NO DATA;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS sensor_data_1d
+WITH (timescaledb.continuous) AS
+SELECT
+	time_bucket(INTERVAL '1 day', time) AS bucket,
+	device_id,
+	count(*) AS readings,
+	min(humidity) AS min_humidity,
+	max(humidity) AS max_humidity,
+	avg(humidity) AS avg_humidity,
+	min(temperature) AS min_temperature,
+	max(temperature) AS max_temperature,
+	avg(temperature) AS avg_temperature
+FROM sensor_data
+GROUP BY bucket, device_id
+WITH NO DATA;
+
+SELECT add_continuous_aggregate_policy('sensor_data_1m',
+	start_offset => INTERVAL '1 hour',
+	end_offset => INTERVAL '1 minute',
+	schedule_interval => INTERVAL '1 minute',
+	if_not_exists => TRUE);
+
+SELECT add_continuous_aggregate_policy('sensor_data_1h',
+	start_offset => INTERVAL '1 day',
+	end_offset => INTERVAL '1 hour',
+	schedule_interval => INTERVAL '30 minutes',
+	if_not_exists => TRUE);
+
+SELECT add_continuous_aggregate_policy('sensor_data_1d',
+	start_offset => INTERVAL '3 days',
+	end_offset => INTERVAL '1 day',
+	schedule_interval => INTERVAL '1 hour',
+	if_not_exists => TRUE);
+
+ALTER MATERIALIZED VIEW sensor_data_1m SET (timescaledb.materialized_only = FALSE);
+ALTER MATERIALIZED VIEW sensor_data_1h SET (timescaledb.materialized_only = FALSE);
+ALTER MATERIALIZED VIEW sensor_data_1d SET (timescaledb.materialized_only = FALSE);
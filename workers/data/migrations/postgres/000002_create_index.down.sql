@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_sensor_data_device_id;
+
+DROP INDEX IF EXISTS idx_sensor_data_time;
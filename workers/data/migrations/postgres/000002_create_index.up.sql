@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_sensor_data_time ON sensor_data (time DESC);
+
+CREATE INDEX IF NOT EXISTS idx_sensor_data_device_id ON sensor_data (device_id);
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sensor_data_device_id_time ON sensor_data (device_id, time);
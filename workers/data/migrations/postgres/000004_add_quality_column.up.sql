@@ -0,0 +1,2 @@
+-- Bitmask of the validation rules a reading failed, 0 for good readings.
+ALTER TABLE sensor_data ADD COLUMN IF NOT EXISTS quality SMALLINT NOT NULL DEFAULT 0;
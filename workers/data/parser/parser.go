@@ -1,37 +1,55 @@
 package parser
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"time"
 
 	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
 	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
 	"google.golang.org/protobuf/proto"
 )
 
+// ParseMessage decodes a sensor data message without validating it.
+// Timestamps are truncated to database.TimestampPrecision, and a missing one
+// is left zero, since the current time would differ between redeliveries.
 func ParseMessage(body []byte) (database.SensorData, error) {
-	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	sensorData, err := decode(body)
 	if err != nil {
-		return database.SensorData{}, fmt.Errorf("failed to decode base64 message: %w", err)
+		return database.SensorData{}, err
 	}
 
-	var sensorData protosensor.SensorData
-	if err := proto.Unmarshal(decoded, &sensorData); err != nil {
-		return database.SensorData{}, fmt.Errorf("failed to parse protobuf message: %w", err)
-	}
-
-	var timestamp time.Time
-	if sensorData.Timestamp > 0 {
-		timestamp = time.Unix(sensorData.Timestamp, 0)
-	} else {
-		timestamp = time.Now()
-	}
+	timestamp, _ := sensorData.ReadingTime()
 
 	return database.SensorData{
 		DeviceID:    sensorData.SensorId,
-		Timestamp:   timestamp,
+		Timestamp:   timestamp.Truncate(database.TimestampPrecision),
+		MessageID:   MessageID(body),
 		Humidity:    sensorData.Humidity,
 		Temperature: sensorData.Temperature,
 	}, nil
 }
+
+// MessageID identifies a message by the SHA-256 of its payload. Redeliveries
+// and publish retries of a message share it, while distinct readings of a
+// device taken at the same time differ in their values or in the sub-second
+// part of their time.
+func MessageID(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:16])
+}
+
+func decode(body []byte) (*protosensor.SensorData, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 message: %w", err)
+	}
+
+	var sensorData protosensor.SensorData
+	if err := proto.Unmarshal(decoded, &sensorData); err != nil {
+		return nil, fmt.Errorf("failed to parse protobuf message: %w", err)
+	}
+
+	return &sensorData, nil
+}
@@ -0,0 +1,272 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// Modes of a Parser, deciding what happens to readings failing a rule.
+const (
+	// ModeOff accepts every reading that decodes.
+	ModeOff = "off"
+	// ModeFlag stores readings failing a rule with their quality flags.
+	ModeFlag = "flag"
+	// ModeReject returns a *ValidationError for readings failing a rule.
+	ModeReject = "reject"
+)
+
+// Metric names of the readings, used as keys of Rules.Ranges.
+const (
+	MetricHumidity    = "humidity"
+	MetricTemperature = "temperature"
+)
+
+// ReasonNotFinite is reported for NaN and infinite values, which are rejected
+// in every mode but ModeOff since no destination can store them.
+const ReasonNotFinite = "not_finite"
+
+// Range bounds the values of a metric. A nil bound is open.
+type Range struct {
+	Min *float64
+	Max *float64
+}
+
+// Rules are the checks a Parser applies to every reading.
+type Rules struct {
+	Mode             string
+	RequireSensorID  bool
+	RequireTimestamp bool
+	// MaxPastSkew and MaxFutureSkew bound how far a timestamp may be from
+	// the time the reading is received. Zero disables the bound.
+	MaxPastSkew   time.Duration
+	MaxFutureSkew time.Duration
+	Ranges        map[string]Range
+}
+
+// ErrNoBrokerTime is returned by Parse, in every mode, for a reading without
+// a timestamp in a message without a broker time. Its time would be the time
+// it was parsed, which differs for every redelivery, so the same reading
+// would be stored again each time.
+var ErrNoBrokerTime = errors.New("sensor data has no timestamp and the message has no broker time")
+
+// ValidationError is returned by Parse in ModeReject for a reading failing a
+// rule. Quality has the flags of the failed rules.
+type ValidationError struct {
+	Quality database.Quality
+	Reasons []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid sensor data: %s", strings.Join(e.Reasons, ", "))
+}
+
+type Options struct {
+	invalid func(reason string) database.Counter
+	skew    *clockskew.Tracker
+}
+
+type Option func(*Options)
+
+// WithInvalidCounter sets a function returning the counter incremented for
+// every reading failing a rule, by the name of the rule: a quality flag name
+// or ReasonNotFinite.
+func WithInvalidCounter(invalid func(reason string) database.Counter) Option {
+	return func(options *Options) {
+		options.invalid = invalid
+	}
+}
+
+// WithSkewTracker sets the tracker estimating the clock skew of every
+// device. Readings of a skewed device are flagged with QualityClockSkew, or
+// corrected and flagged with QualityTimestampCorrected, in every mode.
+func WithSkewTracker(tracker *clockskew.Tracker) Option {
+	return func(options *Options) {
+		options.skew = tracker
+	}
+}
+
+// Parser decodes sensor data messages and validates them against its rules.
+type Parser struct {
+	rules   Rules
+	options *Options
+}
+
+func NewParser(rules Rules, options ...Option) (*Parser, error) {
+	defaultOptions := &Options{
+		invalid: nil,
+		skew:    nil,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	switch rules.Mode {
+	case ModeOff, ModeFlag, ModeReject:
+	default:
+		return nil, fmt.Errorf("unknown validation mode %q, expected off, flag or reject", rules.Mode)
+	}
+
+	for metric, r := range rules.Ranges {
+		if metric != MetricHumidity && metric != MetricTemperature {
+			return nil, fmt.Errorf("unknown metric %q in validation ranges, expected humidity or temperature", metric)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return nil, fmt.Errorf("validation range of %s has min %g above max %g", metric, *r.Min, *r.Max)
+		}
+	}
+
+	return &Parser{
+		rules:   rules,
+		options: defaultOptions,
+	}, nil
+}
+
+// Parse decodes a sensor data message that reached the broker at the given
+// time, the clockskew.BrokerTime of the message or the zero time when it has
+// none, and validates it. A missing timestamp is replaced by the time
+// received and flagged with QualityMissingTimestamp, so redeliveries get the
+// same time, and timestamps are truncated to database.TimestampPrecision. Readings failing a rule are returned
+// with their quality flags in ModeFlag, or as a *ValidationError in
+// ModeReject, as are readings with values that are not finite in both modes.
+func (p *Parser) Parse(body []byte, received time.Time) (database.SensorData, error) {
+	sensorData, err := decode(body)
+	if err != nil {
+		return database.SensorData{}, err
+	}
+
+	timestamp, hasTimestamp := sensorData.ReadingTime()
+
+	if received.IsZero() {
+		if !hasTimestamp {
+			return database.SensorData{}, ErrNoBrokerTime
+		}
+
+		received = time.Now()
+	}
+
+	data := database.SensorData{
+		DeviceID:    sensorData.SensorId,
+		Timestamp:   received,
+		Humidity:    sensorData.Humidity,
+		Temperature: sensorData.Temperature,
+		MessageID:   MessageID(body),
+	}
+
+	if hasTimestamp {
+		data.Timestamp = timestamp
+		p.adjust(&data, received)
+	}
+
+	data.Timestamp = data.Timestamp.Truncate(database.TimestampPrecision)
+
+	if p.rules.Mode == ModeOff {
+		return data, nil
+	}
+
+	var failed database.Quality
+	var reasons []string
+
+	fail := func(flag database.Quality, reason string) {
+		failed |= flag
+		reasons = append(reasons, reason)
+	}
+
+	if data.DeviceID == "" && p.rules.RequireSensorID {
+		fail(database.QualityMissingSensorID, "sensor_id is required")
+	}
+
+	if hasTimestamp {
+		if skew := received.Sub(data.Timestamp); p.rules.MaxPastSkew > 0 && skew > p.rules.MaxPastSkew {
+			fail(database.QualityClockSkew, fmt.Sprintf("timestamp is %s behind the server time", skew.Round(time.Second)))
+		} else if p.rules.MaxFutureSkew > 0 && -skew > p.rules.MaxFutureSkew {
+			fail(database.QualityClockSkew, fmt.Sprintf("timestamp is %s ahead of the server time", (-skew).Round(time.Second)))
+		}
+	} else {
+		data.Quality |= database.QualityMissingTimestamp
+
+		if p.rules.RequireTimestamp {
+			fail(database.QualityMissingTimestamp, "timestamp is required")
+		}
+	}
+
+	var notFinite []string
+
+	for _, v := range []struct {
+		metric string
+		value  float32
+	}{
+		{MetricHumidity, data.Humidity},
+		{MetricTemperature, data.Temperature},
+	} {
+		value := float64(v.value)
+
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			notFinite = append(notFinite, fmt.Sprintf("%s is %v", v.metric, value))
+			continue
+		}
+
+		r, ok := p.rules.Ranges[v.metric]
+		if !ok {
+			continue
+		}
+
+		if r.Min != nil && value < *r.Min {
+			fail(database.QualityOutOfRange, fmt.Sprintf("%s %g is below %g", v.metric, value, *r.Min))
+		} else if r.Max != nil && value > *r.Max {
+			fail(database.QualityOutOfRange, fmt.Sprintf("%s %g is above %g", v.metric, value, *r.Max))
+		}
+	}
+
+	p.count(failed, len(notFinite) > 0)
+
+	if len(notFinite) > 0 {
+		return database.SensorData{}, &ValidationError{Quality: failed, Reasons: append(notFinite, reasons...)}
+	}
+
+	if failed != 0 && p.rules.Mode == ModeReject {
+		return database.SensorData{}, &ValidationError{Quality: failed, Reasons: reasons}
+	}
+
+	data.Quality |= failed
+
+	return data, nil
+}
+
+// adjust observes the skew of the device clock, flagging or correcting the
+// timestamp of a skewed device.
+func (p *Parser) adjust(data *database.SensorData, received time.Time) {
+	if p.options.skew == nil {
+		return
+	}
+
+	result := p.options.skew.Observe(data.DeviceID, data.Timestamp, received)
+	data.Timestamp = result.Timestamp
+
+	switch {
+	case result.Corrected:
+		data.Quality |= database.QualityTimestampCorrected
+	case result.Skewed:
+		data.Quality |= database.QualityClockSkew
+	}
+}
+
+func (p *Parser) count(failed database.Quality, notFinite bool) {
+	if p.options.invalid == nil {
+		return
+	}
+
+	if notFinite {
+		p.options.invalid(ReasonNotFinite).Add(1)
+	}
+
+	for _, reason := range failed.Names() {
+		p.options.invalid(reason).Add(1)
+	}
+}
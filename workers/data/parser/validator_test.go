@@ -0,0 +1,424 @@
+package parser
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+type mockCounter struct {
+	value float64
+}
+
+func (m *mockCounter) Add(v float64) {
+	m.value += v
+}
+
+func encode(t *testing.T, data *protosensor.SensorData) []byte {
+	t.Helper()
+
+	body, err := proto.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(body))
+}
+
+func bound(v float64) *float64 {
+	return &v
+}
+
+func reading(mutate func(*protosensor.SensorData)) *protosensor.SensorData {
+	data := &protosensor.SensorData{
+		SensorId:    "device-1",
+		Humidity:    40,
+		Temperature: 21.5,
+		Timestamp:   now.Add(-time.Minute).Unix(),
+	}
+	if mutate != nil {
+		mutate(data)
+	}
+	return data
+}
+
+func TestParser_Parse(t *testing.T) {
+	rules := Rules{
+		RequireSensorID: true,
+		MaxPastSkew:     24 * time.Hour,
+		MaxFutureSkew:   5 * time.Minute,
+		Ranges: map[string]Range{
+			MetricHumidity:    {Min: bound(0), Max: bound(100)},
+			MetricTemperature: {Min: bound(-40), Max: bound(85)},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		data        *protosensor.SensorData
+		require     bool
+		wantQuality database.Quality
+		wantReject  bool
+		alwaysFails bool
+	}{
+		{
+			name: "valid",
+			data: reading(nil),
+		},
+		{
+			name:        "humidity above range",
+			data:        reading(func(d *protosensor.SensorData) { d.Humidity = 300 }),
+			wantQuality: database.QualityOutOfRange,
+			wantReject:  true,
+		},
+		{
+			name:        "temperature below range",
+			data:        reading(func(d *protosensor.SensorData) { d.Temperature = -500 }),
+			wantQuality: database.QualityOutOfRange,
+			wantReject:  true,
+		},
+		{
+			name:        "missing sensor id",
+			data:        reading(func(d *protosensor.SensorData) { d.SensorId = "" }),
+			wantQuality: database.QualityMissingSensorID,
+			wantReject:  true,
+		},
+		{
+			name:        "missing timestamp",
+			data:        reading(func(d *protosensor.SensorData) { d.Timestamp = 0 }),
+			wantQuality: database.QualityMissingTimestamp,
+		},
+		{
+			name:        "required timestamp",
+			data:        reading(func(d *protosensor.SensorData) { d.Timestamp = 0 }),
+			require:     true,
+			wantQuality: database.QualityMissingTimestamp,
+			wantReject:  true,
+		},
+		{
+			name:        "timestamp too old",
+			data:        reading(func(d *protosensor.SensorData) { d.Timestamp = now.Add(-48 * time.Hour).Unix() }),
+			wantQuality: database.QualityClockSkew,
+			wantReject:  true,
+		},
+		{
+			name:        "timestamp in the future",
+			data:        reading(func(d *protosensor.SensorData) { d.Timestamp = now.Add(time.Hour).Unix() }),
+			wantQuality: database.QualityClockSkew,
+			wantReject:  true,
+		},
+		{
+			name:        "several rules",
+			data:        reading(func(d *protosensor.SensorData) { d.SensorId = ""; d.Humidity = -1 }),
+			wantQuality: database.QualityMissingSensorID | database.QualityOutOfRange,
+			wantReject:  true,
+		},
+		{
+			name:        "nan",
+			data:        reading(func(d *protosensor.SensorData) { d.Temperature = float32(math.NaN()) }),
+			alwaysFails: true,
+		},
+		{
+			name:        "infinity",
+			data:        reading(func(d *protosensor.SensorData) { d.Humidity = float32(math.Inf(1)) }),
+			alwaysFails: true,
+		},
+	}
+
+	for _, mode := range []string{ModeFlag, ModeReject} {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				r := rules
+				r.Mode = mode
+				r.RequireTimestamp = tt.require
+
+				counter := &mockCounter{}
+				p, err := NewParser(r,
+					WithInvalidCounter(func(string) database.Counter { return counter }),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				data, err := p.Parse(encode(t, tt.data), now)
+
+				var validationErr *ValidationError
+				wantErr := tt.alwaysFails || (mode == ModeReject && tt.wantReject)
+
+				if wantErr {
+					if !errors.As(err, &validationErr) {
+						t.Fatalf("Parse() error = %v, want *ValidationError", err)
+					}
+					if counter.value == 0 {
+						t.Error("Parse() did not count the invalid reading")
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("Parse() error = %v", err)
+				}
+				if data.Quality != tt.wantQuality {
+					t.Errorf("Parse() quality = %v, want %v", data.Quality, tt.wantQuality)
+				}
+				if data.DeviceID != tt.data.SensorId || data.Humidity != tt.data.Humidity {
+					t.Errorf("Parse() = %+v, want the decoded reading", data)
+				}
+				if tt.data.Timestamp == 0 && !data.Timestamp.Equal(now) {
+					t.Errorf("Parse() timestamp = %v, want the server time %v", data.Timestamp, now)
+				}
+			})
+		}
+	}
+}
+
+func TestParser_Parse_Off(t *testing.T) {
+	p, err := NewParser(Rules{Mode: ModeOff, RequireSensorID: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := p.Parse(encode(t, reading(func(d *protosensor.SensorData) { d.SensorId = ""; d.Humidity = float32(math.NaN()) })), now)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !data.Quality.Good() {
+		t.Errorf("Parse() quality = %v, want good", data.Quality)
+	}
+
+	if _, err := p.Parse([]byte("not base64!"), now); err == nil {
+		t.Error("Parse() accepted a malformed message")
+	}
+}
+
+func TestParser_Parse_Redelivery(t *testing.T) {
+	withoutTimestamp := encode(t, reading(func(d *protosensor.SensorData) { d.Timestamp = 0 }))
+
+	for _, mode := range []string{ModeOff, ModeFlag, ModeReject} {
+		t.Run(mode, func(t *testing.T) {
+			p, err := NewParser(Rules{Mode: mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// A redelivery carries the same body and broker time.
+			first, err := p.Parse(withoutTimestamp, now)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			second, err := p.Parse(withoutTimestamp, now)
+			if err != nil {
+				t.Fatalf("Parse() again error = %v", err)
+			}
+
+			if !first.Timestamp.Equal(now) || first.Timestamp != second.Timestamp || first.MessageID != second.MessageID {
+				t.Errorf("Parse() = %v %s, then %v %s, want both at %v with the same message ID",
+					first.Timestamp, first.MessageID, second.Timestamp, second.MessageID, now)
+			}
+
+			// Without a broker time it would get the parsing time instead.
+			if _, err := p.Parse(withoutTimestamp, time.Time{}); !errors.Is(err, ErrNoBrokerTime) {
+				t.Errorf("Parse() without a broker time error = %v, want %v", err, ErrNoBrokerTime)
+			}
+
+			if _, err := p.Parse(encode(t, reading(nil)), time.Time{}); err != nil {
+				t.Errorf("Parse() of a timestamped reading without a broker time error = %v", err)
+			}
+		})
+	}
+}
+
+func TestParseMessage_Redelivery(t *testing.T) {
+	body := encode(t, reading(func(d *protosensor.SensorData) { d.Timestamp = 0 }))
+
+	first, err := ParseMessage(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := ParseMessage(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second || !first.Timestamp.IsZero() {
+		t.Errorf("ParseMessage() = %+v, then %+v, want the same reading without a timestamp", first, second)
+	}
+}
+
+func TestParser_Parse_SkewTracker(t *testing.T) {
+	tests := []struct {
+		name          string
+		mode          string
+		validation    string
+		wantQuality   database.Quality
+		wantTimestamp time.Time
+	}{
+		{
+			name:          "flag",
+			mode:          clockskew.ModeFlag,
+			validation:    ModeFlag,
+			wantQuality:   database.QualityClockSkew,
+			wantTimestamp: now.Add(-time.Hour),
+		},
+		{
+			name:          "correct",
+			mode:          clockskew.ModeCorrect,
+			validation:    ModeFlag,
+			wantQuality:   database.QualityTimestampCorrected,
+			wantTimestamp: now,
+		},
+		{
+			name:          "correct without validation",
+			mode:          clockskew.ModeCorrect,
+			validation:    ModeOff,
+			wantQuality:   database.QualityTimestampCorrected,
+			wantTimestamp: now,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker, err := clockskew.NewTracker(clockskew.WithMode(tt.mode), clockskew.WithMinSamples(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p, err := NewParser(Rules{Mode: tt.validation}, WithSkewTracker(tracker))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := p.Parse(encode(t, reading(func(d *protosensor.SensorData) { d.Timestamp = now.Add(-time.Hour).Unix() })), now)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if data.Quality != tt.wantQuality {
+				t.Errorf("Parse() quality = %v, want %v", data.Quality, tt.wantQuality)
+			}
+			if !data.Timestamp.Equal(tt.wantTimestamp) {
+				t.Errorf("Parse() timestamp = %v, want %v", data.Timestamp, tt.wantTimestamp)
+			}
+		})
+	}
+}
+
+func TestParser_Parse_MixedClients(t *testing.T) {
+	readAt := now.Add(-time.Minute).Add(123456789 * time.Nanosecond)
+
+	tests := []struct {
+		name          string
+		data          *protosensor.SensorData
+		wantTimestamp time.Time
+		wantQuality   database.Quality
+	}{
+		{
+			name:          "seconds from an older client",
+			data:          reading(func(d *protosensor.SensorData) { d.Timestamp = readAt.Unix() }),
+			wantTimestamp: readAt.Truncate(time.Second),
+		},
+		{
+			name:          "time truncated to microseconds",
+			data:          reading(func(d *protosensor.SensorData) { d.SetReadingTime(readAt) }),
+			wantTimestamp: readAt.Truncate(time.Microsecond),
+		},
+		{
+			name: "time without seconds",
+			data: reading(func(d *protosensor.SensorData) {
+				d.Timestamp = 0
+				d.Time = timestamppb.New(readAt)
+			}),
+			wantTimestamp: readAt.Truncate(time.Microsecond),
+		},
+		{
+			name:          "neither",
+			data:          reading(func(d *protosensor.SensorData) { d.Timestamp = 0 }),
+			wantTimestamp: now,
+			wantQuality:   database.QualityMissingTimestamp,
+		},
+	}
+
+	for _, mode := range []string{ModeOff, ModeFlag} {
+		p, err := NewParser(Rules{Mode: mode, MaxPastSkew: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				data, err := p.Parse(encode(t, tt.data), now)
+				if err != nil {
+					t.Fatalf("Parse() error = %v", err)
+				}
+				if !data.Timestamp.Equal(tt.wantTimestamp) {
+					t.Errorf("Parse() timestamp = %v, want %v", data.Timestamp, tt.wantTimestamp)
+				}
+				// ModeOff does not flag readings.
+				if mode == ModeFlag && data.Quality != tt.wantQuality {
+					t.Errorf("Parse() quality = %v, want %v", data.Quality, tt.wantQuality)
+				}
+			})
+		}
+	}
+}
+
+func TestParser_Parse_MessageID(t *testing.T) {
+	p, err := NewParser(Rules{Mode: ModeFlag})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readings of an older client only carry whole seconds.
+	first := encode(t, reading(nil))
+	second := encode(t, reading(func(d *protosensor.SensorData) { d.Humidity = 41 }))
+
+	parse := func(body []byte) database.SensorData {
+		data, err := p.Parse(body, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	a, b, redelivered := parse(first), parse(second), parse(first)
+
+	if !a.Timestamp.Equal(b.Timestamp) {
+		t.Fatalf("timestamps = %v and %v, want the same second", a.Timestamp, b.Timestamp)
+	}
+	if a.MessageID == "" || a.MessageID == b.MessageID {
+		t.Errorf("message IDs = %q and %q, want distinct readings told apart", a.MessageID, b.MessageID)
+	}
+	if redelivered.MessageID != a.MessageID {
+		t.Errorf("redelivered message ID = %q, want %q", redelivered.MessageID, a.MessageID)
+	}
+}
+
+func TestNewParser_InvalidRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+	}{
+		{name: "unknown mode", rules: Rules{Mode: "drop"}},
+		{name: "unknown metric", rules: Rules{Mode: ModeFlag, Ranges: map[string]Range{"pressure": {}}}},
+		{name: "min above max", rules: Rules{Mode: ModeFlag, Ranges: map[string]Range{MetricHumidity: {Min: bound(100), Max: bound(0)}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewParser(tt.rules); err == nil {
+				t.Error("NewParser() error = nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+var ErrPipelineClosed = errors.New("sink pipeline closed")
+
+// Filter reports whether a reading is routed to a sink.
+type Filter func(row database.SensorData) bool
+
+// DeviceFilter routes the readings of devices matching any of the path.Match
+// patterns, such as "greenhouse-*". Without patterns every reading matches.
+func DeviceFilter(patterns []string) Filter {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	return func(row database.SensorData) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, row.DeviceID); matched {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Pipeline routes readings to several sinks. Every sink has its own
+// database.BatchWriter, so a slow sink does not hold back the batches of the
+// others.
+//
+// Write returns once every required sink has stored its rows, so the
+// originating messages can be acknowledged. Optional sinks are written in the
+// background: their failures are logged and counted but never fail the
+// write, and when too many of their writes are pending new rows are dropped
+// instead of piling up.
+type Pipeline struct {
+	logger logger.Interface
+	routes []*route
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type route struct {
+	name    string
+	sink    database.Copier
+	writer  *database.BatchWriter
+	options *RouteOptions
+	pending chan struct{}
+}
+
+type RouteOptions struct {
+	required      bool
+	filter        Filter
+	maxPending    int
+	writerOptions []database.WriterOption
+	failures      database.Counter
+	dropped       database.Counter
+}
+
+type RouteOption func(*RouteOptions)
+
+// WithRequired sets whether Write waits for the sink and fails with it.
+// Sinks are required by default.
+func WithRequired(required bool) RouteOption {
+	return func(options *RouteOptions) {
+		options.required = required
+	}
+}
+
+// WithFilter only routes the readings the filter accepts to the sink.
+func WithFilter(filter Filter) RouteOption {
+	return func(options *RouteOptions) {
+		options.filter = filter
+	}
+}
+
+// WithMaxPending sets how many background writes an optional sink can have
+// in flight before its rows are dropped.
+func WithMaxPending(maxPending int) RouteOption {
+	return func(options *RouteOptions) {
+		options.maxPending = maxPending
+	}
+}
+
+// WithWriterOptions sets the batching of the sink.
+func WithWriterOptions(writerOptions ...database.WriterOption) RouteOption {
+	return func(options *RouteOptions) {
+		options.writerOptions = append(options.writerOptions, writerOptions...)
+	}
+}
+
+// WithFailuresCounter sets a counter incremented by the number of rows the
+// sink failed to store.
+func WithFailuresCounter(failures database.Counter) RouteOption {
+	return func(options *RouteOptions) {
+		options.failures = failures
+	}
+}
+
+// WithDroppedCounter sets a counter incremented by the number of rows an
+// optional sink dropped because too many writes were pending.
+func WithDroppedCounter(dropped database.Counter) RouteOption {
+	return func(options *RouteOptions) {
+		options.dropped = dropped
+	}
+}
+
+func NewPipeline(logger logger.Interface) *Pipeline {
+	return &Pipeline{
+		logger: logger,
+	}
+}
+
+// Add routes readings to sink. Sinks must be added before the first Write.
+func (p *Pipeline) Add(name string, sink database.Copier, options ...RouteOption) {
+	defaultOptions := &RouteOptions{
+		required:   true,
+		maxPending: 100,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	if defaultOptions.maxPending < 1 {
+		defaultOptions.maxPending = 1
+	}
+
+	p.routes = append(p.routes, &route{
+		name:    name,
+		sink:    sink,
+		writer:  database.NewBatchWriter(sink, p.logger, defaultOptions.writerOptions...),
+		options: defaultOptions,
+		pending: make(chan struct{}, defaultOptions.maxPending),
+	})
+}
+
+// Write routes the rows to every sink whose filter accepts them and waits for
+// the required ones. The error names every required sink that failed.
+func (p *Pipeline) Write(ctx context.Context, rows []database.SensorData) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPipelineClosed
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(p.routes))
+
+	for i, r := range p.routes {
+		routed := r.filter(rows)
+		if len(routed) == 0 {
+			continue
+		}
+
+		if !r.options.required {
+			p.writeAsync(r, routed)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := p.write(ctx, r, routed); err != nil {
+				errs[i] = fmt.Errorf("sink %s: %w", r.name, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// Close waits for the background writes, flushes every sink and closes the
+// sinks that implement io.Closer.
+func (p *Pipeline) Close() error {
+	return p.Shutdown(context.Background())
+}
+
+// Shutdown is Close bounded by ctx: once ctx is done the background writes
+// are no longer waited for and the flushes still in progress are cancelled,
+// so a sink retrying an unavailable server does not hold up the shutdown.
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	background := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(background)
+	}()
+
+	var errs []error
+
+	select {
+	case <-background:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("background writes still pending: %w", ctx.Err()))
+	}
+
+	for _, r := range p.routes {
+		if err := r.writer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush sink %s: %w", r.name, err))
+		}
+
+		if closer, ok := r.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close sink %s: %w", r.name, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *Pipeline) write(ctx context.Context, r *route, rows []database.SensorData) error {
+	err := r.writer.Write(ctx, rows)
+	if err != nil {
+		p.logger.Error("Failed to write to sink", "error", err, "sink", r.name, "rows", len(rows), "required", r.options.required)
+
+		if r.options.failures != nil {
+			r.options.failures.Add(float64(len(rows)))
+		}
+	}
+	return err
+}
+
+func (p *Pipeline) writeAsync(r *route, rows []database.SensorData) {
+	select {
+	case r.pending <- struct{}{}:
+	default:
+		p.logger.Warn("Dropping rows of a sink that is falling behind", "sink", r.name, "rows", len(rows))
+
+		if r.options.dropped != nil {
+			r.options.dropped.Add(float64(len(rows)))
+		}
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-r.pending }()
+
+		p.write(context.Background(), r, rows)
+	}()
+}
+
+func (r *route) filter(rows []database.SensorData) []database.SensorData {
+	if r.options.filter == nil {
+		return rows
+	}
+
+	routed := make([]database.SensorData, 0, len(rows))
+	for _, row := range rows {
+		if r.options.filter(row) {
+			routed = append(routed, row)
+		}
+	}
+	return routed
+}
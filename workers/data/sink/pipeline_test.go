@@ -0,0 +1,292 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+// mockSink stores rows, fails with err, and blocks until release is closed
+// when it is set.
+type mockSink struct {
+	mu      sync.Mutex
+	rows    []database.SensorData
+	err     error
+	release chan struct{}
+	closed  bool
+}
+
+func (m *mockSink) CopySensorData(data []database.SensorData) (int64, error) {
+	if m.release != nil {
+		<-m.release
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return 0, m.err
+	}
+
+	m.rows = append(m.rows, data...)
+	return int64(len(data)), nil
+}
+
+func (m *mockSink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *mockSink) devices() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	devices := make([]string, len(m.rows))
+	for i, row := range m.rows {
+		devices[i] = row.DeviceID
+	}
+	return devices
+}
+
+type mockCounter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (m *mockCounter) Add(v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.value += v
+}
+
+func (m *mockCounter) get() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.value
+}
+
+func readings(devices ...string) []database.SensorData {
+	rows := make([]database.SensorData, len(devices))
+	for i, device := range devices {
+		rows[i] = database.SensorData{DeviceID: device, Timestamp: time.Unix(int64(i), 0)}
+	}
+	return rows
+}
+
+var fastFlush = WithWriterOptions(database.WithFlushInterval(time.Millisecond))
+
+func TestPipeline_Write(t *testing.T) {
+	sinkErr := errors.New("sink unavailable")
+
+	tests := []struct {
+		name        string
+		sinks       map[string]*mockSink
+		options     map[string][]RouteOption
+		wantErr     bool
+		wantDevices map[string][]string
+		wantFailed  map[string]float64
+	}{
+		{
+			name:  "writes to every required sink",
+			sinks: map[string]*mockSink{"store": {}, "archive": {}},
+			wantDevices: map[string][]string{
+				"store":   {"a-1", "b-1"},
+				"archive": {"a-1", "b-1"},
+			},
+		},
+		{
+			name:  "routes by device",
+			sinks: map[string]*mockSink{"store": {}, "archive": {}},
+			options: map[string][]RouteOption{
+				"archive": {WithFilter(DeviceFilter([]string{"b-*"}))},
+			},
+			wantDevices: map[string][]string{
+				"store":   {"a-1", "b-1"},
+				"archive": {"b-1"},
+			},
+		},
+		{
+			name:    "fails with a required sink",
+			sinks:   map[string]*mockSink{"store": {}, "archive": {err: sinkErr}},
+			wantErr: true,
+			wantDevices: map[string][]string{
+				"store":   {"a-1", "b-1"},
+				"archive": {},
+			},
+			wantFailed: map[string]float64{"archive": 2},
+		},
+		{
+			name:  "isolates failing optional sinks",
+			sinks: map[string]*mockSink{"store": {}, "webhook": {err: sinkErr}},
+			options: map[string][]RouteOption{
+				"webhook": {WithRequired(false)},
+			},
+			wantDevices: map[string][]string{
+				"store":   {"a-1", "b-1"},
+				"webhook": {},
+			},
+			wantFailed: map[string]float64{"webhook": 2},
+		},
+		{
+			name:  "skips sinks without routed rows",
+			sinks: map[string]*mockSink{"store": {}, "archive": {err: sinkErr}},
+			options: map[string][]RouteOption{
+				"archive": {WithFilter(DeviceFilter([]string{"c-*"}))},
+			},
+			wantDevices: map[string][]string{
+				"store":   {"a-1", "b-1"},
+				"archive": {},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline(&mockLogger{})
+
+			failures := make(map[string]*mockCounter)
+			for name, s := range tt.sinks {
+				failures[name] = &mockCounter{}
+				options := append([]RouteOption{fastFlush, WithFailuresCounter(failures[name])}, tt.options[name]...)
+				p.Add(name, s, options...)
+			}
+
+			err := p.Write(context.Background(), readings("a-1", "b-1"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err := p.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			for name, want := range tt.wantDevices {
+				got := tt.sinks[name].devices()
+				if len(got) != len(want) {
+					t.Errorf("sink %s stored %v, want %v", name, got, want)
+					continue
+				}
+				for i := range want {
+					if got[i] != want[i] {
+						t.Errorf("sink %s stored %v, want %v", name, got, want)
+						break
+					}
+				}
+			}
+
+			for name, counter := range failures {
+				if got := counter.get(); got != tt.wantFailed[name] {
+					t.Errorf("sink %s failures = %v, want %v", name, got, tt.wantFailed[name])
+				}
+			}
+
+			for name, s := range tt.sinks {
+				if !s.closed {
+					t.Errorf("sink %s was not closed", name)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeline_SlowOptionalSink(t *testing.T) {
+	store := &mockSink{}
+	webhook := &mockSink{release: make(chan struct{})}
+	dropped := &mockCounter{}
+
+	p := NewPipeline(&mockLogger{})
+	p.Add("store", store, fastFlush)
+	p.Add("webhook", webhook, fastFlush, WithRequired(false), WithMaxPending(1), WithDroppedCounter(dropped))
+
+	for _, device := range []string{"a-1", "a-2"} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := p.Write(ctx, readings(device))
+		cancel()
+
+		if err != nil {
+			t.Fatalf("Write() error = %v, a slow optional sink must not block it", err)
+		}
+	}
+
+	if got := dropped.get(); got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+
+	close(webhook.release)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := store.devices(); len(got) != 2 {
+		t.Errorf("store stored %v, want both readings", got)
+	}
+	if got := webhook.devices(); len(got) != 1 || got[0] != "a-1" {
+		t.Errorf("webhook stored %v, want [a-1]", got)
+	}
+
+	if err := p.Write(context.Background(), readings("a-3")); !errors.Is(err, ErrPipelineClosed) {
+		t.Errorf("Write() after Close() error = %v, want %v", err, ErrPipelineClosed)
+	}
+}
+
+// retryingSink fails every write until its context is done, like a sink
+// retrying a server that stays unavailable.
+type retryingSink struct {
+	mockSink
+}
+
+func (r *retryingSink) CopySensorDataContext(ctx context.Context, data []database.SensorData) (int64, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestPipeline_ShutdownCancelsRetryingSink(t *testing.T) {
+	store := &mockSink{}
+	influx := &retryingSink{}
+
+	p := NewPipeline(&mockLogger{})
+	p.Add("store", store, fastFlush)
+	p.Add("influx", influx, fastFlush, WithRequired(false))
+
+	if err := p.Write(context.Background(), readings("a-1")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown() blocked on a sink retrying after the deadline")
+	}
+
+	if got := store.devices(); len(got) != 1 {
+		t.Errorf("store stored %v, want [a-1]", got)
+	}
+	if !influx.closed || !store.closed {
+		t.Error("Shutdown() did not close the sinks")
+	}
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// Reading is the JSON form of a reading sent by the webhook and republish
+// sinks, as an array per batch. Quality lists the validation rules a flagged
+// reading failed and is omitted for good readings.
+type Reading struct {
+	DeviceID    string    `json:"device_id"`
+	Timestamp   time.Time `json:"timestamp"`
+	Humidity    float32   `json:"humidity"`
+	Temperature float32   `json:"temperature"`
+	Quality     []string  `json:"quality,omitempty"`
+}
+
+func encodeReadings(rows []database.SensorData) ([]byte, error) {
+	readings := make([]Reading, len(rows))
+	for i, row := range rows {
+		readings[i] = Reading{
+			DeviceID:    row.DeviceID,
+			Timestamp:   row.Timestamp.UTC(),
+			Humidity:    row.Humidity,
+			Temperature: row.Temperature,
+			Quality:     row.Quality.Names(),
+		}
+	}
+
+	body, err := json.Marshal(readings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode readings: %w", err)
+	}
+
+	return body, nil
+}
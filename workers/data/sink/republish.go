@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// Republish publishes every batch of readings as a JSON array, so other
+// services can consume parsed readings without decoding the device payload.
+type Republish struct {
+	publisher  broker.MessagePublisher
+	exchange   string
+	routingKey string
+	timeout    time.Duration
+}
+
+// NewRepublish publishes to exchange with routingKey, waiting up to timeout
+// for the broker to confirm every message.
+func NewRepublish(publisher broker.MessagePublisher, exchange, routingKey string, timeout time.Duration) *Republish {
+	return &Republish{
+		publisher:  publisher,
+		exchange:   exchange,
+		routingKey: routingKey,
+		timeout:    timeout,
+	}
+}
+
+// CopySensorData publishes the rows and waits for the broker to confirm them.
+func (r *Republish) CopySensorData(data []database.SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	body, err := encodeReadings(data)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	err = r.publisher.PublishWithConfirm(ctx, r.exchange, r.routingKey, broker.Message{
+		Body:        body,
+		ContentType: "application/json",
+		Timestamp:   time.Now(),
+		Persistent:  true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to republish readings: %w", err)
+	}
+
+	return int64(len(data)), nil
+}
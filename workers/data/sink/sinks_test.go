@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/memory"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+func TestWebhook_CopySensorData(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "rejected", status: http.StatusBadGateway, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Reading
+			var authorization string
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &got)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			webhook := NewWebhook(server.URL, WithToken("secret"))
+			defer webhook.Close()
+
+			rows := readings("a-1", "b-1")
+			rows[1].Quality = database.QualityClockSkew | database.QualityOutOfRange
+
+			_, err := webhook.CopySensorData(rows)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CopySensorData() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(got) != 2 || got[0].DeviceID != "a-1" || got[1].DeviceID != "b-1" {
+				t.Fatalf("webhook received %+v, want readings of a-1 and b-1", got)
+			}
+			if len(got[0].Quality) != 0 || len(got[1].Quality) != 2 || got[1].Quality[0] != "clock_skew" || got[1].Quality[1] != "out_of_range" {
+				t.Errorf("webhook received qualities %v and %v, want none and [clock_skew out_of_range]", got[0].Quality, got[1].Quality)
+			}
+			if authorization != "Bearer secret" {
+				t.Errorf("Authorization = %q, want %q", authorization, "Bearer secret")
+			}
+		})
+	}
+}
+
+func TestRepublish_CopySensorData(t *testing.T) {
+	b := memory.NewBroker()
+	b.DeclareQueue("readings")
+	defer b.Close()
+
+	republish := NewRepublish(b, "", "readings", time.Second)
+
+	inserted, err := republish.CopySensorData(readings("a-1", "b-1"))
+	if err != nil {
+		t.Fatalf("CopySensorData() error = %v", err)
+	}
+	if inserted != 2 {
+		t.Errorf("CopySensorData() = %d, want 2", inserted)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, memory.Queue("readings"), "test")
+	if err != nil {
+		t.Fatalf("ConsumeQueue() error = %v", err)
+	}
+
+	msg := <-messages
+
+	var got []Reading
+	if err := json.Unmarshal(msg.Body, &got); err != nil {
+		t.Fatalf("republished message is not JSON: %v", err)
+	}
+	if len(got) != 2 || got[1].DeviceID != "b-1" || !got[1].Timestamp.Equal(time.Unix(1, 0)) {
+		t.Errorf("republished %+v, want readings of a-1 and b-1", got)
+	}
+	if msg.ContentType != "application/json" {
+		t.Errorf("ContentType = %q, want application/json", msg.ContentType)
+	}
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// Webhook posts every batch of readings as a JSON array to a URL.
+type Webhook struct {
+	url     string
+	options *WebhookOptions
+}
+
+type WebhookOptions struct {
+	token      string
+	httpClient *http.Client
+}
+
+type WebhookOption func(*WebhookOptions)
+
+// WithToken sends the token as a bearer token.
+func WithToken(token string) WebhookOption {
+	return func(options *WebhookOptions) {
+		options.token = token
+	}
+}
+
+// WithHTTPClient sets the client used for the requests.
+func WithHTTPClient(client *http.Client) WebhookOption {
+	return func(options *WebhookOptions) {
+		options.httpClient = client
+	}
+}
+
+func NewWebhook(url string, options ...WebhookOption) *Webhook {
+	defaultOptions := &WebhookOptions{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Webhook{
+		url:     url,
+		options: defaultOptions,
+	}
+}
+
+// CopySensorData posts the rows and fails unless the response is 2xx.
+func (w *Webhook) CopySensorData(data []database.SensorData) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	body, err := encodeReadings(data)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if w.options.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.options.token)
+	}
+
+	resp, err := w.options.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return 0, fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+
+	return int64(len(data)), nil
+}
+
+// Close releases idle connections.
+func (w *Webhook) Close() error {
+	w.options.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/archive"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/metrics"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/sink"
+)
+
+// buildPipeline routes readings to the store, which is always required, and
+// to every destination enabled by the configuration. Closing the pipeline
+// closes the store.
+func buildPipeline(cfg *config.Config, log logger.Interface, store database.SensorStore, publisher messageBroker, metricsServer *metrics.Server) (*sink.Pipeline, error) {
+	pipeline := sink.NewPipeline(log)
+
+	pipeline.Add("store", store,
+		sink.WithWriterOptions(
+			database.WithMaxRows(cfg.Writer.MaxRows),
+			database.WithFlushInterval(time.Duration(cfg.Writer.FlushIntervalMs)*time.Millisecond),
+			database.WithDuplicatesCounter(metricsServer.DuplicatesDropped),
+		),
+		sink.WithFailuresCounter(metricsServer.SinkFailures.WithLabelValues("store")),
+	)
+
+	if cfg.Archive.Enabled {
+		sensorArchive, err := archive.NewArchive(
+			cfg.Archive.Dir,
+			log,
+			archive.WithMaxRowsPerFile(cfg.Archive.MaxRowsPerFile),
+			archive.WithCompactionInterval(cfg.Archive.CompactionInterval()),
+		)
+		if err != nil {
+			pipeline.Close()
+			return nil, fmt.Errorf("failed to open archive: %w", err)
+		}
+
+		addSink(pipeline, cfg, metricsServer, "archive", sensorArchive, cfg.Archive.SinkConfig)
+	}
+
+	if cfg.Influx.Enabled {
+		lineProtocolSink, err := openInflux(cfg, log)
+		if err != nil {
+			pipeline.Close()
+			return nil, fmt.Errorf("failed to open InfluxDB output: %w", err)
+		}
+
+		addSink(pipeline, cfg, metricsServer, "influx", lineProtocolSink, cfg.Influx.SinkConfig)
+	}
+
+	if cfg.Webhook.Enabled {
+		webhook := sink.NewWebhook(cfg.Webhook.URL, sink.WithToken(cfg.Webhook.Token))
+
+		addSink(pipeline, cfg, metricsServer, "webhook", webhook, cfg.Webhook.SinkConfig)
+	}
+
+	if cfg.Republish.Enabled {
+		republish := sink.NewRepublish(publisher, cfg.Republish.Exchange, cfg.Republish.RoutingKey, 10*time.Second)
+
+		addSink(pipeline, cfg, metricsServer, "republish", republish, cfg.Republish.SinkConfig)
+	}
+
+	return pipeline, nil
+}
+
+// addSink adds a destination with its routing policy, batching with the
+// writer settings unless the policy overrides them.
+func addSink(pipeline *sink.Pipeline, cfg *config.Config, metricsServer *metrics.Server, name string, destination database.Copier, policy config.SinkConfig) {
+	maxRows := cfg.Writer.MaxRows
+	if policy.MaxRows > 0 {
+		maxRows = policy.MaxRows
+	}
+
+	flushIntervalMs := cfg.Writer.FlushIntervalMs
+	if policy.FlushIntervalMs > 0 {
+		flushIntervalMs = policy.FlushIntervalMs
+	}
+
+	pipeline.Add(name, destination,
+		sink.WithRequired(policy.Required),
+		sink.WithFilter(sink.DeviceFilter(policy.Devices)),
+		sink.WithWriterOptions(
+			database.WithMaxRows(maxRows),
+			database.WithFlushInterval(time.Duration(flushIntervalMs)*time.Millisecond),
+		),
+		sink.WithFailuresCounter(metricsServer.SinkFailures.WithLabelValues(name)),
+		sink.WithDroppedCounter(metricsServer.SinkDropped.WithLabelValues(name)),
+	)
+}
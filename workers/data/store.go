@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+)
+
+// openStore opens the sensor data store selected by the configuration and
+// runs its migrations.
+func openStore(cfg *config.Config, log logger.Interface) (database.SensorStore, error) {
+	switch cfg.Store {
+	case "memory":
+		log.Warn("Storing sensor data in memory, it is lost when the worker stops")
+
+		return database.NewMemory(), nil
+	case "postgres":
+		log.Debug("Connecting to PostgreSQL", "host", cfg.Postgres.Host, "database", cfg.Postgres.Database)
+
+		db, err := database.NewPostgres(cfg.Postgres.ConnectionString())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+		}
+
+		return db, nil
+	case "sqlite":
+		log.Debug("Opening SQLite database", "path", cfg.SQLite.Path)
+
+		db, err := database.NewSQLite(cfg.SQLite.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+		}
+
+		return db, nil
+	case "timescaledb":
+		log.Debug("Connecting to TimescaleDB", "host", cfg.TimescaleDB.Host, "database", cfg.TimescaleDB.Database)
+
+		db, err := database.NewDatabase(cfg.TimescaleDB.ConnectionString())
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to TimescaleDB: %w", err)
+		}
+
+		if err := setUpPolicies(db, cfg.TimescaleDBPolicies, log); err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		return db, nil
+	default:
+		return nil, fmt.Errorf("unknown store %q, expected timescaledb, postgres, sqlite or memory", cfg.Store)
+	}
+}
+
+// setUpPolicies applies the configured compression and retention intervals
+// and checks that the continuous aggregates and every policy exist.
+func setUpPolicies(db *database.Database, policies config.PoliciesConfig, log logger.Interface) error {
+	err := db.ApplyPolicies(database.Policies{
+		CompressAfter: policies.CompressAfter,
+		Retention:     policies.Retention,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply TimescaleDB policies: %w", err)
+	}
+
+	if !policies.Verify {
+		return nil
+	}
+
+	if err := db.VerifyPolicies(); err != nil {
+		return fmt.Errorf("failed to verify TimescaleDB policies: %w", err)
+	}
+
+	log.Info("Verified TimescaleDB policies",
+		"aggregates", database.ContinuousAggregates,
+		"compress_after", policies.CompressAfter,
+		"retention", policies.Retention,
+	)
+	return nil
+}
@@ -0,0 +1,148 @@
+package timesync
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"google.golang.org/protobuf/proto"
+)
+
+// DeviceIDPlaceholder is replaced by the device ID in the response topic.
+const DeviceIDPlaceholder = "{device_id}"
+
+type Options struct {
+	now     func() time.Time
+	timeout time.Duration
+}
+
+type Option func(*Options)
+
+// WithClock sets the function returning the server time, time.Now by
+// default.
+func WithClock(now func() time.Time) Option {
+	return func(options *Options) {
+		options.now = now
+	}
+}
+
+// WithTimeout sets how long a response waits for the broker to confirm it.
+func WithTimeout(timeout time.Duration) Option {
+	return func(options *Options) {
+		options.timeout = timeout
+	}
+}
+
+// Responder answers the time requests of devices, so they can estimate the
+// offset of their clock NTP style.
+//
+// The receive time of a request is the time it reached the broker, so the
+// time it waited in the queue counts as processing time rather than as
+// network delay, and the device estimate is not skewed by a backlog.
+type Responder struct {
+	publisher     broker.MessagePublisher
+	exchange      string
+	responseTopic string
+	logger        logger.Interface
+	options       *Options
+}
+
+// NewResponder publishes responses to exchange, with responseTopic as routing
+// key after replacing DeviceIDPlaceholder by the device ID.
+func NewResponder(publisher broker.MessagePublisher, exchange, responseTopic string, logger logger.Interface, options ...Option) *Responder {
+	defaultOptions := &Options{
+		now:     time.Now,
+		timeout: 5 * time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Responder{
+		publisher:     publisher,
+		exchange:      exchange,
+		responseTopic: responseTopic,
+		logger:        logger,
+		options:       defaultOptions,
+	}
+}
+
+// Handle answers a time request. Malformed requests are rejected. A response
+// the broker does not take is only logged, since the device sends a new
+// request instead of waiting for a late answer.
+func (r *Responder) Handle(msg broker.Message) error {
+	received := clockskew.ArrivalTime(msg)
+
+	request, err := decodeRequest(msg.Body)
+	if err != nil {
+		r.logger.Warn("Rejecting invalid time request", "error", err)
+		return consumer.Reject(err)
+	}
+
+	topic := strings.ReplaceAll(r.responseTopic, DeviceIDPlaceholder, request.SensorId)
+
+	response := &protosensor.TimeResponse{
+		SensorId:            request.SensorId,
+		ClientSendTimeMs:    request.ClientSendTimeMs,
+		ServerReceiveTimeMs: received.UnixMilli(),
+		ServerSendTimeMs:    r.options.now().UnixMilli(),
+	}
+
+	body, err := proto.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode time response: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.timeout)
+	defer cancel()
+
+	err = r.publisher.PublishWithConfirm(ctx, r.exchange, topic, broker.Message{
+		Body:      []byte(base64.StdEncoding.EncodeToString(body)),
+		Timestamp: time.UnixMilli(response.ServerSendTimeMs),
+	})
+
+	var returned *broker.ReturnedError
+	switch {
+	case errors.As(err, &returned):
+		r.logger.Debug("Device is not subscribed to time responses", "device_id", request.SensorId, "topic", topic)
+	case err != nil:
+		r.logger.Error("Failed to publish time response", "error", err, "device_id", request.SensorId, "topic", topic)
+	default:
+		r.logger.Debug("Answered time request", "device_id", request.SensorId, "topic", topic)
+	}
+
+	return nil
+}
+
+func decodeRequest(body []byte) (*protosensor.TimeRequest, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 message: %w", err)
+	}
+
+	var request protosensor.TimeRequest
+	if err := proto.Unmarshal(decoded, &request); err != nil {
+		return nil, fmt.Errorf("failed to parse protobuf message: %w", err)
+	}
+
+	if request.SensorId == "" {
+		return nil, errors.New("sensor_id is required")
+	}
+
+	// The device ID becomes a topic level, so wildcards and separators would
+	// send the response to other devices.
+	if strings.ContainsAny(request.SensorId, "#+*/") {
+		return nil, fmt.Errorf("sensor_id %q has topic wildcards or separators", request.SensorId)
+	}
+
+	return &request, nil
+}
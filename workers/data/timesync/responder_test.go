@@ -0,0 +1,118 @@
+package timesync
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/memory"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+var now = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func encodeRequest(t *testing.T, deviceID string, sent int64) []byte {
+	t.Helper()
+
+	body, err := proto.Marshal(&protosensor.TimeRequest{SensorId: deviceID, ClientSendTimeMs: sent})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(body))
+}
+
+func TestResponder_Handle(t *testing.T) {
+	b := memory.NewBroker()
+	b.Bind("device-1", "amq.topic", "iot.device.time.response.device-1")
+
+	r := NewResponder(b, "amq.topic", "iot.device.time.response.{device_id}", &mockLogger{},
+		WithClock(func() time.Time { return now }),
+	)
+
+	err := r.Handle(broker.Message{
+		Body:    encodeRequest(t, "device-1", 1234),
+		Headers: map[string]any{clockskew.ArrivalHeader: now.Add(-time.Second).UnixMilli()},
+	})
+	if err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	msgs, err := b.ConsumeQueue(ctx, memory.Queue("device-1"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg broker.Message
+	select {
+	case msg = <-msgs:
+	case <-ctx.Done():
+		t.Fatal("no time response was published")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var response protosensor.TimeResponse
+	if err := proto.Unmarshal(decoded, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if response.SensorId != "device-1" || response.ClientSendTimeMs != 1234 {
+		t.Errorf("response = %+v, want the device and send time of the request", &response)
+	}
+	if response.ServerReceiveTimeMs != now.Add(-time.Second).UnixMilli() {
+		t.Errorf("receive time = %d, want the arrival time %d", response.ServerReceiveTimeMs, now.Add(-time.Second).UnixMilli())
+	}
+	if response.ServerSendTimeMs != now.UnixMilli() {
+		t.Errorf("send time = %d, want %d", response.ServerSendTimeMs, now.UnixMilli())
+	}
+}
+
+func TestResponder_Handle_NotSubscribed(t *testing.T) {
+	r := NewResponder(memory.NewBroker(), "amq.topic", "iot.device.time.response.{device_id}", &mockLogger{})
+
+	if err := r.Handle(broker.Message{Body: encodeRequest(t, "device-1", 1234)}); err != nil {
+		t.Errorf("Handle() error = %v, want nil", err)
+	}
+}
+
+func TestResponder_Handle_RejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+	}{
+		{name: "not base64", body: []byte("not base64!")},
+		{name: "missing sensor id", body: encodeRequest(t, "", 1234)},
+		{name: "wildcard sensor id", body: encodeRequest(t, "#", 1234)},
+	}
+
+	r := NewResponder(memory.NewBroker(), "amq.topic", "iot.device.time.response.{device_id}", &mockLogger{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rejectErr *consumer.RejectError
+			if err := r.Handle(broker.Message{Body: tt.body}); !errors.As(err, &rejectErr) {
+				t.Errorf("Handle() error = %v, want *consumer.RejectError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/database"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/metrics"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/data/parser"
+)
+
+// buildParser creates the parser validating sensor data with the configured
+// rules, counting the readings failing them and tracking the clock skew of
+// every device.
+func buildParser(cfg *config.Config, metricsServer *metrics.Server) (*parser.Parser, error) {
+	ranges := make(map[string]parser.Range, len(cfg.Validation.Ranges))
+	for metric, r := range cfg.Validation.Ranges {
+		ranges[metric] = parser.Range{Min: r.Min, Max: r.Max}
+	}
+
+	rules := parser.Rules{
+		Mode:             cfg.Validation.Mode,
+		RequireSensorID:  cfg.Validation.RequireSensorID,
+		RequireTimestamp: cfg.Validation.RequireTimestamp,
+		MaxPastSkew:      cfg.Validation.MaxPastSkew(),
+		MaxFutureSkew:    cfg.Validation.MaxFutureSkew(),
+		Ranges:           ranges,
+	}
+
+	options := []parser.Option{
+		parser.WithInvalidCounter(func(reason string) database.Counter {
+			action := cfg.Validation.Mode
+			if reason == parser.ReasonNotFinite {
+				action = parser.ModeReject
+			}
+			return metricsServer.InvalidReadings.WithLabelValues(reason, action)
+		}),
+	}
+
+	if cfg.ClockSkew.Mode != clockskew.ModeOff {
+		tracker, err := clockskew.NewTracker(
+			clockskew.WithMode(cfg.ClockSkew.Mode),
+			clockskew.WithThreshold(cfg.ClockSkew.Threshold()),
+			clockskew.WithWindow(cfg.ClockSkew.Window),
+			clockskew.WithMinSamples(cfg.ClockSkew.MinSamples),
+			clockskew.WithGauge(func(deviceID string) clockskew.Gauge {
+				return metricsServer.ClockSkew.WithLabelValues(deviceID)
+			}),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clock skew tracker: %w", err)
+		}
+
+		options = append(options, parser.WithSkewTracker(tracker))
+	}
+
+	return parser.NewParser(rules, options...)
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+)
+
+type Config struct {
+	User                   string `json:"rabbitmq_user"`
+	Password               string
+	Domain                 string        `json:"rabbitmq_domain"`
+	Port                   string        `json:"rabbitmq_port"`
+	DataExchange           string        `json:"data_exchange"`
+	MetricsExchange        string        `json:"metrics_exchange"`
+	ListenAddress          string        `json:"listen_address"`
+	TLSCertFile            string        `json:"tls_cert_file"`
+	TLSKeyFile             string        `json:"tls_key_file"`
+	HealthAddress          string        `json:"health_address"`
+	Gateway                GatewayConfig `json:"gateway"`
+	MaxBatchSize           int           `json:"max_batch_size"`
+	PublishTimeoutMs       int           `json:"publish_timeout_ms"`
+	ShutdownTimeoutSeconds int           `json:"shutdown_timeout_seconds"`
+	Log                    logger.Config `json:"log"`
+
+	// DeviceTokens maps device IDs to the bearer token they authenticate
+	// with, over gRPC and through the gateway.
+	DeviceTokens map[string]string
+}
+
+// GatewayConfig is the HTTP gateway, disabled when Address is empty. It is
+// served over TLS when both TLSCertFile and TLSKeyFile are set.
+type GatewayConfig struct {
+	Address      string `json:"address"`
+	MaxBodyBytes int64  `json:"max_body_bytes"`
+	TLSCertFile  string `json:"tls_cert_file"`
+	TLSKeyFile   string `json:"tls_key_file"`
+}
+
+var DEFAULT_SECRET_PATH = getStringEnv("DEFAULT_SECRET_PATH", "/run/secrets/")
+
+func NewConfig() *Config {
+	fileConfig, err := getFromFile("config.json")
+	if err != nil {
+		log.Fatalf("Failed to read config file: %v", err)
+		return nil
+	}
+
+	password, err := getFromSecret("RABBITMQ_CLIENT_USER_PASSWORD")
+	if err != nil {
+		log.Fatalf("Failed to read secret RABBITMQ_CLIENT_USER_PASSWORD: %v", err)
+	}
+
+	// Without tokens every device is rejected, so they are optional.
+	var deviceTokens map[string]string
+	tokens, err := getFromSecret("INGEST_DEVICE_TOKENS")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Failed to read secret INGEST_DEVICE_TOKENS: %v", err)
+	}
+
+	if tokens != "" {
+		if err := json.Unmarshal([]byte(tokens), &deviceTokens); err != nil {
+			log.Fatalf("Failed to parse secret INGEST_DEVICE_TOKENS: %v", err)
+		}
+	}
+
+	return &Config{
+		User:            getStringEnv("RABBITMQ_CLIENT_USER", fileConfig.User),
+		Password:        password,
+		Domain:          getStringEnv("RABBITMQ_DOMAIN", fileConfig.Domain),
+		Port:            getStringEnv("RABBITMQ_AMQP_PORT", fileConfig.Port),
+		DataExchange:    getStringEnv("RABBITMQ_DATA_TOPIC", fileConfig.DataExchange),
+		MetricsExchange: getStringEnv("RABBITMQ_METRICS_TOPIC", fileConfig.MetricsExchange),
+		ListenAddress:   getStringEnv("LISTEN_ADDRESS", fileConfig.ListenAddress),
+		TLSCertFile:     getStringEnv("TLS_CERT_FILE", fileConfig.TLSCertFile),
+		TLSKeyFile:      getStringEnv("TLS_KEY_FILE", fileConfig.TLSKeyFile),
+		HealthAddress:   getStringEnv("HEALTH_ADDRESS", fileConfig.HealthAddress),
+		DeviceTokens:    deviceTokens,
+		Gateway: GatewayConfig{
+			Address:      getStringEnv("GATEWAY_ADDRESS", fileConfig.Gateway.Address),
+			MaxBodyBytes: int64(getIntEnv("GATEWAY_MAX_BODY_BYTES", int(fileConfig.Gateway.MaxBodyBytes))),
+			TLSCertFile:  getStringEnv("GATEWAY_TLS_CERT_FILE", fileConfig.Gateway.TLSCertFile),
+			TLSKeyFile:   getStringEnv("GATEWAY_TLS_KEY_FILE", fileConfig.Gateway.TLSKeyFile),
+		},
+		MaxBatchSize:           getIntEnv("MAX_BATCH_SIZE", fileConfig.MaxBatchSize),
+		PublishTimeoutMs:       getIntEnv("PUBLISH_TIMEOUT_MS", fileConfig.PublishTimeoutMs),
+		ShutdownTimeoutSeconds: getIntEnv("SHUTDOWN_TIMEOUT_SECONDS", fileConfig.ShutdownTimeoutSeconds),
+		Log: logger.Config{
+			Level: getStringEnv("LOG_LEVEL", "info"),
+			Source: logger.SourceConfig{
+				Enabled:  getBoolEnv("LOG_SOURCE_ENABLED", true),
+				Relative: getBoolEnv("LOG_SOURCE_RELATIVE", true),
+				AsJSON:   getBoolEnv("LOG_SOURCE_AS_JSON", false),
+			},
+		},
+	}
+}
+
+func getFromFile(path string) (*Config, error) {
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configData := Config{
+		DataExchange:    "iot.device.data.binary",
+		MetricsExchange: "iot.device.metrics",
+		ListenAddress:   ":50051",
+		HealthAddress:   ":2114",
+		Gateway: GatewayConfig{
+			Address:      ":8081",
+			MaxBodyBytes: 1 << 20,
+		},
+		MaxBatchSize:           1000,
+		PublishTimeoutMs:       10000,
+		ShutdownTimeoutSeconds: 10,
+	}
+	json.Unmarshal(config, &configData)
+
+	return &configData, nil
+}
+
+func getBoolEnv(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value == "true"
+}
+
+func getIntEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+func getStringEnv(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getFromSecret(name string) (string, error) {
+	path := filepath.Join(DEFAULT_SECRET_PATH, name)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+func (c *Config) PublishTimeout() time.Duration {
+	return time.Duration(c.PublishTimeoutMs) * time.Millisecond
+}
+
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+}
@@ -0,0 +1,254 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/ingest/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Forwarder publishes a batch of readings tagged with messageID, as
+// service.Service does. Errors are gRPC statuses.
+type Forwarder interface {
+	Forward(ctx context.Context, request *proto.PublishReadingsRequest, messageID string) (uint64, error)
+}
+
+// Gateway accepts single readings over HTTP from devices that cannot use
+// MQTT or gRPC, and forwards them like the ingest service.
+type Gateway struct {
+	forwarder Forwarder
+	tokens    map[string]string
+	logger    logger.Interface
+	options   *Options
+	mux       *http.ServeMux
+}
+
+type Options struct {
+	maxBodyBytes int64
+}
+
+type Option func(*Options)
+
+// WithMaxBodyBytes sets the largest request body accepted. Larger ones are
+// rejected with 413.
+func WithMaxBodyBytes(maxBodyBytes int64) Option {
+	return func(options *Options) {
+		options.maxBodyBytes = maxBodyBytes
+	}
+}
+
+type acceptedResponse struct {
+	MessageID string `json:"message_id"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// NewGateway authenticates every device with its token in tokens, keyed by
+// device ID. Devices without a token are rejected.
+func NewGateway(forwarder Forwarder, tokens map[string]string, logger logger.Interface, options ...Option) *Gateway {
+	defaultOptions := &Options{
+		maxBodyBytes: 1 << 20,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	g := &Gateway{
+		forwarder: forwarder,
+		tokens:    tokens,
+		logger:    logger,
+		options:   defaultOptions,
+		mux:       http.NewServeMux(),
+	}
+
+	g.mux.HandleFunc("POST /v1/devices/{id}/sensor-data", g.handleSensorData)
+	g.mux.HandleFunc("POST /v1/devices/{id}/metrics-data", g.handleMetricsData)
+
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *Gateway) handleSensorData(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.PathValue("id")
+
+	var data proto.SensorData
+	if !g.decode(w, r, deviceID, &data) {
+		return
+	}
+
+	if data.GetSensorId() == "" {
+		data.SensorId = deviceID
+	}
+
+	if err := validateSensorData(deviceID, &data); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	g.forward(w, r, &proto.PublishReadingsRequest{SensorData: []*proto.SensorData{&data}})
+}
+
+func (g *Gateway) handleMetricsData(w http.ResponseWriter, r *http.Request) {
+	deviceID := r.PathValue("id")
+
+	var data proto.MetricsData
+	if !g.decode(w, r, deviceID, &data) {
+		return
+	}
+
+	if data.GetSensorId() == "" {
+		data.SensorId = deviceID
+	}
+
+	if err := validateMetricsData(deviceID, &data); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	g.forward(w, r, &proto.PublishReadingsRequest{MetricsData: []*proto.MetricsData{&data}})
+}
+
+// decode authenticates the device and decodes the body into message, writing
+// the error response and returning false when either fails.
+func (g *Gateway) decode(w http.ResponseWriter, r *http.Request, deviceID string, message protobuf.Message) bool {
+	if !g.authenticate(r, deviceID) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ingest"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "missing or invalid device token"})
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = ""
+	}
+
+	var unmarshal func([]byte, protobuf.Message) error
+
+	switch mediaType {
+	case "application/json":
+		unmarshal = protojson.Unmarshal
+	case "application/x-protobuf", "application/protobuf":
+		unmarshal = protobuf.Unmarshal
+	default:
+		writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "content type must be application/json or application/x-protobuf"})
+		return false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, g.options.maxBodyBytes))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: fmt.Sprintf("body is larger than %d bytes", maxBytesError.Limit)})
+			return false
+		}
+
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "failed to read body"})
+		return false
+	}
+
+	if err := unmarshal(body, message); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid %s body: %v", mediaType, err)})
+		return false
+	}
+
+	return true
+}
+
+func (g *Gateway) authenticate(r *http.Request, deviceID string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	return service.ValidToken(g.tokens, deviceID, token)
+}
+
+func (g *Gateway) forward(w http.ResponseWriter, r *http.Request, request *proto.PublishReadingsRequest) {
+	messageID := service.NewMessageID()
+
+	if _, err := g.forwarder.Forward(r.Context(), request, messageID); err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: status.Convert(err).Message()})
+			return
+		}
+
+		g.logger.Error("Failed to forward reading", "error", err, "message_id", messageID)
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "failed to forward reading, retry later"})
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, acceptedResponse{MessageID: messageID})
+}
+
+func validateSensorData(deviceID string, data *proto.SensorData) error {
+	if data.GetSensorId() != deviceID {
+		return fmt.Errorf("sensor_id %q does not match device %q", data.GetSensorId(), deviceID)
+	}
+
+	if _, ok := data.ReadingTime(); !ok {
+		return errors.New("time or timestamp is required")
+	}
+
+	return validateFinite([]field{
+		{"humidity", data.GetHumidity()},
+		{"temperature", data.GetTemperature()},
+	})
+}
+
+func validateMetricsData(deviceID string, data *proto.MetricsData) error {
+	if data.GetSensorId() != deviceID {
+		return fmt.Errorf("sensor_id %q does not match device %q", data.GetSensorId(), deviceID)
+	}
+
+	if _, ok := data.ReadingTime(); !ok {
+		return errors.New("time or timestamp is required")
+	}
+
+	return validateFinite([]field{
+		{"cpu_usage", data.GetCpuUsage()},
+		{"memory_usage", data.GetMemoryUsage()},
+		{"disk_usage", data.GetDiskUsage()},
+		{"network_usage", data.GetNetworkUsage()},
+	})
+}
+
+// field is a named value of a reading.
+type field struct {
+	name  string
+	value float32
+}
+
+func validateFinite(fields []field) error {
+	for _, f := range fields {
+		if math.IsNaN(float64(f.value)) || math.IsInf(float64(f.value), 0) {
+			return fmt.Errorf("%s must be a finite number", f.name)
+		}
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+type mockForwarder struct {
+	requests   []*proto.PublishReadingsRequest
+	messageIDs []string
+	err        error
+}
+
+func (m *mockForwarder) Forward(ctx context.Context, request *proto.PublishReadingsRequest, messageID string) (uint64, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+
+	m.requests = append(m.requests, request)
+	m.messageIDs = append(m.messageIDs, messageID)
+
+	return uint64(len(request.GetSensorData()) + len(request.GetMetricsData())), nil
+}
+
+func mustMarshal(t *testing.T, message protobuf.Message) []byte {
+	t.Helper()
+
+	body, err := protobuf.Marshal(message)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	return body
+}
+
+func TestGateway(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		token       string
+		contentType string
+		body        []byte
+		forwardErr  error
+		wantStatus  int
+		wantForward bool
+	}{
+		{
+			name:        "json sensor data",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json; charset=utf-8",
+			body:        []byte(`{"sensor_id": "sensor-1", "humidity": 40, "temperature": 21.5, "timestamp": 1700000000}`),
+			wantStatus:  http.StatusAccepted,
+			wantForward: true,
+		},
+		{
+			name:        "json without sensor ID",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"humidity": 40, "temperature": 21.5, "timestamp": 1700000000}`),
+			wantStatus:  http.StatusAccepted,
+			wantForward: true,
+		},
+		{
+			name:        "json sub-second time",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"sensor_id": "sensor-1", "humidity": 40, "time": "2026-01-01T12:00:00.123456Z"}`),
+			wantStatus:  http.StatusAccepted,
+			wantForward: true,
+		},
+		{
+			name:        "json invalid time",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"sensor_id": "sensor-1", "time": "yesterday"}`),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "protobuf metrics data",
+			path:        "/v1/devices/sensor-1/metrics-data",
+			token:       "secret-1",
+			contentType: "application/x-protobuf",
+			body:        mustMarshal(t, &proto.MetricsData{SensorId: "sensor-1", CpuUsage: 12, Timestamp: 1700000000}),
+			wantStatus:  http.StatusAccepted,
+			wantForward: true,
+		},
+		{
+			name:        "missing token",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			contentType: "application/json",
+			body:        []byte(`{"timestamp": 1700000000}`),
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "token of another device",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-2",
+			contentType: "application/json",
+			body:        []byte(`{"timestamp": 1700000000}`),
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "unknown device",
+			path:        "/v1/devices/sensor-3/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"timestamp": 1700000000}`),
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:        "unsupported content type",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "text/plain",
+			body:        []byte(`humidity=40`),
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "invalid json",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"humidity": "wet"}`),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "unknown json field",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"pressure": 1013, "timestamp": 1700000000}`),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "sensor ID of another device",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"sensor_id": "sensor-2", "timestamp": 1700000000}`),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "missing timestamp",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"humidity": 40}`),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "not a number",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/x-protobuf",
+			body:        mustMarshal(t, &proto.SensorData{Temperature: float32(math.NaN()), Timestamp: 1700000000}),
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "body too large",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        bytes.Repeat([]byte(" "), 2048),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "broker unavailable",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"timestamp": 1700000000}`),
+			forwardErr:  status.Error(codes.Unavailable, "failed to publish reading"),
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+		{
+			name:        "rejected by the forwarder",
+			path:        "/v1/devices/sensor-1/sensor-data",
+			token:       "secret-1",
+			contentType: "application/json",
+			body:        []byte(`{"timestamp": 1700000000}`),
+			forwardErr:  status.Error(codes.InvalidArgument, "too many readings"),
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	tokens := map[string]string{"sensor-1": "secret-1", "sensor-2": "secret-2"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarder := &mockForwarder{err: tt.forwardErr}
+			gateway := NewGateway(forwarder, tokens, &mockLogger{}, WithMaxBodyBytes(1024))
+
+			request := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+			request.Header.Set("Content-Type", tt.contentType)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			recorder := httptest.NewRecorder()
+			gateway.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", recorder.Code, tt.wantStatus, recorder.Body)
+			}
+
+			if got := len(forwarder.requests) == 1; got != tt.wantForward {
+				t.Fatalf("forwarded = %v, want %v", got, tt.wantForward)
+			}
+
+			if !tt.wantForward {
+				return
+			}
+
+			var response acceptedResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			if response.MessageID == "" || response.MessageID != forwarder.messageIDs[0] {
+				t.Errorf("message ID = %q, want the forwarded one %q", response.MessageID, forwarder.messageIDs[0])
+			}
+
+			forwarded := forwarder.requests[0]
+			for _, data := range forwarded.GetSensorData() {
+				if data.GetSensorId() != "sensor-1" {
+					t.Errorf("forwarded sensor ID = %q, want sensor-1", data.GetSensorId())
+				}
+			}
+			for _, data := range forwarded.GetMetricsData() {
+				if data.GetSensorId() != "sensor-1" {
+					t.Errorf("forwarded sensor ID = %q, want sensor-1", data.GetSensorId())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/RicardoCenci/iot-distributed-architecture/workers/ingest
+
+go 1.24.0
+
+require (
+	github.com/RicardoCenci/iot-distributed-architecture/shared v0.0.0
+	google.golang.org/grpc v1.77.0
+	google.golang.org/protobuf v1.36.10
+)
+
+require (
+	github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+	golang.org/x/net v0.47.0 // indirect
+	golang.org/x/sys v0.38.0 // indirect
+	golang.org/x/text v0.31.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251022142026-3a174f9686a8 // indirect
+)
+
+replace github.com/RicardoCenci/iot-distributed-architecture/shared => ../../shared
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/rabbitmq"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/health"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/ingest/config"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/ingest/gateway"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/ingest/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthgrpc "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func main() {
+	config := config.NewConfig()
+
+	loggerConfig := logger.Config{
+		Level: config.Log.Level,
+		Source: logger.SourceConfig{
+			Enabled:  config.Log.Source.Enabled,
+			Relative: config.Log.Source.Relative,
+			AsJSON:   config.Log.Source.AsJSON,
+		},
+	}
+
+	logger := logger.NewSlogLogger(loggerConfig)
+
+	url := fmt.Sprintf(
+		"amqp://%s:%s@%s:%s",
+		config.User,
+		config.Password,
+		config.Domain,
+		config.Port,
+	)
+
+	logger.Debug("Connecting to RabbitMQ", "domain", config.Domain, "port", config.Port)
+
+	rabbitMQ := rabbitmq.NewBroker(url, logger)
+
+	if err := rabbitMQ.Connect(); err != nil {
+		logger.Error("Failed to connect to RabbitMQ", "error", err)
+		os.Exit(1)
+	}
+
+	ingestService := service.NewService(
+		rabbitMQ,
+		logger,
+		config.DataExchange,
+		config.MetricsExchange,
+		service.WithMaxBatchSize(config.MaxBatchSize),
+		service.WithPublishTimeout(config.PublishTimeout()),
+	)
+
+	healthServer := healthgrpc.NewServer()
+
+	if len(config.DeviceTokens) == 0 {
+		logger.Warn("No device tokens configured, the gRPC service and the HTTP gateway reject every device")
+	}
+
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(service.UnaryAuthInterceptor(config.DeviceTokens)),
+		grpc.ChainStreamInterceptor(service.StreamAuthInterceptor(config.DeviceTokens)),
+	}
+
+	if config.TLSCertFile != "" && config.TLSKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			logger.Error("Failed to load TLS certificate", "error", err, "cert_file", config.TLSCertFile)
+			os.Exit(1)
+		}
+		serverOptions = append(serverOptions, grpc.Creds(creds))
+	} else {
+		logger.Warn("No TLS certificate configured, the gRPC service accepts device tokens in plaintext")
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
+	proto.RegisterIngestServiceServer(grpcServer, ingestService)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		logger.Error("Failed to listen", "error", err, "address", config.ListenAddress)
+		os.Exit(1)
+	}
+
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			logger.Error("Failed to serve gRPC", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	var gatewayServer *http.Server
+
+	if config.Gateway.Address != "" {
+		gatewayServer = &http.Server{
+			Addr: config.Gateway.Address,
+			Handler: gateway.NewGateway(
+				ingestService,
+				config.DeviceTokens,
+				logger,
+				gateway.WithMaxBodyBytes(config.Gateway.MaxBodyBytes),
+			),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		gatewayTLS := config.Gateway.TLSCertFile != "" && config.Gateway.TLSKeyFile != ""
+		if !gatewayTLS {
+			logger.Warn("No TLS certificate configured, the HTTP gateway accepts device tokens in plaintext")
+		}
+
+		go func() {
+			var err error
+			if gatewayTLS {
+				err = gatewayServer.ListenAndServeTLS(config.Gateway.TLSCertFile, config.Gateway.TLSKeyFile)
+			} else {
+				err = gatewayServer.ListenAndServe()
+			}
+
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Failed to start HTTP gateway", "error", err)
+				os.Exit(1)
+			}
+		}()
+
+		logger.Info("HTTP gateway is running", "address", config.Gateway.Address, "devices", len(config.DeviceTokens), "tls", gatewayTLS)
+	}
+
+	brokerCheck := func() error {
+		if state := rabbitMQ.State(); state != broker.StateConnected {
+			return fmt.Errorf("rabbitmq is %s", state)
+		}
+		return nil
+	}
+
+	healthMux := http.NewServeMux()
+	healthMux.Handle("/healthz", health.Handler(map[string]health.Check{
+		"broker": brokerCheck,
+	}))
+
+	httpServer := &http.Server{
+		Addr:              config.HealthAddress,
+		Handler:           healthMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to start health server", "error", err)
+		}
+	}()
+
+	// The gRPC health service follows the broker, so load balancers stop
+	// sending readings while they cannot be forwarded.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			status := healthpb.HealthCheckResponse_SERVING
+			if brokerCheck() != nil {
+				status = healthpb.HealthCheckResponse_NOT_SERVING
+			}
+			healthServer.SetServingStatus("", status)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	logger.Info("Ingest service is running", "address", config.ListenAddress, "tls", config.TLSCertFile != "" && config.TLSKeyFile != "")
+	<-c
+	logger.Info("Shutting down ingest service", "timeout", config.ShutdownTimeout())
+
+	cancel()
+	healthServer.Shutdown()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer shutdownCancel()
+
+	if gatewayServer != nil {
+		if err := gatewayServer.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("HTTP requests were not finished before the shutdown timeout", "error", err)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		logger.Warn("Requests were not finished before the shutdown timeout")
+		grpcServer.Stop()
+	}
+
+	if err := httpServer.Close(); err != nil {
+		logger.Error("Failed to close health server", "error", err)
+	}
+
+	if err := rabbitMQ.Close(); err != nil {
+		logger.Error("Failed to close broker connection", "error", err)
+	}
+
+	logger.Info("Ingest service stopped")
+}
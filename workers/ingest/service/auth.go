@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DeviceIDMetadata is the gRPC metadata key naming the device a request is
+// sent by. Its token goes in the authorization metadata as "Bearer <token>".
+const DeviceIDMetadata = "x-device-id"
+
+// ValidToken reports whether token is the token of deviceID in tokens, keyed
+// by device ID. Devices without a token are never valid.
+func ValidToken(tokens map[string]string, deviceID, token string) bool {
+	expected, ok := tokens[deviceID]
+	if !ok || expected == "" || token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// UnaryAuthInterceptor authenticates the IngestService calls with the device
+// tokens, and only lets a device publish its own readings. Calls to other
+// services, such as health checks, are not authenticated.
+func UnaryAuthInterceptor(tokens map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !ingestMethod(info.FullMethod) {
+			return handler(ctx, request)
+		}
+
+		deviceID, err := authenticate(ctx, tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := authorize(request, deviceID); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, request)
+	}
+}
+
+// StreamAuthInterceptor is UnaryAuthInterceptor for streams: the device is
+// authenticated when the stream opens and every batch received is checked.
+func StreamAuthInterceptor(tokens map[string]string) grpc.StreamServerInterceptor {
+	return func(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !ingestMethod(info.FullMethod) {
+			return handler(server, stream)
+		}
+
+		deviceID, err := authenticate(stream.Context(), tokens)
+		if err != nil {
+			return err
+		}
+
+		return handler(server, &authorizedStream{ServerStream: stream, deviceID: deviceID})
+	}
+}
+
+// authorizedStream fails the stream on the first batch holding readings of
+// another device.
+type authorizedStream struct {
+	grpc.ServerStream
+	deviceID string
+}
+
+func (s *authorizedStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return authorize(m, s.deviceID)
+}
+
+func ingestMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+proto.IngestService_ServiceDesc.ServiceName+"/")
+}
+
+// authenticate returns the device of the call, failing with Unauthenticated
+// unless it sent the token of that device.
+func authenticate(ctx context.Context, tokens map[string]string) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	deviceID := first(md.Get(DeviceIDMetadata))
+	token, ok := strings.CutPrefix(first(md.Get("authorization")), "Bearer ")
+
+	if deviceID == "" || !ok || !ValidToken(tokens, deviceID, token) {
+		return "", status.Error(codes.Unauthenticated, "missing or invalid device token")
+	}
+
+	return deviceID, nil
+}
+
+// authorize fails with PermissionDenied when a reading of the request has
+// another sensor_id than the authenticated device.
+func authorize(request any, deviceID string) error {
+	readings, ok := request.(*proto.PublishReadingsRequest)
+	if !ok {
+		return nil
+	}
+
+	for i, data := range readings.GetSensorData() {
+		if data.GetSensorId() != deviceID {
+			return status.Errorf(codes.PermissionDenied, "sensor_data[%d] has sensor_id %q, not the authenticated device %q", i, data.GetSensorId(), deviceID)
+		}
+	}
+
+	for i, data := range readings.GetMetricsData() {
+		if data.GetSensorId() != deviceID {
+			return status.Errorf(codes.PermissionDenied, "metrics_data[%d] has sensor_id %q, not the authenticated device %q", i, data.GetSensorId(), deviceID)
+		}
+	}
+
+	return nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var deviceTokens = map[string]string{"sensor-1": "secret-1", "sensor-2": "secret-2"}
+
+func startAuthenticatedService(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+
+	return serve(t, newBroker(t), []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryAuthInterceptor(deviceTokens)),
+		grpc.ChainStreamInterceptor(StreamAuthInterceptor(deviceTokens)),
+	})
+}
+
+func withDevice(deviceID, token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), DeviceIDMetadata, deviceID, "authorization", "Bearer "+token)
+}
+
+func TestUnaryAuthInterceptor(t *testing.T) {
+	client := proto.NewIngestServiceClient(startAuthenticatedService(t))
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		sensorID string
+		wantCode codes.Code
+	}{
+		{
+			name:     "own readings",
+			ctx:      withDevice("sensor-1", "secret-1"),
+			sensorID: "sensor-1",
+			wantCode: codes.OK,
+		},
+		{
+			name:     "no credentials",
+			ctx:      context.Background(),
+			sensorID: "sensor-1",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "token of another device",
+			ctx:      withDevice("sensor-1", "secret-2"),
+			sensorID: "sensor-1",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "unknown device",
+			ctx:      withDevice("sensor-3", "secret-1"),
+			sensorID: "sensor-3",
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "readings of another device",
+			ctx:      withDevice("sensor-1", "secret-1"),
+			sensorID: "sensor-2",
+			wantCode: codes.PermissionDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.PublishReadings(tt.ctx, &proto.PublishReadingsRequest{
+				SensorData:  []*proto.SensorData{{SensorId: "sensor-1", Timestamp: 1700000000}},
+				MetricsData: []*proto.MetricsData{{SensorId: tt.sensorID, Timestamp: 1700000000}},
+			})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("PublishReadings() error = %v, want %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestStreamAuthInterceptor(t *testing.T) {
+	client := proto.NewIngestServiceClient(startAuthenticatedService(t))
+
+	t.Run("no credentials", func(t *testing.T) {
+		stream, err := client.StreamReadings(context.Background())
+		if err != nil {
+			t.Fatalf("StreamReadings() error = %v", err)
+		}
+
+		if _, err := stream.CloseAndRecv(); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("CloseAndRecv() error = %v, want Unauthenticated", err)
+		}
+	})
+
+	t.Run("readings of another device", func(t *testing.T) {
+		stream, err := client.StreamReadings(withDevice("sensor-1", "secret-1"))
+		if err != nil {
+			t.Fatalf("StreamReadings() error = %v", err)
+		}
+
+		for _, sensorID := range []string{"sensor-1", "sensor-2"} {
+			stream.Send(&proto.PublishReadingsRequest{
+				SensorData: []*proto.SensorData{{SensorId: sensorID, Timestamp: 1700000000}},
+			})
+		}
+
+		if _, err := stream.CloseAndRecv(); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("CloseAndRecv() error = %v, want PermissionDenied", err)
+		}
+	})
+
+	t.Run("own readings", func(t *testing.T) {
+		stream, err := client.StreamReadings(withDevice("sensor-2", "secret-2"))
+		if err != nil {
+			t.Fatalf("StreamReadings() error = %v", err)
+		}
+
+		if err := stream.Send(&proto.PublishReadingsRequest{
+			SensorData: []*proto.SensorData{{SensorId: "sensor-2", Timestamp: 1700000000}},
+		}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+
+		response, err := stream.CloseAndRecv()
+		if err != nil {
+			t.Fatalf("CloseAndRecv() error = %v", err)
+		}
+		if response.GetAccepted() != 1 {
+			t.Errorf("accepted = %d, want 1", response.GetAccepted())
+		}
+	})
+}
+
+func TestAuthInterceptors_SkipHealthChecks(t *testing.T) {
+	client := healthpb.NewHealthClient(startAuthenticatedService(t))
+
+	if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+		t.Errorf("Check() error = %v, health checks need no token", err)
+	}
+}
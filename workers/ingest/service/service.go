@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// Service forwards the readings sent over gRPC to the exchanges MQTT readings
+// are published to, encoded the way the MQTT clients encode them, so the
+// workers consume both alike.
+type Service struct {
+	proto.UnimplementedIngestServiceServer
+
+	publisher       broker.MessagePublisher
+	logger          logger.Interface
+	dataExchange    string
+	metricsExchange string
+	options         *Options
+}
+
+// MessageIDHeader carries the ID of the request a reading was received in,
+// so the readings of a request can be traced through the workers.
+const MessageIDHeader = "x-message-id"
+
+type Options struct {
+	maxBatchSize   int
+	publishTimeout time.Duration
+}
+
+type Option func(*Options)
+
+// WithMaxBatchSize sets the most readings a single request can carry.
+// Larger requests are rejected with InvalidArgument.
+func WithMaxBatchSize(maxBatchSize int) Option {
+	return func(options *Options) {
+		options.maxBatchSize = maxBatchSize
+	}
+}
+
+// WithPublishTimeout sets how long a reading waits for the broker to confirm
+// it before the request fails with Unavailable.
+func WithPublishTimeout(publishTimeout time.Duration) Option {
+	return func(options *Options) {
+		options.publishTimeout = publishTimeout
+	}
+}
+
+// NewService publishes sensor data to dataExchange and metrics data to
+// metricsExchange, with the device ID as routing key.
+func NewService(
+	publisher broker.MessagePublisher, logger logger.Interface,
+	dataExchange, metricsExchange string, options ...Option,
+) *Service {
+	defaultOptions := &Options{
+		maxBatchSize:   1000,
+		publishTimeout: 10 * time.Second,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Service{
+		publisher:       publisher,
+		logger:          logger,
+		dataExchange:    dataExchange,
+		metricsExchange: metricsExchange,
+		options:         defaultOptions,
+	}
+}
+
+// PublishReadings publishes every reading of the request and waits for the
+// broker to confirm them. When it fails some readings may already have been
+// published; the data worker drops the duplicates of a retried batch.
+func (s *Service) PublishReadings(ctx context.Context, request *proto.PublishReadingsRequest) (*proto.PublishReadingsResponse, error) {
+	accepted, err := s.Forward(ctx, request, NewMessageID())
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.PublishReadingsResponse{Accepted: accepted}, nil
+}
+
+// StreamReadings publishes the batches of the stream as they arrive. The
+// stream fails on the first batch that cannot be published.
+func (s *Service) StreamReadings(stream proto.IngestService_StreamReadingsServer) error {
+	var accepted uint64
+
+	for {
+		request, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&proto.PublishReadingsResponse{Accepted: accepted})
+		}
+
+		if err != nil {
+			return err
+		}
+
+		n, err := s.Forward(stream.Context(), request, NewMessageID())
+		if err != nil {
+			return err
+		}
+
+		accepted += n
+	}
+}
+
+// Forward validates a batch of readings and publishes them, tagged with
+// messageID, returning how many were published. Errors are gRPC statuses:
+// InvalidArgument for invalid batches, which publish nothing, and Unavailable
+// when a reading could not be published.
+func (s *Service) Forward(ctx context.Context, request *proto.PublishReadingsRequest, messageID string) (uint64, error) {
+	if err := s.validate(request); err != nil {
+		return 0, err
+	}
+
+	var accepted uint64
+
+	for _, data := range request.GetSensorData() {
+		if err := s.publish(ctx, s.dataExchange, data.GetSensorId(), data, messageID); err != nil {
+			return accepted, err
+		}
+		accepted++
+	}
+
+	for _, data := range request.GetMetricsData() {
+		if err := s.publish(ctx, s.metricsExchange, data.GetSensorId(), data, messageID); err != nil {
+			return accepted, err
+		}
+		accepted++
+	}
+
+	return accepted, nil
+}
+
+func (s *Service) publish(ctx context.Context, exchange, deviceID string, data protobuf.Message, messageID string) error {
+	body, err := encodeBase64(data)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "failed to encode reading: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.options.publishTimeout)
+	defer cancel()
+
+	err = s.publisher.PublishWithConfirm(ctx, exchange, deviceID, broker.Message{
+		Body:        body,
+		Headers:     map[string]any{MessageIDHeader: messageID},
+		ContentType: "text/plain",
+		Timestamp:   time.Now(),
+		Persistent:  true,
+	})
+	if err != nil {
+		s.logger.Error("Failed to publish reading", "error", err, "exchange", exchange, "device_id", deviceID, "message_id", messageID)
+		return status.Errorf(codes.Unavailable, "failed to publish reading: %v", err)
+	}
+
+	s.logger.Debug("Published reading", "exchange", exchange, "device_id", deviceID, "message_id", messageID)
+
+	return nil
+}
+
+func (s *Service) validate(request *proto.PublishReadingsRequest) error {
+	size := len(request.GetSensorData()) + len(request.GetMetricsData())
+	if size > s.options.maxBatchSize {
+		return status.Errorf(codes.InvalidArgument, "request has %d readings, at most %d are allowed", size, s.options.maxBatchSize)
+	}
+
+	for i, data := range request.GetSensorData() {
+		if data.GetSensorId() == "" {
+			return status.Errorf(codes.InvalidArgument, "sensor_data[%d] has no sensor_id", i)
+		}
+	}
+
+	for i, data := range request.GetMetricsData() {
+		if data.GetSensorId() == "" {
+			return status.Errorf(codes.InvalidArgument, "metrics_data[%d] has no sensor_id", i)
+		}
+	}
+
+	return nil
+}
+
+// NewMessageID returns a random ID for the readings of a request.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// encodeBase64 encodes a message as the MQTT clients do: the protobuf wire
+// format, base64 encoded.
+func encodeBase64(message protobuf.Message) ([]byte, error) {
+	data, err := protobuf.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal protobuf: %w", err)
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(encoded, data)
+	return encoded, nil
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthgrpc "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+// startService serves a Service publishing to b over an in-memory listener
+// and returns a client connected to it.
+func startService(t *testing.T, b *memory.Broker, options ...Option) proto.IngestServiceClient {
+	t.Helper()
+
+	return proto.NewIngestServiceClient(serve(t, b, nil, options...))
+}
+
+// serve serves a Service publishing to b, and the health service, on a server
+// created with serverOptions over an in-memory listener and returns a
+// connection to it.
+func serve(t *testing.T, b *memory.Broker, serverOptions []grpc.ServerOption, options ...Option) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+
+	server := grpc.NewServer(serverOptions...)
+	proto.RegisterIngestServiceServer(server, NewService(b, &mockLogger{}, "data", "metrics", options...))
+	healthpb.RegisterHealthServer(server, healthgrpc.NewServer())
+
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func newBroker(t *testing.T) *memory.Broker {
+	t.Helper()
+
+	b := memory.NewBroker()
+	b.Bind("data-queue", "data", "#")
+	b.Bind("metrics-queue", "metrics", "#")
+	t.Cleanup(func() { b.Close() })
+
+	return b
+}
+
+// consume returns the next n messages of queue.
+func consume(t *testing.T, b *memory.Broker, queue string, n int) []broker.Message {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	messages, err := b.ConsumeQueue(ctx, memory.Queue(queue), "test")
+	if err != nil {
+		t.Fatalf("failed to consume %s: %v", queue, err)
+	}
+
+	var received []broker.Message
+	for len(received) < n {
+		select {
+		case msg := <-messages:
+			received = append(received, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("received %d messages from %s, want %d", len(received), queue, n)
+		}
+	}
+
+	return received
+}
+
+func decodeSensorData(t *testing.T, msg broker.Message) *proto.SensorData {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(string(msg.Body))
+	if err != nil {
+		t.Fatalf("body is not base64: %v", err)
+	}
+
+	var sensorData proto.SensorData
+	if err := protobuf.Unmarshal(data, &sensorData); err != nil {
+		t.Fatalf("body is not a SensorData: %v", err)
+	}
+
+	return &sensorData
+}
+
+func TestService_PublishReadings(t *testing.T) {
+	b := newBroker(t)
+	client := startService(t, b)
+
+	response, err := client.PublishReadings(context.Background(), &proto.PublishReadingsRequest{
+		SensorData: []*proto.SensorData{
+			{SensorId: "sensor-1", Humidity: 40, Temperature: 21.5, Timestamp: 1700000000},
+			{SensorId: "sensor-2", Humidity: 41, Temperature: 22.5, Timestamp: 1700000001},
+		},
+		MetricsData: []*proto.MetricsData{
+			{SensorId: "sensor-1", CpuUsage: 12, Timestamp: 1700000000},
+		},
+	})
+	if err != nil {
+		t.Fatalf("PublishReadings() error = %v", err)
+	}
+
+	if response.GetAccepted() != 3 {
+		t.Errorf("accepted = %d, want 3", response.GetAccepted())
+	}
+
+	messages := consume(t, b, "data-queue", 2)
+
+	first := decodeSensorData(t, messages[0])
+	if first.GetSensorId() != "sensor-1" || first.GetTemperature() != 21.5 || first.GetTimestamp() != 1700000000 {
+		t.Errorf("first reading = %v", first)
+	}
+
+	id, _ := messages[0].Headers[MessageIDHeader].(string)
+	if id == "" || messages[1].Headers[MessageIDHeader] != id {
+		t.Errorf("message IDs = %v, %v, want the same ID for the readings of a request", messages[0].Headers[MessageIDHeader], messages[1].Headers[MessageIDHeader])
+	}
+
+	if messages[1].RoutingKey != "sensor-2" {
+		t.Errorf("routing key = %q, want the device ID sensor-2", messages[1].RoutingKey)
+	}
+
+	if got := b.Stats("metrics-queue").Ready; got != 1 {
+		t.Errorf("metrics ready = %d, want 1", got)
+	}
+}
+
+func TestService_PublishReadings_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  *proto.PublishReadingsRequest
+		wantCode codes.Code
+	}{
+		{
+			name: "missing sensor ID",
+			request: &proto.PublishReadingsRequest{
+				SensorData: []*proto.SensorData{{SensorId: "sensor-1"}, {Humidity: 40}},
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "batch too large",
+			request: &proto.PublishReadingsRequest{
+				SensorData:  []*proto.SensorData{{SensorId: "sensor-1"}, {SensorId: "sensor-2"}},
+				MetricsData: []*proto.MetricsData{{SensorId: "sensor-1"}},
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "unroutable",
+			request: &proto.PublishReadingsRequest{
+				MetricsData: []*proto.MetricsData{{SensorId: "sensor-1"}},
+			},
+			wantCode: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Metrics data is not routed to any queue.
+			b := memory.NewBroker()
+			b.Bind("data-queue", "data", "#")
+			defer b.Close()
+
+			client := startService(t, b, WithMaxBatchSize(2))
+
+			_, err := client.PublishReadings(context.Background(), tt.request)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("PublishReadings() code = %v, want %v (error %v)", got, tt.wantCode, err)
+			}
+
+			if tt.wantCode == codes.InvalidArgument && b.Stats("data-queue").Ready != 0 {
+				t.Error("an invalid request published readings")
+			}
+		})
+	}
+}
+
+func TestService_StreamReadings(t *testing.T) {
+	b := newBroker(t)
+	client := startService(t, b)
+
+	stream, err := client.StreamReadings(context.Background())
+	if err != nil {
+		t.Fatalf("StreamReadings() error = %v", err)
+	}
+
+	for i := range 3 {
+		err := stream.Send(&proto.PublishReadingsRequest{
+			SensorData: []*proto.SensorData{{SensorId: "sensor-1", Timestamp: 1700000000 + int64(i)}},
+		})
+		if err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv() error = %v", err)
+	}
+
+	if response.GetAccepted() != 3 {
+		t.Errorf("accepted = %d, want 3", response.GetAccepted())
+	}
+
+	for i, msg := range consume(t, b, "data-queue", 3) {
+		if got := decodeSensorData(t, msg).GetTimestamp(); got != 1700000000+int64(i) {
+			t.Errorf("reading %d timestamp = %d, want %d", i, got, 1700000000+int64(i))
+		}
+	}
+}
+
+func TestService_StreamReadings_InvalidBatch(t *testing.T) {
+	b := newBroker(t)
+	client := startService(t, b)
+
+	stream, err := client.StreamReadings(context.Background())
+	if err != nil {
+		t.Fatalf("StreamReadings() error = %v", err)
+	}
+
+	if err := stream.Send(&proto.PublishReadingsRequest{SensorData: []*proto.SensorData{{}}}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CloseAndRecv() error = %v, want InvalidArgument", err)
+	}
+}
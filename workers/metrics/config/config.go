@@ -2,22 +2,89 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
 )
 
 type Config struct {
-	User              string `json:"rabbitmq_user"`
-	Password          string
-	Domain            string        `json:"rabbitmq_domain"`
-	Port              string        `json:"rabbitmq_port"`
-	QueueName         string        `json:"rabbitmq_queue_name"`
-	PrometheusAddress string        `json:"prometheus_address"`
-	Log               logger.Config `json:"log"`
+	Broker                 string      `json:"broker"`
+	NATS                   NATSConfig  `json:"nats"`
+	Kafka                  KafkaConfig `json:"kafka"`
+	MQTT                   MQTTConfig  `json:"mqtt"`
+	User                   string      `json:"rabbitmq_user"`
+	Password               string
+	Domain                 string          `json:"rabbitmq_domain"`
+	Port                   string          `json:"rabbitmq_port"`
+	QueueName              string          `json:"rabbitmq_queue_name"`
+	PrometheusAddress      string          `json:"prometheus_address"`
+	Consumer               ConsumerConfig  `json:"consumer"`
+	ClockSkew              ClockSkewConfig `json:"clock_skew"`
+	ShutdownTimeoutSeconds int             `json:"shutdown_timeout_seconds"`
+	Log                    logger.Config   `json:"log"`
+}
+
+// NATSConfig is used when Broker is "nats". The queue name is used as the
+// durable consumer name.
+type NATSConfig struct {
+	URL               string   `json:"url"`
+	Stream            string   `json:"stream"`
+	Subjects          []string `json:"subjects"`
+	FilterSubject     string   `json:"filter_subject"`
+	MaxDeliver        int      `json:"max_deliver"`
+	DeadLetterSubject string   `json:"dead_letter_subject"`
+}
+
+// KafkaConfig is used when Broker is "kafka". The queue name is used as the
+// consumer group.
+type KafkaConfig struct {
+	Brokers         []string `json:"brokers"`
+	Topic           string   `json:"topic"`
+	Partitions      int      `json:"partitions"`
+	MaxRetries      int      `json:"max_retries"`
+	DeadLetterTopic string   `json:"dead_letter_topic"`
+}
+
+// MQTTConfig is used when Broker is "mqtt". The queue name is used as the
+// shared subscription group.
+type MQTTConfig struct {
+	URL             string `json:"url"`
+	User            string `json:"user"`
+	Password        string
+	Topic           string `json:"topic"`
+	ClientID        string `json:"client_id"`
+	CleanSession    bool   `json:"clean_session"`
+	QoS             int    `json:"qos"`
+	MaxRetries      int    `json:"max_retries"`
+	DeadLetterTopic string `json:"dead_letter_topic"`
+}
+
+// ClockSkewConfig sets how the clock of every device is compared with the
+// time its messages reach the broker. With Mode "flag" a device whose clock
+// is further than ThresholdSeconds from the arrival time is logged, with
+// "correct" the timestamps of its metrics are shifted by the skew, and with
+// "off" devices are not tracked. The skew is the smallest difference over the last Window
+// messages of a device, trusted once MinSamples were received.
+type ClockSkewConfig struct {
+	Mode             string `json:"mode"`
+	ThresholdSeconds int    `json:"threshold_seconds"`
+	Window           int    `json:"window"`
+	MinSamples       int    `json:"min_samples"`
+}
+
+type ConsumerConfig struct {
+	PrefetchCount  int  `json:"prefetch_count"`
+	Workers        int  `json:"workers"`
+	OrderByDevice  bool `json:"order_by_device"`
+	BatchSize      int  `json:"batch_size"`
+	BatchTimeoutMs int  `json:"batch_timeout_ms"`
 }
 
 var DEFAULT_SECRET_PATH = getStringEnv("DEFAULT_SECRET_PATH", "/run/secrets/")
@@ -29,19 +96,73 @@ func NewConfig() *Config {
 		return nil
 	}
 
-	password, err := getFromSecret("RABBITMQ_METRICS_WORKER_PASSWORD")
+	brokerType := getStringEnv("BROKER", fileConfig.Broker)
 
-	if err != nil {
-		log.Fatalf("Failed to read secret RABBITMQ_METRICS_WORKER_PASSWORD: %v", err)
+	var password string
+	if brokerType == "rabbitmq" {
+		password, err = getFromSecret("RABBITMQ_METRICS_WORKER_PASSWORD")
+		if err != nil {
+			log.Fatalf("Failed to read secret RABBITMQ_METRICS_WORKER_PASSWORD: %v", err)
+		}
+	}
+
+	// MQTT brokers often allow anonymous clients, so the password is optional.
+	var mqttPassword string
+	if brokerType == "mqtt" {
+		mqttPassword, err = getFromSecret("MQTT_METRICS_WORKER_PASSWORD")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Fatalf("Failed to read secret MQTT_METRICS_WORKER_PASSWORD: %v", err)
+		}
 	}
 
 	return &Config{
+		Broker: brokerType,
+		NATS: NATSConfig{
+			URL:               getStringEnv("NATS_URL", fileConfig.NATS.URL),
+			Stream:            getStringEnv("NATS_STREAM", fileConfig.NATS.Stream),
+			Subjects:          getListEnv("NATS_SUBJECTS", fileConfig.NATS.Subjects),
+			FilterSubject:     getStringEnv("NATS_FILTER_SUBJECT", fileConfig.NATS.FilterSubject),
+			MaxDeliver:        getIntEnv("NATS_MAX_DELIVER", fileConfig.NATS.MaxDeliver),
+			DeadLetterSubject: getStringEnv("NATS_DEAD_LETTER_SUBJECT", fileConfig.NATS.DeadLetterSubject),
+		},
+		Kafka: KafkaConfig{
+			Brokers:         getListEnv("KAFKA_BROKERS", fileConfig.Kafka.Brokers),
+			Topic:           getStringEnv("KAFKA_TOPIC", fileConfig.Kafka.Topic),
+			Partitions:      getIntEnv("KAFKA_PARTITIONS", fileConfig.Kafka.Partitions),
+			MaxRetries:      getIntEnv("KAFKA_MAX_RETRIES", fileConfig.Kafka.MaxRetries),
+			DeadLetterTopic: getStringEnv("KAFKA_DEAD_LETTER_TOPIC", fileConfig.Kafka.DeadLetterTopic),
+		},
+		MQTT: MQTTConfig{
+			URL:             getStringEnv("MQTT_URL", fileConfig.MQTT.URL),
+			User:            getStringEnv("MQTT_USER", fileConfig.MQTT.User),
+			Password:        mqttPassword,
+			Topic:           getStringEnv("MQTT_TOPIC", fileConfig.MQTT.Topic),
+			ClientID:        getStringEnv("MQTT_CLIENT_ID", fileConfig.MQTT.ClientID),
+			CleanSession:    getBoolEnv("MQTT_CLEAN_SESSION", fileConfig.MQTT.CleanSession),
+			QoS:             getIntEnv("MQTT_QOS", fileConfig.MQTT.QoS),
+			MaxRetries:      getIntEnv("MQTT_MAX_RETRIES", fileConfig.MQTT.MaxRetries),
+			DeadLetterTopic: getStringEnv("MQTT_DEAD_LETTER_TOPIC", fileConfig.MQTT.DeadLetterTopic),
+		},
 		User:              getStringEnv("RABBITMQ_METRICS_WORKER_USER", fileConfig.User),
 		Password:          password,
 		Domain:            getStringEnv("RABBITMQ_DOMAIN", fileConfig.Domain),
 		Port:              getStringEnv("RABBITMQ_AMQP_PORT", fileConfig.Port),
 		QueueName:         getStringEnv("RABBITMQ_METRICS_WORKER_QUEUE_NAME", fileConfig.QueueName),
 		PrometheusAddress: getStringEnv("PROMETHEUS_ADDRESS", fileConfig.PrometheusAddress),
+		Consumer: ConsumerConfig{
+			PrefetchCount:  getIntEnv("CONSUMER_PREFETCH_COUNT", fileConfig.Consumer.PrefetchCount),
+			Workers:        getIntEnv("CONSUMER_WORKERS", fileConfig.Consumer.Workers),
+			OrderByDevice:  getBoolEnv("CONSUMER_ORDER_BY_DEVICE", fileConfig.Consumer.OrderByDevice),
+			BatchSize:      getIntEnv("CONSUMER_BATCH_SIZE", fileConfig.Consumer.BatchSize),
+			BatchTimeoutMs: getIntEnv("CONSUMER_BATCH_TIMEOUT_MS", fileConfig.Consumer.BatchTimeoutMs),
+		},
+		ClockSkew: ClockSkewConfig{
+			Mode:             getStringEnv("CLOCK_SKEW_MODE", fileConfig.ClockSkew.Mode),
+			ThresholdSeconds: getIntEnv("CLOCK_SKEW_THRESHOLD_SECONDS", fileConfig.ClockSkew.ThresholdSeconds),
+			Window:           getIntEnv("CLOCK_SKEW_WINDOW", fileConfig.ClockSkew.Window),
+			MinSamples:       getIntEnv("CLOCK_SKEW_MIN_SAMPLES", fileConfig.ClockSkew.MinSamples),
+		},
+		ShutdownTimeoutSeconds: getIntEnv("SHUTDOWN_TIMEOUT_SECONDS", fileConfig.ShutdownTimeoutSeconds),
 		Log: logger.Config{
 			Level: getStringEnv("LOG_LEVEL", "debug"),
 			Source: logger.SourceConfig{
@@ -53,13 +174,54 @@ func NewConfig() *Config {
 	}
 }
 
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeoutSeconds) * time.Second
+}
+
+func (c *ClockSkewConfig) Threshold() time.Duration {
+	return time.Duration(c.ThresholdSeconds) * time.Second
+}
+
 func getFromFile(path string) (*Config, error) {
 	config, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var configData Config
+	configData := Config{
+		Broker: "rabbitmq",
+		NATS: NATSConfig{
+			URL:           "nats://nats:4222",
+			Stream:        "IOT",
+			Subjects:      []string{"iot.device.>"},
+			FilterSubject: "iot.device.metrics",
+		},
+		Kafka: KafkaConfig{
+			Brokers:    []string{"kafka:9092"},
+			Topic:      "iot.device.metrics",
+			MaxRetries: -1,
+		},
+		MQTT: MQTTConfig{
+			URL:          "tcp://mosquitto:1883",
+			Topic:        "iot.device.metrics",
+			CleanSession: true,
+			QoS:          1,
+			MaxRetries:   -1,
+		},
+		Consumer: ConsumerConfig{
+			PrefetchCount:  1,
+			Workers:        1,
+			BatchSize:      1,
+			BatchTimeoutMs: 1000,
+		},
+		ClockSkew: ClockSkewConfig{
+			Mode:             "flag",
+			ThresholdSeconds: 60,
+			Window:           20,
+			MinSamples:       5,
+		},
+		ShutdownTimeoutSeconds: 20,
+	}
 	json.Unmarshal(config, &configData)
 
 	return &configData, nil
@@ -73,6 +235,28 @@ func getBoolEnv(key string, defaultValue bool) bool {
 	return value == "true"
 }
 
+func getIntEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return i
+}
+
+// getListEnv reads a comma-separated list.
+func getListEnv(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return strings.Split(value, ",")
+}
+
 func getStringEnv(key string, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
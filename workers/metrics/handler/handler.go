@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/parser"
+)
+
+// Recorder exports device metrics. It is satisfied by *prometheus.Client.
+type Recorder interface {
+	RecordMetric(metric parser.MetricData) error
+	RecordMetrics(metrics []parser.MetricData) error
+}
+
+type Options struct {
+	skew *clockskew.Tracker
+}
+
+type Option func(*Options)
+
+// WithSkewTracker sets the tracker estimating the clock skew of every
+// device from the time its messages reach the broker. Skewed devices are
+// logged, and their timestamps corrected in clockskew.ModeCorrect.
+func WithSkewTracker(tracker *clockskew.Tracker) Option {
+	return func(options *Options) {
+		options.skew = tracker
+	}
+}
+
+// Handler turns metrics messages into exported metrics.
+type Handler struct {
+	recorder Recorder
+	logger   logger.Interface
+	options  *Options
+}
+
+func NewHandler(recorder Recorder, logger logger.Interface, options ...Option) *Handler {
+	defaultOptions := &Options{
+		skew: nil,
+	}
+
+	for _, option := range options {
+		option(defaultOptions)
+	}
+
+	return &Handler{
+		recorder: recorder,
+		logger:   logger,
+		options:  defaultOptions,
+	}
+}
+
+func (h *Handler) Handle(msg broker.Message) error {
+	h.logger.Debug("Received message", "message", string(msg.Body))
+
+	metricData, err := h.parse(msg)
+	if err != nil {
+		return consumer.Reject(err)
+	}
+
+	if err := h.recorder.RecordMetric(metricData); err != nil {
+		h.logger.Error("Failed to record metric", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// HandleBatch records every message of the batch that parses. Messages that
+// do not parse are reported in a *consumer.BatchError so only they are
+// rejected.
+func (h *Handler) HandleBatch(msgs []broker.Message) error {
+	metrics := make([]parser.MetricData, 0, len(msgs))
+
+	var rejected []int
+	var parseErr error
+
+	for i, msg := range msgs {
+		metricData, err := h.parse(msg)
+		if err != nil {
+			rejected = append(rejected, i)
+			parseErr = err
+			continue
+		}
+
+		metrics = append(metrics, metricData)
+	}
+
+	if err := h.recorder.RecordMetrics(metrics); err != nil {
+		h.logger.Error("Failed to record metrics batch", "error", err, "size", len(metrics))
+		return err
+	}
+
+	if len(rejected) > 0 {
+		return &consumer.BatchError{Rejected: rejected, Err: parseErr}
+	}
+
+	return nil
+}
+
+func (h *Handler) parse(msg broker.Message) (parser.MetricData, error) {
+	arrival := clockskew.ArrivalTime(msg)
+
+	metricData, err := parser.ParseMessage(msg.Body, arrival)
+	if err != nil {
+		h.logger.Error("Failed to parse message", "error", err, "message", string(msg.Body))
+		return parser.MetricData{}, err
+	}
+
+	if h.options.skew == nil || metricData.Received {
+		return metricData, nil
+	}
+
+	result := h.options.skew.Observe(metricData.DeviceID, metricData.Timestamp, arrival)
+	if result.Skewed {
+		h.logger.Warn("Device clock is skewed", "device_id", metricData.DeviceID, "skew", result.Skew, "corrected", result.Corrected)
+	}
+	metricData.Timestamp = result.Timestamp
+
+	return metricData, nil
+}
+
+// DeviceID is a consumer shard key that keeps the messages of a device in
+// order. The device ID only lives inside the payload, so the message is parsed
+// once to pick the worker and again by the handler.
+func DeviceID(msg broker.Message) string {
+	metricData, err := parser.ParseMessage(msg.Body, msg.Timestamp)
+	if err != nil {
+		return ""
+	}
+	return metricData.DeviceID
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/memory"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/parser"
+	"google.golang.org/protobuf/proto"
+)
+
+type mockLogger struct{}
+
+func (m *mockLogger) Debug(msg string, args ...any) {}
+func (m *mockLogger) Info(msg string, args ...any)  {}
+func (m *mockLogger) Warn(msg string, args ...any)  {}
+func (m *mockLogger) Error(msg string, args ...any) {}
+
+type mockRecorder struct {
+	mu      sync.Mutex
+	metrics []parser.MetricData
+}
+
+func (m *mockRecorder) RecordMetric(metric parser.MetricData) error {
+	return m.RecordMetrics([]parser.MetricData{metric})
+}
+
+func (m *mockRecorder) RecordMetrics(metrics []parser.MetricData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = append(m.metrics, metrics...)
+	return nil
+}
+
+func (m *mockRecorder) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.metrics)
+}
+
+func encode(t *testing.T, deviceID string, cpu float32) []byte {
+	t.Helper()
+
+	data, err := proto.Marshal(&protosensor.MetricsData{
+		SensorId:  deviceID,
+		CpuUsage:  cpu,
+		Timestamp: 1700000000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []byte(base64.StdEncoding.EncodeToString(data))
+}
+
+func TestHandler_ConsumesFromBroker(t *testing.T) {
+	b := memory.NewBroker()
+	b.DeclareQueue("metrics-queue")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, device := range []string{"device-1", "device-2"} {
+		if err := b.PublishWithConfirm(ctx, "", "metrics-queue", broker.Message{Body: encode(t, device, 42)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorder := &mockRecorder{}
+	c := consumer.NewConsumer(b, &mockLogger{}, "test", consumer.WithShardKey(DeviceID))
+
+	if err := c.Start(ctx, memory.Queue("metrics-queue"), NewHandler(recorder, &mockLogger{}).Handle); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Stats("metrics-queue").Acked < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := c.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := b.Stats("metrics-queue"); got.Acked != 2 {
+		t.Errorf("acked = %d, want 2", got.Acked)
+	}
+	if recorder.count() != 2 {
+		t.Errorf("recorded %d metrics, want 2", recorder.count())
+	}
+}
+
+func TestHandler_HandleBatch_RejectsInvalidMessages(t *testing.T) {
+	recorder := &mockRecorder{}
+	h := NewHandler(recorder, &mockLogger{})
+
+	err := h.HandleBatch([]broker.Message{
+		{Body: []byte("not base64!")},
+		{Body: encode(t, "device-1", 10)},
+	})
+
+	var batchErr *consumer.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("error = %v, want *consumer.BatchError", err)
+	}
+	if len(batchErr.Rejected) != 1 || batchErr.Rejected[0] != 0 || len(batchErr.Failed) != 0 {
+		t.Errorf("rejected = %v, failed = %v, want [0] rejected", batchErr.Rejected, batchErr.Failed)
+	}
+	if recorder.count() != 1 || recorder.metrics[0].CPUUsage != 10 {
+		t.Errorf("recorded = %+v", recorder.metrics)
+	}
+}
+
+func TestHandler_Handle_RejectsInvalidMessages(t *testing.T) {
+	recorder := &mockRecorder{}
+	h := NewHandler(recorder, &mockLogger{})
+
+	var rejectErr *consumer.RejectError
+	if err := h.Handle(broker.Message{Body: []byte("not base64!")}); !errors.As(err, &rejectErr) {
+		t.Errorf("Handle() error = %v, want *consumer.RejectError", err)
+	}
+	if recorder.count() != 0 {
+		t.Errorf("recorded %d metrics, want none", recorder.count())
+	}
+}
+
+func TestHandler_Handle_CorrectsSkewedClocks(t *testing.T) {
+	tracker, err := clockskew.NewTracker(clockskew.WithMode(clockskew.ModeCorrect), clockskew.WithMinSamples(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &mockRecorder{}
+	h := NewHandler(recorder, &mockLogger{}, WithSkewTracker(tracker))
+
+	// The device clock is an hour behind the time the broker received the
+	// metrics.
+	msg := broker.Message{
+		Body:    encode(t, "device-1", 42),
+		Headers: map[string]any{clockskew.ArrivalHeader: int64(1700003600000)},
+	}
+
+	if err := h.Handle(msg); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+
+	if recorder.count() != 1 || recorder.metrics[0].Timestamp.Unix() != 1700003600 {
+		t.Errorf("recorded = %+v, want a single metric at the arrival time", recorder.metrics)
+	}
+}
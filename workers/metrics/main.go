@@ -6,14 +6,16 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/logger"
-	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker/rabbitmq"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/broker"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/clockskew"
 	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/consumer"
+	"github.com/RicardoCenci/iot-distributed-architecture/shared/workers/health"
 	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/config"
-	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/parser"
+	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/handler"
 	"github.com/RicardoCenci/iot-distributed-architecture/workers/metrics/prometheus"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 func main() {
@@ -33,68 +35,79 @@ func main() {
 
 	log.Debug("Starting metrics worker", "config", cfg)
 
-	url := fmt.Sprintf(
-		"amqp://%s:%s@%s:%s",
-		cfg.User,
-		cfg.Password,
-		cfg.Domain,
-		cfg.Port,
-	)
-
-	log.Debug("Connecting to RabbitMQ with URL", "url", url)
-
-	rabbitMQ := rabbitmq.NewBroker(
-		url,
-		log,
-	)
-
-	if err := rabbitMQ.Connect(); err != nil {
-		log.Error("Failed to connect to RabbitMQ", "error", err)
+	messageBroker, queue, err := connectBroker(cfg, log)
+	if err != nil {
+		log.Error("Failed to set up broker", "error", err, "broker", cfg.Broker)
 		os.Exit(1)
 	}
 
-	defer rabbitMQ.Close()
-
 	prometheusClient := prometheus.NewClient(log, ":2112")
 
+	prometheusClient.Handle("/healthz", health.Handler(map[string]health.Check{
+		"broker": func() error {
+			if state := messageBroker.State(); state != broker.StateConnected {
+				return fmt.Errorf("%s is %s", cfg.Broker, state)
+			}
+			return nil
+		},
+	}))
+
 	if err := prometheusClient.Start(); err != nil {
 		log.Error("Failed to start Prometheus client", "error", err)
 		os.Exit(1)
 	}
 
-	defer prometheusClient.Close()
-
 	log.Info("Prometheus metrics endpoint", "endpoint", prometheusClient.GetMetricsEndpoint())
 
-	metricsConsumer := consumer.NewConsumer(rabbitMQ, log, "metrics-consumer")
-
-	queue := rabbitmq.NewQueue(cfg.QueueName)
-
-	log.Debug("Setting up queue channel", "queue", queue.GetName())
+	consumerOptions := []consumer.Option{
+		consumer.WithPrefetchCount(cfg.Consumer.PrefetchCount),
+		consumer.WithWorkers(cfg.Consumer.Workers),
+		consumer.WithBatchSize(cfg.Consumer.BatchSize),
+		consumer.WithBatchTimeout(time.Duration(cfg.Consumer.BatchTimeoutMs) * time.Millisecond),
+	}
 
-	if err := rabbitMQ.SetupQueueChannel(queue); err != nil {
-		log.Error("Failed to setup queue channel", "error", err)
-		os.Exit(1)
+	if cfg.Consumer.OrderByDevice {
+		consumerOptions = append(consumerOptions, consumer.WithShardKey(handler.DeviceID))
 	}
 
-	log.Info("Starting consumer")
+	metricsConsumer := consumer.NewConsumer(messageBroker, log, "metrics-consumer", consumerOptions...)
 
-	if err := metricsConsumer.Start(context.Background(), queue, func(delivery amqp.Delivery) error {
-		log.Debug("Received message", "message", string(delivery.Body))
+	log.Info("Starting consumer")
 
-		metricData, err := parser.ParseMessage(delivery.Body)
+	// Cancelling ctx cancels the broker consumer, so no new deliveries arrive
+	// while the ones already received are drained.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var handlerOptions []handler.Option
+
+	if cfg.ClockSkew.Mode != clockskew.ModeOff {
+		tracker, err := clockskew.NewTracker(
+			clockskew.WithMode(cfg.ClockSkew.Mode),
+			clockskew.WithThreshold(cfg.ClockSkew.Threshold()),
+			clockskew.WithWindow(cfg.ClockSkew.Window),
+			clockskew.WithMinSamples(cfg.ClockSkew.MinSamples),
+			clockskew.WithGauge(func(deviceID string) clockskew.Gauge {
+				return prometheusClient.ClockSkewGauge(deviceID)
+			}),
+		)
 		if err != nil {
-			log.Error("Failed to parse message", "error", err, "message", string(delivery.Body))
-			return err
+			log.Error("Failed to set up clock skew tracking", "error", err)
+			os.Exit(1)
 		}
 
-		if err := prometheusClient.RecordMetric(metricData); err != nil {
-			log.Error("Failed to record metric", "error", err)
-			return err
-		}
+		handlerOptions = append(handlerOptions, handler.WithSkewTracker(tracker))
+	}
+
+	metricsHandler := handler.NewHandler(prometheusClient, log, handlerOptions...)
+
+	if cfg.Consumer.BatchSize > 1 {
+		err = metricsConsumer.StartBatch(ctx, queue, metricsHandler.HandleBatch)
+	} else {
+		err = metricsConsumer.Start(ctx, queue, metricsHandler.Handle)
+	}
 
-		return nil
-	}); err != nil {
+	if err != nil {
 		log.Error("Failed to start consumer", "error", err)
 		os.Exit(1)
 	}
@@ -104,5 +117,24 @@ func main() {
 
 	log.Info("Metrics worker is running. Press Ctrl+C to stop.")
 	<-c
-	log.Info("Shutting down metrics worker")
+	log.Info("Shutting down metrics worker", "timeout", cfg.ShutdownTimeout())
+
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout())
+	defer shutdownCancel()
+
+	if err := metricsConsumer.Wait(shutdownCtx); err != nil {
+		log.Warn("In-flight messages were not drained before the shutdown timeout, they will be redelivered", "error", err)
+	}
+
+	if err := messageBroker.Close(); err != nil {
+		log.Error("Failed to close broker connection", "error", err)
+	}
+
+	if err := prometheusClient.Close(); err != nil {
+		log.Error("Failed to close Prometheus client", "error", err)
+	}
+
+	log.Info("Metrics worker stopped")
 }
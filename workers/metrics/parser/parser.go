@@ -26,9 +26,14 @@ type MetricData struct {
 	DiskUsage    float32
 	NetworkUsage float32
 	Timestamp    time.Time
+	// Received is set when the message had no timestamp and Timestamp is
+	// the time it was received instead.
+	Received bool
 }
 
-func ParseMessage(body []byte) (MetricData, error) {
+// ParseMessage decodes a metrics message received at the given time. A
+// missing timestamp is replaced by the time received.
+func ParseMessage(body []byte, received time.Time) (MetricData, error) {
 	decoded, err := base64.StdEncoding.DecodeString(string(body))
 	if err != nil {
 		return MetricData{}, fmt.Errorf("failed to decode base64 message: %w", err)
@@ -40,11 +45,9 @@ func ParseMessage(body []byte) (MetricData, error) {
 		return MetricData{}, fmt.Errorf("failed to parse protobuf message: %w", err)
 	}
 
-	var timestamp time.Time
-	if metricsData.Timestamp > 0 {
-		timestamp = time.Unix(metricsData.Timestamp, 0)
-	} else {
-		timestamp = time.Now()
+	timestamp, ok := metricsData.ReadingTime()
+	if !ok {
+		timestamp = received
 	}
 
 	return MetricData{
@@ -54,5 +57,6 @@ func ParseMessage(body []byte) (MetricData, error) {
 		DiskUsage:    metricsData.DiskUsage,
 		NetworkUsage: metricsData.NetworkUsage,
 		Timestamp:    timestamp,
+		Received:     !ok,
 	}, nil
 }
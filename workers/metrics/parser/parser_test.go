@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	protosensor "github.com/RicardoCenci/iot-distributed-architecture/shared/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestParseMessage_MixedClients(t *testing.T) {
+	received := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	readAt := received.Add(-time.Minute).Add(123456789 * time.Nanosecond)
+
+	tests := []struct {
+		name          string
+		data          *protosensor.MetricsData
+		wantTimestamp time.Time
+		wantReceived  bool
+	}{
+		{
+			name:          "seconds from an older client",
+			data:          &protosensor.MetricsData{SensorId: "device-1", Timestamp: readAt.Unix()},
+			wantTimestamp: readAt.Truncate(time.Second),
+		},
+		{
+			name: "time and seconds",
+			data: func() *protosensor.MetricsData {
+				data := &protosensor.MetricsData{SensorId: "device-1"}
+				data.SetReadingTime(readAt)
+				return data
+			}(),
+			wantTimestamp: readAt,
+		},
+		{
+			name:          "time without seconds",
+			data:          &protosensor.MetricsData{SensorId: "device-1", Time: timestamppb.New(readAt)},
+			wantTimestamp: readAt,
+		},
+		{
+			name:          "neither",
+			data:          &protosensor.MetricsData{SensorId: "device-1"},
+			wantTimestamp: received,
+			wantReceived:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := proto.Marshal(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			metric, err := ParseMessage([]byte(base64.StdEncoding.EncodeToString(body)), received)
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			if !metric.Timestamp.Equal(tt.wantTimestamp) {
+				t.Errorf("ParseMessage() timestamp = %v, want %v", metric.Timestamp, tt.wantTimestamp)
+			}
+			if metric.Received != tt.wantReceived {
+				t.Errorf("ParseMessage() received = %v, want %v", metric.Received, tt.wantReceived)
+			}
+		})
+	}
+}
+
+func TestParseMessage_InvalidBody(t *testing.T) {
+	if _, err := ParseMessage([]byte("not base64!"), time.Now()); err == nil {
+		t.Error("ParseMessage() error = nil")
+	}
+}
@@ -120,6 +120,19 @@ func (tc *timestampedCollector) recordMetric(metric parser.MetricData) {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
 
+	tc.setMetric(metric)
+}
+
+func (tc *timestampedCollector) recordMetrics(metrics []parser.MetricData) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	for _, metric := range metrics {
+		tc.setMetric(metric)
+	}
+}
+
+func (tc *timestampedCollector) setMetric(metric parser.MetricData) {
 	deviceID := metric.DeviceID
 	timestamp := metric.Timestamp
 
@@ -144,15 +157,22 @@ func (tc *timestampedCollector) recordMetric(metric parser.MetricData) {
 type Client struct {
 	registry   *prometheus.Registry
 	logger     logger.Interface
+	mux        *http.ServeMux
 	httpServer *http.Server
 	collector  *timestampedCollector
+	clockSkew  *prometheus.GaugeVec
 }
 
 func NewClient(logger logger.Interface, listenAddress string) *Client {
 	registry := prometheus.NewRegistry()
 	collector := newTimestampedCollector()
 
-	registry.MustRegister(collector)
+	clockSkew := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "iot_device_clock_skew_seconds",
+		Help: "Estimated offset of the device clock from the time its metrics reach the broker, positive when the device clock is behind",
+	}, []string{"device_id"})
+
+	registry.MustRegister(collector, clockSkew)
 
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
@@ -165,8 +185,10 @@ func NewClient(logger logger.Interface, listenAddress string) *Client {
 	return &Client{
 		registry:   registry,
 		logger:     logger,
+		mux:        mux,
 		httpServer: server,
 		collector:  collector,
+		clockSkew:  clockSkew,
 	}
 }
 
@@ -185,6 +207,26 @@ func (c *Client) RecordMetric(metric parser.MetricData) error {
 	return nil
 }
 
+func (c *Client) RecordMetrics(metrics []parser.MetricData) error {
+	c.collector.recordMetrics(metrics)
+
+	c.logger.Debug("Recorded metrics batch", "size", len(metrics))
+
+	return nil
+}
+
+// ClockSkewGauge returns the gauge exporting the estimated clock skew of a
+// device.
+func (c *Client) ClockSkewGauge(deviceID string) prometheus.Gauge {
+	return c.clockSkew.WithLabelValues(deviceID)
+}
+
+// Handle registers an extra handler, such as a health check, on the metrics
+// HTTP server.
+func (c *Client) Handle(pattern string, handler http.Handler) {
+	c.mux.Handle(pattern, handler)
+}
+
 func (c *Client) Start() error {
 	go func() {
 		if err := c.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {